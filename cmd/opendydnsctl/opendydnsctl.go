@@ -7,6 +7,6 @@ import (
 
 func main() {
 	if err := opendydnsctl.NewCLIApp().App().Run(os.Args); err != nil {
-		os.Exit(1)
+		os.Exit(opendydnsctl.ExitCode(err))
 	}
 }
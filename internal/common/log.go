@@ -1,17 +1,39 @@
 package common
 
 import (
+	"errors"
+	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh/terminal"
 	"io"
 	"os"
 )
 
+const (
+	// LogFormatAuto use the console format on a terminal and JSON otherwise
+	LogFormatAuto = "auto"
+	// LogFormatConsole is the human friendly format
+	LogFormatConsole = "console"
+	// LogFormatJSON is the structured format, one JSON object per line
+	LogFormatJSON = "json"
+)
+
+// ErrConflictingLogFlags is returned when both --quiet and --log-level are given
+var ErrConflictingLogFlags = errors.New("--quiet and --log-level cannot be used together")
+
 // GetLogFlags return the logging flags
 func GetLogFlags() []cli.Flag {
 	return []cli.Flag{
 		&cli.StringFlag{Name: "log-level", Usage: "the logging level", Value: "info"},
 		&cli.StringFlag{Name: "log-file", Usage: "path to the log file"},
+		&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}, Usage: "only output the warnings and errors"},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "the logging format: console, json or auto (console on a terminal, json otherwise)",
+			Value:   LogFormatAuto,
+			EnvVars: []string{"OPENDYDNS_LOG_FORMAT"},
+		},
 	}
 }
 
@@ -23,8 +45,19 @@ func ConfigureLogger(c *cli.Context) (zerolog.Logger, error) {
 		return zerolog.Logger{}, err
 	}
 
+	if c.Bool("quiet") {
+		if c.IsSet("log-level") {
+			return zerolog.Logger{}, ErrConflictingLogFlags
+		}
+		lvl = zerolog.WarnLevel
+	}
+
+	writer, err := newLogWriter(c.String("log-format"), os.Stdout)
+	if err != nil {
+		return zerolog.Logger{}, err
+	}
+
 	var writers []io.Writer
-	writer := zerolog.NewConsoleWriter()
 	writers = append(writers, writer)
 
 	if file := c.String("log-file"); file != "" {
@@ -42,3 +75,28 @@ func ConfigureLogger(c *cli.Context) (zerolog.Logger, error) {
 
 	return l.Level(lvl), nil
 }
+
+// newLogWriter return the writer formatting the logs written to out using given format
+// the log file, if any, is always written using the JSON format
+func newLogWriter(format string, out *os.File) (io.Writer, error) {
+	isTerminal := terminal.IsTerminal(int(out.Fd()))
+
+	if format == LogFormatAuto || format == "" {
+		format = LogFormatJSON
+		if isTerminal {
+			format = LogFormatConsole
+		}
+	}
+
+	switch format {
+	case LogFormatConsole:
+		return zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) {
+			w.Out = out
+			w.NoColor = !isTerminal
+		}), nil
+	case LogFormatJSON:
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown log format `%s`", format)
+	}
+}
@@ -1,16 +1,21 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestGetLogFlags(t *testing.T) {
 	flags := GetLogFlags()
 
-	if len(flags) != 2 {
+	if len(flags) != 4 {
 		t.Error("Wrong number of flags returned")
 	}
 
@@ -30,6 +35,104 @@ func TestConfigureLogger(t *testing.T) {
 	}
 }
 
+func TestConfigureLogger_Quiet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "test.log")
+
+	app := &cli.App{
+		Flags: GetLogFlags(),
+		Action: func(c *cli.Context) error {
+			l, err := ConfigureLogger(c)
+			if err != nil {
+				return err
+			}
+
+			l.Info().Msg("successfully registered alias.")
+			l.Error().Msg("error while registering alias.")
+			return nil
+		},
+	}
+
+	if err := app.Run([]string{"app", "-q", "--log-file", logFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "successfully registered alias.") {
+		t.Errorf("success output should have been suppressed: %s", b)
+	}
+	if !strings.Contains(string(b), "error while registering alias.") {
+		t.Errorf("error output should have been kept: %s", b)
+	}
+
+	if err := app.Run([]string{"app", "--quiet", "--log-level", "debug"}); err != ErrConflictingLogFlags {
+		t.Errorf("ConfigureLogger() should have returned ErrConflictingLogFlags")
+	}
+}
+
+func TestNewLogWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a file is not a terminal: auto means JSON
+	for _, format := range []string{LogFormatJSON, LogFormatAuto} {
+		entry := writeLogEntry(t, filepath.Join(dir, format+".log"), format)
+
+		var values map[string]interface{}
+		if err := json.Unmarshal([]byte(entry), &values); err != nil {
+			t.Fatalf("%s format: invalid JSON entry `%s`: %s", format, entry, err)
+		}
+		if values["message"] != "successfully registered alias." || values["Domain"] != "foo.example.org" || values["level"] != "info" {
+			t.Errorf("%s format: wrong entry: %v", format, values)
+		}
+	}
+
+	entry := writeLogEntry(t, filepath.Join(dir, "console.log"), LogFormatConsole)
+	if json.Valid([]byte(entry)) {
+		t.Errorf("console format: entry should not be JSON: %s", entry)
+	}
+	if !strings.Contains(entry, "successfully registered alias.") || !strings.Contains(entry, "Domain=foo.example.org") {
+		t.Errorf("console format: wrong entry: %s", entry)
+	}
+
+	if _, err := newLogWriter("xml", os.Stdout); err == nil {
+		t.Error("newLogWriter() should have failed")
+	}
+}
+
+func writeLogEntry(t *testing.T, path, format string) string {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, err := newLogWriter(format, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := zerolog.New(w)
+	l.Info().Str("Domain", "foo.example.org").Msg("successfully registered alias.")
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
 func run(c *cli.Context) error {
 	l, err := ConfigureLogger(c)
 	if err != nil {
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrNameNotFound is returned when the queried name doesn't exist (NXDOMAIN)
+var ErrNameNotFound = errors.New("name not found")
+
+// DNSRecords are the records of a name, as returned by a Resolver
+type DNSRecords struct {
+	A     []string
+	AAAA  []string
+	TXT   []string
+	CNAME string
+}
+
+// Resolver query a given DNS server directly, bypassing the system resolver
+type Resolver struct {
+	address string
+	client  *dns.Client
+}
+
+// NewResolver return a Resolver querying the DNS server at given address
+// the port defaults to 53 if missing
+func NewResolver(address string, timeout time.Duration) *Resolver {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+
+	return &Resolver{
+		address: address,
+		client:  &dns.Client{Timeout: timeout},
+	}
+}
+
+// NewDialer return a net.Dialer resolving the names using the DNS server at given address
+// instead of the system resolver. The address may be prefixed by tls:// to use DNS over TLS
+// the port defaults to 53, or 853 for DNS over TLS
+func NewDialer(address string, timeout time.Duration) *net.Dialer {
+	useTLS := strings.HasPrefix(address, "tls://")
+	address = strings.TrimPrefix(address, "tls://")
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		port := "53"
+		if useTLS {
+			port = "853"
+		}
+		address = net.JoinHostPort(address, port)
+	}
+
+	serverDialer := &net.Dialer{Timeout: timeout}
+
+	return &net.Dialer{
+		Timeout: timeout,
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				if !useTLS {
+					return serverDialer.DialContext(ctx, network, address)
+				}
+
+				// a stream connection makes the resolver use the TCP framing
+				host, _, _ := net.SplitHostPort(address)
+				return tls.DialWithDialer(serverDialer, "tcp", address, &tls.Config{ServerName: host})
+			},
+		},
+	}
+}
+
+// Lookup return all the A, AAAA, TXT & CNAME records of given name
+func (r *Resolver) Lookup(name string) (DNSRecords, error) {
+	var records DNSRecords
+	var err error
+
+	if records.A, err = r.LookupA(name); err != nil {
+		return DNSRecords{}, err
+	}
+	if records.AAAA, err = r.LookupAAAA(name); err != nil {
+		return DNSRecords{}, err
+	}
+	if records.TXT, err = r.LookupTXT(name); err != nil {
+		return DNSRecords{}, err
+	}
+	if records.CNAME, err = r.LookupCNAME(name); err != nil {
+		return DNSRecords{}, err
+	}
+
+	return records, nil
+}
+
+// LookupA return the IPv4 addresses of given name
+func (r *Resolver) LookupA(name string) ([]string, error) {
+	answers, err := r.query(name, dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, answer := range answers {
+		if a, ok := answer.(*dns.A); ok {
+			values = append(values, a.A.String())
+		}
+	}
+	return values, nil
+}
+
+// LookupAAAA return the IPv6 addresses of given name
+func (r *Resolver) LookupAAAA(name string) ([]string, error) {
+	answers, err := r.query(name, dns.TypeAAAA)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, answer := range answers {
+		if aaaa, ok := answer.(*dns.AAAA); ok {
+			values = append(values, aaaa.AAAA.String())
+		}
+	}
+	return values, nil
+}
+
+// LookupTXT return the TXT records of given name
+// the strings of a record are joined together
+func (r *Resolver) LookupTXT(name string) ([]string, error) {
+	answers, err := r.query(name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, answer := range answers {
+		if txt, ok := answer.(*dns.TXT); ok {
+			value := ""
+			for _, s := range txt.Txt {
+				value += s
+			}
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// LookupCNAME return the canonical name of given name, or an empty string if none
+func (r *Resolver) LookupCNAME(name string) (string, error) {
+	answers, err := r.query(name, dns.TypeCNAME)
+	if err != nil {
+		return "", err
+	}
+
+	for _, answer := range answers {
+		if cname, ok := answer.(*dns.CNAME); ok {
+			return cname.Target, nil
+		}
+	}
+	return "", nil
+}
+
+// LookupNS return the nameservers of given name, without their trailing dot
+func (r *Resolver) LookupNS(name string) ([]string, error) {
+	answers, err := r.query(name, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, answer := range answers {
+		if ns, ok := answer.(*dns.NS); ok {
+			values = append(values, strings.TrimSuffix(ns.Ns, "."))
+		}
+	}
+	return values, nil
+}
+
+func (r *Resolver) query(name string, qType uint16) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qType)
+	m.RecursionDesired = true
+
+	res, _, err := r.client.Exchange(m, r.address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.Rcode {
+	case dns.RcodeSuccess:
+		return res.Answer, nil
+	case dns.RcodeNameError:
+		return nil, ErrNameNotFound
+	default:
+		return nil, fmt.Errorf("DNS query failed: %s", dns.RcodeToString[res.Rcode])
+	}
+}
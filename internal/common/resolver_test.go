@@ -0,0 +1,171 @@
+package common
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolver_Lookup(t *testing.T) {
+	address, shutdown := startMockResolver(t, map[string][]string{
+		"foo.example.org.": {
+			"foo.example.org. 60 IN A 127.0.0.1",
+			"foo.example.org. 60 IN A 127.0.0.2",
+			"foo.example.org. 60 IN AAAA ::1",
+			"foo.example.org. 60 IN TXT \"hello \" \"world\"",
+		},
+		"www.example.org.": {
+			"www.example.org. 60 IN CNAME foo.example.org.",
+		},
+		"example.org.": {
+			"example.org. 3600 IN NS ns1.example.net.",
+			"example.org. 3600 IN NS ns2.example.net.",
+		},
+	})
+	defer shutdown()
+
+	r := NewResolver(address, time.Second)
+
+	records, err := r.Lookup("foo.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := DNSRecords{
+		A:    []string{"127.0.0.1", "127.0.0.2"},
+		AAAA: []string{"::1"},
+		TXT:  []string{"hello world"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("wrong records returned: %+v", records)
+	}
+
+	cname, err := r.LookupCNAME("www.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cname != "foo.example.org." {
+		t.Errorf("wrong CNAME returned: %s", cname)
+	}
+
+	ns, err := r.LookupNS("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ns, []string{"ns1.example.net", "ns2.example.net"}) {
+		t.Errorf("wrong nameservers returned: %v", ns)
+	}
+
+	if _, err := r.LookupA("bar.example.org"); err != ErrNameNotFound {
+		t.Errorf("LookupA() should have returned ErrNameNotFound")
+	}
+}
+
+func TestResolver_Timeout(t *testing.T) {
+	// nothing will ever answer
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := NewResolver(conn.LocalAddr().String(), 50*time.Millisecond)
+	if _, err := r.LookupA("foo.example.org"); err == nil {
+		t.Errorf("LookupA() should have failed")
+	}
+}
+
+func TestNewResolver_DefaultPort(t *testing.T) {
+	if r := NewResolver("1.1.1.1", time.Second); r.address != "1.1.1.1:53" {
+		t.Errorf("wrong address: %s", r.address)
+	}
+	if r := NewResolver("127.0.0.1:5353", time.Second); r.address != "127.0.0.1:5353" {
+		t.Errorf("wrong address: %s", r.address)
+	}
+}
+
+func TestNewDialer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("192.0.2.1"))
+	}))
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the name only exists on the custom resolver
+	address, shutdown := startMockResolver(t, map[string][]string{
+		"echo.test.": {"echo.test. 60 IN A 127.0.0.1"},
+	})
+	defer shutdown()
+
+	dialer := NewDialer(address, time.Second)
+	client := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+
+	res, err := client.Get("http://echo.test:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "192.0.2.1" {
+		t.Errorf("wrong body: %s", body)
+	}
+
+	if _, err := dialer.DialContext(context.Background(), "tcp", "unknown.test:"+port); err == nil {
+		t.Error("DialContext() should have failed to resolve an unknown name")
+	}
+}
+
+// startMockResolver start a DNS server answering with given records (zone file format)
+func startMockResolver(t *testing.T, zone map[string][]string) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		res := new(dns.Msg)
+		res.SetReply(req)
+
+		question := req.Question[0]
+		records, exist := zone[question.Name]
+		if !exist {
+			res.Rcode = dns.RcodeNameError
+		}
+
+		for _, record := range records {
+			rr, err := dns.NewRR(record)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if rr.Header().Rrtype == question.Qtype {
+				res.Answer = append(res.Answer, rr)
+			}
+		}
+
+		_ = w.WriteMsg(res)
+	})
+
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+
+	return conn.LocalAddr().String(), func() { _ = server.Shutdown() }
+}
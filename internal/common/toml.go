@@ -1,16 +1,36 @@
 package common
 
 import (
+	"errors"
 	"github.com/pelletier/go-toml"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+const (
+	lockRetryDelay = 10 * time.Millisecond
+	lockTimeout    = 5 * time.Second
+	// lock older than this are considered as left over by a crashed process
+	lockStaleAfter = 30 * time.Second
+)
+
+// ErrFileLocked is returned when the file cannot be locked before the timeout
+var ErrFileLocked = errors.New("file is locked by another process")
+
+// saveMutex serialize the writers of the current process
+var saveMutex sync.Mutex
+
 // LoadToml load given TOML file and decode it into given structure
 func LoadToml(path string, value interface{}) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	err = toml.NewDecoder(file).Decode(value)
 	if err != nil {
@@ -21,11 +41,116 @@ func LoadToml(path string, value interface{}) error {
 }
 
 // SaveToml save given structure in toml format into file located at given path
+// the file is replaced atomically, so it's never left half written
 func SaveToml(path string, value interface{}) error {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0640)
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return toml.NewEncoder(w).Encode(value)
+	})
+}
+
+// UpdateToml save given structure in toml format into file located at given path
+// only the changed keys of an existing file are rewritten: its comments and formatting are kept.
+// The file is rewritten entirely if it uses a syntax that cannot be patched
+func UpdateToml(path string, value interface{}) error {
+	encoded, err := toml.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		// read while holding the lock so a concurrent update is not lost
+		original, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if patched, err := patchToml(original, encoded); err == nil {
+			encoded = patched
+		}
+
+		_, err = w.Write(encoded)
+		return err
+	})
+}
+
+// SaveFile write given data into the file located at given path
+// the file is replaced atomically, so it's never left half written
+func SaveFile(path string, data []byte) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomic write the file located at given path using a temporary file
+// which is renamed once completely written. Concurrent writers are serialized
+// using a lock file.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// keep the permissions of the existing file if any
+	mode := os.FileMode(0640)
+	if stat, err := os.Stat(path); err == nil {
+		mode = stat.Mode().Perm()
+	}
+
+	// the temporary file must live in the same directory to allow atomic rename
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// lockFile acquire the lock associated to given path
+// and return the function to call to release it
+func lockFile(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
 
-	return toml.NewEncoder(file).Encode(value)
+		// reclaim lock left over by a crashed process
+		if stat, err := os.Stat(lockPath); err == nil && time.Since(stat.ModTime()) > lockStaleAfter {
+			_ = os.Remove(lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrFileLocked
+		}
+		time.Sleep(lockRetryDelay)
+	}
 }
@@ -0,0 +1,249 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Token   string
+	Aliases map[string]string
+}
+
+func TestSaveToml(t *testing.T) {
+	dir, path := tempConfigPath(t)
+	defer os.RemoveAll(dir)
+
+	// write a longer file first to make sure the new one is not appended to it
+	if err := SaveToml(path, testConfig{Token: "a-very-long-token", Aliases: map[string]string{"foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveToml(path, testConfig{Token: "short"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var c testConfig
+	if err := LoadToml(path, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "short" || len(c.Aliases) != 0 {
+		t.Errorf("wrong config loaded: %v", c)
+	}
+
+	assertNoLeftOver(t, dir)
+}
+
+type testAliasConfig struct {
+	Synchronize bool
+}
+
+type testCommentedConfig struct {
+	APIAddr  string
+	Token    string
+	Insecure bool
+	Aliases  map[string]testAliasConfig
+}
+
+func TestUpdateToml(t *testing.T) {
+	dir, path := tempConfigPath(t)
+	defer os.RemoveAll(dir)
+
+	original := `# the daemon to talk to
+APIAddr = "https://dydns.example.org" # production
+
+Token = "old-token"
+
+[Aliases]
+  # my laptop
+  [Aliases."foo.example.org"]
+    Synchronize = true
+
+  [Aliases."old.example.org"]
+    Synchronize = false
+`
+	if err := ioutil.WriteFile(path, []byte(original), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testCommentedConfig{
+		APIAddr: "https://dydns.example.org",
+		Token:   "new-token",
+		Aliases: map[string]testAliasConfig{
+			"foo.example.org": {Synchronize: true},
+			"bar.example.org": {Synchronize: true},
+		},
+	}
+	if err := UpdateToml(path, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	// changed keys are rewritten in place, added ones are appended to their table & removed tables are dropped
+	expected := `# the daemon to talk to
+APIAddr = "https://dydns.example.org" # production
+
+Token = "new-token"
+Insecure = false
+
+[Aliases]
+  # my laptop
+  [Aliases."foo.example.org"]
+    Synchronize = true
+
+  [Aliases."bar.example.org"]
+    Synchronize = true
+`
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Errorf("wrong file content:\n%s", b)
+	}
+
+	var c testCommentedConfig
+	if err := LoadToml(path, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "new-token" || len(c.Aliases) != 2 || !c.Aliases["bar.example.org"].Synchronize {
+		t.Errorf("wrong config loaded: %v", c)
+	}
+
+	assertNoLeftOver(t, dir)
+}
+
+func TestUpdateToml_NotPatchable(t *testing.T) {
+	dir, path := tempConfigPath(t)
+	defer os.RemoveAll(dir)
+
+	// arrays of tables are not patched: the file is rewritten
+	if err := ioutil.WriteFile(path, []byte("# comment\nToken = \"old\"\n[[Servers]]\n  Name = \"a\"\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateToml(path, testConfig{Token: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var c testConfig
+	if err := LoadToml(path, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "new" {
+		t.Errorf("wrong config loaded: %v", c)
+	}
+
+	// missing file
+	if err := UpdateToml(filepath.Join(dir, "new.toml"), testConfig{Token: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadToml(filepath.Join(dir, "new.toml"), &c); err != nil || c.Token != "new" {
+		t.Errorf("wrong config loaded: %v (%v)", c, err)
+	}
+}
+
+func TestWriteFileAtomic_Interrupted(t *testing.T) {
+	dir, path := tempConfigPath(t)
+	defer os.RemoveAll(dir)
+
+	if err := SaveToml(path, testConfig{Token: "original"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a write failing in the middle
+	writeErr := errors.New("disk full")
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		_, _ = w.Write([]byte("Token = \"trunc"))
+		return writeErr
+	})
+	if err != writeErr {
+		t.Errorf("writeFileAtomic() should have returned the write error")
+	}
+
+	var c testConfig
+	if err := LoadToml(path, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "original" {
+		t.Errorf("original file has been altered: %v", c)
+	}
+
+	assertNoLeftOver(t, dir)
+}
+
+func TestSaveToml_Concurrent(t *testing.T) {
+	dir, path := tempConfigPath(t)
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := SaveToml(path, testConfig{Token: fmt.Sprintf("token-%d", i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var c testConfig
+	if err := LoadToml(path, &c); err != nil {
+		t.Fatalf("config file is corrupted: %s", err)
+	}
+
+	assertNoLeftOver(t, dir)
+}
+
+func TestLockFile(t *testing.T) {
+	dir, path := tempConfigPath(t)
+	defer os.RemoveAll(dir)
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	// stale lock must be reclaimed
+	if err := ioutil.WriteFile(path+".lock", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStaleAfter)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err = lockFile(path)
+	if err != nil {
+		t.Errorf("stale lock should have been reclaimed: %s", err)
+	}
+	unlock()
+}
+
+func tempConfigPath(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "opendydns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, filepath.Join(dir, "config.toml")
+}
+
+func assertNoLeftOver(t *testing.T, dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		if f.Name() != "config.toml" {
+			t.Errorf("unexpected left over file: %s", f.Name())
+		}
+	}
+}
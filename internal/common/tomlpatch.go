@@ -0,0 +1,372 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errNotPatchable is returned when a TOML document uses a syntax the patcher does not handle
+var errNotPatchable = errors.New("toml document cannot be patched")
+
+var bareKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlSection is a table of the original document
+type tomlSection struct {
+	path    []string
+	seen    map[string]bool // the keys found in the section
+	lastKey int             // index of the last output line holding a key of the section
+	indent  string          // indentation of the keys of the section
+}
+
+// patchToml rewrite the keys of the original document whose value differs in the encoded one,
+// add the missing keys & tables and remove the ones which are gone. Everything else
+// (comments, blank lines, ordering, formatting) is kept as is.
+func patchToml(original, encoded []byte) ([]byte, error) {
+	if len(strings.TrimSpace(string(original))) == 0 {
+		return nil, errNotPatchable
+	}
+
+	oldTree, err := toml.LoadBytes(original)
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := toml.LoadBytes(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	seenTables := map[string]bool{}
+	section := &tomlSection{seen: map[string]bool{}, lastKey: -1}
+	skipping := false
+
+	closeSection := func() error {
+		if skipping {
+			return nil
+		}
+
+		missing, err := missingKeys(newTree, section)
+		if err != nil {
+			return err
+		}
+
+		// insert after the last key of the section (or its header), before the next table
+		at := len(out)
+		if section.lastKey != -1 {
+			at = section.lastKey + 1
+		}
+		out = append(out[:at], append(missing, out[at:]...)...)
+		return nil
+	}
+
+	for _, line := range strings.Split(string(original), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "[") {
+			if strings.HasPrefix(trimmed, "[[") {
+				return nil, errNotPatchable // arrays of tables are not handled
+			}
+
+			if err := closeSection(); err != nil {
+				return nil, err
+			}
+
+			header, _ := splitComment(trimmed)
+			if !strings.HasSuffix(header, "]") {
+				return nil, errNotPatchable
+			}
+			path, err := splitKey(header[1 : len(header)-1])
+			if err != nil {
+				return nil, err
+			}
+
+			// the whole section is dropped if the table is gone
+			skipping = !isTable(newTree, path)
+			section = &tomlSection{
+				path:    path,
+				seen:    map[string]bool{},
+				lastKey: -1,
+				indent:  strings.Repeat("  ", len(path)),
+			}
+			if !skipping {
+				seenTables[joinKey(path)] = true
+				out = append(out, line)
+				section.lastKey = len(out) - 1
+			}
+			continue
+		}
+
+		if skipping {
+			continue
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			out = append(out, line)
+			continue
+		}
+
+		patched, keep, err := patchKeyLine(line, section, oldTree, newTree)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			out = append(out, patched)
+			section.lastKey = len(out) - 1
+		}
+	}
+
+	if err := closeSection(); err != nil {
+		return nil, err
+	}
+
+	result := strings.Join(out, "\n")
+
+	// the tables which do not exist in the original document are appended
+	added, err := missingTables(newTree, nil, seenTables)
+	if err != nil {
+		return nil, err
+	}
+	if len(added) > 0 {
+		result = strings.TrimRight(result, "\n") + "\n" + strings.Join(added, "\n") + "\n"
+	}
+
+	// make sure the patched document holds the wanted values
+	patchedTree, err := toml.LoadBytes([]byte(result))
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(patchedTree.ToMap(), newTree.ToMap()) {
+		return nil, errNotPatchable
+	}
+
+	return []byte(result), nil
+}
+
+// patchKeyLine patch the `key = value` line using the value from the new tree
+// keep is false if the key does not exist anymore
+func patchKeyLine(line string, section *tomlSection, oldTree, newTree *toml.Tree) (string, bool, error) {
+	eq := indexOutsideQuotes(line, '=')
+	if eq == -1 {
+		return "", false, errNotPatchable // multi-line value
+	}
+
+	keyPath, err := splitKey(line[:eq])
+	if err != nil {
+		return "", false, err
+	}
+	path := append(append([]string{}, section.path...), keyPath...)
+	section.seen[joinKey(keyPath)] = true
+	section.indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+
+	newValue := newTree.GetPath(path)
+	if newValue == nil {
+		return "", false, nil
+	}
+	if _, isTree := newValue.(*toml.Tree); isTree {
+		return "", false, errNotPatchable // inline table
+	}
+	if reflect.DeepEqual(oldTree.GetPath(path), newValue) {
+		return line, true, nil
+	}
+
+	rendered, err := renderValue(newValue)
+	if err != nil {
+		return "", false, err
+	}
+
+	_, comment := splitComment(line[eq+1:])
+	if comment != "" {
+		comment = " " + comment
+	}
+
+	return strings.TrimRight(line[:eq], " \t") + " = " + rendered + comment, true, nil
+}
+
+// missingKeys render the values of the new tree at the section path not present in the section
+func missingKeys(newTree *toml.Tree, section *tomlSection) ([]string, error) {
+	table := newTree
+	if len(section.path) > 0 {
+		t, ok := newTree.GetPath(section.path).(*toml.Tree)
+		if !ok {
+			return nil, nil
+		}
+		table = t
+	}
+
+	var lines []string
+	for _, key := range sortedKeys(table) {
+		value := table.GetPath([]string{key})
+		if _, isTree := value.(*toml.Tree); isTree || section.seen[quoteKey(key)] {
+			continue
+		}
+
+		rendered, err := renderValue(value)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, section.indent+quoteKey(key)+" = "+rendered)
+	}
+
+	return lines, nil
+}
+
+// missingTables render the tables of given tree not present in the original document
+func missingTables(tree *toml.Tree, path []string, seenTables map[string]bool) ([]string, error) {
+	var lines []string
+
+	keys := sortedKeys(tree)
+	hasValues, hasTables := false, false
+	for _, key := range keys {
+		if _, isTree := tree.GetPath([]string{key}).(*toml.Tree); isTree {
+			hasTables = true
+		} else {
+			hasValues = true
+		}
+	}
+
+	if len(path) > 0 && !seenTables[joinKey(path)] && (hasValues || !hasTables) {
+		lines = append(lines, "", strings.Repeat("  ", len(path)-1)+"["+joinKey(path)+"]")
+		for _, key := range keys {
+			value := tree.GetPath([]string{key})
+			if _, isTree := value.(*toml.Tree); isTree {
+				continue
+			}
+
+			rendered, err := renderValue(value)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, strings.Repeat("  ", len(path))+quoteKey(key)+" = "+rendered)
+		}
+	}
+
+	for _, key := range keys {
+		if subTree, isTree := tree.GetPath([]string{key}).(*toml.Tree); isTree {
+			subLines, err := missingTables(subTree, append(append([]string{}, path...), key), seenTables)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, subLines...)
+		}
+	}
+
+	return lines, nil
+}
+
+// renderValue return the TOML representation of given value
+func renderValue(value interface{}) (string, error) {
+	tree, err := toml.TreeFromMap(map[string]interface{}{"v": value})
+	if err != nil {
+		return "", err
+	}
+
+	s, err := tree.ToTomlString()
+	if err != nil {
+		return "", err
+	}
+
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "v = ") || strings.Contains(s, "\n") {
+		return "", errNotPatchable
+	}
+	return strings.TrimPrefix(s, "v = "), nil
+}
+
+// isTable determinate if given path is a table of the tree
+func isTable(tree *toml.Tree, path []string) bool {
+	_, ok := tree.GetPath(path).(*toml.Tree)
+	return ok
+}
+
+// splitKey split a (dotted) TOML key into its parts
+func splitKey(key string) ([]string, error) {
+	var parts []string
+
+	for _, part := range splitOutsideQuotes(key, '.') {
+		part = strings.TrimSpace(part)
+
+		switch {
+		case strings.HasPrefix(part, `"`):
+			unquoted, err := strconv.Unquote(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key `%s`: %w", key, err)
+			}
+			part = unquoted
+		case strings.HasPrefix(part, "'") && strings.HasSuffix(part, "'") && len(part) >= 2:
+			part = part[1 : len(part)-1]
+		case !bareKeyRegex.MatchString(part):
+			return nil, fmt.Errorf("invalid key `%s`", key)
+		}
+
+		parts = append(parts, part)
+	}
+
+	return parts, nil
+}
+
+// joinKey return the dotted TOML key of given parts
+func joinKey(parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = quoteKey(part)
+	}
+	return strings.Join(quoted, ".")
+}
+
+func quoteKey(key string) string {
+	if bareKeyRegex.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+func sortedKeys(tree *toml.Tree) []string {
+	keys := tree.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+// splitComment split given line into its content and its trailing comment
+func splitComment(line string) (string, string) {
+	if i := indexOutsideQuotes(line, '#'); i != -1 {
+		return strings.TrimSpace(line[:i]), line[i:]
+	}
+	return strings.TrimSpace(line), ""
+}
+
+// indexOutsideQuotes return the index of the first given character not inside a string
+func indexOutsideQuotes(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++ // escaped character
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote == 0 && (s[i] == '"' || s[i] == '\''):
+			quote = s[i]
+		case quote == 0 && s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitOutsideQuotes split given string on the given character when not inside a string
+func splitOutsideQuotes(s string, c byte) []string {
+	var parts []string
+	for {
+		i := indexOutsideQuotes(s, c)
+		if i == -1 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
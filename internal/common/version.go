@@ -0,0 +1,4 @@
+package common
+
+// Version is the current OpenDyDNS version, shared by the daemon and the CLI
+const Version = "0.3.0"
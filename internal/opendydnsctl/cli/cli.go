@@ -1,11 +1,21 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/client"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/rs/zerolog"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 // ErrBadRequest is returned when function is calling with missing parameters
@@ -14,44 +24,104 @@ var ErrBadRequest = fmt.Errorf("missing parameters")
 // ErrAlreadyLoggedIn is returned when trying to log-in but already logged in
 var ErrAlreadyLoggedIn = fmt.Errorf("already logged in")
 
+// ErrNotLoggedIn is returned when trying to log-out but not logged in
+var ErrNotLoggedIn = fmt.Errorf("not logged in")
+
+// ErrUpdateCheckDisabled is returned when checking for update while the check is disabled
+var ErrUpdateCheckDisabled = fmt.Errorf("update check disabled")
+
+// ErrDomainNotManaged is returned when an alias is not under one of the managed domains
+var ErrDomainNotManaged = fmt.Errorf("domain not managed by the daemon")
+
+// ErrAmbiguousDomain is returned when a bare host is given while several domains are managed
+var ErrAmbiguousDomain = fmt.Errorf("several domains are managed, please give the full alias name")
+
+// ErrUnknownField is returned when selecting a field which does not exist
+var ErrUnknownField = fmt.Errorf("unknown field")
+
+// ErrInvalidIP is returned when an IP address cannot be published
+var ErrInvalidIP = fmt.Errorf("invalid IP address")
+
 // AliasStatus represent an alias as viewed by the CLI app
 type AliasStatus struct {
 	proto.AliasDto
 	Synchronize bool
 }
 
+// AliasGroup represent aliases sharing the same base domain
+type AliasGroup struct {
+	Domain  string
+	Aliases []AliasStatus
+}
+
 // CLI represent a instance of the cli application
 type CLI interface {
 	Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error)
-	GetAliases() ([]AliasStatus, error)
+	Reauthenticate(cred proto.CredentialsDto) (proto.TokenDto, error)
+	Register(registration proto.RegistrationDto) (proto.RegisteredUserDto, error)
+	VerifyEmail(token string) (proto.TokenDto, error)
+	Logout(all bool) error
+	TokenInfo() (TokenInfo, error)
+	GetAliases(fields ...string) ([]AliasStatus, error)
+	GetAliasesByName(names []string) ([]AliasStatus, []string, error)
 	RegisterAlias(alias proto.AliasDto) (proto.AliasDto, error)
 	UpdateAlias(alias proto.AliasDto) (proto.AliasDto, error)
+	PatchAlias(aliasName string, patch proto.AliasPatchDto) (proto.AliasDto, error)
 	DeleteAlias(aliasName string) error
+	SetTTL(aliasName string, ttl int) (proto.AliasDto, error)
+	SetEnabled(aliasName string, enabled bool) (proto.AliasDto, error)
+	RenameAlias(aliasName, newName string) (proto.AliasDto, error)
 	GetDomains() ([]proto.DomainDto, error)
+	GetLimits() (proto.UserLimitsDto, error)
+	GetAllAliases(cursor string, limit int, filter proto.AliasFilterDto) ([]proto.OwnedAliasDto, string, error)
+	GetUserAliases(email, cursor string, limit int) ([]proto.AliasDto, string, error)
+	GetDNSStatus() (proto.DNSStatusDto, error)
+	GetDelegations() ([]proto.DelegationDto, error)
+	ExportZone(domain string) (proto.ZoneDto, error)
+	GetWebhooks() ([]proto.WebhookDto, error)
+	CreateWebhook(webhook proto.WebhookDto) (proto.WebhookDto, error)
+	DeleteWebhook(id uint) error
+	CreateShare(aliasName string, expiresIn time.Duration) (proto.ShareDto, error)
+	GetShares() ([]proto.ShareDto, error)
+	DeleteShare(id uint) error
+	ShareURL(shareToken string) string
 	SetSynchronize(aliasName string, status bool) error
-	Synchronize(IP string) error
+	Synchronize(IPs ...string) error
+	SyncConfig() config.SyncConfig
+	GetIP() (string, error)
+	GetEchoIP() (string, error)
+	DetectIPs() ([]string, error)
+	CheckVersion(current string) (string, bool, error)
+	Reload() error
 }
 
+// DefaultEchoURL is the URL of the service returning the IP of the caller
+const DefaultEchoURL = "https://ifconfig.me/ip"
+
 type cli struct {
 	tok          proto.TokenDto
 	logger       *zerolog.Logger
 	conf         config.Config
 	confProvider config.Provider
 	apiClient    proto.APIContract
+	clientOpts   client.Options
+	timeout      time.Duration
+	echoURL      string
 }
 
 // NewCLI instantiate a new CLI instance
-func NewCLI(confPath string, logger *zerolog.Logger) (CLI, error) {
-	provider := config.NewFileProvider(confPath)
+func NewCLI(confPath string, clientOpts client.Options, logger *zerolog.Logger) (CLI, error) {
+	provider := config.NewEnvProvider(confPath)
 
 	// Load the configuration file
-	conf, err := provider.Load()
+	conf, err := loadConfig(provider)
 	if err != nil {
 		return nil, err
 	}
 
-	if !conf.Valid() {
-		return nil, fmt.Errorf("invalid config file")
+	opts := mergeClientOptions(clientOpts, conf)
+	if opts.Insecure {
+		logger.Warn().Msg("the daemon certificate is NOT verified: the connection is insecure. use for development only!")
 	}
 
 	return &cli{
@@ -59,10 +129,53 @@ func NewCLI(confPath string, logger *zerolog.Logger) (CLI, error) {
 		logger:       logger,
 		conf:         conf,
 		confProvider: provider,
-		apiClient:    client.NewClient(conf.APIAddr),
+		apiClient:    client.NewClient(conf.APIAddr, opts),
+		clientOpts:   clientOpts,
+		timeout:      clientOpts.Timeout,
+		echoURL:      DefaultEchoURL,
 	}, nil
 }
 
+// Reload read the configuration again, to pick up the changes made meanwhile
+// (e.g. a new token or aliases to synchronize) by a long running command
+// the current configuration is kept if the new one cannot be loaded
+func (c *cli) Reload() error {
+	conf, err := loadConfig(c.confProvider)
+	if err != nil {
+		return err
+	}
+
+	c.conf = conf
+	c.tok = proto.TokenDto{Token: conf.Token}
+	c.apiClient = client.NewClient(conf.APIAddr, mergeClientOptions(c.clientOpts, conf))
+	return nil
+}
+
+func loadConfig(provider config.Provider) (config.Config, error) {
+	conf, err := provider.Load()
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	if !conf.Valid() {
+		return config.Config{}, fmt.Errorf("invalid config file")
+	}
+
+	return conf, nil
+}
+
+// mergeClientOptions return the client options overridden by the configuration
+func mergeClientOptions(opts client.Options, conf config.Config) client.Options {
+	if conf.Insecure {
+		opts.Insecure = true
+	}
+	if conf.UserAgent != "" {
+		opts.UserAgent = conf.UserAgent
+	}
+
+	return opts
+}
+
 func (c *cli) Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error) {
 	if cred.Email == "" || cred.Password == "" {
 		return proto.TokenDto{}, ErrBadRequest
@@ -73,26 +186,164 @@ func (c *cli) Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error) {
 		return proto.TokenDto{}, ErrAlreadyLoggedIn
 	}
 
+	return c.login(cred)
+}
+
+// Reauthenticate replace the saved access token (e.g. expired or revoked) by a new one
+// the saved token is kept if the authentication fails
+func (c *cli) Reauthenticate(cred proto.CredentialsDto) (proto.TokenDto, error) {
+	if cred.Email == "" || cred.Password == "" {
+		return proto.TokenDto{}, ErrBadRequest
+	}
+
+	if c.conf.Token == "" {
+		return proto.TokenDto{}, ErrNotLoggedIn
+	}
+
+	return c.login(cred)
+}
+
+// login authenticate using given credentials and save the obtained token
+func (c *cli) login(cred proto.CredentialsDto) (proto.TokenDto, error) {
 	token, err := c.apiClient.Authenticate(cred)
 	if err != nil {
 		return proto.TokenDto{}, err
 	}
 
 	// save token
+	c.tok = proto.TokenDto{Token: token.Token}
 	c.conf.Token = token.Token
 	if err := c.saveConfig(); err != nil {
 		return proto.TokenDto{}, err
 	}
 
-	return proto.TokenDto{Token: c.conf.Token}, nil
+	return proto.TokenDto{Token: c.conf.Token, MOTD: token.MOTD}, nil
 }
 
-func (c *cli) GetAliases() ([]AliasStatus, error) {
-	aliases, err := c.apiClient.GetAliases(c.tok)
+// Register create an account on the Daemon
+// the user is logged in if the email address doesn't need to be verified
+func (c *cli) Register(registration proto.RegistrationDto) (proto.RegisteredUserDto, error) {
+	if registration.Email == "" || registration.Password == "" {
+		return proto.RegisteredUserDto{}, ErrBadRequest
+	}
+
+	if c.conf.Token != "" {
+		return proto.RegisteredUserDto{}, ErrAlreadyLoggedIn
+	}
+
+	user, err := c.apiClient.Register(registration)
+	if err != nil {
+		return proto.RegisteredUserDto{}, err
+	}
+
+	if user.Token != "" {
+		c.conf.Token = user.Token
+		if err := c.saveConfig(); err != nil {
+			return proto.RegisteredUserDto{}, err
+		}
+	}
+
+	return user, nil
+}
+
+// VerifyEmail activate the account using the token received by email, and log in
+func (c *cli) VerifyEmail(token string) (proto.TokenDto, error) {
+	if token == "" {
+		return proto.TokenDto{}, ErrBadRequest
+	}
+
+	if c.conf.Token != "" {
+		return proto.TokenDto{}, ErrAlreadyLoggedIn
+	}
+
+	tok, err := c.apiClient.VerifyEmail(proto.VerificationDto{Token: token})
+	if err != nil {
+		return proto.TokenDto{}, err
+	}
+
+	c.conf.Token = tok.Token
+	if err := c.saveConfig(); err != nil {
+		return proto.TokenDto{}, err
+	}
+
+	return tok, nil
+}
+
+// Logout forget the saved token
+// if all is true, every token of the user are revoked first (logout everywhere)
+func (c *cli) Logout(all bool) error {
+	if c.conf.Token == "" {
+		return ErrNotLoggedIn
+	}
+
+	if all {
+		if err := c.apiClient.RevokeTokens(c.tok); err != nil {
+			return err
+		}
+	}
+
+	c.conf.Token = ""
+	c.tok = proto.TokenDto{}
+	return c.saveConfig()
+}
+
+// TokenInfo decode the saved access token, without verifying it
+func (c *cli) TokenInfo() (TokenInfo, error) {
+	if c.conf.Token == "" {
+		return TokenInfo{}, ErrNotLoggedIn
+	}
+
+	return ParseTokenInfo(c.conf.Token)
+}
+
+// GetAliases return the user aliases, restricted to given fields (JSON names) if any
+func (c *cli) GetAliases(fields ...string) ([]AliasStatus, error) {
+	aliases, err := c.apiClient.GetAliases(c.token(), daemonFields(fields)...)
 	if err != nil {
 		return nil, err
 	}
 
+	return c.aliasStatuses(aliases), nil
+}
+
+// daemonFields return the given fields to request from the daemon
+// the synchronization status is local, but needs the alias name to be looked up
+func daemonFields(fields []string) []string {
+	var result []string
+	synchronize, domain := false, false
+	for _, field := range fields {
+		switch field {
+		case "synchronize":
+			synchronize = true
+			continue
+		case "domain":
+			domain = true
+		}
+		result = append(result, field)
+	}
+
+	if synchronize && !domain && len(result) > 0 {
+		result = append(result, "domain")
+	}
+
+	return result
+}
+
+func (c *cli) GetAliasesByName(names []string) ([]AliasStatus, []string, error) {
+	if len(names) == 0 {
+		return nil, nil, ErrBadRequest
+	}
+
+	result, err := c.apiClient.GetAliasesByName(c.token(), names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c.aliasStatuses(result.Aliases), result.Missing, nil
+}
+
+// aliasStatuses attach the local synchronization status to given aliases
+func (c *cli) aliasStatuses(aliases []proto.AliasDto) []AliasStatus {
 	var aliasStatuses []AliasStatus
 
 	for _, alias := range aliases {
@@ -108,23 +359,63 @@ func (c *cli) GetAliases() ([]AliasStatus, error) {
 		})
 	}
 
-	return aliasStatuses, nil
+	return aliasStatuses
 }
 
 func (c *cli) RegisterAlias(alias proto.AliasDto) (proto.AliasDto, error) {
-	if alias.Domain == "" || alias.Value == "" {
+	// the value of an alias following another name is resolved by the daemon
+	if alias.Domain == "" || (alias.Value == "" && alias.Value6 == "" && alias.Follow == "") {
 		return proto.AliasDto{}, ErrBadRequest
 	}
 
-	return c.apiClient.RegisterAlias(c.tok, alias)
+	return c.apiClient.RegisterAlias(c.token(), splitAddresses(alias))
 }
 
+// UpdateAlias update the given addresses of the alias, the address of the other family is kept
 func (c *cli) UpdateAlias(alias proto.AliasDto) (proto.AliasDto, error) {
-	if alias.Domain == "" || alias.Value == "" {
+	if alias.Domain == "" || (alias.Value == "" && alias.Value6 == "") {
 		return proto.AliasDto{}, ErrBadRequest
 	}
 
-	return c.apiClient.UpdateAlias(c.tok, alias)
+	return c.apiClient.UpdateAlias(c.token(), splitAddresses(alias))
+}
+
+// splitAddresses make sure an IPv6 address given as the value is sent as the AAAA record one
+func splitAddresses(alias proto.AliasDto) proto.AliasDto {
+	if alias.Value6 == "" && strings.Contains(alias.Value, ":") {
+		alias.Value, alias.Value6 = "", alias.Value
+	}
+	return alias
+}
+
+// AliasAddresses sort given IP addresses by family: at most one IPv4 and one IPv6 address
+func AliasAddresses(ips ...string) (string, string, error) {
+	var value, value6 string
+
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return "", "", fmt.Errorf("%w: %s", ErrInvalidIP, ip)
+		}
+
+		if strings.Contains(ip, ":") {
+			if value6 != "" || parsed.To4() != nil {
+				return "", "", fmt.Errorf("%w: %s", ErrInvalidIP, ip)
+			}
+			value6 = ip
+		} else {
+			if value != "" {
+				return "", "", fmt.Errorf("%w: %s", ErrInvalidIP, ip)
+			}
+			value = ip
+		}
+	}
+
+	if value == "" && value6 == "" {
+		return "", "", ErrBadRequest
+	}
+
+	return value, value6, nil
 }
 
 func (c *cli) DeleteAlias(aliasName string) error {
@@ -132,11 +423,117 @@ func (c *cli) DeleteAlias(aliasName string) error {
 		return ErrBadRequest
 	}
 
-	return c.apiClient.DeleteAlias(c.tok, aliasName)
+	return c.apiClient.DeleteAlias(c.token(), aliasName)
+}
+
+func (c *cli) SetEnabled(aliasName string, enabled bool) (proto.AliasDto, error) {
+	if aliasName == "" {
+		return proto.AliasDto{}, ErrBadRequest
+	}
+
+	return c.apiClient.SetAliasEnabled(c.token(), aliasName, enabled)
+}
+
+// RenameAlias rename given alias
+// the local configuration of the alias (synchronization) follows it
+func (c *cli) RenameAlias(aliasName, newName string) (proto.AliasDto, error) {
+	if aliasName == "" || newName == "" {
+		return proto.AliasDto{}, ErrBadRequest
+	}
+
+	alias, err := c.apiClient.RenameAlias(c.token(), aliasName, newName)
+	if err != nil {
+		return proto.AliasDto{}, err
+	}
+
+	if aliasConfig, exist := c.conf.Aliases[aliasName]; exist {
+		delete(c.conf.Aliases, aliasName)
+		c.conf.Aliases[alias.Domain] = aliasConfig
+		if err := c.saveConfig(); err != nil {
+			return proto.AliasDto{}, err
+		}
+	}
+
+	return alias, nil
+}
+
+// SetTTL change the TTL of given alias, leaving the other fields unchanged
+func (c *cli) SetTTL(aliasName string, ttl int) (proto.AliasDto, error) {
+	if aliasName == "" || ttl <= 0 {
+		return proto.AliasDto{}, ErrBadRequest
+	}
+
+	return c.PatchAlias(aliasName, proto.AliasPatchDto{TTL: &ttl})
+}
+
+// PatchAlias update the given fields of an alias, the others are left unchanged
+func (c *cli) PatchAlias(aliasName string, patch proto.AliasPatchDto) (proto.AliasDto, error) {
+	if aliasName == "" {
+		return proto.AliasDto{}, ErrBadRequest
+	}
+
+	return c.apiClient.PatchAlias(c.token(), aliasName, patch)
 }
 
 func (c *cli) GetDomains() ([]proto.DomainDto, error) {
-	return c.apiClient.GetDomains(c.tok)
+	return c.apiClient.GetDomains(c.token())
+}
+
+func (c *cli) GetAllAliases(cursor string, limit int, filter proto.AliasFilterDto) ([]proto.OwnedAliasDto, string, error) {
+	return c.apiClient.GetAllAliases(c.token(), cursor, limit, filter)
+}
+
+func (c *cli) GetUserAliases(email, cursor string, limit int) ([]proto.AliasDto, string, error) {
+	if email == "" {
+		return nil, "", ErrBadRequest
+	}
+
+	return c.apiClient.GetUserAliases(c.token(), email, cursor, limit)
+}
+
+func (c *cli) GetDNSStatus() (proto.DNSStatusDto, error) {
+	return c.apiClient.GetDNSStatus(c.token())
+}
+
+func (c *cli) GetLimits() (proto.UserLimitsDto, error) {
+	return c.apiClient.GetLimits(c.token())
+}
+
+func (c *cli) GetDelegations() ([]proto.DelegationDto, error) {
+	return c.apiClient.GetDelegations(c.token())
+}
+
+func (c *cli) ExportZone(domain string) (proto.ZoneDto, error) {
+	return c.apiClient.ExportZone(c.token(), domain)
+}
+
+func (c *cli) GetWebhooks() ([]proto.WebhookDto, error) {
+	return c.apiClient.GetWebhooks(c.token())
+}
+
+func (c *cli) CreateWebhook(webhook proto.WebhookDto) (proto.WebhookDto, error) {
+	return c.apiClient.CreateWebhook(c.token(), webhook)
+}
+
+func (c *cli) DeleteWebhook(id uint) error {
+	return c.apiClient.DeleteWebhook(c.token(), id)
+}
+
+func (c *cli) CreateShare(aliasName string, expiresIn time.Duration) (proto.ShareDto, error) {
+	return c.apiClient.CreateShare(c.token(), aliasName, expiresIn)
+}
+
+func (c *cli) GetShares() ([]proto.ShareDto, error) {
+	return c.apiClient.GetShares(c.token())
+}
+
+func (c *cli) DeleteShare(id uint) error {
+	return c.apiClient.DeleteShare(c.token(), id)
+}
+
+// ShareURL return the URL giving access to the alias shared using given token
+func (c *cli) ShareURL(shareToken string) string {
+	return strings.TrimSuffix(c.conf.APIAddr, "/") + "/shared/" + url.PathEscape(shareToken)
 }
 
 func (c *cli) SetSynchronize(aliasName string, status bool) error {
@@ -161,25 +558,483 @@ func (c *cli) SetSynchronize(aliasName string, status bool) error {
 	return nil
 }
 
-func (c *cli) Synchronize(ip string) error {
+// Synchronize update the synchronized aliases using given IP addresses, at most one per family
+// the aliases rejected by the daemon are logged & skipped: an error is only returned if it may
+// go away by retrying later (the daemon cannot be reached or failed)
+func (c *cli) Synchronize(ips ...string) error {
+	var syncErr error
 	for name, conf := range c.conf.Aliases {
 		if !conf.Synchronize {
 			continue
 		}
 
+		value, value6, err := publishedAddresses(conf, ips)
+		if err != nil {
+			c.logger.Err(err).Str("Domain", name).Strs("IPs", ips).Msg("invalid alias value, see the configuration file.")
+			continue
+		}
+
 		if _, err := c.UpdateAlias(proto.AliasDto{
 			Domain: name,
-			Value:  ip,
+			Value:  value,
+			Value6: value6,
 		}); err != nil {
-			c.logger.Err(err).Str("Domain", name).Str("Value", ip).Msg("error while updating alias.")
+			c.logger.Err(err).Str("Domain", name).Str("Value", value).Str("Value6", value6).Msg("error while updating alias.")
+			if syncErr == nil && isTransient(err) {
+				syncErr = fmt.Errorf("unable to update alias %s: %w", name, err)
+			}
 		} else {
-			c.logger.Info().Str("Domain", name).Str("Value", ip).Msg("successfully updated alias.")
+			c.logger.Info().Str("Domain", name).Str("Value", value).Str("Value6", value6).Msg("successfully updated alias.")
 		}
 	}
 
-	return nil
+	return syncErr
+}
+
+// publishedAddresses return the IPv4 and IPv6 addresses to publish for the alias given the detected IPs
+// the IPs the configured value cannot be computed from (e.g. a template of the other family) are skipped
+func publishedAddresses(conf config.AliasConfig, ips []string) (string, string, error) {
+	var values []string
+	var err error
+	for _, ip := range ips {
+		value, e := conf.PublishedValue(ip)
+		if e != nil {
+			err = e
+			continue
+		}
+
+		// a fixed value is the same whatever the IP
+		if len(values) == 0 || values[0] != value {
+			values = append(values, value)
+		}
+	}
+
+	if len(values) == 0 && err != nil {
+		return "", "", err
+	}
+
+	return AliasAddresses(values...)
+}
+
+// CheckVersion compare given version against the daemon one
+// and return the latest version and whether given version is outdated
+func (c *cli) CheckVersion(current string) (string, bool, error) {
+	if c.conf.DisableUpdateCheck {
+		return "", false, ErrUpdateCheckDisabled
+	}
+
+	version, err := c.apiClient.GetVersion()
+	if err != nil {
+		return "", false, err
+	}
+
+	outdated, err := isOutdated(current, version.Version)
+	if err != nil {
+		return "", false, err
+	}
+
+	return version.Version, outdated, nil
+}
+
+// token return the access token to use, refreshed first if it is past half its lifetime
+// so it doesn't expire while the CLI is used. If the refresh fails, the current token is used until it expires
+func (c *cli) token() proto.TokenDto {
+	info, err := ParseTokenInfo(c.tok.Token)
+	if err != nil || !info.RefreshDue(time.Now()) {
+		return c.tok
+	}
+
+	tok, err := c.apiClient.RefreshToken(c.tok)
+	if err != nil {
+		c.logger.Warn().Str("Error", err.Error()).Time("ExpiresAt", info.ExpiresAt).Msg("unable to refresh the access token.")
+		return c.tok
+	}
+
+	c.tok = proto.TokenDto{Token: tok.Token}
+	c.conf.Token = tok.Token
+	if err := c.saveConfig(); err != nil {
+		// the token given by the environment must be renewed by the user
+		c.logger.Warn().Str("Error", err.Error()).Msg("unable to save the refreshed access token.")
+	}
+
+	return c.tok
 }
 
 func (c *cli) saveConfig() error {
 	return c.confProvider.Save(c.conf)
 }
+
+// GroupAliasesByDomain group given aliases by their base domain
+// the base domain is the longest matching managed domain, or what follows the host otherwise
+// groups are sorted by domain, and aliases by name inside each group
+func GroupAliasesByDomain(aliases []AliasStatus, domains []proto.DomainDto) []AliasGroup {
+	groupsByDomain := map[string]*AliasGroup{}
+	var groups []*AliasGroup
+
+	for _, alias := range aliases {
+		domain := baseDomain(alias.Domain, domains)
+
+		group, exist := groupsByDomain[domain]
+		if !exist {
+			group = &AliasGroup{Domain: domain}
+			groupsByDomain[domain] = group
+			groups = append(groups, group)
+		}
+		group.Aliases = append(group.Aliases, alias)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Domain < groups[j].Domain
+	})
+
+	var result []AliasGroup
+	for _, group := range groups {
+		sort.Slice(group.Aliases, func(i, j int) bool {
+			return group.Aliases[i].Domain < group.Aliases[j].Domain
+		})
+		result = append(result, *group)
+	}
+
+	return result
+}
+
+// CheckAliasDomain make sure given alias is under one of given managed domains
+func CheckAliasDomain(name string, domains []proto.DomainDto) error {
+	for _, domain := range domains {
+		if strings.HasSuffix(name, "."+domain.Domain) {
+			return nil
+		}
+	}
+
+	return ErrDomainNotManaged
+}
+
+// ResolveAliasName return the full name of given alias
+// a bare host (without any dot) is completed with the managed domain if there's only one,
+// otherwise choose is used to select the domain. choose may be nil in non-interactive mode.
+func ResolveAliasName(name string, domains []proto.DomainDto, choose func([]proto.DomainDto) (proto.DomainDto, error)) (string, error) {
+	if strings.Contains(name, ".") {
+		return name, nil
+	}
+
+	switch {
+	case len(domains) == 0:
+		return "", ErrDomainNotManaged
+	case len(domains) == 1:
+		return name + "." + domains[0].Domain, nil
+	case choose == nil:
+		return "", ErrAmbiguousDomain
+	}
+
+	domain, err := choose(domains)
+	if err != nil {
+		return "", err
+	}
+
+	return name + "." + domain.Domain, nil
+}
+
+// PromptDomain return a function asking the user to choose one of the domains
+// using given input & output
+func PromptDomain(in io.Reader, out io.Writer) func([]proto.DomainDto) (proto.DomainDto, error) {
+	return func(domains []proto.DomainDto) (proto.DomainDto, error) {
+		for i, domain := range domains {
+			_, _ = fmt.Fprintf(out, "%d) %s\n", i+1, domain.Domain)
+		}
+		_, _ = fmt.Fprintf(out, "Domain [1-%d]: ", len(domains))
+
+		var choice int
+		if _, err := fmt.Fscanln(in, &choice); err != nil || choice < 1 || choice > len(domains) {
+			return proto.DomainDto{}, fmt.Errorf("invalid domain choice")
+		}
+
+		return domains[choice-1], nil
+	}
+}
+
+// WriteDomains write given domains into w, either as a table or as JSON
+func WriteDomains(w io.Writer, domains []proto.DomainDto, asJSON bool) error {
+	if asJSON {
+		// always output a list, even if empty
+		if domains == nil {
+			domains = []proto.DomainDto{}
+		}
+		return json.NewEncoder(w).Encode(domains)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "DOMAIN")
+	for _, domain := range domains {
+		_, _ = fmt.Fprintln(tw, domain.Domain)
+	}
+
+	return tw.Flush()
+}
+
+// WriteAliases write given aliases into w as a table
+func WriteAliases(w io.Writer, aliases []AliasStatus) error {
+	rows := [][]string{{"DOMAIN", "VALUE", "VALUE6", "ENABLED", "SYNCHRONIZE", "FOLLOW", "UPDATED FROM", "UPDATED BY"}}
+	for _, alias := range aliases {
+		rows = append(rows, []string{
+			alias.Domain,
+			alias.Value,
+			alias.Value6,
+			strconv.FormatBool(alias.Enabled),
+			strconv.FormatBool(alias.Synchronize),
+			alias.Follow,
+			// only returned if recorded by the daemon
+			alias.SourceIP,
+			alias.SourceUserAgent,
+		})
+	}
+
+	return writeTable(w, rows)
+}
+
+// WriteOwnedAliases write given aliases along with their owner into w as a table
+func WriteOwnedAliases(w io.Writer, aliases []proto.OwnedAliasDto) error {
+	rows := [][]string{{"DOMAIN", "VALUE", "VALUE6", "ENABLED", "OWNER"}}
+	for _, alias := range aliases {
+		rows = append(rows, []string{alias.Domain, alias.Value, alias.Value6, strconv.FormatBool(alias.Enabled), alias.Owner})
+	}
+
+	return writeTable(w, rows)
+}
+
+// WriteDNSStatus write given DNS status into w as a table
+func WriteDNSStatus(w io.Writer, status proto.DNSStatusDto) error {
+	return writeTable(w, [][]string{
+		{"CIRCUIT BREAKER", "PENDING RECORDS"},
+		{status.CircuitBreaker, strconv.FormatInt(status.PendingRecords, 10)},
+	})
+}
+
+// WriteDelegations write given delegation checks into w as a table
+func WriteDelegations(w io.Writer, delegations []proto.DelegationDto) error {
+	rows := [][]string{{"DOMAIN", "STATUS", "NAMESERVERS", "CHECKED AT", "ERROR"}}
+	for _, delegation := range delegations {
+		rows = append(rows, []string{
+			delegation.Domain,
+			delegation.Status,
+			strings.Join(delegation.Nameservers, ","),
+			delegation.CheckedAt.Format(time.RFC3339),
+			delegation.Error,
+		})
+	}
+
+	return writeTable(w, rows)
+}
+
+// WriteWebhooks write given webhooks into w as a table
+func WriteWebhooks(w io.Writer, webhooks []proto.WebhookDto) error {
+	rows := [][]string{{"ID", "URL", "SIGNED"}}
+	for _, webhook := range webhooks {
+		rows = append(rows, []string{strconv.FormatUint(uint64(webhook.ID), 10), webhook.URL, strconv.FormatBool(webhook.Signed)})
+	}
+
+	return writeTable(w, rows)
+}
+
+// WriteShares write given shares into w as a table
+func WriteShares(w io.Writer, shares []proto.ShareDto) error {
+	rows := [][]string{{"ID", "ALIAS", "EXPIRES AT"}}
+	for _, share := range shares {
+		expiresAt := "never"
+		if share.ExpiresAt != nil {
+			expiresAt = share.ExpiresAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{strconv.FormatUint(uint64(share.ID), 10), share.Alias, expiresAt})
+	}
+
+	return writeTable(w, rows)
+}
+
+// writeTable write given rows into w as aligned columns, the empty cells are written as -
+func writeTable(w io.Writer, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			if cell == "" {
+				cell = "-"
+			}
+			cells[i] = cell
+		}
+		_, _ = fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// WriteMOTD write given message of the day into w, if any
+func WriteMOTD(w io.Writer, motd string) error {
+	motd = strings.TrimRight(motd, "\n")
+	if motd == "" {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(w, "\n%s\n\n", motd)
+	return err
+}
+
+// WriteField write the value of given field of each item (one per line, no header)
+// the field is one of the JSON field names of the items, which must be a slice of structs
+func WriteField(w io.Writer, items interface{}, field string) error {
+	return WriteFields(w, items, []string{field})
+}
+
+// WriteFields write the values of given fields of each item (one tab separated row per line, no header)
+// the fields are JSON field names of the items, which must be a slice of structs
+func WriteFields(w io.Writer, items interface{}, fields []string) error {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("cannot select field of %s", v.Kind())
+	}
+
+	indexes, err := selectedIndexes(v.Type().Elem(), fields)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		values := make([]string, len(indexes))
+		for j, index := range indexes {
+			values[j] = fmt.Sprint(v.Index(i).FieldByIndex(index).Interface())
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(values, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckFields make sure given fields are usable with WriteFields for given type
+func CheckFields(t reflect.Type, fields []string) error {
+	_, err := selectedIndexes(t, fields)
+	return err
+}
+
+func selectedIndexes(t reflect.Type, fields []string) ([][]int, error) {
+	availableIndexes := fieldIndexes(t)
+
+	var indexes [][]int
+	for _, field := range fields {
+		index, exist := availableIndexes[field]
+		if !exist {
+			return nil, fmt.Errorf("%w: %s (available: %s)", ErrUnknownField, field, strings.Join(FieldNames(t), ", "))
+		}
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+// FieldNames return the sorted field names usable with WriteField for given type
+func FieldNames(t reflect.Type) []string {
+	var names []string
+	for name := range fieldIndexes(t) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// fieldIndexes return the index of the struct fields by their JSON name
+// embedded structs fields are promoted, like encoding/json does
+func fieldIndexes(t reflect.Type) map[string][]int {
+	indexes := map[string][]int{}
+	if t.Kind() != reflect.Struct {
+		return indexes
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for name, index := range fieldIndexes(field.Type) {
+				indexes[name] = append([]int{i}, index...)
+			}
+			continue
+		}
+
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		indexes[name] = []int{i}
+	}
+
+	return indexes
+}
+
+func baseDomain(name string, domains []proto.DomainDto) string {
+	best := ""
+	for _, domain := range domains {
+		if strings.HasSuffix(name, "."+domain.Domain) && len(domain.Domain) > len(best) {
+			best = domain.Domain
+		}
+	}
+
+	if best != "" {
+		return best
+	}
+
+	// domain not managed (anymore?): fallback on everything after the host
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 {
+		return name
+	}
+	return parts[1]
+}
+
+// isOutdated determinate if current version is older than latest one
+// versions are expected to be in the MAJOR.MINOR.PATCH format
+func isOutdated(current, latest string) (bool, error) {
+	currentParts, err := parseVersion(current)
+	if err != nil {
+		return false, err
+	}
+	latestParts, err := parseVersion(latest)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range currentParts {
+		if currentParts[i] != latestParts[i] {
+			return currentParts[i] < latestParts[i], nil
+		}
+	}
+
+	return false, nil
+}
+
+func parseVersion(version string) ([3]int, error) {
+	var parts [3]int
+
+	// drop the pre-release / build metadata if any
+	v := strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(v, "-+"); i != -1 {
+		v = v[:i]
+	}
+
+	fields := strings.Split(v, ".")
+	if len(fields) != 3 {
+		return parts, fmt.Errorf("invalid version `%s`", version)
+	}
+
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return parts, fmt.Errorf("invalid version `%s`", version)
+		}
+		parts[i] = n
+	}
+
+	return parts, nil
+}
@@ -1,17 +1,99 @@
 package cli
 
 import (
+	"bytes"
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/client"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/config_mock"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/creekorful/open-dydns/proto_mock"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/golang/mock/gomock"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
+func TestNewCLI_Insecure(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version": "0.3.0"}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "opendydnsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opendydnsctl.toml")
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	for _, insecure := range []bool{false, true} {
+		if err := config.NewFileProvider(path).Save(config.Config{APIAddr: server.URL, Insecure: insecure}); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := NewCLI(path, client.Options{}, &l)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = c.CheckVersion("0.3.0")
+		if insecure && err != nil {
+			t.Errorf("request should have succeeded with Insecure = true: %s", err)
+		}
+		if !insecure && err == nil {
+			t.Error("request should have failed with Insecure = false")
+		}
+	}
+}
+
+func TestCli_Reload(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	configMock := config_mock.NewMockProvider(mockCtrl)
+	c := cli{
+		tok:          proto.TokenDto{Token: "old-token"},
+		conf:         config.Config{APIAddr: "https://api.example.org", Token: "old-token"},
+		confProvider: configMock,
+	}
+
+	// the configuration is kept if the new one is invalid
+	configMock.EXPECT().Load().Return(config.Config{Token: "new-token"}, nil)
+	if err := c.Reload(); err == nil {
+		t.Error("Reload() should have failed")
+	}
+	if c.tok.Token != "old-token" {
+		t.Errorf("the previous token should have been kept: %s", c.tok.Token)
+	}
+
+	newConf := config.Config{
+		APIAddr: "https://api.example.org",
+		Token:   "new-token",
+		Aliases: map[string]config.AliasConfig{"foo.example.org": {Synchronize: true}},
+	}
+	configMock.EXPECT().Load().Return(newConf, nil)
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if c.tok.Token != "new-token" || !reflect.DeepEqual(c.conf, newConf) {
+		t.Errorf("the configuration should have been reloaded: %v", c.conf)
+	}
+}
+
 func TestCli_Authenticate_InvalidRequest(t *testing.T) {
 	c := cli{}
 
@@ -51,7 +133,7 @@ func TestCli_Authenticate(t *testing.T) {
 
 	clientMock.EXPECT().
 		Authenticate(proto.CredentialsDto{Email: "root", Password: "toor"}).
-		Return(proto.TokenDto{Token: "test-token"}, nil)
+		Return(proto.TokenDto{Token: "test-token", MOTD: "Welcome!"}, nil)
 	configMock.EXPECT().Save(config.Config{Token: "test-token"})
 
 	tok, err := c.Authenticate(proto.CredentialsDto{Email: "root", Password: "toor"})
@@ -59,11 +141,198 @@ func TestCli_Authenticate(t *testing.T) {
 		t.Error(err)
 	}
 
-	if tok.Token != "test-token" {
+	if tok.Token != "test-token" || tok.MOTD != "Welcome!" {
 		t.Error("invalid token returned")
 	}
 }
 
+func TestCli_Reauthenticate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	c := cli{
+		logger:       &l,
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+
+	if _, err := c.Reauthenticate(proto.CredentialsDto{Email: "root", Password: "toor"}); err != ErrNotLoggedIn {
+		t.Errorf("Reauthenticate() should have returned ErrNotLoggedIn: %v", err)
+	}
+
+	c.conf.Token = "expired-token"
+	c.tok = proto.TokenDto{Token: "expired-token"}
+	cred := proto.CredentialsDto{Email: "root", Password: "toor", Scopes: []string{proto.ScopeAliasesRead}}
+
+	// the saved token is kept on failure
+	clientMock.EXPECT().Authenticate(cred).Return(proto.TokenDto{}, proto.ErrInvalidParameters)
+
+	if _, err := c.Reauthenticate(cred); err != proto.ErrInvalidParameters {
+		t.Errorf("Reauthenticate() should have returned ErrInvalidParameters: %v", err)
+	}
+	if c.conf.Token != "expired-token" || c.tok.Token != "expired-token" {
+		t.Errorf("the saved token should have been kept: %s", c.conf.Token)
+	}
+
+	clientMock.EXPECT().Authenticate(cred).Return(proto.TokenDto{Token: "test-token"}, nil)
+	configMock.EXPECT().Save(config.Config{Token: "test-token"})
+
+	if _, err := c.Reauthenticate(cred); err != nil {
+		t.Fatal(err)
+	}
+	if c.tok.Token != "test-token" {
+		t.Errorf("the new token should be used by the next requests: %s", c.tok.Token)
+	}
+}
+
+func TestCli_Register(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	c := cli{
+		logger:       &l,
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+
+	if _, err := c.Register(proto.RegistrationDto{Email: "test@example.org"}); err != ErrBadRequest {
+		t.Errorf("Register() should have returned ErrBadRequest: %v", err)
+	}
+
+	// the email address must be verified: nothing is saved
+	registration := proto.RegistrationDto{Email: "test@example.org", Password: "Correct horse 1"}
+	clientMock.EXPECT().Register(registration).
+		Return(proto.RegisteredUserDto{Email: "test@example.org", VerificationRequired: true}, nil)
+
+	user, err := c.Register(registration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.VerificationRequired || c.conf.Token != "" {
+		t.Errorf("wrong registration result: %v", user)
+	}
+
+	clientMock.EXPECT().VerifyEmail(proto.VerificationDto{Token: "verification-token"}).
+		Return(proto.TokenDto{Token: "test-token"}, nil)
+	configMock.EXPECT().Save(config.Config{Token: "test-token"})
+
+	if _, err := c.VerifyEmail("verification-token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.VerifyEmail("verification-token"); err != ErrAlreadyLoggedIn {
+		t.Errorf("VerifyEmail() should have returned ErrAlreadyLoggedIn: %v", err)
+	}
+
+	// the user is logged in right away
+	c.conf.Token = ""
+	clientMock.EXPECT().Register(registration).
+		Return(proto.RegisteredUserDto{Email: "test@example.org", Token: "other-token"}, nil)
+	configMock.EXPECT().Save(config.Config{Token: "other-token"})
+
+	if _, err := c.Register(registration); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCli_Logout_NotLoggedIn(t *testing.T) {
+	c := cli{}
+
+	if err := c.Logout(false); err != ErrNotLoggedIn {
+		t.Error("Logout() should have returned ErrNotLoggedIn")
+	}
+}
+
+func TestCli_Logout(t *testing.T) {
+	for _, all := range []bool{false, true} {
+		mockCtrl := gomock.NewController(t)
+
+		l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+		clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+		confProviderMock := config_mock.NewMockProvider(mockCtrl)
+
+		c := cli{
+			logger:       &l,
+			apiClient:    clientMock,
+			confProvider: confProviderMock,
+			conf:         config.Config{APIAddr: "http://127.0.0.1", Token: "test-token"},
+			tok:          proto.TokenDto{Token: "test-token"},
+		}
+
+		if all {
+			clientMock.EXPECT().RevokeTokens(proto.TokenDto{Token: "test-token"}).Return(nil)
+		}
+		confProviderMock.EXPECT().Save(config.Config{APIAddr: "http://127.0.0.1"}).Return(nil)
+
+		if err := c.Logout(all); err != nil {
+			t.Error(err)
+		}
+		if c.tok.Token != "" {
+			t.Error("token should have been forgotten")
+		}
+
+		mockCtrl.Finish()
+	}
+}
+
+func TestCli_RefreshToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	confProviderMock := config_mock.NewMockProvider(mockCtrl)
+
+	// issued 20 days ago, valid for 30 days
+	now := time.Now()
+	oldToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID": 1,
+		"iat":    now.Add(-20 * 24 * time.Hour).Unix(),
+		"exp":    now.Add(10 * 24 * time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cli{
+		logger:       &l,
+		apiClient:    clientMock,
+		confProvider: confProviderMock,
+		conf:         config.Config{APIAddr: "http://127.0.0.1", Token: oldToken},
+		tok:          proto.TokenDto{Token: oldToken},
+	}
+
+	// the token is refreshed & saved before being used
+	newToken := proto.TokenDto{Token: "new-token"}
+	gomock.InOrder(
+		clientMock.EXPECT().RefreshToken(proto.TokenDto{Token: oldToken}).Return(newToken, nil),
+		confProviderMock.EXPECT().Save(config.Config{APIAddr: "http://127.0.0.1", Token: "new-token"}).Return(nil),
+		clientMock.EXPECT().GetDomains(newToken).Return(nil, nil),
+	)
+
+	if _, err := c.GetDomains(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the current token is used until it expires if the refresh fails
+	c.tok = proto.TokenDto{Token: oldToken}
+	gomock.InOrder(
+		clientMock.EXPECT().RefreshToken(proto.TokenDto{Token: oldToken}).Return(proto.TokenDto{}, client.ErrTimeout),
+		clientMock.EXPECT().GetDomains(proto.TokenDto{Token: oldToken}).Return(nil, nil),
+	)
+
+	if _, err := c.GetDomains(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCli_GetAliases(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -103,6 +372,82 @@ func TestCli_GetAliases(t *testing.T) {
 	}
 }
 
+func TestCli_GetAliases_Fields(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		conf: config.Config{
+			Aliases: map[string]config.AliasConfig{
+				"creekorful.fr": {Synchronize: true},
+			},
+		},
+		tok: proto.TokenDto{Token: "test-token"},
+	}
+
+	// the synchronization status is local: the name is needed to look it up
+	clientMock.EXPECT().GetAliases(c.tok, "value", "domain").Return([]proto.AliasDto{
+		{Domain: "creekorful.fr", Value: "127.0.0.1"},
+	}, nil)
+
+	aliases, err := c.GetAliases("value", "synchronize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || !aliases[0].Synchronize {
+		t.Errorf("wrong aliases returned: %v", aliases)
+	}
+
+	clientMock.EXPECT().GetAliases(c.tok, "domain", "ttl").Return([]proto.AliasDto{{Domain: "creekorful.fr"}}, nil)
+	if _, err := c.GetAliases("domain", "ttl"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCli_GetAliasesByName(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		conf: config.Config{
+			Aliases: map[string]config.AliasConfig{
+				"foo.example.org": {Synchronize: true},
+			},
+		},
+		tok: proto.TokenDto{Token: "test-token"},
+	}
+
+	if _, _, err := c.GetAliasesByName(nil); err != ErrBadRequest {
+		t.Error("GetAliasesByName() should have failed")
+	}
+
+	clientMock.EXPECT().GetAliasesByName(c.tok, []string{"foo.example.org", "bar.example.org"}).Return(proto.AliasesLookupDto{
+		Aliases: []proto.AliasDto{{Domain: "foo.example.org", Value: "127.0.0.1"}},
+		Missing: []string{"bar.example.org"},
+	}, nil)
+
+	aliases, missing, err := c.GetAliasesByName([]string{"foo.example.org", "bar.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || !aliases[0].Synchronize {
+		t.Errorf("wrong aliases returned: %v", aliases)
+	}
+	if len(missing) != 1 || missing[0] != "bar.example.org" {
+		t.Errorf("wrong missing names returned: %v", missing)
+	}
+}
+
 func TestCli_RegisterAlias_InvalidRequest(t *testing.T) {
 	c := cli{}
 
@@ -225,6 +570,99 @@ func TestCli_UpdateAlias(t *testing.T) {
 	}
 }
 
+func TestCli_UpdateAlias_IPv6(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		tok:       proto.TokenDto{Token: "test-token"},
+	}
+
+	// the IPv6 address is sent as the AAAA record one
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "foo.bar.baz", Value6: "2001:db8::1"}).
+		Return(proto.AliasDto{Domain: "foo.bar.baz", Value: "127.0.0.1", Value6: "2001:db8::1"}, nil)
+
+	al, err := c.UpdateAlias(proto.AliasDto{Domain: "foo.bar.baz", Value: "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if al.Value != "127.0.0.1" || al.Value6 != "2001:db8::1" {
+		t.Errorf("wrong alias returned: %v", al)
+	}
+}
+
+func TestAliasAddresses(t *testing.T) {
+	tests := []struct {
+		ips    []string
+		value  string
+		value6 string
+		err    error
+	}{
+		{[]string{"192.0.2.1"}, "192.0.2.1", "", nil},
+		{[]string{"2001:db8::1"}, "", "2001:db8::1", nil},
+		{[]string{"2001:db8::1", "192.0.2.1"}, "192.0.2.1", "2001:db8::1", nil},
+		{[]string{"192.0.2.1", "192.0.2.2"}, "", "", ErrInvalidIP},
+		{[]string{"2001:db8::1", "2001:db8::2"}, "", "", ErrInvalidIP},
+		{[]string{"::ffff:192.0.2.1"}, "", "", ErrInvalidIP},
+		{[]string{"foo.example.org"}, "", "", ErrInvalidIP},
+		{nil, "", "", ErrBadRequest},
+	}
+
+	for _, test := range tests {
+		value, value6, err := AliasAddresses(test.ips...)
+		if !errors.Is(err, test.err) {
+			t.Errorf("AliasAddresses(%v) returned %v instead of %v", test.ips, err, test.err)
+		}
+		if value != test.value || value6 != test.value6 {
+			t.Errorf("AliasAddresses(%v) returned %s %s", test.ips, value, value6)
+		}
+	}
+}
+
+func TestCli_SetTTL(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		tok:       proto.TokenDto{Token: "test-token"},
+	}
+
+	if _, err := c.SetTTL("foo.bar.baz", 0); err != ErrBadRequest {
+		t.Error("SetTTL() should have returned ErrBadRequest")
+	}
+
+	clientMock.EXPECT().PatchAlias(c.tok, "bar.bar.baz", gomock.Any()).Return(proto.AliasDto{}, proto.ErrAliasNotFound)
+
+	if _, err := c.SetTTL("bar.bar.baz", 60); err != proto.ErrAliasNotFound {
+		t.Error("SetTTL() should have returned proto.ErrAliasNotFound")
+	}
+
+	// only the TTL is sent: the value is left as-is
+	ttl := 60
+	clientMock.EXPECT().
+		PatchAlias(c.tok, "foo.bar.baz", proto.AliasPatchDto{TTL: &ttl}).
+		Return(proto.AliasDto{Domain: "foo.bar.baz", Value: "127.0.0.1", TTL: 60}, nil)
+
+	al, err := c.SetTTL("foo.bar.baz", 60)
+	if err != nil {
+		t.Error(err)
+	}
+	if al.TTL != 60 || al.Value != "127.0.0.1" {
+		t.Error("wrong alias returned")
+	}
+}
+
 func TestCli_DeleteAlias_AliasNotFound(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -313,10 +751,17 @@ func TestCli_Synchronize(t *testing.T) {
 				"foo.example.org":    {Synchronize: true},
 				"local.example.org":  {Synchronize: true},
 				"dummy.notexist.org": {Synchronize: true},
+				"vpn.example.org":    {Synchronize: true, Value: "10.8.0.1"},
+				"bad.example.org":    {Synchronize: true, Value: "{{.IP}}:8080"},
 			},
 		},
 	}
 
+	// the configured value is published instead of the detected IP, an invalid one is skipped
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "vpn.example.org", Value: "10.8.0.1"}).
+		Return(proto.AliasDto{Domain: "vpn.example.org", Value: "10.8.0.1"}, nil)
+
 	clientMock.EXPECT().
 		UpdateAlias(c.tok, proto.AliasDto{Domain: "local.example.org", Value: "127.0.0.1"}).
 		Return(proto.AliasDto{Domain: "local.example.org", Value: "127.0.0.1"}, nil)
@@ -332,6 +777,81 @@ func TestCli_Synchronize(t *testing.T) {
 	}
 }
 
+func TestCli_Synchronize_DualStack(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		tok:       proto.TokenDto{Token: "test-token"},
+		conf: config.Config{
+			Aliases: map[string]config.AliasConfig{
+				"foo.example.org": {Synchronize: true},
+				"vpn.example.org": {Synchronize: true, Value: "10.8.0.1"},
+				"nas.example.org": {Synchronize: true, Value: `{{combine .IP 64 "::10"}}`},
+			},
+		},
+	}
+
+	// both records are updated, unless the configured value is of a single family
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "foo.example.org", Value: "192.0.2.1", Value6: "2001:db8::1"}).
+		Return(proto.AliasDto{}, nil)
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "vpn.example.org", Value: "10.8.0.1"}).
+		Return(proto.AliasDto{}, nil)
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "nas.example.org", Value6: "2001:db8::10"}).
+		Return(proto.AliasDto{}, nil)
+
+	if err := c.Synchronize("192.0.2.1", "2001:db8::1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCli_RenameAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	confProvider := config_mock.NewMockProvider(mockCtrl)
+
+	c := cli{
+		tok:          proto.TokenDto{Token: "test"},
+		logger:       &l,
+		apiClient:    clientMock,
+		confProvider: confProvider,
+		conf: config.Config{
+			Aliases: map[string]config.AliasConfig{"www.example.org": {Synchronize: true}},
+		},
+	}
+
+	if _, err := c.RenameAlias("www.example.org", ""); err != ErrBadRequest {
+		t.Error("RenameAlias() should have returned ErrBadRequest")
+	}
+
+	clientMock.EXPECT().RenameAlias(proto.TokenDto{Token: "test"}, "www.example.org", "home.example.org").
+		Return(proto.AliasDto{Domain: "home.example.org", Value: "127.0.0.1"}, nil)
+
+	// the synchronization setting follows the alias
+	confProvider.EXPECT().Save(config.Config{
+		Aliases: map[string]config.AliasConfig{"home.example.org": {Synchronize: true}},
+	})
+
+	alias, err := c.RenameAlias("www.example.org", "home.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.Domain != "home.example.org" {
+		t.Errorf("wrong alias returned: %v", alias)
+	}
+}
+
 func TestCli_SetSynchronize(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -388,3 +908,363 @@ func TestCli_SetSynchronize(t *testing.T) {
 		t.Error("alias foo.example.org is not updated")
 	}
 }
+
+func TestCli_CheckVersion(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+	}
+
+	// behind
+	clientMock.EXPECT().GetVersion().Return(proto.VersionDto{Version: "0.4.0"}, nil)
+
+	latest, outdated, err := c.CheckVersion("0.3.0")
+	if err != nil {
+		t.Error(err)
+	}
+	if !outdated {
+		t.Error("version 0.3.0 should be outdated")
+	}
+	if latest != "0.4.0" {
+		t.Errorf("wrong latest version returned: %s", latest)
+	}
+
+	// up-to-date
+	clientMock.EXPECT().GetVersion().Return(proto.VersionDto{Version: "0.3.0"}, nil)
+
+	if _, outdated, err = c.CheckVersion("0.3.0"); err != nil {
+		t.Error(err)
+	}
+	if outdated {
+		t.Error("version 0.3.0 should be up-to-date")
+	}
+}
+
+func TestCli_GetIP(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		tok:       proto.TokenDto{Token: "test"},
+		apiClient: clientMock,
+	}
+
+	clientMock.EXPECT().GetIP(proto.TokenDto{Token: "test"}).Return(proto.IPDto{IP: "2001:db8::1", Version: 6}, nil)
+
+	ip, err := c.GetIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "2001:db8::1" {
+		t.Errorf("wrong IP returned: %s", ip)
+	}
+}
+
+func TestCli_CheckVersion_Disabled(t *testing.T) {
+	c := cli{
+		conf: config.Config{DisableUpdateCheck: true},
+	}
+
+	if _, _, err := c.CheckVersion("0.3.0"); err != ErrUpdateCheckDisabled {
+		t.Error("CheckVersion() should have returned ErrUpdateCheckDisabled")
+	}
+}
+
+func TestIsOutdated(t *testing.T) {
+	tests := []struct {
+		current  string
+		latest   string
+		outdated bool
+	}{
+		{"0.3.0", "0.3.0", false},
+		{"0.3.0", "0.3.1", true},
+		{"0.3.0", "0.10.0", true},
+		{"v1.0.0", "0.9.9", false},
+		{"1.0.0-rc1", "1.0.0", false},
+	}
+
+	for _, test := range tests {
+		outdated, err := isOutdated(test.current, test.latest)
+		if err != nil {
+			t.Error(err)
+		}
+		if outdated != test.outdated {
+			t.Errorf("isOutdated(%s, %s) should have returned %t", test.current, test.latest, test.outdated)
+		}
+	}
+
+	if _, err := isOutdated("0.3.0", "latest"); err == nil {
+		t.Error("isOutdated() should have failed")
+	}
+}
+
+func TestGroupAliasesByDomain(t *testing.T) {
+	aliases := []AliasStatus{
+		{AliasDto: proto.AliasDto{Domain: "foo.example.org"}},
+		{AliasDto: proto.AliasDto{Domain: "zz.demo.dydns.org"}},
+		{AliasDto: proto.AliasDto{Domain: "bar.example.org"}},
+		{AliasDto: proto.AliasDto{Domain: "aa.demo.dydns.org"}},
+		{AliasDto: proto.AliasDto{Domain: "test.unmanaged.net"}},
+	}
+	domains := []proto.DomainDto{{Domain: "example.org"}, {Domain: "dydns.org"}, {Domain: "demo.dydns.org"}}
+
+	groups := GroupAliasesByDomain(aliases, domains)
+
+	expected := []struct {
+		domain  string
+		aliases []string
+	}{
+		{"demo.dydns.org", []string{"aa.demo.dydns.org", "zz.demo.dydns.org"}},
+		{"example.org", []string{"bar.example.org", "foo.example.org"}},
+		{"unmanaged.net", []string{"test.unmanaged.net"}},
+	}
+
+	if len(groups) != len(expected) {
+		t.Fatalf("wrong number of groups: %d", len(groups))
+	}
+
+	for i, group := range groups {
+		if group.Domain != expected[i].domain {
+			t.Errorf("wrong group domain: %s", group.Domain)
+		}
+
+		if len(group.Aliases) != len(expected[i].aliases) {
+			t.Fatalf("wrong number of aliases in group %s", group.Domain)
+		}
+		for j, alias := range group.Aliases {
+			if alias.Domain != expected[i].aliases[j] {
+				t.Errorf("wrong alias in group %s: %s", group.Domain, alias.Domain)
+			}
+		}
+	}
+}
+
+func TestCheckAliasDomain(t *testing.T) {
+	domains := []proto.DomainDto{{Domain: "example.org"}, {Domain: "demo.dydns.org"}}
+
+	for _, name := range []string{"foo.example.org", "foo.demo.dydns.org", "foo.bar.example.org"} {
+		if err := CheckAliasDomain(name, domains); err != nil {
+			t.Errorf("%s should be accepted", name)
+		}
+	}
+
+	for _, name := range []string{"example.org", "foo.dydns.org", "fooexample.org", "foo.example.net"} {
+		if err := CheckAliasDomain(name, domains); err != ErrDomainNotManaged {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
+
+func TestResolveAliasName(t *testing.T) {
+	single := []proto.DomainDto{{Domain: "example.org"}}
+	several := []proto.DomainDto{{Domain: "example.org"}, {Domain: "demo.dydns.org"}}
+
+	// full names are kept as-is
+	if name, err := ResolveAliasName("foo.example.org", several, nil); err != nil || name != "foo.example.org" {
+		t.Errorf("wrong name returned: %s (%v)", name, err)
+	}
+
+	// single managed domain: automatically appended
+	if name, err := ResolveAliasName("foo", single, nil); err != nil || name != "foo.example.org" {
+		t.Errorf("wrong name returned: %s (%v)", name, err)
+	}
+
+	if _, err := ResolveAliasName("foo", nil, nil); err != ErrDomainNotManaged {
+		t.Errorf("ResolveAliasName() should have returned ErrDomainNotManaged")
+	}
+
+	// several managed domains: error in non-interactive mode
+	if _, err := ResolveAliasName("foo", several, nil); err != ErrAmbiguousDomain {
+		t.Errorf("ResolveAliasName() should have returned ErrAmbiguousDomain")
+	}
+
+	// several managed domains: prompt the user
+	var out bytes.Buffer
+	name, err := ResolveAliasName("foo", several, PromptDomain(strings.NewReader("2\n"), &out))
+	if err != nil || name != "foo.demo.dydns.org" {
+		t.Errorf("wrong name returned: %s (%v)", name, err)
+	}
+	if out.String() != "1) example.org\n2) demo.dydns.org\nDomain [1-2]: " {
+		t.Errorf("wrong prompt: %s", out.String())
+	}
+
+	for _, input := range []string{"3\n", "0\n", "foo\n", ""} {
+		if _, err := ResolveAliasName("foo", several, PromptDomain(strings.NewReader(input), &out)); err == nil {
+			t.Errorf("invalid choice `%s` should have been rejected", input)
+		}
+	}
+}
+
+func TestWriteField(t *testing.T) {
+	aliases := []AliasStatus{
+		{AliasDto: proto.AliasDto{Domain: "foo.example.org", Value: "127.0.0.1", TTL: 60}, Synchronize: true},
+		{AliasDto: proto.AliasDto{Domain: "bar.example.org", Value: "::1"}},
+	}
+
+	var b bytes.Buffer
+	if err := WriteField(&b, aliases, "value"); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "127.0.0.1\n::1\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	b.Reset()
+	if err := WriteField(&b, aliases, "synchronize"); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "true\nfalse\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	b.Reset()
+	if err := WriteField(&b, []proto.DomainDto{{Domain: "example.org"}}, "domain"); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "example.org\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	// the field names are the JSON ones
+	if err := WriteField(&b, aliases, "Value"); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("WriteField() should have returned ErrUnknownField")
+	}
+
+	b.Reset()
+	if err := WriteFields(&b, aliases, []string{"domain", "ttl", "synchronize"}); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "foo.example.org\t60\ttrue\nbar.example.org\t0\tfalse\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	if err := CheckFields(reflect.TypeOf(AliasStatus{}), []string{"domain", "secret"}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("CheckFields() should have returned ErrUnknownField")
+	}
+
+	names := FieldNames(reflect.TypeOf(AliasStatus{}))
+	if !reflect.DeepEqual(names, []string{"dnssec", "domain", "enabled", "follow", "source_ip", "source_user_agent", "synchronize", "ttl", "value", "value6", "version"}) {
+		t.Errorf("wrong field names: %v", names)
+	}
+}
+
+func TestWriteDomains(t *testing.T) {
+	domains := []proto.DomainDto{{Domain: "example.org"}, {Domain: "demo.dydns.org"}}
+
+	var b bytes.Buffer
+	if err := WriteDomains(&b, domains, false); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "DOMAIN\nexample.org\ndemo.dydns.org\n" {
+		t.Errorf("wrong table output: %s", b.String())
+	}
+
+	b.Reset()
+	if err := WriteDomains(&b, domains, true); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != `[{"domain":"example.org"},{"domain":"demo.dydns.org"}]`+"\n" {
+		t.Errorf("wrong JSON output: %s", b.String())
+	}
+
+	b.Reset()
+	if err := WriteDomains(&b, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "[]\n" {
+		t.Errorf("wrong JSON output: %s", b.String())
+	}
+}
+
+func TestWriteAliases(t *testing.T) {
+	aliases := []AliasStatus{
+		{AliasDto: proto.AliasDto{Domain: "foo.example.org", Value: "192.0.2.1", Enabled: true}, Synchronize: true},
+		{AliasDto: proto.AliasDto{Domain: "bar.example.org", Value: "192.0.2.2", Value6: "2001:db8::1", SourceIP: "198.51.100.1"}},
+	}
+
+	var b bytes.Buffer
+	if err := WriteAliases(&b, aliases); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "DOMAIN           VALUE      VALUE6       ENABLED  SYNCHRONIZE  FOLLOW  UPDATED FROM  UPDATED BY\n" +
+		"foo.example.org  192.0.2.1  -            true     true         -       -             -\n" +
+		"bar.example.org  192.0.2.2  2001:db8::1  false    false        -       198.51.100.1  -\n"
+	if b.String() != expected {
+		t.Errorf("wrong table output:\n%s", b.String())
+	}
+}
+
+func TestWriteOwnedAliases(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteOwnedAliases(&b, []proto.OwnedAliasDto{
+		{AliasDto: proto.AliasDto{Domain: "foo.example.org", Value: "192.0.2.1", Enabled: true}, Owner: "alois@micard.lu"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "DOMAIN           VALUE      VALUE6  ENABLED  OWNER\n" +
+		"foo.example.org  192.0.2.1  -       true     alois@micard.lu\n"
+	if b.String() != expected {
+		t.Errorf("wrong table output:\n%s", b.String())
+	}
+}
+
+func TestWriteMOTD(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteMOTD(&b, "Welcome!\nBe nice.\n"); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "\nWelcome!\nBe nice.\n\n" {
+		t.Errorf("wrong MOTD written: %q", b.String())
+	}
+
+	// nothing is written without MOTD
+	b.Reset()
+	if err := WriteMOTD(&b, ""); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("nothing should have been written: %q", b.String())
+	}
+}
+
+func TestCli_GetEchoIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("192.0.2.1\n"))
+	}))
+	defer server.Close()
+
+	c := cli{echoURL: server.URL, timeout: time.Second}
+
+	ip, err := c.GetEchoIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "192.0.2.1" {
+		t.Errorf("wrong IP returned: %s", ip)
+	}
+
+	// the configured resolver is used instead of the system one: nothing answers here
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c.conf.IPLookupResolver = conn.LocalAddr().String()
+	c.echoURL = "http://echo.test:" + server.URL[strings.LastIndex(server.URL, ":")+1:]
+	c.timeout = 100 * time.Millisecond
+
+	if _, err := c.GetEchoIP(); err == nil {
+		t.Error("GetEchoIP() should have failed since the resolver doesn't answer")
+	}
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ErrEditAborted is returned when the user gave up fixing the edited configuration
+var ErrEditAborted = errors.New("edit aborted, configuration left untouched")
+
+// Editor return the command of the user preferred editor
+// using $VISUAL, $EDITOR, then the platform default
+func Editor() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.Fields(os.Getenv(env)); len(editor) > 0 {
+			return editor
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// EditConfig open a copy of the configuration file located at given path using the editor
+// the file is only replaced once the copy is valid. Until then retry is called with the
+// problem found, and the copy is opened again if it returns true
+func EditConfig(path string, editor []string, retry func(err error) bool) error {
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// keep the extension to let the editor highlight the syntax
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.edit"+filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(original)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := runEditor(editor, tmp.Name()); err != nil {
+			return err
+		}
+
+		err := validateConfigFile(tmp.Name())
+		if err == nil {
+			break
+		}
+		if !retry(err) {
+			return ErrEditAborted
+		}
+	}
+
+	edited, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+
+	return common.SaveFile(path, edited)
+}
+
+// PromptRetry return a function reporting the configuration problem
+// and asking the user whether to edit the file again, using given input & output
+func PromptRetry(in io.Reader, out io.Writer) func(err error) bool {
+	return func(err error) bool {
+		_, _ = fmt.Fprintf(out, "invalid configuration: %s\nEdit again? [Y/n]: ", err)
+
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && answer == "" {
+			return false // no more input
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+
+		return answer == "" || answer == "y" || answer == "yes"
+	}
+}
+
+func runEditor(editor []string, path string) error {
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error while running editor `%s`: %w", strings.Join(editor, " "), err)
+	}
+
+	return nil
+}
+
+func validateConfigFile(path string) error {
+	var conf config.Config
+	if err := common.LoadToml(path, &conf); err != nil {
+		return err
+	}
+
+	return conf.Validate()
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"bytes"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const originalConfig = "APIAddr = \"http://127.0.0.1:8888\"\n"
+
+func TestEditConfig(t *testing.T) {
+	dir, path := tempEditConfig(t)
+	defer os.RemoveAll(dir)
+
+	editor := stubEditor(t, "APIAddr = \"https://dydns.example.org\"")
+	if err := EditConfig(path, editor, func(err error) bool {
+		t.Errorf("retry should not have been called: %s", err)
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := config.NewFileProvider(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.APIAddr != "https://dydns.example.org" {
+		t.Errorf("config not saved: %v", conf)
+	}
+
+	assertNoEditLeftOver(t, dir)
+}
+
+func TestEditConfig_Invalid(t *testing.T) {
+	dir, path := tempEditConfig(t)
+	defer os.RemoveAll(dir)
+
+	// neither a syntax error nor an invalid value may reach the config file
+	for _, content := range []string{"APIAddr = \"http://", "APIAddr = \"127.0.0.1:8888\""} {
+		retries := 0
+		err := EditConfig(path, stubEditor(t, content), func(err error) bool {
+			retries++
+			return false
+		})
+		if err != ErrEditAborted {
+			t.Errorf("EditConfig() should have returned ErrEditAborted: %v", err)
+		}
+		if retries != 1 {
+			t.Errorf("retry called %d times", retries)
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != originalConfig {
+			t.Errorf("config file has been altered: %s", b)
+		}
+	}
+
+	assertNoEditLeftOver(t, dir)
+}
+
+func TestEditConfig_Retry(t *testing.T) {
+	dir, path := tempEditConfig(t)
+	defer os.RemoveAll(dir)
+
+	// the first edit is invalid, the second one fix it
+	editor := stubEditor(t, "APIAddr = \"\"", "APIAddr = \"http://10.0.0.1\"")
+
+	var out bytes.Buffer
+	if err := EditConfig(path, editor, PromptRetry(strings.NewReader("\n"), &out)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "APIAddr is missing") {
+		t.Errorf("problem not reported: %s", out.String())
+	}
+
+	conf, err := config.NewFileProvider(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.APIAddr != "http://10.0.0.1" {
+		t.Errorf("config not saved: %v", conf)
+	}
+}
+
+func TestPromptRetry(t *testing.T) {
+	for input, expected := range map[string]bool{"\n": true, "y\n": true, "Yes\n": true, "n\n": false, "": false} {
+		if retry := PromptRetry(strings.NewReader(input), ioutil.Discard)(nil); retry != expected {
+			t.Errorf("wrong answer for input %q: %v", input, retry)
+		}
+	}
+}
+
+func tempEditConfig(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "opendydnsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "opendydnsctl.toml")
+	if err := ioutil.WriteFile(path, []byte(originalConfig), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, path
+}
+
+// stubEditor return an editor writing the given contents into the file, one per run
+func stubEditor(t *testing.T, contents ...string) []string {
+	script := "#!/bin/sh\nrun=$(cat \"$0.run\" 2>/dev/null || echo 0)\necho $((run + 1)) > \"$0.run\"\ncase $run in\n"
+	for i, content := range contents {
+		script += "  " + string(rune('0'+i)) + ") cat > \"$1\" <<'EOF'\n" + content + "\nEOF\n  ;;\n"
+	}
+	script += "esac\n"
+
+	editorDir, err := ioutil.TempDir("", "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(editorDir) })
+	path := filepath.Join(editorDir, "editor.sh")
+	if err := ioutil.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return []string{"/bin/sh", path}
+}
+
+func assertNoEditLeftOver(t *testing.T, dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		if f.Name() != "opendydnsctl.toml" {
+			t.Errorf("unexpected left over file: %s", f.Name())
+		}
+	}
+}
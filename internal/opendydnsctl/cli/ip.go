@@ -0,0 +1,412 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/client"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
+	"github.com/go-resty/resty/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSTUNResponse is returned when a STUN server answer cannot be decoded
+var ErrInvalidSTUNResponse = errors.New("invalid STUN response")
+
+// ErrNoPublicAddress is returned when no network interface has a public address
+var ErrNoPublicAddress = errors.New("no public address found")
+
+// STUN message constants (RFC 5389)
+const (
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMagicCookie      = 0x2112A442
+	stunMappedAddress    = 0x0001
+	stunXorMappedAddress = 0x0020
+	stunHeaderLength     = 20
+)
+
+// privateNetworks are the ranges of the global unicast addresses not reachable from Internet
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustParseCIDR("fc00::/7"),      // unique local addresses
+}
+
+// DetectIPs return the public IP addresses of the host, one per configured address family
+// the strategies are tried in order for each family, a family which cannot be detected is skipped
+func (c *cli) DetectIPs() ([]string, error) {
+	families := c.conf.IPDetection.Families
+	if len(families) == 0 {
+		ip, err := c.detectIP("")
+		if err != nil {
+			return nil, err
+		}
+		return []string{ip}, nil
+	}
+
+	var ips []string
+	var err error
+	for _, family := range families {
+		var ip string
+		if ip, err = c.detectIP(family); err != nil {
+			if err == client.ErrTimeout {
+				return nil, err
+			}
+			c.logger.Warn().Str("Family", family).Str("Error", err.Error()).Msg("unable to detect the IP address.")
+			continue
+		}
+		ips = append(ips, ip)
+	}
+
+	if len(ips) == 0 {
+		return nil, err
+	}
+
+	return ips, nil
+}
+
+// detectIP return the IP address of given family (any if empty) found by the first successful strategy
+func (c *cli) detectIP(family string) (string, error) {
+	var err error
+	for _, strategy := range c.conf.IPDetection.GetStrategies() {
+		var ip string
+		if ip, err = c.detectIPWith(strategy, family); err == nil {
+			if err = checkFamily(ip, family); err == nil {
+				c.logger.Debug().Str("Strategy", strategy).Str("IP", ip).Msg("IP address detected.")
+				return ip, nil
+			}
+		}
+
+		// the daemon cannot be reached: the aliases cannot be updated anyway
+		if err == client.ErrTimeout {
+			return "", err
+		}
+		c.logger.Debug().
+			Str("Strategy", strategy).
+			Str("Family", family).
+			Str("Error", err.Error()).
+			Msg("unable to detect the IP address. trying the next strategy.")
+	}
+
+	return "", fmt.Errorf("unable to detect the IP address: %w", err)
+}
+
+func (c *cli) detectIPWith(strategy, family string) (string, error) {
+	switch strategy {
+	case config.IPStrategyDaemon:
+		return c.GetIP()
+	case config.IPStrategyEcho:
+		urls := c.conf.IPDetection.EchoURLs
+		if len(urls) == 0 {
+			urls = []string{c.echoURL}
+		}
+		return firstIP(urls, func(u string) (string, error) { return c.echoIP(u, family) })
+	case config.IPStrategySTUN:
+		return firstIP(c.conf.IPDetection.GetSTUNServers(), func(server string) (string, error) {
+			return stunIP(c.dialer(), "udp"+familySuffix(family), server, c.timeout)
+		})
+	case config.IPStrategyInterface:
+		addrs, err := interfaceAddrs(c.conf.IPDetection.Interfaces)
+		if err != nil {
+			return "", err
+		}
+		return publicIP(addrs, family)
+	default:
+		return "", fmt.Errorf("unknown IP detection strategy `%s`", strategy)
+	}
+}
+
+// firstIP return the IP returned by the first source answering
+func firstIP(sources []string, detect func(source string) (string, error)) (string, error) {
+	var err error
+	for _, source := range sources {
+		var ip string
+		if ip, err = detect(source); err == nil {
+			return ip, nil
+		}
+		err = fmt.Errorf("%s: %w", source, err)
+	}
+
+	return "", err
+}
+
+// GetIP return the IP of the CLI as seen by the daemon
+func (c *cli) GetIP() (string, error) {
+	ip, err := c.apiClient.GetIP(c.token())
+	if err != nil {
+		return "", err
+	}
+
+	return ip.IP, nil
+}
+
+// GetEchoIP return the IP of the CLI as seen by the IP echo service
+// the service name is resolved using the configured resolver, if any
+func (c *cli) GetEchoIP() (string, error) {
+	return c.echoIP(c.echoURL, "")
+}
+
+// echoIP return the IP of given family (any if empty) of the CLI as seen by given IP echo service
+func (c *cli) echoIP(echoURL, family string) (string, error) {
+	httpClient := resty.New()
+	httpClient.SetTimeout(c.timeout)
+	if c.conf.IPLookupResolver != "" || family != "" {
+		dialer := c.dialer()
+		httpClient.SetTransport(&http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			// the service sees the address of the family used to connect
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network+familySuffix(family), address)
+			},
+		})
+	}
+
+	r, err := httpClient.R().Get(echoURL)
+	if err != nil {
+		return "", err
+	}
+	if r.IsError() {
+		return "", fmt.Errorf("IP echo service returned %s", r.Status())
+	}
+
+	return strings.TrimSpace(r.String()), nil
+}
+
+// dialer return the dialer used to reach the IP detection services
+// their names are resolved using the configured resolver, if any
+func (c *cli) dialer() *net.Dialer {
+	if c.conf.IPLookupResolver != "" {
+		return common.NewDialer(c.conf.IPLookupResolver, c.timeout)
+	}
+	return &net.Dialer{Timeout: c.timeout}
+}
+
+// stunIP return the IP of the host as seen by given STUN server, using a binding request (RFC 5389)
+func stunIP(dialer *net.Dialer, network, server string, timeout time.Duration) (string, error) {
+	conn, err := dialer.Dial(network, server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return "", err
+		}
+	}
+
+	request := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	if _, err := rand.Read(request[8:]); err != nil {
+		return "", err
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return "", err
+	}
+
+	response := make([]byte, 1500)
+	n, err := conn.Read(response)
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := parseSTUNResponse(response[:n], request[8:])
+	if err != nil {
+		return "", err
+	}
+
+	return ip.String(), nil
+}
+
+// parseSTUNResponse return the mapped address of a binding success response to the given transaction
+// the XOR-MAPPED-ADDRESS attribute is preferred over the MAPPED-ADDRESS one
+func parseSTUNResponse(msg, transactionID []byte) (net.IP, error) {
+	if len(msg) < stunHeaderLength ||
+		binary.BigEndian.Uint16(msg[0:]) != stunBindingSuccess ||
+		binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie ||
+		!bytes.Equal(msg[8:stunHeaderLength], transactionID) {
+		return nil, ErrInvalidSTUNResponse
+	}
+
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if stunHeaderLength+length > len(msg) {
+		return nil, ErrInvalidSTUNResponse
+	}
+
+	var mapped net.IP
+	attributes := msg[stunHeaderLength : stunHeaderLength+length]
+	for len(attributes) >= 4 {
+		attrType := binary.BigEndian.Uint16(attributes[0:])
+		attrLength := int(binary.BigEndian.Uint16(attributes[2:]))
+		if 4+attrLength > len(attributes) {
+			return nil, ErrInvalidSTUNResponse
+		}
+		value := attributes[4 : 4+attrLength]
+
+		switch attrType {
+		case stunXorMappedAddress:
+			// the address is xored with the magic cookie followed by the transaction ID
+			return stunAddress(value, msg[4:stunHeaderLength])
+		case stunMappedAddress:
+			ip, err := stunAddress(value, nil)
+			if err != nil {
+				return nil, err
+			}
+			mapped = ip
+		}
+
+		// the attributes are padded to a multiple of 4 bytes
+		padded := (attrLength + 3) &^ 3
+		if 4+padded >= len(attributes) {
+			break
+		}
+		attributes = attributes[4+padded:]
+	}
+
+	if mapped == nil {
+		return nil, ErrInvalidSTUNResponse
+	}
+
+	return mapped, nil
+}
+
+// stunAddress decode the address of a (XOR-)MAPPED-ADDRESS attribute, xored with key if given
+func stunAddress(value, key []byte) (net.IP, error) {
+	if len(value) < 4 {
+		return nil, ErrInvalidSTUNResponse
+	}
+
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil, ErrInvalidSTUNResponse
+	}
+	if len(value) < 4+size {
+		return nil, ErrInvalidSTUNResponse
+	}
+
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if key != nil {
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+
+	return ip, nil
+}
+
+// interfaceAddrs return the addresses of given network interfaces, of all of them if none is given
+func interfaceAddrs(names []string) ([]net.Addr, error) {
+	if len(names) == 0 {
+		return net.InterfaceAddrs()
+	}
+
+	var addrs []net.Addr
+	for _, name := range names {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, ifaceAddrs...)
+	}
+
+	return addrs, nil
+}
+
+// publicIP return the first public address of given family (IPv4 first if any)
+func publicIP(addrs []net.Addr, family string) (string, error) {
+	var candidates []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !isPublicIP(ipNet.IP) {
+			continue
+		}
+
+		ip := ipNet.IP.String()
+		if checkFamily(ip, family) == nil {
+			candidates = append(candidates, ip)
+		}
+	}
+
+	for _, ip := range candidates {
+		if !strings.Contains(ip, ":") {
+			return ip, nil
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0], nil
+	}
+
+	return "", ErrNoPublicAddress
+}
+
+// isPublicIP determinate if given address is reachable from Internet
+func isPublicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() {
+		return false
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkFamily make sure given IP is a valid address of given family (any if empty)
+func checkFamily(ip, family string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("%w: %s", ErrInvalidIP, ip)
+	}
+
+	isIPv6 := strings.Contains(ip, ":")
+	if (family == config.FamilyIPv4 && isIPv6) || (family == config.FamilyIPv6 && !isIPv6) {
+		return fmt.Errorf("%s is not an %s address", ip, family)
+	}
+
+	return nil
+}
+
+// familySuffix return the suffix of the network names (e.g. tcp4) restricting them to given family
+func familySuffix(family string) string {
+	switch family {
+	case config.FamilyIPv4:
+		return "4"
+	case config.FamilyIPv6:
+		return "6"
+	default:
+		return ""
+	}
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
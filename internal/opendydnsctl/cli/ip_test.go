@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/client"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/creekorful/open-dydns/proto_mock"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stunResponse build a binding success response mapping given address
+func stunResponse(transactionID []byte, attrType uint16, ip net.IP, port int) []byte {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	msg := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(msg[0:], stunBindingSuccess)
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	copy(msg[8:], transactionID)
+
+	value := make([]byte, 4+len(ip))
+	value[1] = 0x01
+	if len(ip) == net.IPv6len {
+		value[1] = 0x02
+	}
+	binary.BigEndian.PutUint16(value[2:], uint16(port))
+	copy(value[4:], ip)
+	if attrType == stunXorMappedAddress {
+		binary.BigEndian.PutUint16(value[2:], uint16(port)^uint16(stunMagicCookie>>16))
+		for i := range ip {
+			value[4+i] ^= msg[4+i]
+		}
+	}
+
+	// an unknown attribute comes first, padded to 4 bytes
+	attributes := []byte{0x80, 0x22, 0x00, 0x03, 'f', 'o', 'o', 0x00}
+	attribute := make([]byte, 4)
+	binary.BigEndian.PutUint16(attribute[0:], attrType)
+	binary.BigEndian.PutUint16(attribute[2:], uint16(len(value)))
+	attributes = append(attributes, append(attribute, value...)...)
+
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(attributes)))
+	return append(msg, attributes...)
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	transactionID := []byte("0123456789ab")
+
+	for _, test := range []struct {
+		attrType uint16
+		ip       string
+	}{
+		{stunXorMappedAddress, "192.0.2.1"},
+		{stunXorMappedAddress, "2001:db8::1"},
+		{stunMappedAddress, "198.51.100.7"},
+	} {
+		ip, err := parseSTUNResponse(stunResponse(transactionID, test.attrType, net.ParseIP(test.ip), 4242), transactionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip.String() != test.ip {
+			t.Errorf("wrong IP decoded: %s instead of %s", ip, test.ip)
+		}
+	}
+
+	response := stunResponse(transactionID, stunXorMappedAddress, net.ParseIP("192.0.2.1"), 4242)
+
+	// the answer of another transaction
+	if _, err := parseSTUNResponse(response, []byte("ba9876543210")); err != ErrInvalidSTUNResponse {
+		t.Errorf("parseSTUNResponse() should have returned ErrInvalidSTUNResponse: %v", err)
+	}
+
+	// truncated answers
+	for _, length := range []int{10, len(response) - 2} {
+		if _, err := parseSTUNResponse(response[:length], transactionID); err != ErrInvalidSTUNResponse {
+			t.Errorf("parseSTUNResponse() should have returned ErrInvalidSTUNResponse: %v", err)
+		}
+	}
+}
+
+func TestStunIP(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the server maps the source address of the request
+	go func() {
+		b := make([]byte, 1500)
+		n, addr, err := conn.ReadFrom(b)
+		if err != nil || n != stunHeaderLength {
+			return
+		}
+		udpAddr := addr.(*net.UDPAddr)
+		_, _ = conn.WriteTo(stunResponse(b[8:n], stunXorMappedAddress, udpAddr.IP, udpAddr.Port), addr)
+	}()
+
+	ip, err := stunIP(&net.Dialer{}, "udp4", conn.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "127.0.0.1" {
+		t.Errorf("wrong IP returned: %s", ip)
+	}
+
+	// nothing answers
+	if _, err := stunIP(&net.Dialer{}, "udp4", conn.LocalAddr().String(), 100*time.Millisecond); err == nil {
+		t.Error("stunIP() should have failed")
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	var addrs []net.Addr
+	for _, cidr := range []string{
+		"127.0.0.1/8",
+		"192.168.1.10/24",
+		"100.64.0.3/10",
+		"fe80::1/64",
+		"fd00::10/64",
+		"2001:db8::10/64",
+		"203.0.113.5/24",
+	} {
+		ip, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network.IP = ip
+		addrs = append(addrs, network)
+	}
+
+	tests := []struct {
+		family   string
+		expected string
+	}{
+		{"", "203.0.113.5"},
+		{config.FamilyIPv4, "203.0.113.5"},
+		{config.FamilyIPv6, "2001:db8::10"},
+	}
+	for _, test := range tests {
+		ip, err := publicIP(addrs, test.family)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip != test.expected {
+			t.Errorf("wrong %s address returned: %s", test.family, ip)
+		}
+	}
+
+	if _, err := publicIP(addrs[:5], ""); err != ErrNoPublicAddress {
+		t.Errorf("publicIP() should have returned ErrNoPublicAddress: %v", err)
+	}
+}
+
+func TestCli_DetectIPs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("192.0.2.1\n"))
+	}))
+	defer server.Close()
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		tok:       proto.TokenDto{Token: "test"},
+		timeout:   time.Second,
+		conf: config.Config{IPDetection: config.IPDetectionConfig{
+			Strategies: []string{config.IPStrategyDaemon, config.IPStrategyEcho},
+			Families:   []string{config.FamilyIPv4, config.FamilyIPv6},
+			EchoURLs:   []string{"http://127.0.0.1:1/ip", server.URL},
+		}},
+	}
+
+	// the daemon only sees the IPv6 address: the IPv4 one is found by the next strategy
+	clientMock.EXPECT().GetIP(c.tok).Return(proto.IPDto{IP: "2001:db8::1", Version: 6}, nil).Times(2)
+
+	ips, err := c.DetectIPs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ips, []string{"192.0.2.1", "2001:db8::1"}) {
+		t.Errorf("wrong IPs detected: %v", ips)
+	}
+
+	// without family, the first address found is returned
+	c.conf.IPDetection.Families = nil
+	clientMock.EXPECT().GetIP(c.tok).Return(proto.IPDto{}, errors.New("unavailable"))
+
+	if ips, err := c.DetectIPs(); err != nil || !reflect.DeepEqual(ips, []string{"192.0.2.1"}) {
+		t.Errorf("wrong IPs detected: %v (%v)", ips, err)
+	}
+
+	// the daemon cannot be reached: no need to go further
+	clientMock.EXPECT().GetIP(c.tok).Return(proto.IPDto{}, client.ErrTimeout)
+
+	if _, err := c.DetectIPs(); err != client.ErrTimeout {
+		t.Errorf("DetectIPs() should have returned ErrTimeout: %v", err)
+	}
+}
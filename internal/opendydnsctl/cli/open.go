@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// defaultPorts are the ports omitted from the URLs as implied by their scheme
+var defaultPorts = map[string]int{"http": 80, "https": 443}
+
+// AliasURL return the URL of the service fronted by given alias
+// the port is omitted if zero or the default one of the scheme
+func AliasURL(aliasName, scheme string, port int) (string, error) {
+	scheme = strings.ToLower(scheme)
+	if scheme == "" {
+		scheme = "https"
+	}
+	if port < 0 || port > 65535 {
+		return "", fmt.Errorf("invalid port %d", port)
+	}
+
+	host := strings.TrimSuffix(aliasName, ".")
+	if port != 0 && port != defaultPorts[scheme] {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	u := url.URL{Scheme: scheme, Host: host, Path: "/"}
+	return u.String(), nil
+}
+
+// Browser return the command opening an URL in the user preferred browser
+// using $BROWSER, then the platform default. It is empty in headless environments
+func Browser() []string {
+	return browser(runtime.GOOS, os.Getenv)
+}
+
+func browser(goos string, getenv func(string) string) []string {
+	if b := strings.Fields(getenv("BROWSER")); len(b) > 0 {
+		return b
+	}
+
+	switch goos {
+	case "windows":
+		return []string{"rundll32", "url.dll,FileProtocolHandler"}
+	case "darwin":
+		return []string{"open"}
+	default:
+		// no graphical session: nothing to open the URL in
+		if getenv("DISPLAY") == "" && getenv("WAYLAND_DISPLAY") == "" {
+			return nil
+		}
+		return []string{"xdg-open"}
+	}
+}
+
+// OpenURL open given URL using the browser command, or write it into out if there is none
+func OpenURL(u string, browser []string, out io.Writer) error {
+	if len(browser) == 0 {
+		_, err := fmt.Fprintln(out, u)
+		return err
+	}
+
+	cmd := exec.Command(browser[0], append(browser[1:], u)...)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error while running browser `%s`: %w", strings.Join(browser, " "), err)
+	}
+
+	return nil
+}
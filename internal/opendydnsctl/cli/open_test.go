@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAliasURL(t *testing.T) {
+	tests := []struct {
+		alias    string
+		scheme   string
+		port     int
+		expected string
+	}{
+		{"home.example.org", "", 0, "https://home.example.org/"},
+		{"home.example.org.", "http", 0, "http://home.example.org/"},
+		{"home.example.org", "https", 443, "https://home.example.org/"},
+		{"home.example.org", "HTTP", 80, "http://home.example.org/"},
+		{"home.example.org", "http", 8080, "http://home.example.org:8080/"},
+		{"home.example.org", "https", 80, "https://home.example.org:80/"},
+		{"nas.example.org", "smb", 445, "smb://nas.example.org:445/"},
+	}
+
+	for _, test := range tests {
+		u, err := AliasURL(test.alias, test.scheme, test.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != test.expected {
+			t.Errorf("AliasURL(%s, %s, %d) returned %s instead of %s", test.alias, test.scheme, test.port, u, test.expected)
+		}
+	}
+
+	if _, err := AliasURL("home.example.org", "https", 70000); err == nil {
+		t.Error("AliasURL() should have failed")
+	}
+}
+
+func TestBrowser(t *testing.T) {
+	tests := []struct {
+		goos     string
+		env      map[string]string
+		expected []string
+	}{
+		{"linux", map[string]string{}, nil},
+		{"linux", map[string]string{"DISPLAY": ":0"}, []string{"xdg-open"}},
+		{"linux", map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, []string{"xdg-open"}},
+		{"linux", map[string]string{"BROWSER": "lynx -accept_all_cookies"}, []string{"lynx", "-accept_all_cookies"}},
+		{"darwin", map[string]string{}, []string{"open"}},
+		{"windows", map[string]string{}, []string{"rundll32", "url.dll,FileProtocolHandler"}},
+	}
+
+	for _, test := range tests {
+		env := test.env
+		if b := browser(test.goos, func(key string) string { return env[key] }); !reflect.DeepEqual(b, test.expected) {
+			t.Errorf("wrong browser for %s %v: %v", test.goos, test.env, b)
+		}
+	}
+}
+
+func TestOpenURL_Headless(t *testing.T) {
+	var out bytes.Buffer
+	if err := OpenURL("https://home.example.org/", nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "https://home.example.org/\n" {
+		t.Errorf("URL not printed: %s", out.String())
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the stub browser records the URL it has been given
+	path := filepath.Join(dir, "opened")
+	script := filepath.Join(dir, "browser.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$1\" > \""+path+"\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := OpenURL("https://home.example.org/", []string{"/bin/sh", script}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("nothing should have been printed: %s", out.String())
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(b)) != "https://home.example.org/" {
+		t.Errorf("wrong URL opened: %s", b)
+	}
+
+	if err := OpenURL("https://home.example.org/", []string{filepath.Join(dir, "missing")}, &out); err == nil {
+		t.Error("OpenURL() should have failed")
+	}
+}
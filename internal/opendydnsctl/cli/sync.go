@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"math/rand"
+	"time"
+)
+
+// jitterRand is seeded on startup so the clients don't draw the same jitters
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// SyncConfig return the background synchronization configuration
+func (c *cli) SyncConfig() config.SyncConfig {
+	return c.conf.Sync
+}
+
+// NextSync return the delay before the next background synchronization given the number of consecutive
+// failures: the interval is doubled on each of them up to the maximum backoff, then a random jitter is added
+func NextSync(conf config.SyncConfig, failures int, jitter func(max time.Duration) time.Duration) time.Duration {
+	delay, maxBackoff := conf.GetInterval(), conf.GetMaxBackoff()
+	for i := 0; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	if conf.Jitter > 0 && jitter != nil {
+		delay += jitter(conf.Jitter)
+	}
+
+	return delay
+}
+
+// RandomJitter return a random duration in [0, max)
+func RandomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
+
+// isTransient determinate if given error may go away by retrying later:
+// the daemon cannot be reached or failed, as opposed to a rejected request
+func isTransient(err error) bool {
+	var apiErr *proto.ErrorDto
+	var httpErr *echo.HTTPError
+	if errors.As(err, &apiErr) || errors.As(err, &httpErr) {
+		return errors.Is(err, proto.ErrInternal)
+	}
+
+	return true
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/client"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/creekorful/open-dydns/proto_mock"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestNextSync(t *testing.T) {
+	conf := config.SyncConfig{Interval: time.Minute, MaxBackoff: 10 * time.Minute}
+
+	for failures, expected := range []time.Duration{
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		10 * time.Minute,
+		10 * time.Minute,
+	} {
+		if delay := NextSync(conf, failures, nil); delay != expected {
+			t.Errorf("wrong delay after %d failures: %s instead of %s", failures, delay, expected)
+		}
+	}
+
+	// the jitter is added to the delay, backoff included
+	conf.Jitter = 30 * time.Second
+	jitter := func(max time.Duration) time.Duration { return max / 2 }
+	if delay := NextSync(conf, 0, jitter); delay != time.Minute+15*time.Second {
+		t.Errorf("wrong delay: %s", delay)
+	}
+	if delay := NextSync(conf, 100, jitter); delay != 10*time.Minute+15*time.Second {
+		t.Errorf("wrong delay: %s", delay)
+	}
+
+	// the defaults are used if nothing is configured
+	if delay := NextSync(config.SyncConfig{}, 0, RandomJitter); delay != config.DefaultSyncInterval {
+		t.Errorf("wrong default delay: %s", delay)
+	}
+	if delay := NextSync(config.SyncConfig{}, 100, RandomJitter); delay != config.DefaultSyncMaxBackoff {
+		t.Errorf("wrong default maximum delay: %s", delay)
+	}
+}
+
+func TestRandomJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if j := RandomJitter(time.Second); j < 0 || j >= time.Second {
+			t.Fatalf("jitter out of range: %s", j)
+		}
+	}
+
+	if j := RandomJitter(0); j != 0 {
+		t.Errorf("jitter should be zero: %s", j)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for err, expected := range map[error]bool{
+		client.ErrTimeout:                                 true,
+		errors.New("connection refused"):                  true,
+		proto.ErrInternal:                                 true,
+		&proto.ErrorDto{Message: "internal server error"}: true,
+		proto.ErrAliasNotFound:                            false,
+		&proto.ErrorDto{Message: "alias not found"}:       false,
+	} {
+		if isTransient(err) != expected {
+			t.Errorf("isTransient(%v) should have returned %v", err, expected)
+		}
+	}
+}
+
+func TestCli_Synchronize_Transient(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		tok:       proto.TokenDto{Token: "test-token"},
+		conf: config.Config{
+			Aliases: map[string]config.AliasConfig{"foo.example.org": {Synchronize: true}},
+		},
+	}
+
+	// the daemon cannot be reached: the synchronization must be retried
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "foo.example.org", Value: "192.0.2.1"}).
+		Return(proto.AliasDto{}, client.ErrTimeout)
+
+	if err := c.Synchronize("192.0.2.1"); !errors.Is(err, client.ErrTimeout) {
+		t.Errorf("Synchronize() should have returned ErrTimeout: %v", err)
+	}
+}
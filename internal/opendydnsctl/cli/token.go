@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"time"
+)
+
+// TokenInfo represent the claims of an access token
+// they are decoded locally: the signature is NOT verified
+type TokenInfo struct {
+	UserID    uint
+	IssuedAt  time.Time // zero if unknown
+	ExpiresAt time.Time // zero if the token never expires
+	Scopes    []string  // empty for an unrestricted token
+	Aliases   []string
+}
+
+// Expired determinate if the token is expired at given time
+func (ti TokenInfo) Expired(now time.Time) bool {
+	return !ti.ExpiresAt.IsZero() && !now.Before(ti.ExpiresAt)
+}
+
+// RefreshDue determinate if the token should be refreshed at given time: once past half its lifetime
+// the tokens which never expire cannot be refreshed, nor the expired ones
+func (ti TokenInfo) RefreshDue(now time.Time) bool {
+	if ti.ExpiresAt.IsZero() || ti.IssuedAt.IsZero() || ti.Expired(now) {
+		return false
+	}
+
+	return !now.Before(ti.IssuedAt.Add(ti.ExpiresAt.Sub(ti.IssuedAt) / 2))
+}
+
+// ParseTokenInfo decode the claims of given access token without verifying it
+func ParseTokenInfo(token string) (TokenInfo, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return TokenInfo{}, fmt.Errorf("malformed access token: %w", err)
+	}
+
+	var info TokenInfo
+	if userID, ok := claims["userID"].(float64); ok {
+		info.UserID = uint(userID)
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		info.IssuedAt = time.Unix(int64(iat), 0)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		info.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	info.Scopes = stringsClaim(claims, "scopes")
+	info.Aliases = stringsClaim(claims, "aliases")
+
+	return info, nil
+}
+
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	values, _ := claims[name].([]interface{})
+
+	var result []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
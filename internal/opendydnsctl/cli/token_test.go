@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
+	"github.com/dgrijalva/jwt-go"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTokenInfo(t *testing.T) {
+	// the signing key is unknown to the CLI
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID":       12,
+		"tokenVersion": 1,
+		"iat":          1600000000,
+		"exp":          1600003600,
+		"scopes":       []string{"aliases:update"},
+		"aliases":      []string{"home.example.org"},
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ParseTokenInfo(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(info, TokenInfo{
+		UserID:    12,
+		IssuedAt:  time.Unix(1600000000, 0),
+		ExpiresAt: time.Unix(1600003600, 0),
+		Scopes:    []string{"aliases:update"},
+		Aliases:   []string{"home.example.org"},
+	}) {
+		t.Errorf("wrong token info: %+v", info)
+	}
+
+	if info.Expired(time.Unix(1600000000, 0)) {
+		t.Error("token should not be expired yet")
+	}
+	if !info.Expired(time.Unix(1600003600, 0)) {
+		t.Error("token should be expired")
+	}
+}
+
+func TestParseTokenInfo_NoExpiry(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userID": 1}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ParseTokenInfo(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.ExpiresAt.IsZero() || !info.IssuedAt.IsZero() || len(info.Scopes) != 0 {
+		t.Errorf("wrong token info: %+v", info)
+	}
+	if info.Expired(time.Now()) {
+		t.Error("token without expiry should never expire")
+	}
+}
+
+func TestParseTokenInfo_Malformed(t *testing.T) {
+	if _, err := ParseTokenInfo("not-a-token"); err == nil {
+		t.Error("ParseTokenInfo() should have failed")
+	}
+}
+
+func TestCli_TokenInfo_NotLoggedIn(t *testing.T) {
+	c := cli{conf: config.Config{}}
+
+	if _, err := c.TokenInfo(); err != ErrNotLoggedIn {
+		t.Error("TokenInfo() should have returned ErrNotLoggedIn")
+	}
+}
+
+func TestTokenInfo_RefreshDue(t *testing.T) {
+	info := TokenInfo{IssuedAt: time.Unix(1600000000, 0), ExpiresAt: time.Unix(1600003600, 0)}
+
+	tests := map[int64]bool{
+		1600000000: false,
+		1600001799: false,
+		1600001800: true,
+		1600003599: true,
+		1600003600: false, // expired
+	}
+	for now, due := range tests {
+		if info.RefreshDue(time.Unix(now, 0)) != due {
+			t.Errorf("wrong RefreshDue(%d): %t", now, !due)
+		}
+	}
+
+	if (TokenInfo{IssuedAt: time.Unix(1600000000, 0)}).RefreshDue(time.Unix(1700000000, 0)) {
+		t.Error("a token which never expires should not be refreshed")
+	}
+}
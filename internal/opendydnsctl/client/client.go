@@ -1,25 +1,75 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/go-resty/resty/v2"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// ErrTimeout is returned when the daemon didn't answer before the deadline
+var ErrTimeout = errors.New("timeout exceeded while waiting for the daemon")
+
+// ErrIncompatibleAPIVersion is returned when the daemon doesn't support the API version of the client
+var ErrIncompatibleAPIVersion = errors.New("incompatible daemon API version")
+
+// DefaultUserAgent is the User-Agent sent by the Client unless overridden
+const DefaultUserAgent = "opendydns-cli/" + common.Version
+
+// Options are the optional Client settings
+type Options struct {
+	// Timeout is both the deadline of each request and of the whole client lifetime
+	// no deadline if zero
+	Timeout time.Duration
+	// Insecure disable the verification of the daemon certificate
+	// this must only be used against development daemons (self-signed certificate)
+	Insecure bool
+	// UserAgent override the User-Agent header, DefaultUserAgent is used if empty
+	UserAgent string
+}
+
 // Client is an HTTP REST client to interface with a OpenDyDNS daemon
 type Client struct {
 	httpClient *resty.Client
+	deadline   time.Time
 }
 
 // NewClient return a new configured Client using given baseURL
-func NewClient(baseURL string) proto.APIContract {
+func NewClient(baseURL string, opts Options) proto.APIContract {
 	httpClient := resty.New()
 	httpClient.SetHostURL(baseURL)
 	httpClient.SetAuthScheme("Bearer")
 
-	return &Client{
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	httpClient.SetHeader("User-Agent", userAgent)
+	httpClient.SetHeader(proto.HeaderAPIVersion, strconv.Itoa(proto.APIVersion))
+	httpClient.OnAfterResponse(checkAPIVersion)
+
+	c := &Client{
 		httpClient: httpClient,
 	}
+
+	if opts.Insecure {
+		httpClient.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+	}
+
+	if opts.Timeout > 0 {
+		httpClient.SetTimeout(opts.Timeout)
+		c.deadline = time.Now().Add(opts.Timeout)
+	}
+
+	return c
 }
 
 // Authenticate see proto.APIContract
@@ -27,19 +77,119 @@ func (c *Client) Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error)
 	var result proto.TokenDto
 	var err proto.ErrorDto
 
-	_, _ = c.httpClient.R().SetBody(cred).SetResult(&result).SetError(&err).Post("/sessions")
+	r, cancel := c.newRequest()
+	defer cancel()
 
-	return result, nonNilError(err)
+	_, reqErr := r.SetBody(cred).SetResult(&result).SetError(&err).Post("/sessions")
+
+	return result, checkError(reqErr, err)
+}
+
+// RefreshToken see proto.APIContract
+func (c *Client) RefreshToken(token proto.TokenDto) (proto.TokenDto, error) {
+	var result proto.TokenDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Post("/sessions/refresh")
+
+	return result, checkError(reqErr, err)
+}
+
+// Register see proto.APIContract
+func (c *Client) Register(registration proto.RegistrationDto) (proto.RegisteredUserDto, error) {
+	var result proto.RegisteredUserDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetBody(registration).SetResult(&result).SetError(&err).Post("/users")
+
+	return result, checkError(reqErr, err)
+}
+
+// VerifyEmail see proto.APIContract
+func (c *Client) VerifyEmail(verification proto.VerificationDto) (proto.TokenDto, error) {
+	var result proto.TokenDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetBody(verification).SetResult(&result).SetError(&err).Post("/users/verify")
+
+	return result, checkError(reqErr, err)
 }
 
 // GetAliases see proto.APIContract
-func (c *Client) GetAliases(token proto.TokenDto) ([]proto.AliasDto, error) {
+func (c *Client) GetAliases(token proto.TokenDto, fields ...string) ([]proto.AliasDto, error) {
+	var result []proto.AliasDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	if len(fields) > 0 {
+		r.SetQueryParam("fields", strings.Join(fields, ","))
+	}
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/aliases")
+
+	return result, checkError(reqErr, err)
+}
+
+// GetAlias see proto.APIContract
+func (c *Client) GetAlias(token proto.TokenDto, name string, fields ...string) (proto.AliasDto, error) {
+	var result proto.AliasDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	if len(fields) > 0 {
+		r.SetQueryParam("fields", strings.Join(fields, ","))
+	}
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).
+		Get(fmt.Sprintf("/aliases/%s", url.PathEscape(name)))
+
+	return result, checkError(reqErr, err)
+}
+
+// GetAliasesPage see proto.APIContract
+func (c *Client) GetAliasesPage(token proto.TokenDto, cursor string, limit int) ([]proto.AliasDto, string, error) {
 	var result []proto.AliasDto
 	var err proto.ErrorDto
 
-	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/aliases")
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	res, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).
+		SetQueryParam("cursor", cursor).
+		SetQueryParam("limit", strconv.Itoa(limit)).
+		Get("/aliases")
+	if err := checkError(reqErr, err); err != nil {
+		return nil, "", err
+	}
+
+	return result, res.Header().Get(proto.HeaderNextCursor), nil
+}
+
+// GetAliasesByName see proto.APIContract
+func (c *Client) GetAliasesByName(token proto.TokenDto, names []string) (proto.AliasesLookupDto, error) {
+	var result proto.AliasesLookupDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetBody(proto.AliasNamesDto{Names: names}).SetResult(&result).SetError(&err).
+		Post("/aliases/batch-get")
 
-	return result, nonNilError(err)
+	return result, checkError(reqErr, err)
 }
 
 // RegisterAlias see proto.APIContract
@@ -47,9 +197,12 @@ func (c *Client) RegisterAlias(token proto.TokenDto, alias proto.AliasDto) (prot
 	var result proto.AliasDto
 	var err proto.ErrorDto
 
-	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetBody(alias).SetResult(&result).SetError(&err).Post("/aliases")
+	r, cancel := c.newRequest()
+	defer cancel()
 
-	return result, nonNilError(err)
+	_, reqErr := r.SetAuthToken(token.Token).SetBody(alias).SetResult(&result).SetError(&err).Post("/aliases")
+
+	return result, checkError(reqErr, err)
 }
 
 // UpdateAlias see proto.APIContract
@@ -57,18 +210,85 @@ func (c *Client) UpdateAlias(token proto.TokenDto, alias proto.AliasDto) (proto.
 	var result proto.AliasDto
 	var err proto.ErrorDto
 
-	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetBody(alias).SetResult(&result).SetError(&err).Put("/aliases")
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetBody(alias).SetResult(&result).SetError(&err).Put("/aliases")
 
-	return result, nonNilError(err)
+	return result, checkError(reqErr, err)
+}
+
+// PatchAlias see proto.APIContract
+func (c *Client) PatchAlias(token proto.TokenDto, name string, patch proto.AliasPatchDto) (proto.AliasDto, error) {
+	var result proto.AliasDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetBody(patch).SetResult(&result).SetError(&err).
+		Patch(fmt.Sprintf("/aliases/%s", url.PathEscape(name)))
+
+	return result, checkError(reqErr, err)
 }
 
 // DeleteAlias see proto.APIContract
 func (c *Client) DeleteAlias(token proto.TokenDto, name string) error {
 	var err proto.ErrorDto
 
-	_, _ = c.httpClient.R().SetAuthToken(token.Token).Delete(fmt.Sprintf("/aliases/%s", name))
+	r, cancel := c.newRequest()
+	defer cancel()
 
-	return nonNilError(err)
+	_, reqErr := r.SetAuthToken(token.Token).SetError(&err).Delete(fmt.Sprintf("/aliases/%s", name))
+
+	return checkError(reqErr, err)
+}
+
+// SetAliasEnabled see proto.APIContract
+func (c *Client) SetAliasEnabled(token proto.TokenDto, name string, enabled bool) (proto.AliasDto, error) {
+	var result proto.AliasDto
+	var err proto.ErrorDto
+
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).
+		Post(fmt.Sprintf("/aliases/%s/%s", name, action))
+
+	return result, checkError(reqErr, err)
+}
+
+// RenameAlias see proto.APIContract
+func (c *Client) RenameAlias(token proto.TokenDto, name, newName string) (proto.AliasDto, error) {
+	var result proto.AliasDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetBody(proto.RenameDto{Name: newName}).SetResult(&result).SetError(&err).
+		Post(fmt.Sprintf("/aliases/%s/rename", name))
+
+	return result, checkError(reqErr, err)
+}
+
+// NotifyAlias see proto.APIContract
+func (c *Client) NotifyAlias(token proto.TokenDto, name, value string) (proto.AliasDto, error) {
+	var result proto.AliasDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetBody(proto.NotifyDto{Value: value}).SetResult(&result).SetError(&err).
+		Post(fmt.Sprintf("/aliases/%s/notify", name))
+
+	return result, checkError(reqErr, err)
 }
 
 // GetDomains see proto.APIContract
@@ -76,9 +296,327 @@ func (c *Client) GetDomains(token proto.TokenDto) ([]proto.DomainDto, error) {
 	var result []proto.DomainDto
 	var err proto.ErrorDto
 
-	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/domains")
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/domains")
+
+	return result, checkError(reqErr, err)
+}
+
+// GetEvents see proto.APIContract
+func (c *Client) GetEvents(token proto.TokenDto, cursor uint) ([]proto.EventDto, error) {
+	var result []proto.EventDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.
+		SetAuthToken(token.Token).
+		SetQueryParam("cursor", strconv.FormatUint(uint64(cursor), 10)).
+		SetResult(&result).
+		SetError(&err).
+		Get("/events")
+
+	return result, checkError(reqErr, err)
+}
+
+// GetAllEvents see proto.APIContract
+func (c *Client) GetAllEvents(token proto.TokenDto, cursor uint) ([]proto.OwnedEventDto, error) {
+	var result []proto.OwnedEventDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.
+		SetAuthToken(token.Token).
+		SetQueryParam("cursor", strconv.FormatUint(uint64(cursor), 10)).
+		SetResult(&result).
+		SetError(&err).
+		Get("/admin/events")
+
+	return result, checkError(reqErr, err)
+}
+
+// GetAllAliases see proto.APIContract
+func (c *Client) GetAllAliases(token proto.TokenDto, cursor string, limit int, filter proto.AliasFilterDto) ([]proto.OwnedAliasDto, string, error) {
+	var result []proto.OwnedAliasDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	res, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).
+		SetQueryParam("cursor", cursor).
+		SetQueryParam("limit", strconv.Itoa(limit)).
+		SetQueryParam("domain", filter.Domain).
+		SetQueryParam("owner", filter.Owner).
+		Get("/admin/aliases")
+	if err := checkError(reqErr, err); err != nil {
+		return nil, "", err
+	}
+
+	return result, res.Header().Get(proto.HeaderNextCursor), nil
+}
+
+// GetUserAliases see proto.APIContract
+func (c *Client) GetUserAliases(token proto.TokenDto, email, cursor string, limit int) ([]proto.AliasDto, string, error) {
+	var result []proto.AliasDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	res, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).
+		SetQueryParam("cursor", cursor).
+		SetQueryParam("limit", strconv.Itoa(limit)).
+		Get(fmt.Sprintf("/admin/users/%s/aliases", url.PathEscape(email)))
+	if err := checkError(reqErr, err); err != nil {
+		return nil, "", err
+	}
+
+	return result, res.Header().Get(proto.HeaderNextCursor), nil
+}
+
+// GetDNSStatus see proto.APIContract
+func (c *Client) GetDNSStatus(token proto.TokenDto) (proto.DNSStatusDto, error) {
+	var result proto.DNSStatusDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/admin/dns")
+
+	return result, checkError(reqErr, err)
+}
+
+// GetLimits see proto.APIContract
+func (c *Client) GetLimits(token proto.TokenDto) (proto.UserLimitsDto, error) {
+	var result proto.UserLimitsDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/users/me/limits")
+
+	return result, checkError(reqErr, err)
+}
+
+// GetDelegations see proto.APIContract
+func (c *Client) GetDelegations(token proto.TokenDto) ([]proto.DelegationDto, error) {
+	var result []proto.DelegationDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/admin/delegations")
+
+	return result, checkError(reqErr, err)
+}
+
+// ExportZone see proto.APIContract
+func (c *Client) ExportZone(token proto.TokenDto, domain string) (proto.ZoneDto, error) {
+	var result proto.ZoneDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).
+		Get(fmt.Sprintf("/admin/zones/%s", url.PathEscape(domain)))
+
+	return result, checkError(reqErr, err)
+}
+
+// RevokeTokens see proto.APIContract
+func (c *Client) RevokeTokens(token proto.TokenDto) error {
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetError(&err).Post("/users/me/revoke-all")
+
+	return checkError(reqErr, err)
+}
+
+// GetWebhooks see proto.APIContract
+func (c *Client) GetWebhooks(token proto.TokenDto) ([]proto.WebhookDto, error) {
+	var result []proto.WebhookDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/users/me/webhooks")
+
+	return result, checkError(reqErr, err)
+}
+
+// CreateWebhook see proto.APIContract
+func (c *Client) CreateWebhook(token proto.TokenDto, webhook proto.WebhookDto) (proto.WebhookDto, error) {
+	var result proto.WebhookDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetBody(webhook).SetResult(&result).SetError(&err).Post("/users/me/webhooks")
+
+	return result, checkError(reqErr, err)
+}
+
+// DeleteWebhook see proto.APIContract
+func (c *Client) DeleteWebhook(token proto.TokenDto, id uint) error {
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetError(&err).Delete(fmt.Sprintf("/users/me/webhooks/%d", id))
+
+	return checkError(reqErr, err)
+}
+
+// CreateShare see proto.APIContract
+func (c *Client) CreateShare(token proto.TokenDto, name string, expiresIn time.Duration) (proto.ShareDto, error) {
+	var result proto.ShareDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetBody(proto.ShareRequestDto{ExpiresIn: int(expiresIn / time.Second)}).
+		SetResult(&result).SetError(&err).
+		Post(fmt.Sprintf("/aliases/%s/shares", url.PathEscape(name)))
+
+	return result, checkError(reqErr, err)
+}
+
+// GetShares see proto.APIContract
+func (c *Client) GetShares(token proto.TokenDto) ([]proto.ShareDto, error) {
+	var result []proto.ShareDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/users/me/shares")
+
+	return result, checkError(reqErr, err)
+}
+
+// DeleteShare see proto.APIContract
+func (c *Client) DeleteShare(token proto.TokenDto, id uint) error {
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetError(&err).Delete(fmt.Sprintf("/users/me/shares/%d", id))
+
+	return checkError(reqErr, err)
+}
+
+// GetSharedAlias see proto.APIContract
+func (c *Client) GetSharedAlias(shareToken string) (proto.SharedAliasDto, error) {
+	var result proto.SharedAliasDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetResult(&result).SetError(&err).Get(fmt.Sprintf("/shared/%s", url.PathEscape(shareToken)))
+
+	return result, checkError(reqErr, err)
+}
+
+// GetIP see proto.APIContract
+func (c *Client) GetIP(token proto.TokenDto) (proto.IPDto, error) {
+	var result proto.IPDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/ip")
+
+	return result, checkError(reqErr, err)
+}
+
+// GetVersion see proto.APIContract
+func (c *Client) GetVersion() (proto.VersionDto, error) {
+	var result proto.VersionDto
+	var err proto.ErrorDto
+
+	r, cancel := c.newRequest()
+	defer cancel()
+
+	_, reqErr := r.SetResult(&result).SetError(&err).Get("/version")
+
+	return result, checkError(reqErr, err)
+}
+
+// newRequest create a new request bound to the client deadline
+// the returned function must be called once the request is done
+func (c *Client) newRequest() (*resty.Request, context.CancelFunc) {
+	if c.deadline.IsZero() {
+		return c.httpClient.R(), func() {}
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), c.deadline)
+	return c.httpClient.R().SetContext(ctx), cancel
+}
+
+// checkAPIVersion make sure the daemon supports the client API version
+// daemons released before the negotiation don't advertise their versions
+func checkAPIVersion(_ *resty.Client, r *resty.Response) error {
+	versions := r.Header().Get(proto.HeaderAPIVersions)
+	if versions == "" {
+		return nil
+	}
+
+	min, max, err := proto.ParseAPIVersions(versions)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case proto.APIVersion < min:
+		return fmt.Errorf("%w: the daemon requires API version %d at least (CLI uses %d), please upgrade the CLI",
+			ErrIncompatibleAPIVersion, min, proto.APIVersion)
+	case proto.APIVersion > max:
+		return fmt.Errorf("%w: the daemon supports API version %d at most (CLI uses %d), please upgrade the daemon",
+			ErrIncompatibleAPIVersion, max, proto.APIVersion)
+	}
+
+	return nil
+}
+
+// checkError return the transport error if any, or the API one
+func checkError(reqErr error, err proto.ErrorDto) error {
+	if reqErr != nil {
+		if isTimeout(reqErr) {
+			return ErrTimeout
+		}
+		return reqErr
+	}
+
+	return nonNilError(err)
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
 
-	return result, nonNilError(err)
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 func nonNilError(err proto.ErrorDto) error {
@@ -0,0 +1,481 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/proto"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_GetDomains(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/domains" || r.Header.Get("Authorization") != "Bearer test-token" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message": "invalid or expired jwt"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"domain": "example.org"}, {"domain": "demo.dydns.org"}]`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{})
+
+	domains, err := c.GetDomains(proto.TokenDto{Token: "test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 || domains[0].Domain != "example.org" || domains[1].Domain != "demo.dydns.org" {
+		t.Errorf("wrong domains returned: %v", domains)
+	}
+
+	if _, err := c.GetDomains(proto.TokenDto{Token: "bad-token"}); err == nil {
+		t.Error("GetDomains() should have failed")
+	}
+}
+
+func TestClient_GetAliasesByName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var names proto.AliasNamesDto
+		if err := json.NewDecoder(r.Body).Decode(&names); err != nil || r.Method != http.MethodPost || r.URL.Path != "/aliases/batch-get" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(names.Names) != 2 || names.Names[0] != "foo.example.org" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"aliases": [{"domain": "foo.example.org", "value": "1.2.3.4"}], "missing": ["bar.example.org"]}`))
+	}))
+	defer server.Close()
+
+	result, err := NewClient(server.URL, Options{}).
+		GetAliasesByName(proto.TokenDto{Token: "test-token"}, []string{"foo.example.org", "bar.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Aliases) != 1 || result.Aliases[0].Value != "1.2.3.4" {
+		t.Errorf("wrong aliases returned: %v", result.Aliases)
+	}
+	if len(result.Missing) != 1 || result.Missing[0] != "bar.example.org" {
+		t.Errorf("wrong missing names returned: %v", result.Missing)
+	}
+}
+
+func TestClient_GetAlias(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/aliases/foo.example.org" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "alias not found"}`))
+			return
+		}
+		if r.URL.Query().Get("fields") != "domain,ttl" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"domain": "foo.example.org", "ttl": 60}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{})
+
+	alias, err := c.GetAlias(proto.TokenDto{Token: "test-token"}, "foo.example.org", "domain", "ttl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias != (proto.AliasDto{Domain: "foo.example.org", TTL: 60}) {
+		t.Errorf("wrong alias returned: %v", alias)
+	}
+
+	if _, err := c.GetAlias(proto.TokenDto{Token: "test-token"}, "bar.example.org"); !errors.Is(err, proto.ErrAliasNotFound) {
+		t.Errorf("GetAlias() should have returned ErrAliasNotFound: %v", err)
+	}
+}
+
+func TestClient_GetAllAliases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/admin/aliases" || q.Get("cursor") != "abc" || q.Get("limit") != "10" || q.Get("domain") != "example.org" || q.Get("owner") != "alois@micard.lu" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(proto.HeaderNextCursor, "def")
+		_, _ = w.Write([]byte(`[{"domain": "foo.example.org", "owner": "alois@micard.lu"}]`))
+	}))
+	defer server.Close()
+
+	aliases, next, err := NewClient(server.URL, Options{}).
+		GetAllAliases(proto.TokenDto{Token: "test-token"}, "abc", 10, proto.AliasFilterDto{Domain: "example.org", Owner: "alois@micard.lu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0].Domain != "foo.example.org" || aliases[0].Owner != "alois@micard.lu" {
+		t.Errorf("wrong aliases returned: %v", aliases)
+	}
+	if next != "def" {
+		t.Errorf("wrong next cursor: %s", next)
+	}
+}
+
+func TestClient_Webhooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/users/me/webhooks":
+			var webhook proto.WebhookDto
+			if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil || webhook.Secret != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(fmt.Sprintf(`{"id": 3, "url": "%s", "signed": true}`, webhook.URL)))
+		case r.Method == http.MethodDelete && r.URL.Path == "/users/me/webhooks/3":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "webhook not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{})
+	tok := proto.TokenDto{Token: "test-token"}
+
+	webhook, err := c.CreateWebhook(tok, proto.WebhookDto{URL: "https://example.org/hooks", Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook != (proto.WebhookDto{ID: 3, URL: "https://example.org/hooks", Signed: true}) {
+		t.Errorf("wrong webhook returned: %v", webhook)
+	}
+
+	if err := c.DeleteWebhook(tok, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteWebhook(tok, 4); !errors.Is(err, proto.ErrWebhookNotFound) {
+		t.Errorf("DeleteWebhook() should have returned ErrWebhookNotFound: %v", err)
+	}
+}
+
+func TestClient_ExportZone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/zones/example.org" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "requested domain not found"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"domain": "example.org", "zone": "foo.example.org.\tIN\tA\t192.0.2.1\n"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{})
+
+	zone, err := c.ExportZone(proto.TokenDto{Token: "test-token"}, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.Domain != "example.org" || zone.Zone != "foo.example.org.\tIN\tA\t192.0.2.1\n" {
+		t.Errorf("wrong zone returned: %v", zone)
+	}
+
+	if _, err := c.ExportZone(proto.TokenDto{Token: "test-token"}, "example.com"); !errors.Is(err, proto.ErrDomainNotFound) {
+		t.Errorf("ExportZone() should have returned ErrDomainNotFound: %v", err)
+	}
+}
+
+func TestClient_PatchAlias(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.Method != http.MethodPatch || r.URL.Path != "/aliases/foo.example.org" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// only the patched fields are sent
+		if len(body) != 1 || body["ttl"] != float64(60) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"domain": "foo.example.org", "value": "1.2.3.4", "ttl": 60}`))
+	}))
+	defer server.Close()
+
+	ttl := 60
+	alias, err := NewClient(server.URL, Options{}).
+		PatchAlias(proto.TokenDto{Token: "test-token"}, "foo.example.org", proto.AliasPatchDto{TTL: &ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.Value != "1.2.3.4" || alias.TTL != 60 {
+		t.Errorf("wrong alias returned: %v", alias)
+	}
+}
+
+func TestClient_DeleteAlias(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/aliases/foo.example.org" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "alias not found"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{})
+
+	if err := c.DeleteAlias(proto.TokenDto{Token: "test-token"}, "foo.example.org"); err != nil {
+		t.Error(err)
+	}
+
+	err := c.DeleteAlias(proto.TokenDto{Token: "test-token"}, "bar.example.org")
+	if err == nil || err.Error() != "alias not found" {
+		t.Errorf("DeleteAlias() should have returned the API error: %v", err)
+	}
+}
+
+func TestClient_ErrorDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"message": "invalid field(s): domain (fqdn), value (ip)", "details": {"domain": "must be a fully qualified domain name", "value": "must be a valid IPv4 or IPv6 address"}}`))
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, Options{}).RegisterAlias(proto.TokenDto{Token: "test-token"}, proto.AliasDto{})
+
+	var errDto *proto.ErrorDto
+	if !errors.As(err, &errDto) {
+		t.Fatalf("expected *proto.ErrorDto, got %v", err)
+	}
+	if errDto.Error() != "invalid field(s): domain (fqdn), value (ip)" {
+		t.Errorf("wrong message: %s", errDto.Error())
+	}
+	if len(errDto.Details) != 2 || errDto.Details["value"] != "must be a valid IPv4 or IPv6 address" {
+		t.Errorf("wrong details: %v", errDto.Details)
+	}
+}
+
+func TestClient_UserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version": "1.0.0"}`))
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL, Options{}).GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != DefaultUserAgent || !strings.HasPrefix(userAgent, "opendydns-cli/") {
+		t.Errorf("wrong User-Agent sent: %s", userAgent)
+	}
+
+	if _, err := NewClient(server.URL, Options{UserAgent: "my-router/1.0"}).GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "my-router/1.0" {
+		t.Errorf("wrong User-Agent sent: %s", userAgent)
+	}
+}
+
+func TestClient_Insecure(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version": "1.0.0"}`))
+	}))
+	defer server.Close()
+
+	// the server certificate is self-signed
+	if _, err := NewClient(server.URL, Options{}).GetVersion(); err == nil {
+		t.Error("GetVersion() should have failed")
+	}
+
+	version, err := NewClient(server.URL, Options{Insecure: true}).GetVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != "1.0.0" {
+		t.Errorf("wrong version returned: %s", version.Version)
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"version": "1.0.0"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{Timeout: 50 * time.Millisecond})
+
+	if _, err := c.GetVersion(); err != ErrTimeout {
+		t.Errorf("GetVersion() should have returned ErrTimeout, got: %v", err)
+	}
+}
+
+func TestClient_Timeout_Overall(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version": "1.0.0"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{Timeout: 100 * time.Millisecond})
+
+	// each request fits in the timeout, but not both of them
+	version, err := c.GetVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != "1.0.0" {
+		t.Errorf("wrong version returned: %s", version.Version)
+	}
+
+	if _, err := c.GetVersion(); err != ErrTimeout {
+		t.Errorf("GetVersion() should have returned ErrTimeout, got: %v", err)
+	}
+}
+
+func TestClient_NoTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version": "1.0.0"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{})
+
+	if _, err := c.GetVersion(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_APIVersion(t *testing.T) {
+	var sentVersion, versions string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sentVersion = r.Header.Get(proto.HeaderAPIVersion)
+		if versions != "" {
+			w.Header().Set(proto.HeaderAPIVersions, versions)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version": "1.0.0"}`))
+	}))
+	defer server.Close()
+
+	// compatible daemons, including the ones not advertising their versions
+	for _, v := range []string{"", proto.APIVersions(), fmt.Sprintf("%d-%d", proto.APIVersion, proto.APIVersion+2)} {
+		versions = v
+		if _, err := NewClient(server.URL, Options{}).GetVersion(); err != nil {
+			t.Errorf("GetVersion() should have succeeded with versions `%s`: %s", v, err)
+		}
+		if sentVersion != strconv.Itoa(proto.APIVersion) {
+			t.Errorf("wrong API version sent: %s", sentVersion)
+		}
+	}
+
+	// incompatible daemons
+	for _, v := range []string{
+		fmt.Sprintf("%d-%d", proto.APIVersion+1, proto.APIVersion+2), // CLI too old
+		fmt.Sprintf("0-%d", proto.APIVersion-1),                      // daemon too old
+	} {
+		versions = v
+		if _, err := NewClient(server.URL, Options{}).GetVersion(); !errors.Is(err, ErrIncompatibleAPIVersion) {
+			t.Errorf("GetVersion() should have returned ErrIncompatibleAPIVersion with versions `%s`: %v", v, err)
+		}
+	}
+}
+
+func TestClient_Register(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var registration proto.RegistrationDto
+		if r.URL.Path != "/users" || json.NewDecoder(r.Body).Decode(&registration) != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if registration.InviteCode != "invite" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "invalid invite code"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"email": "%s", "verification_required": true}`, registration.Email)
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{})
+
+	user, err := c.Register(proto.RegistrationDto{Email: "test@example.org", Password: "test", InviteCode: "invite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "test@example.org" || !user.VerificationRequired || user.Token != "" {
+		t.Errorf("wrong registered user returned: %v", user)
+	}
+
+	if _, err := c.Register(proto.RegistrationDto{Email: "test@example.org", Password: "test"}); !errors.Is(err, proto.ErrInvalidInviteCode) {
+		t.Errorf("Register() should have returned ErrInvalidInviteCode: %v", err)
+	}
+}
+
+func TestClient_RefreshToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != "/sessions/refresh" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer old-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message": "invalid or expired jwt"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"token": "new-token"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{})
+
+	tok, err := c.RefreshToken(proto.TokenDto{Token: "old-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Token != "new-token" {
+		t.Errorf("wrong token returned: %s", tok.Token)
+	}
+
+	if _, err := c.RefreshToken(proto.TokenDto{Token: "expired-token"}); !errors.Is(err, proto.ErrInvalidToken) {
+		t.Errorf("RefreshToken() should have returned ErrInvalidToken: %v", err)
+	}
+}
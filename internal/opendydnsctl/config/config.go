@@ -1,8 +1,16 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/common"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
 )
 
 //go:generate mockgen -source config.go -destination=../config_mock/config_mock.go -package=config_mock
@@ -12,6 +20,16 @@ var DefaultConfig = Config{
 	APIAddr: "http://127.0.0.1:8888",
 }
 
+const (
+	// EnvAPIAddr is the environment variable overriding the daemon address
+	EnvAPIAddr = "OPENDYDNS_API_ADDR"
+	// EnvToken is the environment variable overriding the access token
+	EnvToken = "OPENDYDNS_TOKEN"
+)
+
+// ErrConfigFromEnv is returned when saving a configuration read from the environment only
+var ErrConfigFromEnv = errors.New("the configuration is read from the environment and cannot be saved, use a config file instead")
+
 // Provider represent the way of storing read the configuration
 // used to abstract & ease unit testing without having to write a real file
 type Provider interface {
@@ -37,7 +55,8 @@ func (fp *fileProvider) Load() (Config, error) {
 }
 
 func (fp *fileProvider) Save(config Config) error {
-	return common.SaveToml(fp.filePath, &config)
+	// keep the comments of hand edited files
+	return common.UpdateToml(fp.filePath, &config)
 }
 
 // NewFileProvider return a new config Provider using file for storage
@@ -47,19 +66,351 @@ func NewFileProvider(filepath string) Provider {
 	}
 }
 
+// envProvider override the daemon address & token of the configuration file
+// with the environment variables. Without configuration file, the configuration is
+// read from the environment only if the daemon address is given (stateless usage)
+type envProvider struct {
+	filePath string
+	file     Provider
+	getenv   func(key string) string
+}
+
+// NewEnvProvider return a new config Provider using file for storage,
+// overridden by the OPENDYDNS_API_ADDR & OPENDYDNS_TOKEN environment variables
+func NewEnvProvider(filePath string) Provider {
+	return &envProvider{
+		filePath: filePath,
+		file:     NewFileProvider(filePath),
+		getenv:   os.Getenv,
+	}
+}
+
+// FromEnv determinate if the configuration can be read from the environment only
+func FromEnv() bool {
+	return os.Getenv(EnvAPIAddr) != ""
+}
+
+func (ep *envProvider) Load() (Config, error) {
+	config := DefaultConfig
+	if !ep.stateless() {
+		var err error
+		if config, err = ep.file.Load(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if apiAddr := ep.getenv(EnvAPIAddr); apiAddr != "" {
+		config.APIAddr = apiAddr
+	}
+	if token := ep.getenv(EnvToken); token != "" {
+		config.Token = token
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// Save save the configuration to the file, without the values given by the environment
+func (ep *envProvider) Save(config Config) error {
+	if ep.stateless() {
+		return ErrConfigFromEnv
+	}
+
+	if ep.getenv(EnvAPIAddr) != "" || ep.getenv(EnvToken) != "" {
+		stored, err := ep.file.Load()
+		if err != nil {
+			return err
+		}
+
+		if ep.getenv(EnvAPIAddr) != "" {
+			config.APIAddr = stored.APIAddr
+		}
+		if ep.getenv(EnvToken) != "" {
+			config.Token = stored.Token
+		}
+	}
+
+	return ep.file.Save(config)
+}
+
+func (ep *envProvider) stateless() bool {
+	if ep.getenv(EnvAPIAddr) == "" {
+		return false
+	}
+
+	_, err := os.Stat(ep.filePath)
+	return os.IsNotExist(err)
+}
+
 // Config represent the OpenDyDNS-CLI configuration
 type Config struct {
 	APIAddr string
 	Token   string
+	// DisableUpdateCheck prevent the CLI from checking if a newer version is available
+	DisableUpdateCheck bool
+	// Insecure disable the verification of the daemon certificate (development only)
+	// same as the --insecure flag
+	Insecure bool
+	// UserAgent override the User-Agent sent to the daemon
+	UserAgent string
+	// IPLookupResolver is the DNS server used to resolve the IP echo service (host[:port])
+	// prefix it with tls:// to use DNS over TLS. The system resolver is used if empty
+	IPLookupResolver string
+	// IPDetection configure how the public IP of the host is detected
+	IPDetection IPDetectionConfig
+	// Sync configure the background synchronization (daemon command)
+	Sync    SyncConfig
 	Aliases map[string]AliasConfig
 }
 
+// DefaultSyncInterval is the delay between two background synchronizations when none is configured
+const DefaultSyncInterval = 5 * time.Minute
+
+// DefaultSyncMaxBackoff is the longest delay between two attempts after failures when none is configured
+const DefaultSyncMaxBackoff = time.Hour
+
+// SyncConfig represent the background synchronization part of the configuration file
+type SyncConfig struct {
+	// Interval between two synchronizations, defaults to 5m
+	Interval time.Duration
+	// Jitter is the maximum random delay added to the interval, so the clients don't synchronize all at once
+	Jitter time.Duration
+	// MaxBackoff is the longest delay between two attempts once the daemon or the network failed,
+	// the interval being doubled on each consecutive failure. Defaults to 1h
+	MaxBackoff time.Duration
+}
+
+// GetInterval return the configured interval, or the default one
+func (sc SyncConfig) GetInterval() time.Duration {
+	if sc.Interval == 0 {
+		return DefaultSyncInterval
+	}
+	return sc.Interval
+}
+
+// GetMaxBackoff return the configured maximum backoff, or the default one
+// it is never shorter than the interval
+func (sc SyncConfig) GetMaxBackoff() time.Duration {
+	maxBackoff := sc.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = DefaultSyncMaxBackoff
+	}
+	if interval := sc.GetInterval(); maxBackoff < interval {
+		return interval
+	}
+	return maxBackoff
+}
+
+// Validate return the first problem of the background synchronization configuration, if any
+func (sc SyncConfig) Validate() error {
+	switch {
+	case sc.Interval < 0:
+		return fmt.Errorf("negative interval %s", sc.Interval)
+	case sc.Jitter < 0:
+		return fmt.Errorf("negative jitter %s", sc.Jitter)
+	case sc.MaxBackoff < 0:
+		return fmt.Errorf("negative maximum backoff %s", sc.MaxBackoff)
+	}
+
+	return nil
+}
+
+// The IP detection strategies
+const (
+	// IPStrategyDaemon ask the daemon for the address it sees
+	IPStrategyDaemon = "daemon"
+	// IPStrategyEcho ask the HTTP(S) IP echo services
+	IPStrategyEcho = "echo"
+	// IPStrategySTUN send a binding request to the STUN servers
+	IPStrategySTUN = "stun"
+	// IPStrategyInterface look for a public address on the network interfaces
+	IPStrategyInterface = "interface"
+)
+
+// The address families
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// DefaultIPStrategies are the strategies used when none are configured
+var DefaultIPStrategies = []string{IPStrategyDaemon, IPStrategyEcho}
+
+// DefaultSTUNServers are the STUN servers used when none are configured
+var DefaultSTUNServers = []string{"stun.l.google.com:19302", "stun.cloudflare.com:3478"}
+
+// IPDetectionConfig represent the IP detection part of the configuration file
+type IPDetectionConfig struct {
+	// Strategies are tried in order until one detects the address (daemon, echo, stun, interface)
+	// defaults to daemon then echo
+	Strategies []string
+	// Families are the address families to detect (ipv4, ipv6), each one separately
+	// if empty, a single address of the family returned by the first successful strategy is detected
+	Families []string
+	// EchoURLs are the IP echo services (returning the IP of the caller as text), tried in order
+	EchoURLs []string
+	// STUNServers are the STUN servers (host:port), tried in order
+	STUNServers []string
+	// Interfaces are the network interfaces inspected, all of them if empty
+	Interfaces []string
+}
+
+// GetStrategies return the configured strategies, or the default ones
+func (ic IPDetectionConfig) GetStrategies() []string {
+	if len(ic.Strategies) == 0 {
+		return DefaultIPStrategies
+	}
+	return ic.Strategies
+}
+
+// GetSTUNServers return the configured STUN servers, or the default ones
+func (ic IPDetectionConfig) GetSTUNServers() []string {
+	if len(ic.STUNServers) == 0 {
+		return DefaultSTUNServers
+	}
+	return ic.STUNServers
+}
+
+// Validate return the first problem of the IP detection configuration, if any
+func (ic IPDetectionConfig) Validate() error {
+	for _, strategy := range ic.Strategies {
+		switch strategy {
+		case IPStrategyDaemon, IPStrategyEcho, IPStrategySTUN, IPStrategyInterface:
+		default:
+			return fmt.Errorf("unknown IP detection strategy `%s`", strategy)
+		}
+	}
+
+	for _, family := range ic.Families {
+		if family != FamilyIPv4 && family != FamilyIPv6 {
+			return fmt.Errorf("unknown address family `%s`", family)
+		}
+	}
+
+	for _, echoURL := range ic.EchoURLs {
+		u, err := url.Parse(echoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("IP echo service `%s` is not an http(s) URL", echoURL)
+		}
+	}
+
+	for _, server := range ic.STUNServers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return fmt.Errorf("invalid STUN server `%s`: %w", server, err)
+		}
+	}
+
+	return nil
+}
+
 // AliasConfig represent the aliases part of the configuration file
 type AliasConfig struct {
 	Synchronize bool
+	// Value is published instead of the detected IP when synchronizing (e.g. a tunnel endpoint
+	// behind a CGNAT): either a fixed address or a template using the detected IP ({{.IP}}).
+	// The detected IP is published if empty
+	Value string
+}
+
+// valueFuncs are the functions available in the value templates
+var valueFuncs = template.FuncMap{
+	"combine": combineIP,
+}
+
+// PublishedValue return the value to publish for the alias given the detected IP
+func (ac AliasConfig) PublishedValue(ip string) (string, error) {
+	if ac.Value == "" {
+		return ip, nil
+	}
+
+	tmpl, err := parseValue(ac.Value)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, struct{ IP string }{IP: ip}); err != nil {
+		return "", err
+	}
+
+	value := strings.TrimSpace(b.String())
+	if net.ParseIP(value) == nil {
+		return "", fmt.Errorf("value `%s` is not an IP address", value)
+	}
+
+	return value, nil
+}
+
+func parseValue(value string) (*template.Template, error) {
+	return template.New("value").Funcs(valueFuncs).Parse(value)
+}
+
+// combineIP return the address made of the first bits of ip and the remaining bits of suffix
+// e.g. combine "2001:db8:1:2::9" 64 "::10" is 2001:db8:1:2::10
+func combineIP(ip string, bits int, suffix string) (string, error) {
+	prefixIP, suffixIP := net.ParseIP(ip), net.ParseIP(suffix)
+	if prefixIP == nil || suffixIP == nil {
+		return "", fmt.Errorf("cannot combine `%s` and `%s`: invalid IP address", ip, suffix)
+	}
+
+	// both addresses must be of the same family
+	if v4 := prefixIP.To4(); v4 != nil {
+		if suffixIP = suffixIP.To4(); suffixIP == nil {
+			return "", fmt.Errorf("cannot combine IPv4 `%s` and IPv6 `%s`", ip, suffix)
+		}
+		prefixIP = v4
+	} else if suffixIP.To4() != nil {
+		return "", fmt.Errorf("cannot combine IPv6 `%s` and IPv4 `%s`", ip, suffix)
+	}
+
+	if bits < 0 || bits > len(prefixIP)*8 {
+		return "", fmt.Errorf("invalid prefix length %d", bits)
+	}
+
+	mask := net.CIDRMask(bits, len(prefixIP)*8)
+	combined := make(net.IP, len(prefixIP))
+	for i := range combined {
+		combined[i] = prefixIP[i]&mask[i] | suffixIP[i]&^mask[i]
+	}
+
+	return combined.String(), nil
 }
 
 // Valid determinate if current configuration is valid one
 func (c Config) Valid() bool {
-	return c.APIAddr != ""
+	return c.Validate() == nil
+}
+
+// Validate return the first problem of the configuration, if any
+func (c Config) Validate() error {
+	if c.APIAddr == "" {
+		return fmt.Errorf("APIAddr is missing")
+	}
+
+	u, err := url.Parse(c.APIAddr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("APIAddr `%s` is not an http(s) URL", c.APIAddr)
+	}
+
+	for name, alias := range c.Aliases {
+		if alias.Value == "" {
+			continue
+		}
+		if _, err := parseValue(alias.Value); err != nil {
+			return fmt.Errorf("invalid value of alias %s: %w", name, err)
+		}
+	}
+
+	if err := c.IPDetection.Validate(); err != nil {
+		return fmt.Errorf("invalid IP detection: %w", err)
+	}
+
+	if err := c.Sync.Validate(); err != nil {
+		return fmt.Errorf("invalid sync: %w", err)
+	}
+
+	return nil
 }
@@ -1,6 +1,13 @@
 package config
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestIsValid(t *testing.T) {
 	config := Config{}
@@ -12,4 +19,236 @@ func TestIsValid(t *testing.T) {
 	if !DefaultConfig.Valid() {
 		t.Error("DefaultConfig should be valid")
 	}
+
+	for _, addr := range []string{"127.0.0.1:8888", "ftp://127.0.0.1", "http://"} {
+		if err := (Config{APIAddr: addr}).Validate(); err == nil {
+			t.Errorf("Validate() should have failed for %s", addr)
+		}
+	}
+
+	conf := Config{APIAddr: "http://127.0.0.1:8888", Aliases: map[string]AliasConfig{"foo.example.org": {Value: "{{.IP"}}}
+	if err := conf.Validate(); err == nil {
+		t.Error("Validate() should have failed for an invalid value template")
+	}
+}
+
+func TestIPDetectionConfig_Validate(t *testing.T) {
+	valid := IPDetectionConfig{
+		Strategies:  []string{IPStrategySTUN, IPStrategyInterface, IPStrategyEcho, IPStrategyDaemon},
+		Families:    []string{FamilyIPv4, FamilyIPv6},
+		EchoURLs:    []string{"https://ifconfig.me/ip"},
+		STUNServers: []string{"stun.example.org:3478", "[2001:db8::1]:3478"},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	for _, conf := range []IPDetectionConfig{
+		{Strategies: []string{"upnp"}},
+		{Families: []string{"ipv5"}},
+		{EchoURLs: []string{"ifconfig.me/ip"}},
+		{STUNServers: []string{"stun.example.org"}},
+	} {
+		if err := conf.Validate(); err == nil {
+			t.Errorf("Validate() should have failed for %v", conf)
+		}
+	}
+
+	// the defaults are used if nothing is configured
+	if s := (IPDetectionConfig{}).GetStrategies(); len(s) != 2 || s[0] != IPStrategyDaemon || s[1] != IPStrategyEcho {
+		t.Errorf("wrong default strategies: %v", s)
+	}
+	if s := valid.GetSTUNServers(); len(s) != 2 || s[0] != "stun.example.org:3478" {
+		t.Errorf("wrong STUN servers: %v", s)
+	}
+}
+
+func TestSyncConfig(t *testing.T) {
+	if err := (SyncConfig{Interval: time.Minute, Jitter: time.Second, MaxBackoff: time.Hour}).Validate(); err != nil {
+		t.Error(err)
+	}
+
+	for _, conf := range []SyncConfig{
+		{Interval: -time.Minute},
+		{Jitter: -time.Second},
+		{MaxBackoff: -time.Hour},
+	} {
+		if err := conf.Validate(); err == nil {
+			t.Errorf("Validate() should have failed for %v", conf)
+		}
+	}
+
+	// the maximum backoff is never shorter than the interval
+	if d := (SyncConfig{Interval: 2 * time.Hour}).GetMaxBackoff(); d != 2*time.Hour {
+		t.Errorf("wrong maximum backoff: %s", d)
+	}
+	if d := (SyncConfig{}).GetMaxBackoff(); d != DefaultSyncMaxBackoff {
+		t.Errorf("wrong default maximum backoff: %s", d)
+	}
+}
+
+func TestAliasConfig_PublishedValue(t *testing.T) {
+	for value, expected := range map[string]string{
+		"":                                   "2001:db8:1:2::9",
+		"{{.IP}}":                            "2001:db8:1:2::9",
+		"10.8.0.1":                           "10.8.0.1",
+		`{{combine .IP 64 "::10"}}`:          "2001:db8:1:2::10",
+		`{{combine .IP 56 "::ab:0:0:0:10"}}`: "2001:db8:1:ab::10",
+	} {
+		published, err := AliasConfig{Value: value}.PublishedValue("2001:db8:1:2::9")
+		if err != nil {
+			t.Errorf("PublishedValue() failed for %s: %s", value, err)
+			continue
+		}
+		if published != expected {
+			t.Errorf("wrong value published for %s: %s", value, published)
+		}
+	}
+
+	published, err := AliasConfig{Value: `{{combine .IP 24 "0.0.0.42"}}`}.PublishedValue("198.51.100.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published != "198.51.100.42" {
+		t.Errorf("wrong value published: %s", published)
+	}
+
+	// the result must be an IP address
+	for value, ip := range map[string]string{
+		"{{.IP}}:8080":                 "198.51.100.7",
+		`{{combine .IP 24 "::1"}}`:     "198.51.100.7",
+		`{{combine .IP 64 "0.0.0.1"}}`: "2001:db8:1:2::9",
+		`{{combine .IP 129 "::1"}}`:    "2001:db8:1:2::9",
+		"{{.Port}}":                    "198.51.100.7",
+	} {
+		if published, err := (AliasConfig{Value: value}).PublishedValue(ip); err == nil {
+			t.Errorf("PublishedValue() should have failed for %s: %s", value, published)
+		}
+	}
+}
+
+func TestFileProvider_SaveKeepComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opendydnsctl.toml")
+	content := "# managed by hand\nAPIAddr = \"https://dydns.example.org\" # the production daemon\nToken = \"\"\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := NewFileProvider(path)
+	conf, err := provider.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Token = "new-token"
+	if err := provider.Save(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "# managed by hand\nAPIAddr = \"https://dydns.example.org\" # the production daemon\nToken = \"new-token\"\n") {
+		t.Errorf("comments not kept:\n%s", b)
+	}
+
+	if conf, err = provider.Load(); err != nil || conf.Token != "new-token" {
+		t.Errorf("wrong config loaded: %v (%v)", conf, err)
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opendydnsctl.toml")
+	if err := NewFileProvider(path).Save(Config{APIAddr: "http://127.0.0.1:8888", Token: "file-token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{}
+	provider := &envProvider{filePath: path, file: NewFileProvider(path), getenv: func(key string) string { return env[key] }}
+
+	conf, err := provider.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.APIAddr != "http://127.0.0.1:8888" || conf.Token != "file-token" {
+		t.Errorf("wrong config loaded: %+v", conf)
+	}
+
+	// the environment takes precedence over the file
+	env[EnvAPIAddr] = "https://dydns.example.org"
+	env[EnvToken] = "env-token"
+
+	conf, err = provider.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.APIAddr != "https://dydns.example.org" || conf.Token != "env-token" {
+		t.Errorf("wrong config loaded: %+v", conf)
+	}
+
+	// but is never saved into it
+	conf.Aliases = map[string]AliasConfig{"foo.example.org": {Synchronize: true}}
+	if err := provider.Save(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := NewFileProvider(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.APIAddr != "http://127.0.0.1:8888" || stored.Token != "file-token" || !stored.Aliases["foo.example.org"].Synchronize {
+		t.Errorf("wrong config saved: %+v", stored)
+	}
+
+	env[EnvAPIAddr] = "127.0.0.1:8888"
+	if _, err := provider.Load(); err == nil {
+		t.Error("Load() should have failed for an invalid address")
+	}
+}
+
+func TestEnvProvider_Stateless(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opendydnsctl.toml")
+	env := map[string]string{}
+	provider := &envProvider{filePath: path, file: NewFileProvider(path), getenv: func(key string) string { return env[key] }}
+
+	if _, err := provider.Load(); err == nil {
+		t.Error("Load() should have failed without config file nor environment")
+	}
+
+	env[EnvAPIAddr] = "https://dydns.example.org"
+	env[EnvToken] = "env-token"
+
+	conf, err := provider.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.APIAddr != "https://dydns.example.org" || conf.Token != "env-token" {
+		t.Errorf("wrong config loaded: %+v", conf)
+	}
+
+	if err := provider.Save(conf); err != ErrConfigFromEnv {
+		t.Errorf("Save() should have returned ErrConfigFromEnv: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("no config file should have been created")
+	}
 }
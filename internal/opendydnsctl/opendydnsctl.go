@@ -1,19 +1,37 @@
 package opendydnsctl
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/common"
 	cli2 "github.com/creekorful/open-dydns/internal/opendydnsctl/cli"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/client"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
 	"github.com/creekorful/open-dydns/proto"
-	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/crypto/ssh/terminal"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// ExitCodeTimeout is the exit code used when the daemon didn't answer in time
+const ExitCodeTimeout = 124
+
+// defaultTimeout is the default deadline of a command
+const defaultTimeout = 30 * time.Second
+
+// defaultResolver is the DNS server queried by default
+const defaultResolver = "1.1.1.1:53"
+
 // CLIApp represent the opendydnsctl running context
 type CLIApp struct {
 }
@@ -29,12 +47,21 @@ func (odc *CLIApp) App() *cli.App {
 		Name:    "opendydnsctl",
 		Usage:   "The OpenDyDNS CLI",
 		Authors: []*cli.Author{{Name: "Aloïs Micard", Email: "alois@micard.lu"}},
-		Version: "0.3.0",
+		Version: common.Version,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:  "config",
 				Value: "opendydnsctl.toml",
 			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Deadline of the requests and of the whole command",
+				Value: defaultTimeout,
+			},
+			&cli.BoolFlag{
+				Name:  "insecure",
+				Usage: "Skip the verification of the daemon certificate (development only)",
+			},
 		},
 		Commands: []*cli.Command{
 			{
@@ -42,18 +69,189 @@ func (odc *CLIApp) App() *cli.App {
 				ArgsUsage: "<EMAIL>",
 				Usage:     "Authenticate against an OpenDyDNS daemon",
 				Action:    odc.login,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "scope",
+						Usage: "Restrict the access token to given scope (aliases:read, aliases:update)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "alias",
+						Usage: "Restrict the scoped access token to given alias",
+					},
+				},
+			},
+			{
+				Name:      "signup",
+				ArgsUsage: "<EMAIL>",
+				Usage:     "Create an account on an OpenDyDNS daemon allowing self registration",
+				Action:    odc.signup,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "invite-code",
+						Usage: "The invite code required by the daemon (if any)",
+					},
+				},
+			},
+			{
+				Name:      "verify-email",
+				ArgsUsage: "<TOKEN>",
+				Usage:     "Activate your account using the token received by email",
+				Action:    odc.verifyEmail,
+			},
+			{
+				Name:   "logout",
+				Usage:  "Forget the saved access token",
+				Action: odc.logout,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Revoke all the access tokens of the user first (logout everywhere)",
+					},
+				},
+			},
+			{
+				Name:   "whoami",
+				Usage:  "Display the user the saved access token belongs to",
+				Action: odc.whoami,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "token-info",
+						Usage: "Display the claims of the access token (expiry, scopes)",
+					},
+				},
+			},
+			{
+				Name:  "webhooks",
+				Usage: "Manage the endpoints notified of the changes of your aliases",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "ls",
+						Usage:  "List the registered webhooks",
+						Action: odc.webhooksList,
+					},
+					{
+						Name:      "add",
+						ArgsUsage: "<URL>",
+						Usage:     "Register a webhook",
+						Action:    odc.webhooksAdd,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "secret",
+								Usage: "Sign the notifications using given secret (see the X-OpenDyDNS-Signature header)",
+							},
+						},
+					},
+					{
+						Name:      "rm",
+						ArgsUsage: "<ID>",
+						Usage:     "Unregister a webhook",
+						Action:    odc.webhooksRemove,
+					},
+				},
+			},
+			{
+				Name:  "shares",
+				Usage: "Manage the read-only links giving access to the value of your aliases",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "create",
+						ArgsUsage: "<ALIAS>",
+						Usage:     "Create a link giving access to the value of given alias and print it",
+						Action:    odc.sharesCreate,
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "expires-in",
+								Usage: "Lifetime of the link (e.g. 24h), it never expires if unset",
+							},
+						},
+					},
+					{
+						Name:   "ls",
+						Usage:  "List the links (their URL is only displayed on creation)",
+						Action: odc.sharesList,
+					},
+					{
+						Name:      "rm",
+						ArgsUsage: "<ID>",
+						Usage:     "Revoke a link",
+						Action:    odc.sharesRemove,
+					},
+				},
+			},
+			{
+				Name:   "limits",
+				Usage:  "Display the usage of the account and the restrictions applying to it",
+				Action: odc.limits,
 			},
 			{
 				Name:      "ls",
 				ArgsUsage: "<WHAT>",
 				Usage:     "List given resource (aliases, domains). Defaults to aliases",
 				Action:    odc.ls,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "group-by-domain",
+						Usage: "Group the aliases by their base domain",
+					},
+					&cli.StringFlag{
+						Name:  "field",
+						Usage: "Only print given field (e.g. domain, value) of each row",
+					},
+					&cli.StringFlag{
+						Name:  "fields",
+						Usage: "Only print given comma separated fields (e.g. domain,value) of each row, tab separated",
+					},
+				},
+			},
+			{
+				Name:      "get",
+				ArgsUsage: "<ALIAS>...",
+				Usage:     "Display the given aliases",
+				Action:    odc.get,
+			},
+			{
+				Name:      "open",
+				ArgsUsage: "<ALIAS>",
+				Usage:     "Open the service fronted by given alias in the browser (the URL is printed if there is none)",
+				Action:    odc.open,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "scheme",
+						Usage: "Scheme of the URL",
+						Value: "https",
+					},
+					&cli.IntFlag{
+						Name:  "port",
+						Usage: "Port of the URL, the default one of the scheme if unset",
+					},
+				},
+			},
+			{
+				Name:   "domains",
+				Usage:  "List the domains aliases can be registered under",
+				Action: odc.domains,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Output the domains as JSON",
+					},
+					&cli.StringFlag{
+						Name:  "field",
+						Usage: "Only print given field of each row",
+					},
+				},
 			},
 			{
 				Name:      "register",
 				ArgsUsage: "<ALIAS>",
 				Usage:     "Register an alias",
 				Action:    odc.register,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "follow",
+						Usage: "Keep the alias pointing at the address of given name, resolved by the daemon",
+					},
+				},
 			},
 			{
 				Name:      "rm",
@@ -63,10 +261,34 @@ func (odc *CLIApp) App() *cli.App {
 			},
 			{
 				Name:      "set-ip",
-				ArgsUsage: "<ALIAS> <IP>",
-				Usage:     "Override the IP value for given alias",
+				ArgsUsage: "<ALIAS> <IP> [IP]",
+				Usage:     "Override the IPv4 (A record) and/or IPv6 (AAAA record) value for given alias",
 				Action:    odc.setIP,
 			},
+			{
+				Name:      "set-ttl",
+				ArgsUsage: "<ALIAS> <SECONDS>",
+				Usage:     "Change the TTL of given alias without changing its value",
+				Action:    odc.setTTL,
+			},
+			{
+				Name:      "enable",
+				ArgsUsage: "<ALIAS>",
+				Usage:     "Publish again a disabled alias",
+				Action:    odc.setEnabled(true),
+			},
+			{
+				Name:      "disable",
+				ArgsUsage: "<ALIAS>",
+				Usage:     "Withdraw an alias from the DNS without deleting it",
+				Action:    odc.setEnabled(false),
+			},
+			{
+				Name:      "rename",
+				ArgsUsage: "<OLD> <NEW>",
+				Usage:     "Rename an alias, keeping its value",
+				Action:    odc.rename,
+			},
 			{
 				Name:      "set-synchronize",
 				ArgsUsage: "<ALIAS> <STATUS>",
@@ -79,6 +301,121 @@ func (odc *CLIApp) App() *cli.App {
 				Usage:   "Synchronize enabled aliases with current IP",
 				Action:  odc.synchronize,
 			},
+			{
+				Name:   "daemon",
+				Usage:  "Keep enabled aliases synchronized with current IP until interrupted",
+				Action: odc.daemon,
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "Delay between two synchronizations (default 5m, overrides the configuration)",
+					},
+					&cli.DurationFlag{
+						Name:  "jitter",
+						Usage: "Maximum random delay added to the interval (overrides the configuration)",
+					},
+					&cli.DurationFlag{
+						Name:  "max-backoff",
+						Usage: "Longest delay between two attempts after failures (default 1h, overrides the configuration)",
+					},
+				},
+			},
+			{
+				Name:  "admin",
+				Usage: "Administrate the daemon (admin only)",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "aliases",
+						Usage:  "List the aliases of every user, a page at a time",
+						Action: odc.adminAliases,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "domain",
+								Usage: "Only list the aliases under given domain",
+							},
+							&cli.StringFlag{
+								Name:  "owner",
+								Usage: "Only list the aliases of given user email",
+							},
+							&cli.IntFlag{
+								Name:  "limit",
+								Usage: "Maximum number of aliases listed (100 at most)",
+							},
+							&cli.StringFlag{
+								Name:  "cursor",
+								Usage: "List the page starting at given cursor (as displayed after the previous page)",
+							},
+						},
+					},
+					{
+						Name:      "user-aliases",
+						ArgsUsage: "<EMAIL>",
+						Usage:     "List the aliases of given user, a page at a time",
+						Action:    odc.adminUserAliases,
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "limit",
+								Usage: "Maximum number of aliases listed (100 at most)",
+							},
+							&cli.StringFlag{
+								Name:  "cursor",
+								Usage: "List the page starting at given cursor (as displayed after the previous page)",
+							},
+						},
+					},
+					{
+						Name:   "dns-status",
+						Usage:  "Display the state of the DNS circuit breaker and the pending record changes",
+						Action: odc.adminDNSStatus,
+					},
+					{
+						Name:   "delegations",
+						Usage:  "Display whether the managed domains are delegated to the expected nameservers",
+						Action: odc.adminDelegations,
+					},
+					{
+						Name:      "export-zone",
+						ArgsUsage: "<DOMAIN>",
+						Usage:     "Print the published aliases of given domain as a zone file fragment",
+						Action:    odc.adminExportZone,
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Manage the configuration file",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "edit",
+						Usage:  "Open the configuration file in $EDITOR, it is only saved once valid",
+						Action: odc.configEdit,
+					},
+				},
+			},
+			{
+				Name:      "resolve",
+				ArgsUsage: "<NAME>",
+				Usage:     "Display what the public DNS currently returns for given name",
+				Action:    odc.resolve,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "resolver",
+						Usage: "Address of the DNS server to query",
+						Value: defaultResolver,
+					},
+				},
+			},
+			{
+				Name:   "version",
+				Usage:  "Display the CLI version",
+				Action: odc.version,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "check",
+						Usage: "Check if a newer version is available",
+					},
+				},
+			},
 		},
 	}
 
@@ -86,9 +423,102 @@ func (odc *CLIApp) App() *cli.App {
 		app.Flags = append(app.Flags, flag)
 	}
 
+	// allow overriding the global timeout for a single command
+	for _, command := range app.Commands {
+		command.Flags = append(command.Flags, &cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Deadline of the requests and of the whole command (overrides the global one)",
+		})
+	}
+
+	wrapActions(app.Commands, odc.withRelogin)
+
 	return app
 }
 
+// wrapActions wrap the action of given commands (and of their subcommands) using given function
+func wrapActions(commands []*cli.Command, wrap func(cli.ActionFunc) cli.ActionFunc) {
+	for _, command := range commands {
+		if command.Action != nil {
+			command.Action = wrap(command.Action)
+		}
+		wrapActions(command.Subcommands, wrap)
+	}
+}
+
+// withRelogin run given action again once logged in, if it failed because
+// the access token has expired or has been revoked (the refresh came too late)
+func (odc *CLIApp) withRelogin(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		err := action(c)
+		if !errors.Is(err, proto.ErrInvalidToken) && !errors.Is(err, proto.ErrTokenRevoked) {
+			return err
+		}
+
+		if err := odc.relogin(c); err != nil {
+			return err
+		}
+
+		return action(c)
+	}
+}
+
+// relogin prompt the user for their credentials and replace the saved access token
+// the new token has the same restrictions (scopes, aliases) as the previous one
+func (odc *CLIApp) relogin(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	// nobody to prompt (e.g. cron job)
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		err := fmt.Errorf("session expired")
+		logger.Err(err).Msg("the access token has expired or has been revoked, please login again.")
+		return err
+	}
+
+	info, err := app.TokenInfo()
+	if err != nil {
+		logger.Err(err).Msg("unable to read the access token.")
+		return err
+	}
+
+	logger.Warn().Msg("the access token has expired or has been revoked, please login again.")
+
+	fmt.Printf("Email: ")
+	email, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Printf("Password: ")
+	password, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+
+	// the saved token is only replaced on success, so the user can retry
+	token, err := app.Reauthenticate(proto.CredentialsDto{
+		Email:    strings.TrimSpace(email),
+		Password: string(password),
+		Scopes:   info.Scopes,
+		Aliases:  info.Aliases,
+	})
+	if err != nil {
+		logger.Err(err).Msg("error while authenticating.")
+		logErrorDetails(logger, err)
+		return err
+	}
+
+	logger.Info().Str("Email", strings.TrimSpace(email)).Msg("successfully authenticated.")
+
+	return cli2.WriteMOTD(c.App.Writer, token.MOTD)
+}
+
+// ExitCode return the process exit code to use for given error
+func ExitCode(err error) int {
+	if errors.Is(err, client.ErrTimeout) {
+		return ExitCodeTimeout
+	}
+
+	return 1
+}
+
 func (odc *CLIApp) login(c *cli.Context) error {
 	app, logger, err := getInstance(c)
 	if err != nil {
@@ -108,237 +538,1140 @@ func (odc *CLIApp) login(c *cli.Context) error {
 	password, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
 	// TODO clear screen after that
 
-	if _, err := app.Authenticate(proto.CredentialsDto{
+	token, err := app.Authenticate(proto.CredentialsDto{
 		Email:    c.Args().First(),
 		Password: string(password),
-	}); err != nil {
+		Scopes:   c.StringSlice("scope"),
+		Aliases:  c.StringSlice("alias"),
+	})
+	if err != nil {
 		logger.Err(err).Msg("error while authenticating.")
+		logErrorDetails(logger, err)
 		return err
 	}
 
 	logger.Info().Str("Email", c.Args().First()).Msg("successfully authenticated.")
 
-	return nil
+	return cli2.WriteMOTD(c.App.Writer, token.MOTD)
 }
 
-func (odc *CLIApp) ls(c *cli.Context) error {
+func (odc *CLIApp) signup(c *cli.Context) error {
 	app, logger, err := getInstance(c)
 	if err != nil {
 		return err
 	}
 
-	if c.Args().First() == "domain" {
-		return odc.lsDomains(app, logger)
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing EMAIL")
+		logger.Err(err).Msg("missing EMAIL.")
+		return err
 	}
 
-	return odc.lsAliases(app, logger)
-}
+	// Ask for user password, twice to prevent typos
+	fmt.Printf("Password: ")
+	password, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Printf("\nConfirm password: ")
+	confirmation, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
 
-func (odc *CLIApp) lsAliases(c cli2.CLI, logger *zerolog.Logger) error {
-	aliases, err := c.GetAliases()
+	if string(password) != string(confirmation) {
+		err := fmt.Errorf("passwords do not match")
+		logger.Err(err).Msg("passwords do not match.")
+		return err
+	}
+
+	user, err := app.Register(proto.RegistrationDto{
+		Email:      c.Args().First(),
+		Password:   string(password),
+		InviteCode: c.String("invite-code"),
+	})
 	if err != nil {
+		logger.Err(err).Msg("error while registering.")
+		logErrorDetails(logger, err)
 		return err
 	}
 
-	if len(aliases) == 0 {
-		logger.Info().Msg("no aliases found.")
+	if user.VerificationRequired {
+		logger.Info().Str("Email", user.Email).Msg("successfully registered, check your emails then run verify-email <TOKEN>.")
 		return nil
 	}
 
-	for _, alias := range aliases {
-		logger.Info().
-			Str("Domain", alias.Domain).
-			Str("Value", alias.Value).
-			Bool("Synchronize", alias.Synchronize).
-			Msg("")
-	}
+	logger.Info().Str("Email", user.Email).Msg("successfully registered and authenticated.")
 
 	return nil
 }
 
-func (odc *CLIApp) lsDomains(c cli2.CLI, logger *zerolog.Logger) error {
-	domains, err := c.GetDomains()
+func (odc *CLIApp) verifyEmail(c *cli.Context) error {
+	app, logger, err := getInstance(c)
 	if err != nil {
 		return err
 	}
 
-	if len(domains) == 0 {
-		logger.Info().Msg("no domains configured.")
-		return nil
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing TOKEN")
+		logger.Err(err).Msg("missing TOKEN.")
+		return err
 	}
 
-	for _, domain := range domains {
-		logger.Info().Str("Domain", domain.Domain).Msg("")
+	token, err := app.VerifyEmail(c.Args().First())
+	if err != nil {
+		logger.Err(err).Msg("error while verifying email address.")
+		return err
 	}
 
-	return nil
+	logger.Info().Msg("email address verified, successfully authenticated.")
+
+	return cli2.WriteMOTD(c.App.Writer, token.MOTD)
 }
 
-func (odc *CLIApp) register(c *cli.Context) error {
+func (odc *CLIApp) logout(c *cli.Context) error {
 	app, logger, err := getInstance(c)
 	if err != nil {
 		return err
 	}
 
-	if !c.Args().Present() {
-		err := fmt.Errorf("missing ALIAS")
-		logger.Err(err).Msg("missing ALIAS.")
+	if err := app.Logout(c.Bool("all")); err != nil {
+		logger.Err(err).Msg("error while logging out.")
 		return err
 	}
 
-	name := c.Args().First()
+	if c.Bool("all") {
+		logger.Info().Msg("successfully revoked all access tokens.")
+	} else {
+		logger.Info().Msg("successfully logged out.")
+	}
 
-	ip, err := odc.getRemoteIP()
+	return nil
+}
+
+func (odc *CLIApp) whoami(c *cli.Context) error {
+	app, logger, err := getInstance(c)
 	if err != nil {
-		logger.Err(err).Msg("error while getting remote IP.")
 		return err
 	}
 
-	alias, err := app.RegisterAlias(proto.AliasDto{
-		Domain: name,
-		Value:  ip,
-	})
-
+	info, err := app.TokenInfo()
 	if err != nil {
-		logger.Err(err).Str("Domain", name).Msg("error while registering alias.")
+		logger.Err(err).Msg("unable to read the access token.")
 		return err
 	}
 
-	logger.Info().Str("Domain", alias.Domain).Msg("successfully registered alias.")
+	logger.Info().Uint("UserID", info.UserID).Msg("logged in.")
+
+	if !c.Bool("token-info") {
+		return nil
+	}
+
+	logger.Warn().Msg("the claims below are decoded locally and are NOT verified.")
+
+	m := logger.Info()
+	if !info.IssuedAt.IsZero() {
+		m = m.Time("IssuedAt", info.IssuedAt)
+	}
+	if info.ExpiresAt.IsZero() {
+		m = m.Str("ExpiresAt", "never")
+	} else {
+		m = m.Time("ExpiresAt", info.ExpiresAt)
+	}
+	if len(info.Scopes) == 0 {
+		m = m.Str("Scopes", "full access")
+	} else {
+		m = m.Strs("Scopes", info.Scopes)
+	}
+	if len(info.Aliases) > 0 {
+		m = m.Strs("Aliases", info.Aliases)
+	}
+	m.Msg("")
+
+	if info.Expired(time.Now()) {
+		logger.Warn().Msg("the access token has expired, please login again.")
+	}
+
 	return nil
 }
 
-func (odc *CLIApp) rm(c *cli.Context) error {
+func (odc *CLIApp) ls(c *cli.Context) error {
 	app, logger, err := getInstance(c)
 	if err != nil {
 		return err
 	}
 
-	if !c.Args().Present() {
-		err := fmt.Errorf("missing ALIAS")
-		logger.Err(err).Msg("missing ALIAS.")
-		return err
+	if field := c.String("field"); field != "" {
+		return odc.lsField(c, app, logger, []string{field})
+	}
+	if fields := c.String("fields"); fields != "" {
+		return odc.lsField(c, app, logger, strings.Split(fields, ","))
 	}
 
-	name := c.Args().First()
+	if c.Args().First() == "domain" {
+		return odc.lsDomains(c, app, logger)
+	}
 
-	if err := app.DeleteAlias(name); err != nil {
-		logger.Err(err).Str("Domain", name).Msg("error while deleting alias.")
+	return odc.lsAliases(c, app, logger, c.Bool("group-by-domain"))
+}
+
+// lsField print only given fields of the listed resources, for scripting
+// only the wanted fields of the aliases are requested from the daemon
+func (odc *CLIApp) lsField(c *cli.Context, app cli2.CLI, logger *zerolog.Logger, fields []string) error {
+	var items interface{}
+	var err error
+	if c.Args().First() == "domain" {
+		items, err = app.GetDomains()
+	} else {
+		// report the unknown fields before reaching the daemon
+		if err := cli2.CheckFields(reflect.TypeOf(cli2.AliasStatus{}), fields); err != nil {
+			logger.Err(err).Msg("invalid field.")
+			return err
+		}
+		items, err = app.GetAliases(fields...)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := cli2.WriteFields(c.App.Writer, items, fields); err != nil {
+		logger.Err(err).Msg("invalid field.")
 		return err
 	}
 
-	logger.Info().Str("Domain", name).Msg("successfully deleted alias.")
 	return nil
 }
 
-func (odc *CLIApp) setIP(c *cli.Context) error {
-	app, logger, err := getInstance(c)
+// lsAliases print the aliases to the standard output, the logger is only used for the diagnostics
+// so the listing is still printed using --quiet
+func (odc *CLIApp) lsAliases(c *cli.Context, app cli2.CLI, logger *zerolog.Logger, groupByDomain bool) error {
+	aliases, err := app.GetAliases()
 	if err != nil {
 		return err
 	}
 
-	if c.Args().Len() != 2 {
-		err := fmt.Errorf("missing ALIAS IP")
-		logger.Err(err).Msg("missing ALIAS IP.")
-		return err
+	if len(aliases) == 0 {
+		logger.Info().Msg("no aliases found.")
+		return nil
 	}
 
-	alias := c.Args().First()
-	ip := c.Args().Get(1)
+	if !groupByDomain {
+		return cli2.WriteAliases(c.App.Writer, aliases)
+	}
 
-	al, err := app.UpdateAlias(proto.AliasDto{
-		Domain: alias,
-		Value:  ip,
-	})
+	domains, err := app.GetDomains()
+	if err != nil {
+		logger.Warn().Str("Error", err.Error()).Msg("unable to get managed domains.")
+	}
 
+	for i, group := range cli2.GroupAliasesByDomain(aliases, domains) {
+		if i > 0 {
+			_, _ = fmt.Fprintln(c.App.Writer)
+		}
+		_, _ = fmt.Fprintf(c.App.Writer, "%s:\n", group.Domain)
+		if err := cli2.WriteAliases(c.App.Writer, group.Aliases); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) lsDomains(c *cli.Context, app cli2.CLI, logger *zerolog.Logger) error {
+	domains, err := app.GetDomains()
 	if err != nil {
-		logger.Err(err).
-			Str("Domain", alias).
-			Str("Value", ip).
-			Msg("error while updating alias.")
 		return err
 	}
 
-	logger.Info().
-		Str("Domain", al.Domain).
-		Str("Value", al.Value).
-		Msg("successfully updated alias.")
-	return nil
+	if len(domains) == 0 {
+		logger.Info().Msg("no domains configured.")
+		return nil
+	}
+
+	return cli2.WriteDomains(c.App.Writer, domains, false)
 }
 
-func (odc *CLIApp) setSynchronize(c *cli.Context) error {
+func (odc *CLIApp) get(c *cli.Context) error {
 	app, logger, err := getInstance(c)
 	if err != nil {
 		return err
 	}
 
-	if c.Args().Len() != 2 {
-		err := fmt.Errorf("missing ALIAS STATUS")
-		logger.Err(err).Msg("missing ALIAS STATUS.")
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ALIAS")
+		logger.Err(err).Msg("missing ALIAS.")
 		return err
 	}
 
-	status, err := strconv.ParseBool(c.Args().Get(1))
+	aliases, missing, err := app.GetAliasesByName(c.Args().Slice())
 	if err != nil {
-		logger.Err(err).Msg("invalid status.")
+		logger.Err(err).Msg("error while getting aliases.")
 		return err
 	}
 
-	if err := app.SetSynchronize(c.Args().First(), status); err != nil {
-		logger.Err(err).
-			Str("Domain", c.Args().First()).
-			Msg("unable to set synchronize status.")
-		return err
+	if len(aliases) > 0 {
+		if err := cli2.WriteAliases(c.App.Writer, aliases); err != nil {
+			return err
+		}
+	}
+	for _, name := range missing {
+		logger.Warn().Str("Domain", name).Msg("alias not found.")
 	}
 
-	m := logger.Info().Str("Domain", c.Args().First())
-	if status {
-		m.Msg("enable synchronization.")
-	} else {
-		m.Msg("disable synchronization.")
+	// exit with an error for the scripts
+	if len(missing) > 0 {
+		return fmt.Errorf("%d alias(es) not found", len(missing))
 	}
 
 	return nil
 }
 
-func (odc *CLIApp) synchronize(c *cli.Context) error {
+func (odc *CLIApp) open(c *cli.Context) error {
 	app, logger, err := getInstance(c)
 	if err != nil {
 		return err
 	}
 
-	ip, err := odc.getRemoteIP()
-	if err != nil {
-		logger.Err(err).Msg("error while getting remote IP.")
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ALIAS")
+		logger.Err(err).Msg("missing ALIAS.")
 		return err
 	}
 
-	return app.Synchronize(ip)
-}
+	aliasName := c.Args().First()
 
-func (odc *CLIApp) getRemoteIP() (string, error) {
-	c := resty.New()
-	r, err := c.R().Get("https://ifconfig.me/ip")
+	// make sure the alias exist before opening anything
+	aliases, _, err := app.GetAliasesByName([]string{aliasName})
 	if err != nil {
-		return "", err
+		logger.Err(err).Msg("error while getting alias.")
+		return err
+	}
+	if len(aliases) == 0 {
+		logger.Err(proto.ErrAliasNotFound).Str("Domain", aliasName).Msg("alias not found.")
+		return proto.ErrAliasNotFound
 	}
 
-	return r.String(), nil
-}
-
-// TODO better?
-func getInstance(c *cli.Context) (cli2.CLI, *zerolog.Logger, error) {
-	// Configure log level
-	logger, err := common.ConfigureLogger(c)
+	u, err := cli2.AliasURL(aliases[0].Domain, c.String("scheme"), c.Int("port"))
 	if err != nil {
-		return nil, defaultLogger(), err
+		logger.Err(err).Msg("error while building URL.")
+		return err
 	}
 
-	// Create configuration file if not exist
-	configFile := c.String("config")
-	configProvider := config.NewFileProvider(configFile)
+	if err := cli2.OpenURL(u, cli2.Browser(), c.App.Writer); err != nil {
+		logger.Err(err).Str("URL", u).Msg("error while opening URL.")
+		return err
+	}
 
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+	return nil
+}
+
+func (odc *CLIApp) domains(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") && c.String("field") != "" {
+		err := fmt.Errorf("--json and --field cannot be used together")
+		logger.Err(err).Msg("conflicting flags.")
+		return err
+	}
+
+	domains, err := app.GetDomains()
+	if err != nil {
+		logger.Err(err).Msg("error while getting domains.")
+		return err
+	}
+
+	if field := c.String("field"); field != "" {
+		if err := cli2.WriteField(c.App.Writer, domains, field); err != nil {
+			logger.Err(err).Msg("invalid field.")
+			return err
+		}
+		return nil
+	}
+
+	return cli2.WriteDomains(c.App.Writer, domains, c.Bool("json"))
+}
+
+func (odc *CLIApp) register(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ALIAS")
+		logger.Err(err).Msg("missing ALIAS.")
+		return err
+	}
+
+	name := c.Args().First()
+
+	// complete a bare host with the managed domain & fail early if the alias is not under one
+	// the daemon remains the source of truth if the domains are not available
+	domains, err := app.GetDomains()
+	if err != nil {
+		if !strings.Contains(name, ".") {
+			logger.Err(err).Msg("unable to get managed domains.")
+			return err
+		}
+		logger.Warn().Str("Error", err.Error()).Msg("unable to get managed domains.")
+	} else {
+		// only prompt in interactive mode
+		var choose func([]proto.DomainDto) (proto.DomainDto, error)
+		if terminal.IsTerminal(int(os.Stdin.Fd())) {
+			choose = cli2.PromptDomain(os.Stdin, c.App.Writer)
+		}
+
+		if name, err = cli2.ResolveAliasName(name, domains, choose); err != nil {
+			logger.Err(err).Str("Domain", c.Args().First()).Msg("invalid alias, see the domains command.")
+			return err
+		}
+
+		if err := cli2.CheckAliasDomain(name, domains); err != nil {
+			logger.Err(err).Str("Domain", name).Msg("invalid alias, see the domains command.")
+			return err
+		}
+	}
+
+	// the value of an alias following another name is resolved by the daemon
+	var value, value6 string
+	if c.String("follow") == "" {
+		ips, err := app.DetectIPs()
+		if err != nil {
+			logger.Err(err).Msg("error while getting remote IP.")
+			return err
+		}
+		if value, value6, err = cli2.AliasAddresses(ips...); err != nil {
+			logger.Err(err).Msg("error while getting remote IP.")
+			return err
+		}
+	}
+
+	alias, err := app.RegisterAlias(proto.AliasDto{
+		Domain: name,
+		Value:  value,
+		Value6: value6,
+		Follow: c.String("follow"),
+	})
+
+	if err != nil {
+		logger.Err(err).Str("Domain", name).Msg("error while registering alias.")
+		logErrorDetails(logger, err)
+		return err
+	}
+
+	logger.Info().Str("Domain", alias.Domain).Msg("successfully registered alias.")
+	return nil
+}
+
+func (odc *CLIApp) rm(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ALIAS")
+		logger.Err(err).Msg("missing ALIAS.")
+		return err
+	}
+
+	name := c.Args().First()
+
+	if err := app.DeleteAlias(name); err != nil {
+		logger.Err(err).Str("Domain", name).Msg("error while deleting alias.")
+		return err
+	}
+
+	logger.Info().Str("Domain", name).Msg("successfully deleted alias.")
+	return nil
+}
+
+func (odc *CLIApp) setEnabled(enabled bool) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		app, logger, err := getInstance(c)
+		if err != nil {
+			return err
+		}
+
+		if !c.Args().Present() {
+			err := fmt.Errorf("missing ALIAS")
+			logger.Err(err).Msg("missing ALIAS.")
+			return err
+		}
+
+		alias, err := app.SetEnabled(c.Args().First(), enabled)
+		if err != nil {
+			logger.Err(err).Str("Domain", c.Args().First()).Msg("error while toggling alias.")
+			return err
+		}
+
+		logger.Info().Str("Domain", alias.Domain).Bool("Enabled", alias.Enabled).Msg("successfully toggled alias.")
+		return nil
+	}
+}
+
+func (odc *CLIApp) rename(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Args().Len() != 2 {
+		err := fmt.Errorf("missing OLD NEW")
+		logger.Err(err).Msg("missing OLD NEW.")
+		return err
+	}
+
+	oldName := c.Args().First()
+	newName := c.Args().Get(1)
+
+	alias, err := app.RenameAlias(oldName, newName)
+	if err != nil {
+		logger.Err(err).Str("Domain", oldName).Str("NewName", newName).Msg("error while renaming alias.")
+		return err
+	}
+
+	logger.Info().Str("PreviousDomain", oldName).Str("Domain", alias.Domain).Msg("successfully renamed alias.")
+	return nil
+}
+
+func (odc *CLIApp) setIP(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Args().Len() != 2 && c.Args().Len() != 3 {
+		err := fmt.Errorf("missing ALIAS IP")
+		logger.Err(err).Msg("missing ALIAS IP.")
+		return err
+	}
+
+	alias := c.Args().First()
+
+	// the record to update depends on the address family
+	value, value6, err := cli2.AliasAddresses(c.Args().Tail()...)
+	if err != nil {
+		logger.Err(err).Msg("expecting an IPv4 and/or an IPv6 address.")
+		return err
+	}
+
+	al, err := app.UpdateAlias(proto.AliasDto{
+		Domain: alias,
+		Value:  value,
+		Value6: value6,
+	})
+
+	if err != nil {
+		logger.Err(err).
+			Str("Domain", alias).
+			Str("Value", value).
+			Str("Value6", value6).
+			Msg("error while updating alias.")
+		logErrorDetails(logger, err)
+		return err
+	}
+
+	logger.Info().
+		Str("Domain", al.Domain).
+		Str("Value", al.Value).
+		Str("Value6", al.Value6).
+		Msg("successfully updated alias.")
+	return nil
+}
+
+func (odc *CLIApp) setTTL(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Args().Len() != 2 {
+		err := fmt.Errorf("missing ALIAS SECONDS")
+		logger.Err(err).Msg("missing ALIAS SECONDS.")
+		return err
+	}
+
+	alias := c.Args().First()
+	ttl, err := strconv.Atoi(c.Args().Get(1))
+	if err != nil || ttl <= 0 {
+		err := fmt.Errorf("invalid SECONDS")
+		logger.Err(err).Msg("invalid SECONDS.")
+		return err
+	}
+
+	al, err := app.SetTTL(alias, ttl)
+	if err != nil {
+		logger.Err(err).
+			Str("Domain", alias).
+			Int("TTL", ttl).
+			Msg("error while updating alias.")
+		logErrorDetails(logger, err)
+		return err
+	}
+
+	logger.Info().
+		Str("Domain", al.Domain).
+		Int("TTL", al.TTL).
+		Msg("successfully updated alias.")
+	return nil
+}
+
+func (odc *CLIApp) setSynchronize(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Args().Len() != 2 {
+		err := fmt.Errorf("missing ALIAS STATUS")
+		logger.Err(err).Msg("missing ALIAS STATUS.")
+		return err
+	}
+
+	status, err := strconv.ParseBool(c.Args().Get(1))
+	if err != nil {
+		logger.Err(err).Msg("invalid status.")
+		return err
+	}
+
+	if err := app.SetSynchronize(c.Args().First(), status); err != nil {
+		logger.Err(err).
+			Str("Domain", c.Args().First()).
+			Msg("unable to set synchronize status.")
+		return err
+	}
+
+	m := logger.Info().Str("Domain", c.Args().First())
+	if status {
+		m.Msg("enable synchronization.")
+	} else {
+		m.Msg("disable synchronization.")
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) synchronize(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	ips, err := app.DetectIPs()
+	if err != nil {
+		logger.Err(err).Msg("error while getting remote IP.")
+		return err
+	}
+
+	return app.Synchronize(ips...)
+}
+
+// daemon synchronize the aliases periodically, backing off while the daemon or the network fail
+// the configuration is reloaded on each round, so the token and the aliases can be changed meanwhile
+func (odc *CLIApp) daemon(c *cli.Context) error {
+	// refuse to start with an invalid configuration
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	// stop cleanly on interruption
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var syncConf config.SyncConfig
+	failures := 0
+	for round := 0; ; round++ {
+		if round > 0 {
+			err = app.Reload()
+		}
+		if err != nil {
+			// keep going with the previous configuration if it gets broken meanwhile
+			logger.Err(err).Msg("unable to reload the configuration.")
+			failures++
+		} else {
+			if syncConf, err = getSyncConfig(c, app); err != nil {
+				logger.Err(err).Msg("invalid synchronization settings.")
+				return err
+			}
+
+			if err := synchronizeOnce(app, logger); err != nil {
+				failures++
+			} else {
+				failures = 0
+			}
+		}
+
+		delay := cli2.NextSync(syncConf, failures, cli2.RandomJitter)
+		logger.Debug().Int("Failures", failures).Str("Delay", delay.String()).Msg("next synchronization scheduled.")
+
+		select {
+		case sig := <-signals:
+			logger.Info().Str("Signal", sig.String()).Msg("stopping synchronization.")
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// synchronizeOnce detect the current IP and update the aliases using it
+func synchronizeOnce(app cli2.CLI, logger *zerolog.Logger) error {
+	ips, err := app.DetectIPs()
+	if err != nil {
+		logger.Err(err).Msg("error while getting remote IP.")
+		return err
+	}
+
+	if err := app.Synchronize(ips...); err != nil {
+		logger.Err(err).Msg("error while synchronizing aliases.")
+		return err
+	}
+
+	return nil
+}
+
+// getSyncConfig return the synchronization configuration overridden by the command flags
+func getSyncConfig(c *cli.Context, app cli2.CLI) (config.SyncConfig, error) {
+	syncConf := app.SyncConfig()
+	if c.IsSet("interval") {
+		syncConf.Interval = c.Duration("interval")
+	}
+	if c.IsSet("jitter") {
+		syncConf.Jitter = c.Duration("jitter")
+	}
+	if c.IsSet("max-backoff") {
+		syncConf.MaxBackoff = c.Duration("max-backoff")
+	}
+
+	return syncConf, syncConf.Validate()
+}
+
+func (odc *CLIApp) adminAliases(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	filter := proto.AliasFilterDto{Domain: c.String("domain"), Owner: c.String("owner")}
+	aliases, next, err := app.GetAllAliases(c.String("cursor"), c.Int("limit"), filter)
+	if err != nil {
+		logger.Err(err).Msg("error while getting aliases.")
+		return err
+	}
+
+	if len(aliases) == 0 {
+		logger.Info().Msg("no aliases found.")
+		return nil
+	}
+
+	if err := cli2.WriteOwnedAliases(c.App.Writer, aliases); err != nil {
+		return err
+	}
+
+	if next != "" {
+		logger.Info().Str("Cursor", next).Msg("more aliases available, use --cursor to list the next page.")
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) adminUserAliases(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing EMAIL")
+		logger.Err(err).Msg("missing EMAIL.")
+		return err
+	}
+
+	email := c.Args().First()
+
+	aliases, next, err := app.GetUserAliases(email, c.String("cursor"), c.Int("limit"))
+	if err != nil {
+		logger.Err(err).Str("Email", email).Msg("error while getting user aliases.")
+		return err
+	}
+
+	if len(aliases) == 0 {
+		logger.Info().Str("Email", email).Msg("no aliases found.")
+		return nil
+	}
+
+	owned := make([]proto.OwnedAliasDto, len(aliases))
+	for i, alias := range aliases {
+		owned[i] = proto.OwnedAliasDto{AliasDto: alias, Owner: email}
+	}
+	if err := cli2.WriteOwnedAliases(c.App.Writer, owned); err != nil {
+		return err
+	}
+
+	if next != "" {
+		logger.Info().Str("Cursor", next).Msg("more aliases available, use --cursor to list the next page.")
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) adminDNSStatus(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	status, err := app.GetDNSStatus()
+	if err != nil {
+		logger.Err(err).Msg("error while getting DNS status.")
+		return err
+	}
+
+	return cli2.WriteDNSStatus(c.App.Writer, status)
+}
+
+func (odc *CLIApp) limits(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	limits, err := app.GetLimits()
+	if err != nil {
+		logger.Err(err).Msg("error while getting account limits.")
+		return err
+	}
+
+	event := logger.Info().
+		Int64("Aliases", limits.Aliases).
+		Strs("Domains", limits.Domains).
+		Bool("Admin", limits.Admin)
+	if len(limits.ReservedNames) > 0 {
+		event = event.Strs("ReservedNames", limits.ReservedNames)
+	}
+	event.Msg("")
+
+	return nil
+}
+
+func (odc *CLIApp) webhooksList(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := app.GetWebhooks()
+	if err != nil {
+		logger.Err(err).Msg("error while getting webhooks.")
+		return err
+	}
+
+	if len(webhooks) == 0 {
+		logger.Info().Msg("no webhooks registered.")
+		return nil
+	}
+
+	return cli2.WriteWebhooks(c.App.Writer, webhooks)
+}
+
+func (odc *CLIApp) webhooksAdd(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing URL")
+		logger.Err(err).Msg("missing URL.")
+		return err
+	}
+
+	webhook, err := app.CreateWebhook(proto.WebhookDto{URL: c.Args().First(), Secret: c.String("secret")})
+	if err != nil {
+		logger.Err(err).Msg("error while registering webhook.")
+		return err
+	}
+
+	logger.Info().Uint("ID", webhook.ID).Str("URL", webhook.URL).Bool("Signed", webhook.Signed).Msg("successfully registered webhook.")
+
+	return nil
+}
+
+func (odc *CLIApp) webhooksRemove(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(c.Args().First(), 10, 32)
+	if err != nil {
+		err := fmt.Errorf("missing or invalid ID")
+		logger.Err(err).Msg("missing or invalid ID.")
+		return err
+	}
+
+	if err := app.DeleteWebhook(uint(id)); err != nil {
+		logger.Err(err).Uint64("ID", id).Msg("error while unregistering webhook.")
+		return err
+	}
+
+	logger.Info().Uint64("ID", id).Msg("successfully unregistered webhook.")
+
+	return nil
+}
+
+func (odc *CLIApp) sharesCreate(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ALIAS")
+		logger.Err(err).Msg("missing ALIAS.")
+		return err
+	}
+
+	share, err := app.CreateShare(c.Args().First(), c.Duration("expires-in"))
+	if err != nil {
+		logger.Err(err).Str("Alias", c.Args().First()).Msg("error while sharing alias.")
+		return err
+	}
+
+	event := logger.Info().Uint("ID", share.ID).Str("Alias", share.Alias)
+	if share.ExpiresAt != nil {
+		event = event.Time("ExpiresAt", *share.ExpiresAt)
+	}
+	event.Msg("successfully shared alias. the link won't be displayed again.")
+
+	// printed as is to be copied
+	_, err = fmt.Fprintln(c.App.Writer, app.ShareURL(share.Token))
+	return err
+}
+
+func (odc *CLIApp) sharesList(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	shares, err := app.GetShares()
+	if err != nil {
+		logger.Err(err).Msg("error while getting shares.")
+		return err
+	}
+
+	if len(shares) == 0 {
+		logger.Info().Msg("no aliases shared.")
+		return nil
+	}
+
+	return cli2.WriteShares(c.App.Writer, shares)
+}
+
+func (odc *CLIApp) sharesRemove(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(c.Args().First(), 10, 32)
+	if err != nil {
+		err := fmt.Errorf("missing or invalid ID")
+		logger.Err(err).Msg("missing or invalid ID.")
+		return err
+	}
+
+	if err := app.DeleteShare(uint(id)); err != nil {
+		logger.Err(err).Uint64("ID", id).Msg("error while revoking share.")
+		return err
+	}
+
+	logger.Info().Uint64("ID", id).Msg("successfully revoked share.")
+
+	return nil
+}
+
+func (odc *CLIApp) adminDelegations(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	delegations, err := app.GetDelegations()
+	if err != nil {
+		logger.Err(err).Msg("error while getting delegations.")
+		return err
+	}
+
+	if len(delegations) == 0 {
+		logger.Info().Msg("no delegation checked. is the delegation check enabled?")
+		return nil
+	}
+
+	for _, delegation := range delegations {
+		if delegation.Status != proto.DelegationOK {
+			logger.Warn().Str("Domain", delegation.Domain).Str("Status", delegation.Status).Msg("domain is not delegated as expected.")
+		}
+	}
+
+	return cli2.WriteDelegations(c.App.Writer, delegations)
+}
+
+func (odc *CLIApp) adminExportZone(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing DOMAIN")
+		logger.Err(err).Msg("missing DOMAIN.")
+		return err
+	}
+
+	domain := c.Args().First()
+
+	zone, err := app.ExportZone(domain)
+	if err != nil {
+		logger.Err(err).Str("Domain", domain).Msg("error while exporting zone.")
+		return err
+	}
+
+	// printed as is to be redirected into a file
+	_, err = fmt.Fprint(c.App.Writer, zone.Zone)
+	return err
+}
+
+func (odc *CLIApp) configEdit(c *cli.Context) error {
+	logger, err := common.ConfigureLogger(c)
+	if err != nil {
+		return err
+	}
+
+	path, err := filepath.Abs(c.String("config"))
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		logger.Info().Str("Path", path).Msg("creating default config file.")
+		if err := config.NewFileProvider(path).Save(config.DefaultConfig); err != nil {
+			logger.Err(err).Msg("error while saving config file.")
+			return err
+		}
+	}
+
+	if err := cli2.EditConfig(path, cli2.Editor(), cli2.PromptRetry(os.Stdin, c.App.Writer)); err != nil {
+		logger.Err(err).Str("Path", path).Msg("error while editing config file.")
+		return err
+	}
+
+	logger.Info().Str("Path", path).Msg("config file successfully saved.")
+	return nil
+}
+
+func (odc *CLIApp) resolve(c *cli.Context) error {
+	logger, err := common.ConfigureLogger(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing NAME")
+		logger.Err(err).Msg("missing NAME.")
+		return err
+	}
+
+	name := c.Args().First()
+
+	records, err := common.NewResolver(c.String("resolver"), getTimeout(c)).Lookup(name)
+	if err != nil {
+		logger.Err(err).Str("Name", name).Msg("error while resolving name.")
+		return err
+	}
+
+	if records.CNAME != "" {
+		logger.Info().Str("Type", "CNAME").Str("Value", records.CNAME).Msg("")
+	}
+	for _, value := range records.A {
+		logger.Info().Str("Type", "A").Str("Value", value).Msg("")
+	}
+	for _, value := range records.AAAA {
+		logger.Info().Str("Type", "AAAA").Str("Value", value).Msg("")
+	}
+	for _, value := range records.TXT {
+		logger.Info().Str("Type", "TXT").Str("Value", value).Msg("")
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) version(c *cli.Context) error {
+	if !c.Bool("check") {
+		fmt.Println(c.App.Version)
+		return nil
+	}
+
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Str("Version", c.App.Version).Msg("")
+
+	// never fail the command because of the check
+	latest, outdated, err := app.CheckVersion(c.App.Version)
+	if err == cli2.ErrUpdateCheckDisabled {
+		logger.Info().Msg("update check is disabled.")
+		return nil
+	}
+	if err != nil {
+		logger.Warn().Str("Error", err.Error()).Msg("unable to check for newer version.")
+		return nil
+	}
+
+	if outdated {
+		logger.Warn().Str("Latest", latest).Msg("a newer version is available, please upgrade.")
+	} else {
+		logger.Info().Msg("you are running the latest version.")
+	}
+
+	return nil
+}
+
+// logErrorDetails log the per-field errors returned by the daemon, if any
+func logErrorDetails(logger *zerolog.Logger, err error) {
+	var errDto *proto.ErrorDto
+	if !errors.As(err, &errDto) {
+		return
+	}
+
+	fields := make([]string, 0, len(errDto.Details))
+	for field := range errDto.Details {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		logger.Error().Str("Field", field).Msg(errDto.Details[field])
+	}
+}
+
+// TODO better?
+func getInstance(c *cli.Context) (cli2.CLI, *zerolog.Logger, error) {
+	// Configure log level
+	logger, err := common.ConfigureLogger(c)
+	if err != nil {
+		return nil, defaultLogger(), err
+	}
+
+	// Create configuration file if not exist
+	// unless the configuration is given by the environment
+	configFile := c.String("config")
+	configProvider := config.NewFileProvider(configFile)
+
+	if _, err := os.Stat(configFile); os.IsNotExist(err) && !config.FromEnv() {
 		logger.Info().Str("Path", configFile).Msg("creating default config file. please edit it accordingly.")
 		if err := configProvider.Save(config.DefaultConfig); err != nil {
 			logger.Err(err).Msg("error while saving config file.")
@@ -348,13 +1681,27 @@ func getInstance(c *cli.Context) (cli2.CLI, *zerolog.Logger, error) {
 		return nil, &logger, fmt.Errorf("please edit config file")
 	}
 
-	app, err := cli2.NewCLI(configFile, &logger)
+	app, err := cli2.NewCLI(configFile, client.Options{
+		Timeout:  getTimeout(c),
+		Insecure: c.Bool("insecure"),
+	}, &logger)
 	if err != nil {
 		return nil, nil, err
 	}
 	return app, &logger, nil
 }
 
+// getTimeout return the command timeout if set, the global one otherwise
+func getTimeout(c *cli.Context) time.Duration {
+	for _, ctx := range c.Lineage() {
+		if timeout := ctx.Duration("timeout"); timeout != 0 {
+			return timeout
+		}
+	}
+
+	return defaultTimeout
+}
+
 func defaultLogger() *zerolog.Logger {
 	l := zerolog.New(zerolog.MultiLevelWriter(zerolog.NewConsoleWriter())).
 		With().
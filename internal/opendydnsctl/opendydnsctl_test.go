@@ -0,0 +1,50 @@
+package opendydnsctl
+
+import (
+	"bytes"
+	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCLIApp_Ls_Quiet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/aliases" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"domain": "foo.example.org", "value": "192.0.2.1", "enabled": true}]`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "opendydnsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opendydnsctl.toml")
+	if err := config.NewFileProvider(path).Save(config.Config{APIAddr: server.URL, Token: "test-token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// --quiet only hides the logs, not the listing
+	var out bytes.Buffer
+	app := NewCLIApp().App()
+	app.Writer = &out
+	if err := app.Run([]string{"opendydnsctl", "--config", path, "-q", "ls"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "DOMAIN") || !strings.HasPrefix(lines[1], "foo.example.org  192.0.2.1") {
+		t.Errorf("wrong listing printed: %q", out.String())
+	}
+}
@@ -2,16 +2,27 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/rs/zerolog"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // API represent the Daemon REST API
@@ -27,6 +38,17 @@ func NewAPI(d daemon.Daemon, conf config.APIConfig) (*API, error) {
 	// Configure echo
 	e := echo.New()
 	e.Logger.SetOutput(ioutil.Discard)
+	e.Validator = newStructValidator()
+
+	// the forwarded headers are only honored when sent by a trusted proxy
+	e.IPExtractor = echo.ExtractIPDirect()
+	if len(conf.TrustedProxies) > 0 {
+		extractor, err := newIPExtractor(conf.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		e.IPExtractor = extractor
+	}
 
 	// Determinate if should run HTTPS
 	if conf.SSLEnabled() {
@@ -40,20 +62,72 @@ func NewAPI(d daemon.Daemon, conf config.APIConfig) (*API, error) {
 		conf:   conf,
 		logger: d.Logger(),
 	}
+	e.HTTPErrorHandler = a.handleError
 
 	// Register global middlewares
+	e.Use(newRecoverMiddleware(d.Logger())) // must be first
+	e.Use(middleware.RequestID())
+	if conf.AccessLog.Enabled {
+		w, err := openAccessLog(conf.AccessLog.Path)
+		if err != nil {
+			return nil, err
+		}
+		e.Use(newAccessLogMiddleware(w))
+	}
 	e.Use(newZeroLogMiddleware(d.Logger()))
+	e.Use(newVersionMiddleware())
 
 	// Register per-route middlewares
-	authMiddleware := getAuthMiddleware(a.conf.SigningKey)
+	authMiddleware := getAuthMiddleware(a.conf.SigningKey, a.conf.PreviousSigningKeys, d.ValidateUserContext)
+
+	// scoped tokens are only accepted by the routes having their scope
+	fullAccess := requireScope("")
+	canRead := requireScope(proto.ScopeAliasesRead)
+	canUpdate := requireScope(proto.ScopeAliasesUpdate)
+
+	// the anonymous routes running the verification hook (or checking its tokens)
+	registrationLimit := newRateLimitMiddleware(conf.Registration.GetRateLimit(), time.Hour)
 
 	// Register endpoints
 	e.POST("/sessions", a.authenticate(d))
-	e.GET("/aliases", a.getAliases(d), authMiddleware)
-	e.POST("/aliases", a.registerAlias(d), authMiddleware)
-	e.PUT("/aliases", a.updateAlias(d), authMiddleware)
-	e.DELETE("/aliases/:name", a.deleteAlias(d), authMiddleware)
-	e.GET("/domains", a.getDomains(d), authMiddleware)
+	e.POST("/sessions/refresh", a.refreshToken, authMiddleware)
+	e.POST("/users", a.registerUser(d), registrationLimit)
+	e.POST("/users/verify", a.verifyEmail(d), registrationLimit)
+	e.GET("/aliases", a.getAliases(d), authMiddleware, canRead)
+	e.POST("/aliases", a.registerAlias(d), authMiddleware, fullAccess)
+	e.POST("/aliases/batch-get", a.getAliasesByName(d), authMiddleware, canRead)
+	e.GET("/aliases/:name", a.getAlias(d), authMiddleware, canRead)
+	e.PUT("/aliases", a.updateAlias(d), authMiddleware, canUpdate)
+	e.PATCH("/aliases/:name", a.patchAlias(d), authMiddleware, canUpdate)
+	e.DELETE("/aliases/:name", a.deleteAlias(d), authMiddleware, fullAccess)
+	e.POST("/aliases/:name/enable", a.setAliasEnabled(d, true), authMiddleware, fullAccess)
+	e.POST("/aliases/:name/disable", a.setAliasEnabled(d, false), authMiddleware, fullAccess)
+	e.POST("/aliases/:name/notify", a.notifyAlias(d), authMiddleware, canUpdate)
+	e.POST("/aliases/:name/rename", a.renameAlias(d), authMiddleware, fullAccess)
+	e.POST("/aliases/:name/shares", a.createShare(d), authMiddleware, fullAccess)
+	e.GET("/domains", a.getDomains(d), authMiddleware, canRead)
+	e.GET("/events", a.getEvents(d), authMiddleware, canRead)
+	e.GET("/users/me/limits", a.getLimits(d), authMiddleware, canRead)
+	e.POST("/users/me/revoke-all", a.revokeTokens(d), authMiddleware, fullAccess)
+	e.GET("/users/me/webhooks", a.getWebhooks(d), authMiddleware, fullAccess)
+	e.POST("/users/me/webhooks", a.createWebhook(d), authMiddleware, fullAccess)
+	e.DELETE("/users/me/webhooks/:id", a.deleteWebhook(d), authMiddleware, fullAccess)
+	e.GET("/users/me/shares", a.getShares(d), authMiddleware, fullAccess)
+	e.DELETE("/users/me/shares/:id", a.deleteShare(d), authMiddleware, fullAccess)
+	// the share token is the credential
+	e.GET("/shared/:token", a.getSharedAlias(d))
+	e.GET("/admin/aliases", a.getAllAliases(d), authMiddleware, fullAccess)
+	e.GET("/admin/events", a.getAllEvents(d), authMiddleware, fullAccess)
+	e.GET("/admin/users/:email/aliases", a.getUserAliases(d), authMiddleware, fullAccess)
+	e.GET("/admin/dns", a.getDNSStatus(d), authMiddleware, fullAccess)
+	e.GET("/admin/delegations", a.getDelegations(d), authMiddleware, fullAccess)
+	e.GET("/admin/zones/:domain", a.exportZone(d), authMiddleware, fullAccess)
+	if conf.AnonymousIPLookup {
+		e.GET("/ip", a.getIP)
+	} else {
+		e.GET("/ip", a.getIP, authMiddleware)
+	}
+	e.GET("/version", a.getVersion)
 
 	return &a, nil
 }
@@ -61,8 +135,8 @@ func NewAPI(d daemon.Daemon, conf config.APIConfig) (*API, error) {
 func (a *API) authenticate(d daemon.Daemon) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var cred proto.CredentialsDto
-		if err := c.Bind(&cred); err != nil {
-			return c.NoContent(http.StatusUnprocessableEntity)
+		if err := bindAndValidate(c, &cred); err != nil {
+			return err
 		}
 
 		userCtx, err := d.Authenticate(cred)
@@ -71,12 +145,72 @@ func (a *API) authenticate(d daemon.Daemon) echo.HandlerFunc {
 		}
 
 		// Create the JWT token
-		token, err := makeToken(userCtx, a.conf.SigningKey, a.conf.TokenTTL)
+		scope := tokenScope{Scopes: cred.Scopes, Aliases: cred.Aliases}
+		token, err := makeScopedToken(userCtx, scope, a.conf.SigningKey, a.conf.GetTokenTTL())
+		if err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		token.MOTD = a.conf.MOTD
+
+		return respond(c, http.StatusOK, token)
+	}
+}
+
+// refreshToken issue a new token of the same user & scope, to replace the current one before it expires
+// the revoked tokens and the ones of the disabled users are rejected by the auth middleware
+func (a *API) refreshToken(c echo.Context) error {
+	token, err := makeScopedToken(getUserContext(c), getTokenScope(c), a.conf.SigningKey, a.conf.GetTokenTTL())
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return respond(c, http.StatusOK, token)
+}
+
+func (a *API) registerUser(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var registration proto.RegistrationDto
+		if err := bindAndValidate(c, &registration); err != nil {
+			return err
+		}
+
+		userCtx, verificationRequired, err := d.RegisterUser(registration)
+		if err != nil {
+			return err
+		}
+
+		result := proto.RegisteredUserDto{Email: registration.Email, VerificationRequired: verificationRequired}
+		if !verificationRequired {
+			token, err := makeToken(userCtx, a.conf.SigningKey, a.conf.GetTokenTTL())
+			if err != nil {
+				return c.NoContent(http.StatusInternalServerError)
+			}
+			result.Token = token.Token
+		}
+
+		return respond(c, http.StatusCreated, result)
+	}
+}
+
+func (a *API) verifyEmail(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var verification proto.VerificationDto
+		if err := bindAndValidate(c, &verification); err != nil {
+			return err
+		}
+
+		userCtx, err := d.VerifyEmail(verification.Token)
+		if err != nil {
+			return err
+		}
+
+		token, err := makeToken(userCtx, a.conf.SigningKey, a.conf.GetTokenTTL())
 		if err != nil {
 			return c.NoContent(http.StatusInternalServerError)
 		}
+		token.MOTD = a.conf.MOTD
 
-		return c.JSON(http.StatusOK, token)
+		return respond(c, http.StatusOK, token)
 	}
 }
 
@@ -84,22 +218,114 @@ func (a *API) getAliases(d daemon.Daemon) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userCtx := getUserContext(c)
 
+		// cursor based pagination is only used if requested
+		if c.QueryParam("cursor") != "" || c.QueryParam("limit") != "" {
+			return a.getAliasesPage(c, d, userCtx)
+		}
+
 		aliases, err := d.GetAliases(userCtx)
 		if err != nil {
 			return err
 		}
 
-		return c.JSON(http.StatusOK, aliases)
+		result, err := selectFields(filterAliases(c, aliases), c.QueryParam("fields"))
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, result)
 	}
 }
 
-func (a *API) registerAlias(d daemon.Daemon) echo.HandlerFunc {
+func (a *API) getAliasesPage(c echo.Context, d daemon.Daemon, userCtx proto.UserContext) error {
+	cursor, err := decodeCursor(c.QueryParam("cursor"))
+	if err != nil {
+		return proto.ErrInvalidParameters
+	}
+	limit, err := parseUintParam(c.QueryParam("limit"))
+	if err != nil {
+		return proto.ErrInvalidParameters
+	}
+
+	aliases, next, err := d.GetAliasesPage(userCtx, cursor, int(limit))
+	if err != nil {
+		return err
+	}
+
+	result, err := selectFields(filterAliases(c, aliases), c.QueryParam("fields"))
+	if err != nil {
+		return err
+	}
+
+	if next != 0 {
+		c.Response().Header().Set(proto.HeaderNextCursor, encodeCursor(next))
+	}
+
+	return respond(c, http.StatusOK, result)
+}
+
+func (a *API) getAlias(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+		name := c.Param("name")
+
+		if err := checkAliasScope(c, name); err != nil {
+			return err
+		}
+
+		aliases, _, err := d.GetAliasesByName(userCtx, []string{name})
+		if err != nil {
+			return err
+		}
+		if len(aliases) == 0 {
+			return proto.ErrAliasNotFound
+		}
+
+		result, err := selectFields(aliases[0], c.QueryParam("fields"))
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, result)
+	}
+}
+
+func (a *API) getAliasesByName(d daemon.Daemon) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userCtx := getUserContext(c)
 
+		var names proto.AliasNamesDto
+		if err := bindAndValidate(c, &names); err != nil {
+			return err
+		}
+
+		aliases, missing, err := d.GetAliasesByName(userCtx, names.Names)
+		if err != nil {
+			return err
+		}
+
+		// the aliases the token cannot target are reported as missing
+		result := proto.AliasesLookupDto{Aliases: []proto.AliasDto{}, Missing: missing}
+		ts := getTokenScope(c)
+		for _, alias := range aliases {
+			if ts.allowAlias(alias.Domain) {
+				result.Aliases = append(result.Aliases, alias)
+			} else {
+				result.Missing = append(result.Missing, alias.Domain)
+			}
+		}
+
+		return respond(c, http.StatusOK, result)
+	}
+}
+
+func (a *API) registerAlias(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getClientContext(c)
+
 		var alias proto.AliasDto
-		if err := c.Bind(&alias); err != nil {
-			return c.NoContent(http.StatusUnprocessableEntity)
+		if err := bindAndValidate(c, &alias); err != nil {
+			return err
 		}
 
 		alias, err := d.RegisterAlias(userCtx, alias)
@@ -107,17 +333,24 @@ func (a *API) registerAlias(d daemon.Daemon) echo.HandlerFunc {
 			return err
 		}
 
-		return c.JSON(http.StatusCreated, alias)
+		// point to the created resource
+		c.Response().Header().Set(echo.HeaderLocation, "/aliases/"+url.PathEscape(alias.Domain))
+
+		return respond(c, http.StatusCreated, alias)
 	}
 }
 
 func (a *API) updateAlias(d daemon.Daemon) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		userCtx := getUserContext(c)
+		userCtx := getClientContext(c)
 
 		var alias proto.AliasDto
-		if err := c.Bind(&alias); err != nil {
-			return c.NoContent(http.StatusUnprocessableEntity)
+		if err := bindAndValidate(c, &alias); err != nil {
+			return err
+		}
+
+		if err := checkAliasScope(c, alias.Domain); err != nil {
+			return err
 		}
 
 		alias, err := d.UpdateAlias(userCtx, alias)
@@ -125,7 +358,25 @@ func (a *API) updateAlias(d daemon.Daemon) echo.HandlerFunc {
 			return err
 		}
 
-		return c.JSON(http.StatusOK, alias)
+		return respond(c, http.StatusOK, alias)
+	}
+}
+
+func (a *API) patchAlias(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getClientContext(c)
+
+		var patch proto.AliasPatchDto
+		if err := bindAndValidate(c, &patch); err != nil {
+			return err
+		}
+
+		alias, err := d.PatchAlias(userCtx, c.Param("name"), patch)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, alias)
 	}
 }
 
@@ -139,7 +390,66 @@ func (a *API) deleteAlias(d daemon.Daemon) echo.HandlerFunc {
 			return err
 		}
 
-		return c.NoContent(http.StatusOK)
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (a *API) setAliasEnabled(d daemon.Daemon, enabled bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		alias, err := d.SetAliasEnabled(userCtx, c.Param("name"), enabled)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, alias)
+	}
+}
+
+// notifyAlias is a single purpose version of updateAlias, meant to be called
+// from router scripts or webhooks: the payload is optional and the IP
+// of the request is used when no value is given
+func (a *API) notifyAlias(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getClientContext(c)
+
+		var notify proto.NotifyDto
+		if err := bindAndValidate(c, &notify); err != nil {
+			return err
+		}
+
+		if notify.Value == "" {
+			notify.Value = c.RealIP()
+			if net.ParseIP(notify.Value) == nil {
+				return proto.ErrInvalidParameters
+			}
+		}
+
+		alias, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: c.Param("name"), Value: notify.Value})
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, alias)
+	}
+}
+
+func (a *API) renameAlias(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		var rename proto.RenameDto
+		if err := bindAndValidate(c, &rename); err != nil {
+			return err
+		}
+
+		alias, err := d.RenameAlias(userCtx, c.Param("name"), rename.Name)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, alias)
 	}
 }
 
@@ -152,12 +462,305 @@ func (a *API) getDomains(d daemon.Daemon) echo.HandlerFunc {
 			return err
 		}
 
-		return c.JSON(http.StatusOK, domains)
+		return respond(c, http.StatusOK, domains)
+	}
+}
+
+func (a *API) getEvents(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		cursor, err := parseUintParam(c.QueryParam("cursor"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+		limit, err := parseUintParam(c.QueryParam("limit"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+
+		events, err := d.GetEvents(userCtx, uint(cursor), int(limit))
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, filterEvents(c, events))
+	}
+}
+
+func (a *API) getAllEvents(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		cursor, err := parseUintParam(c.QueryParam("cursor"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+		limit, err := parseUintParam(c.QueryParam("limit"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+
+		events, err := d.GetAllEvents(userCtx, uint(cursor), int(limit))
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, events)
+	}
+}
+
+func (a *API) getAllAliases(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		cursor, err := decodeCursor(c.QueryParam("cursor"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+		limit, err := parseUintParam(c.QueryParam("limit"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+		filter := proto.AliasFilterDto{
+			Domain: c.QueryParam("domain"),
+			Owner:  c.QueryParam("owner"),
+		}
+
+		aliases, next, err := d.GetAllAliases(userCtx, cursor, int(limit), filter)
+		if err != nil {
+			return err
+		}
+
+		if next != 0 {
+			c.Response().Header().Set(proto.HeaderNextCursor, encodeCursor(next))
+		}
+
+		return respond(c, http.StatusOK, aliases)
+	}
+}
+
+func (a *API) getLimits(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		limits, err := d.GetLimits(userCtx)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, limits)
+	}
+}
+
+func (a *API) getDelegations(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		delegations, err := d.GetDelegations(userCtx)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, delegations)
+	}
+}
+
+func (a *API) exportZone(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		zone, err := d.ExportZone(userCtx, c.Param("domain"))
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, zone)
+	}
+}
+
+func (a *API) getDNSStatus(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		status, err := d.GetDNSStatus(userCtx)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, status)
 	}
 }
 
+func (a *API) getUserAliases(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		email, err := url.PathUnescape(c.Param("email"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+
+		cursor, err := decodeCursor(c.QueryParam("cursor"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+		limit, err := parseUintParam(c.QueryParam("limit"))
+		if err != nil {
+			return proto.ErrInvalidParameters
+		}
+
+		aliases, next, err := d.GetUserAliases(userCtx, email, cursor, int(limit))
+		if err != nil {
+			return err
+		}
+
+		if next != 0 {
+			c.Response().Header().Set(proto.HeaderNextCursor, encodeCursor(next))
+		}
+
+		return respond(c, http.StatusOK, aliases)
+	}
+}
+
+func (a *API) revokeTokens(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		if err := d.RevokeTokens(userCtx); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+func (a *API) getWebhooks(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		webhooks, err := d.GetWebhooks(userCtx)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, webhooks)
+	}
+}
+
+func (a *API) createWebhook(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		var webhook proto.WebhookDto
+		if err := bindAndValidate(c, &webhook); err != nil {
+			return err
+		}
+
+		webhook, err := d.CreateWebhook(userCtx, webhook)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusCreated, webhook)
+	}
+}
+
+func (a *API) deleteWebhook(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			return proto.ErrWebhookNotFound
+		}
+
+		if err := d.DeleteWebhook(userCtx, uint(id)); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (a *API) createShare(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		var request proto.ShareRequestDto
+		if err := bindAndValidate(c, &request); err != nil {
+			return err
+		}
+
+		share, err := d.CreateShare(userCtx, c.Param("name"), time.Duration(request.ExpiresIn)*time.Second)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusCreated, share)
+	}
+}
+
+func (a *API) getShares(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		shares, err := d.GetShares(userCtx)
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, shares)
+	}
+}
+
+func (a *API) deleteShare(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			return proto.ErrShareNotFound
+		}
+
+		if err := d.DeleteShare(userCtx, uint(id)); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (a *API) getSharedAlias(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		alias, err := d.GetSharedAlias(c.Param("token"))
+		if err != nil {
+			return err
+		}
+
+		return respond(c, http.StatusOK, alias)
+	}
+}
+
+func (a *API) getIP(c echo.Context) error {
+	ip, ok := newIPDto(c.RealIP())
+	if !ok {
+		return proto.ErrInvalidParameters
+	}
+
+	return respond(c, http.StatusOK, ip)
+}
+
+func (a *API) getVersion(c echo.Context) error {
+	return respond(c, http.StatusOK, proto.VersionDto{Version: common.Version})
+}
+
 // Start the API server
 func (a *API) Start(address string) error {
+	if a.conf.SelfSignedTLS {
+		return a.startSelfSignedTLS(address)
+	}
+
 	// determinate if should run HTTPS
 	if a.conf.SSLEnabled() {
 		a.logger.Debug().Msg("SSL support enabled.")
@@ -170,6 +773,11 @@ func (a *API) Start(address string) error {
 			fmt.Sprintf("%s/%s", a.conf.CertCacheDir, a.conf.Hostname))
 	}
 
+	if a.conf.H2C {
+		a.logger.Debug().Msg("HTTP/2 cleartext (h2c) support enabled.")
+		return a.e.StartH2CServer(address, &http2.Server{})
+	}
+
 	return a.e.Start(address)
 }
 
@@ -179,8 +787,68 @@ func (a *API) Shutdown(ctx context.Context) error {
 	return a.e.Shutdown(ctx)
 }
 
+// openAccessLog open the access log file located at given path
+// the standard output is used if path is empty
+func openAccessLog(path string) (io.Writer, error) {
+	if path == "" {
+		return os.Stdout, nil
+	}
+
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+}
+
+// encodeCursor make the cursor opaque to the clients
+// so the ordering key can change without breaking them
+func encodeCursor(cursor uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(cursor), 10)))
+}
+
+func decodeCursor(cursor string) (uint, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	val, err := strconv.ParseUint(string(b), 10, 32)
+	return uint(val), err
+}
+
+// parseUintParam parse given optional unsigned integer parameter
+func parseUintParam(param string) (uint64, error) {
+	if param == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(param, 10, 32)
+}
+
+func (a *API) startSelfSignedTLS(address string) error {
+	a.logger.Warn().Msg("starting API using a self-signed certificate. this is insecure and meant for development only!")
+
+	cert, err := generateSelfSignedCertificate(a.conf.Hostname)
+	if err != nil {
+		return err
+	}
+
+	s := a.e.TLSServer
+	s.Addr = address
+	s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	return a.e.StartServer(s)
+}
+
 func (a *API) startAutoTLS(address string) error {
 	a.logger.Debug().Msg("starting API using auto TLS support.")
+
+	if err := prepareCertCacheDir(a.conf.CertCacheDir, a.logger); err != nil {
+		a.logger.Err(err).Msg("invalid certificate cache directory.")
+		return err
+	}
+
 	// since we are using LetsEncrypt we can only use port 443
 	parts := strings.Split(address, ":")
 	if len(parts) == 2 {
@@ -1 +1,649 @@
 package api
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBindAndValidate_Credentials(t *testing.T) {
+	var cred proto.CredentialsDto
+	err := bindAndValidate(newJSONContext(t, `{"email": "not-an-email"}`), &cred)
+
+	msg := assertHTTPError(t, err, http.StatusUnprocessableEntity)
+	if !strings.Contains(msg, "email (email)") {
+		t.Errorf("missing email field error: %s", msg)
+	}
+	if !strings.Contains(msg, "password (required)") {
+		t.Errorf("missing password field error: %s", msg)
+	}
+
+	details := err.(*echo.HTTPError).Message.(proto.ErrorDto).Details
+	if len(details) != 2 || details["email"] != "must be a valid email address" || details["password"] != "is required" {
+		t.Errorf("wrong error details: %v", details)
+	}
+}
+
+func TestBindAndValidate_Alias(t *testing.T) {
+	var alias proto.AliasDto
+	err := bindAndValidate(newJSONContext(t, `{"domain": "foo.example.org", "value": "not-an-ip"}`), &alias)
+
+	msg := assertHTTPError(t, err, http.StatusUnprocessableEntity)
+	if strings.Contains(msg, "domain") {
+		t.Errorf("domain field should be valid: %s", msg)
+	}
+	if !strings.Contains(msg, "value (ip)") {
+		t.Errorf("missing value field error: %s", msg)
+	}
+}
+
+func TestBindAndValidate_MalformedPayload(t *testing.T) {
+	var alias proto.AliasDto
+	err := bindAndValidate(newJSONContext(t, `{"domain": `), &alias)
+
+	assertHTTPError(t, err, http.StatusUnprocessableEntity)
+}
+
+func TestBindAndValidate_Valid(t *testing.T) {
+	var alias proto.AliasDto
+	if err := bindAndValidate(newJSONContext(t, `{"domain": "foo.example.org", "value": "127.0.0.1"}`), &alias); err != nil {
+		t.Errorf("bindAndValidate() has failed: %s", err)
+	}
+
+	if alias.Domain != "foo.example.org" || alias.Value != "127.0.0.1" {
+		t.Error("wrong alias bound")
+	}
+}
+
+func TestCursor(t *testing.T) {
+	cursor := encodeCursor(42)
+	if cursor == "42" {
+		t.Error("cursor should be opaque")
+	}
+
+	val, err := decodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != 42 {
+		t.Errorf("wrong cursor decoded: %d", val)
+	}
+
+	if val, err := decodeCursor(""); err != nil || val != 0 {
+		t.Errorf("empty cursor should decode to 0")
+	}
+	if _, err := decodeCursor("!!"); err == nil {
+		t.Errorf("invalid cursor should have been rejected")
+	}
+}
+
+func newJSONContext(t *testing.T, body string) echo.Context {
+	e := echo.New()
+	e.Validator = newStructValidator()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	return e.NewContext(req, httptest.NewRecorder())
+}
+
+func assertHTTPError(t *testing.T, err error, code int) string {
+	he, ok := err.(*echo.HTTPError)
+	if !ok {
+		t.Fatalf("expected *echo.HTTPError, got %v", err)
+	}
+
+	if he.Code != code {
+		t.Errorf("wrong status code: %d", he.Code)
+	}
+
+	// make sure the error is serialized as an ErrorDto
+	b, err := json.Marshal(he.Message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errDto proto.ErrorDto
+	if err := json.Unmarshal(b, &errDto); err != nil {
+		t.Fatal(err)
+	}
+
+	return errDto.Message
+}
+
+func TestAPI_RegisterAlias_Location(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCtx := proto.UserContext{UserID: 42, RemoteAddr: "192.0.2.1", UserAgent: "opendydns-test"}
+	daemonMock.EXPECT().
+		RegisterAlias(clientCtx, proto.AliasDto{Domain: "foo.example.org", Value: "1.2.3.4"}).
+		Return(proto.AliasDto{Domain: "foo.example.org", Value: "1.2.3.4", Enabled: true}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/aliases", strings.NewReader(`{"domain": "foo.example.org", "value": "1.2.3.4"}`))
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+	req.Header.Set("User-Agent", "opendydns-test")
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+	if location := rec.Header().Get(echo.HeaderLocation); location != "/aliases/foo.example.org" {
+		t.Errorf("wrong location: %s", location)
+	}
+
+	var alias proto.AliasDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &alias); err != nil {
+		t.Fatal(err)
+	}
+	if alias.Domain != "foo.example.org" || !alias.Enabled {
+		t.Errorf("wrong alias returned: %v", alias)
+	}
+}
+
+func TestAPI_RegisterAlias_IPv6(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().
+		RegisterAlias(gomock.Any(), proto.AliasDto{Domain: "foo.example.org", Value6: "2001:db8::1"}).
+		Return(proto.AliasDto{Domain: "foo.example.org", Value6: "2001:db8::1", Enabled: true}, nil)
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"domain": "foo.example.org", "value6": "2001:db8::1"}`, http.StatusCreated},
+		{`{"domain": "foo.example.org", "value6": "192.0.2.1"}`, http.StatusUnprocessableEntity},
+		{`{"domain": "foo.example.org"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/aliases", strings.NewReader(test.body))
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		a.e.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Errorf("wrong status code for %s: %d", test.body, rec.Code)
+		}
+	}
+}
+
+func TestAPI_DeleteAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the daemon decides whether a repeated deletion is an error
+	daemonMock.EXPECT().DeleteAlias(proto.UserContext{UserID: 42}, "foo.example.org").Return(nil)
+	daemonMock.EXPECT().DeleteAlias(proto.UserContext{UserID: 42}, "foo.example.org").Return(proto.ErrAliasNotFound)
+
+	for _, expected := range []int{http.StatusNoContent, http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodDelete, "/aliases/foo.example.org", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+		rec := httptest.NewRecorder()
+		a.e.ServeHTTP(rec, req)
+
+		if rec.Code != expected {
+			t.Errorf("wrong status code: %d (expected %d)", rec.Code, expected)
+		}
+	}
+}
+
+func TestAPI_AdminAliases(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().
+		GetAllAliases(proto.UserContext{UserID: 42}, uint(7), 2, proto.AliasFilterDto{Domain: "example.org", Owner: "alois@micard.lu"}).
+		Return([]proto.OwnedAliasDto{{AliasDto: proto.AliasDto{Domain: "foo.example.org"}, Owner: "alois@micard.lu"}}, uint(9), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/aliases?cursor="+encodeCursor(7)+"&limit=2&domain=example.org&owner=alois@micard.lu", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+	if next := rec.Header().Get(proto.HeaderNextCursor); next != encodeCursor(9) {
+		t.Errorf("wrong next cursor: %s", next)
+	}
+
+	daemonMock.EXPECT().
+		GetUserAliases(proto.UserContext{UserID: 42}, "alois@micard.lu", uint(7), 2).
+		Return([]proto.AliasDto{{Domain: "foo.example.org"}}, uint(0), nil)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/users/alois@micard.lu/aliases?cursor="+encodeCursor(7)+"&limit=2", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+	rec = httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+	// the last page
+	if next := rec.Header().Get(proto.HeaderNextCursor); next != "" {
+		t.Errorf("no next cursor should have been returned: %s", next)
+	}
+
+	// invalid cursor
+	req = httptest.NewRequest(http.MethodGet, "/admin/aliases?cursor=!!", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+	rec = httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_GetAllEvents(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly := mustMakeScopedToken(t, tokenScope{Scopes: []string{proto.ScopeAliasesRead}})
+
+	daemonMock.EXPECT().GetAllEvents(proto.UserContext{UserID: 1}, uint(3), 0).
+		Return([]proto.OwnedEventDto{{EventDto: proto.EventDto{ID: 4, Alias: "foo.example.org"}, Owner: "alois@micard.lu"}}, nil)
+	daemonMock.EXPECT().GetAllEvents(proto.UserContext{UserID: 42}, uint(0), 0).Return(nil, proto.ErrForbidden)
+
+	rec := doScopedRequest(a, admin, http.MethodGet, "/admin/events?cursor=3", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+	var events []proto.OwnedEventDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != 4 || events[0].Owner != "alois@micard.lu" {
+		t.Errorf("wrong events returned: %v", events)
+	}
+
+	// the feed is restricted to the admins, using a full access token
+	for _, tok := range []proto.TokenDto{user, readOnly} {
+		if rec := doScopedRequest(a, tok, http.MethodGet, "/admin/events", ""); rec.Code != http.StatusForbidden {
+			t.Errorf("wrong status code: %d", rec.Code)
+		}
+	}
+}
+
+func TestAPI_GetAliasesByName(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the token cannot target bar.example.org
+	scope := tokenScope{Scopes: []string{proto.ScopeAliasesRead}, Aliases: []string{"foo.example.org"}}
+	tok, err := makeScopedToken(proto.UserContext{UserID: 42}, scope, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"foo.example.org", "bar.example.org", "missing.example.org"}
+	daemonMock.EXPECT().
+		GetAliasesByName(proto.UserContext{UserID: 42}, names).
+		Return([]proto.AliasDto{
+			{Domain: "foo.example.org", Value: "1.2.3.4"},
+			{Domain: "bar.example.org", Value: "5.6.7.8"},
+		}, []string{"missing.example.org"}, nil)
+
+	body := `{"names": ["foo.example.org", "bar.example.org", "missing.example.org"]}`
+	req := httptest.NewRequest(http.MethodPost, "/aliases/batch-get", strings.NewReader(body))
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var result proto.AliasesLookupDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Aliases) != 1 || result.Aliases[0].Domain != "foo.example.org" {
+		t.Errorf("wrong aliases returned: %v", result.Aliases)
+	}
+	if len(result.Missing) != 2 || result.Missing[0] != "missing.example.org" || result.Missing[1] != "bar.example.org" {
+		t.Errorf("wrong missing names returned: %v", result.Missing)
+	}
+}
+
+func TestAPI_GetAlias_Fields(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias := proto.AliasDto{Domain: "foo.example.org", Value: "1.2.3.4", TTL: 60, Enabled: true, Version: 2}
+	daemonMock.EXPECT().
+		GetAliasesByName(proto.UserContext{UserID: 42}, []string{"foo.example.org"}).
+		Return([]proto.AliasDto{alias}, nil, nil).Times(2)
+	daemonMock.EXPECT().
+		GetAliasesByName(proto.UserContext{UserID: 42}, []string{"bar.example.org"}).
+		Return(nil, []string{"bar.example.org"}, nil)
+	daemonMock.EXPECT().GetAliases(proto.UserContext{UserID: 42}).Return([]proto.AliasDto{alias}, nil).Times(2)
+
+	tests := []struct {
+		target   string
+		code     int
+		expected string
+	}{
+		{"/aliases/foo.example.org?fields=domain,value", http.StatusOK, `{"domain":"foo.example.org","value":"1.2.3.4"}`},
+		{"/aliases/foo.example.org?fields=secret", http.StatusBadRequest, ""},
+		{"/aliases/bar.example.org?fields=domain", http.StatusNotFound, ""},
+		{"/aliases?fields=enabled,version", http.StatusOK, `[{"enabled":true,"version":2}]`},
+		{"/aliases?fields=", http.StatusOK, `[{"domain":"foo.example.org","value":"1.2.3.4","ttl":60,"enabled":true,"dnssec":false,"version":2}]`},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+		rec := httptest.NewRecorder()
+		a.e.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Errorf("wrong status code for %s: %d", test.target, rec.Code)
+		}
+		if test.expected != "" && strings.TrimSpace(rec.Body.String()) != test.expected {
+			t.Errorf("wrong body for %s: %s", test.target, rec.Body.String())
+		}
+	}
+}
+
+func TestAPI_CreateShare(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().CreateShare(gomock.Any(), "foo.example.org", 24*time.Hour).
+		Return(proto.ShareDto{ID: 3, Alias: "foo.example.org", Token: "share-token"}, nil)
+
+	rec := doScopedRequest(a, tok, http.MethodPost, "/aliases/foo.example.org/shares", `{"expires_in": 86400}`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	// the lifetime would overflow once converted to a duration
+	for _, body := range []string{`{"expires_in": -1}`, `{"expires_in": 9300000000000}`} {
+		if rec := doScopedRequest(a, tok, http.MethodPost, "/aliases/foo.example.org/shares", body); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("wrong status code for %s: %d", body, rec.Code)
+		}
+	}
+}
+
+func TestAPI_GetSharedAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().GetSharedAlias("share-token").
+		Return(proto.SharedAliasDto{Alias: "foo.example.org", Value: "1.2.3.4", Enabled: true}, nil)
+	daemonMock.EXPECT().GetSharedAlias("revoked-token").Return(proto.SharedAliasDto{}, proto.ErrShareNotFound)
+
+	// no authentication: the share token is the credential
+	req := httptest.NewRequest(http.MethodGet, "/shared/share-token", nil)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+	var alias proto.SharedAliasDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &alias); err != nil {
+		t.Fatal(err)
+	}
+	if alias.Alias != "foo.example.org" || alias.Value != "1.2.3.4" {
+		t.Errorf("wrong alias returned: %v", alias)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/shared/revoked-token", nil)
+	rec = httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_Authenticate_MOTD(t *testing.T) {
+	for _, motd := range []string{"", "Welcome!"} {
+		mockCtrl := gomock.NewController(t)
+
+		logger := zerolog.New(ioutil.Discard)
+		daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+		daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+		a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test", MOTD: motd})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		daemonMock.EXPECT().Authenticate(gomock.Any()).Return(proto.UserContext{UserID: 42}, nil)
+
+		rec := doScopedRequest(a, proto.TokenDto{}, http.MethodPost, "/sessions", `{"email": "test@example.org", "password": "test"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("wrong status code: %d", rec.Code)
+		}
+
+		var tok proto.TokenDto
+		if err := json.Unmarshal(rec.Body.Bytes(), &tok); err != nil {
+			t.Fatal(err)
+		}
+		if tok.MOTD != motd {
+			t.Errorf("wrong MOTD returned: %s", tok.MOTD)
+		}
+		if motd == "" && strings.Contains(rec.Body.String(), "motd") {
+			t.Errorf("empty MOTD should be omitted: %s", rec.Body.String())
+		}
+
+		mockCtrl.Finish()
+	}
+}
+
+func TestAPI_RegisterUser(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test", MOTD: "Welcome!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registration := proto.RegistrationDto{Email: "test@example.org", Password: "Correct horse battery", InviteCode: "invite"}
+	body := `{"email": "test@example.org", "password": "Correct horse battery", "invite_code": "invite"}`
+
+	// the email address must be verified: no token is issued
+	daemonMock.EXPECT().RegisterUser(registration).Return(proto.UserContext{}, true, nil)
+
+	rec := doScopedRequest(a, proto.TokenDto{}, http.MethodPost, "/users", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var user proto.RegisteredUserDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "test@example.org" || !user.VerificationRequired || user.Token != "" {
+		t.Errorf("wrong registered user returned: %v", user)
+	}
+
+	// the account is active right away
+	daemonMock.EXPECT().RegisterUser(registration).Return(proto.UserContext{UserID: 42}, false, nil)
+
+	rec = doScopedRequest(a, proto.TokenDto{}, http.MethodPost, "/users", body)
+	if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.VerificationRequired || user.Token == "" {
+		t.Errorf("a token should have been issued: %v", user)
+	}
+
+	daemonMock.EXPECT().RegisterUser(gomock.Any()).Return(proto.UserContext{}, false, proto.ErrRegistrationDisabled)
+
+	if rec := doScopedRequest(a, proto.TokenDto{}, http.MethodPost, "/users", body); rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	// the email address is verified: the user is logged in
+	daemonMock.EXPECT().VerifyEmail("verification-token").Return(proto.UserContext{UserID: 42}, nil)
+
+	rec = doScopedRequest(a, proto.TokenDto{}, http.MethodPost, "/users/verify", `{"token": "verification-token"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var tok proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &tok); err != nil {
+		t.Fatal(err)
+	}
+	if tok.Token == "" || tok.MOTD != "Welcome!" {
+		t.Errorf("wrong token returned: %v", tok)
+	}
+
+	if rec := doScopedRequest(a, proto.TokenDto{}, http.MethodPost, "/users/verify", `{}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
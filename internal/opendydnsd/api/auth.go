@@ -1,18 +1,128 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"strings"
 	"time"
 )
 
+// maxTokenLength is the maximum length of the accepted tokens
+// the issued tokens are far smaller: anything longer is not worth parsing
+const maxTokenLength = 4096
+
+// errMalformedToken is returned when the token is not made of three base64url encoded segments
+var errMalformedToken = errors.New("malformed jwt")
+
 // getAuthMiddleware instantiate a authentication middleware
-func getAuthMiddleware(signingKey string) echo.MiddlewareFunc {
-	return middleware.JWTWithConfig(middleware.JWTConfig{
-		SigningKey: []byte(signingKey),
-	})
+// tokens signed using the signing key or one of the previous keys are accepted
+// which allows to rotate the signing key without logging everyone out
+// the user context is then checked using given validate function, if any
+func getAuthMiddleware(signingKey string, previousKeys []string, validate func(proto.UserContext) error) echo.MiddlewareFunc {
+	keys := [][]byte{[]byte(signingKey)}
+	for _, key := range previousKeys {
+		keys = append(keys, []byte(key))
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			if !strings.HasPrefix(auth, "Bearer ") {
+				return middleware.ErrJWTMissing
+			}
+
+			// the token is attacker controlled: reject anything unexpected
+			// before parsing it, and never fail with something else than a 401
+			raw := strings.TrimPrefix(auth, "Bearer ")
+			if err := checkTokenFormat(raw); err != nil {
+				return invalidToken(err)
+			}
+
+			token, err := parseToken(raw, keys)
+			if err != nil {
+				return invalidToken(err)
+			}
+			if _, ok := token.Claims.(jwt.MapClaims)["userID"].(float64); !ok {
+				return invalidToken(errors.New("missing userID claim"))
+			}
+
+			c.Set("user", token)
+
+			if validate != nil {
+				if err := validate(getUserContext(c)); err != nil {
+					return err
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// invalidToken return the error of a rejected token, keeping the reason for the logs
+func invalidToken(err error) error {
+	return &echo.HTTPError{
+		Code:     proto.ErrInvalidToken.Code,
+		Message:  proto.ErrInvalidToken.Message,
+		Internal: err,
+	}
+}
+
+// checkTokenFormat make sure given token is made of three non empty base64url
+// encoded segments and isn't unreasonably long
+func checkTokenFormat(token string) error {
+	if len(token) > maxTokenLength {
+		return fmt.Errorf("jwt exceeds %d bytes", maxTokenLength)
+	}
+
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return errMalformedToken
+	}
+
+	for _, segment := range segments {
+		if segment == "" {
+			return errMalformedToken
+		}
+		for _, r := range segment {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return errMalformedToken
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseToken parse & validate given JWT token using the first matching key
+func parseToken(auth string, keys [][]byte) (*jwt.Token, error) {
+	var err error
+	for _, key := range keys {
+		key := key
+
+		var token *jwt.Token
+		token, err = jwt.Parse(auth, func(t *jwt.Token) (interface{}, error) {
+			if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+				return nil, fmt.Errorf("unexpected jwt signing method=%v", t.Header["alg"])
+			}
+			return key, nil
+		})
+		if err == nil && token.Valid {
+			return token, nil
+		}
+
+		// only a signature mismatch is worth trying the next key
+		var validationErr *jwt.ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			return nil, err
+		}
+	}
+
+	return nil, err
 }
 
 // getUserContext extract the user context from current request
@@ -20,18 +130,46 @@ func getUserContext(c echo.Context) proto.UserContext {
 	user := c.Get("user").(*jwt.Token)
 	claims := user.Claims.(jwt.MapClaims)
 
+	// tokens issued before the token version introduction don't have it
+	tokenVersion, _ := claims["tokenVersion"].(float64)
+
 	return proto.UserContext{
-		UserID: uint(claims["userID"].(float64)),
+		UserID:       uint(claims["userID"].(float64)),
+		TokenVersion: uint(tokenVersion),
 	}
 }
 
-// makeToken create & signed a new JWT token
+// getClientContext extract the user context along with the information
+// of the client from current request, used to record what updated an alias
+// the address is the one of the connection unless forwarded by a trusted proxy, so it can be audited
+func getClientContext(c echo.Context) proto.UserContext {
+	userCtx := getUserContext(c)
+	userCtx.RemoteAddr = c.RealIP()
+	userCtx.UserAgent = c.Request().UserAgent()
+	return userCtx
+}
+
+// makeToken create & signed a new unrestricted JWT token
 func makeToken(userCtx proto.UserContext, secretKey string, tokenTTL time.Duration) (proto.TokenDto, error) {
+	return makeScopedToken(userCtx, tokenScope{}, secretKey, tokenTTL)
+}
+
+// makeScopedToken create & signed a new JWT token restricted to given scope
+func makeScopedToken(userCtx proto.UserContext, scope tokenScope, secretKey string, tokenTTL time.Duration) (proto.TokenDto, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	// Set claims
 	claims := token.Claims.(jwt.MapClaims)
 	claims["userID"] = userCtx.UserID
+	claims["tokenVersion"] = userCtx.TokenVersion
+	claims["iat"] = time.Now().Unix()
+
+	if scope.restricted() {
+		claims["scopes"] = scope.Scopes
+		if len(scope.Aliases) > 0 {
+			claims["aliases"] = scope.Aliases
+		}
+	}
 
 	if tokenTTL != 0 {
 		claims["exp"] = time.Now().Add(tokenTTL).Unix()
@@ -3,12 +3,53 @@ package api
 import (
 	"encoding/base64"
 	"encoding/json"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
 	"github.com/creekorful/open-dydns/proto"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 )
 
+func TestAuthMiddleware_KeyRotation(t *testing.T) {
+	middleware := getAuthMiddleware("new-key", []string{"old-key"}, nil)
+
+	for key, code := range map[string]int{
+		"new-key":     http.StatusOK,
+		"old-key":     http.StatusOK,
+		"removed-key": http.StatusUnauthorized,
+	} {
+		token, err := makeToken(proto.UserContext{UserID: 42}, key, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status := doAuthenticatedRequest(t, middleware, "Bearer "+token.Token); status != code {
+			t.Errorf("wrong status code for token signed with %s: %d", key, status)
+		}
+	}
+
+	// an expired token must be rejected even if signed with a previous key
+	token, err := makeToken(proto.UserContext{UserID: 42}, "old-key", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := doAuthenticatedRequest(t, middleware, "Bearer "+token.Token); status != http.StatusUnauthorized {
+		t.Errorf("wrong status code for expired token: %d", status)
+	}
+
+	if status := doAuthenticatedRequest(t, middleware, ""); status != http.StatusBadRequest {
+		t.Errorf("wrong status code for missing token: %d", status)
+	}
+}
+
 func TestMakeToken(t *testing.T) {
 	token := encodeToken(t, 42, 0)
 	if token.UserID != 42 {
@@ -45,4 +86,174 @@ func encodeToken(t *testing.T, userID uint, ttl time.Duration) proto.UserContext
 	return userCtx
 }
 
-// TODO test token expiration
+func TestAuthMiddleware_RevokedToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	middleware := getAuthMiddleware("test", nil, daemonMock.ValidateUserContext)
+
+	oldToken, err := makeToken(proto.UserContext{UserID: 42, TokenVersion: 0}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := makeToken(proto.UserContext{UserID: 42, TokenVersion: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tokens have been revoked once
+	daemonMock.EXPECT().ValidateUserContext(proto.UserContext{UserID: 42, TokenVersion: 0}).Return(proto.ErrTokenRevoked)
+	daemonMock.EXPECT().ValidateUserContext(proto.UserContext{UserID: 42, TokenVersion: 1}).Return(nil)
+
+	if status := doAuthenticatedRequest(t, middleware, "Bearer "+oldToken.Token); status != http.StatusUnauthorized {
+		t.Errorf("wrong status code for revoked token: %d", status)
+	}
+	if status := doAuthenticatedRequest(t, middleware, "Bearer "+newToken.Token); status != http.StatusOK {
+		t.Errorf("wrong status code for fresh token: %d", status)
+	}
+}
+
+func TestAuthMiddleware_MalformedToken(t *testing.T) {
+	middleware := getAuthMiddleware("test", nil, nil)
+
+	token, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signed with the right key but without user
+	noUser := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"foo": "bar"})
+	noUserToken, err := noUser.SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"truncated token":   token.Token[:len(token.Token)/2],
+		"garbage token":     "\x00\xff not a token at all",
+		"garbage segments":  "Zm9v.YmFy.YmF6",
+		"empty segment":     strings.Join(strings.Split(token.Token, ".")[:2], ".") + ".",
+		"oversized token":   strings.Repeat("a", maxTokenLength) + "." + token.Token,
+		"huge header":       strings.Repeat("a", 1<<20),
+		"missing user":      noUserToken,
+		"unsupported chars": strings.Replace(token.Token, ".", ".+", 1),
+	}
+
+	for name, tok := range tests {
+		if status := doAuthenticatedRequest(t, middleware, "Bearer "+tok); status != http.StatusUnauthorized {
+			t.Errorf("wrong status code for %s: %d", name, status)
+		}
+	}
+}
+
+func doAuthenticatedRequest(t *testing.T, middleware echo.MiddlewareFunc, authorization string) int {
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		if getUserContext(c).UserID != 42 {
+			t.Error("wrong user id")
+		}
+		return c.NoContent(http.StatusOK)
+	}, middleware)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		req.Header.Set(echo.HeaderAuthorization, authorization)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec.Code
+}
+
+func TestAPI_RefreshToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(proto.UserContext{UserID: 42, TokenVersion: 3}).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test", TokenTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the scope of the token is kept
+	scope := tokenScope{Scopes: []string{proto.ScopeAliasesUpdate}, Aliases: []string{"home.example.org"}}
+	tok, err := makeScopedToken(proto.UserContext{UserID: 42, TokenVersion: 3}, scope, "test", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := doScopedRequest(a, tok, http.MethodPost, "/sessions/refresh", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var refreshed proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &refreshed); err != nil {
+		t.Fatal(err)
+	}
+	token, err := parseToken(refreshed.Token, [][]byte{[]byte("test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if exp := time.Unix(int64(claims["exp"].(float64)), 0); exp.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("wrong expiry of the refreshed token: %s", exp)
+	}
+	if claims["tokenVersion"].(float64) != 3 || len(getStringsClaim(claims, "scopes")) != 1 || len(getStringsClaim(claims, "aliases")) != 1 {
+		t.Errorf("wrong claims of the refreshed token: %v", claims)
+	}
+
+	// an expired token cannot be refreshed
+	expired, err := makeToken(proto.UserContext{UserID: 42, TokenVersion: 3}, "test", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := doScopedRequest(a, expired, http.MethodPost, "/sessions/refresh", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code for expired token: %d", rec.Code)
+	}
+}
+
+func TestGetClientContext(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the spoofed forwarded headers don't end up in the update source
+	clientCtx := proto.UserContext{UserID: 42, RemoteAddr: "192.0.2.1", UserAgent: "opendydns-test"}
+	daemonMock.EXPECT().
+		UpdateAlias(clientCtx, proto.AliasDto{Domain: "foo.example.org", Value: "1.2.3.4"}).
+		Return(proto.AliasDto{Domain: "foo.example.org", Value: "1.2.3.4"}, nil)
+
+	req := httptest.NewRequest(http.MethodPut, "/aliases", strings.NewReader(`{"domain": "foo.example.org", "value": "1.2.3.4"}`))
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+	req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.7")
+	req.Header.Set(echo.HeaderXRealIP, "198.51.100.8")
+	req.Header.Set("User-Agent", "opendydns-test")
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
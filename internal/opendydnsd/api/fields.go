@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/xml"
+	"github.com/creekorful/open-dydns/proto"
+	"reflect"
+	"strings"
+)
+
+// selectFields restrict given struct (or slice of structs) to the fields listed in the comma
+// separated fields query parameter, identified by their JSON name. The value is returned as is if empty
+func selectFields(value interface{}, fields string) (interface{}, error) {
+	if fields == "" {
+		return value, nil
+	}
+
+	v := reflect.ValueOf(value)
+	t := v.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	wanted := map[string]bool{}
+	for _, field := range strings.Split(fields, ",") {
+		wanted[strings.TrimSpace(field)] = true
+	}
+
+	// keep the tags so that the encoding of the selected fields is unchanged
+	selected := []reflect.StructField{
+		{Name: "XMLName", Type: reflect.TypeOf(xml.Name{}), Tag: reflect.StructTag(`json:"-" xml:"` + t.Name() + `"`)},
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if wanted[name] {
+			selected = append(selected, reflect.StructField{Name: field.Name, Type: field.Type, Tag: field.Tag})
+			delete(wanted, name)
+		}
+	}
+	if len(wanted) > 0 {
+		return nil, proto.ErrUnknownField
+	}
+
+	selectedType := reflect.StructOf(selected)
+	project := func(item reflect.Value) reflect.Value {
+		projected := reflect.New(selectedType).Elem()
+		for i := 1; i < len(selected); i++ {
+			projected.Field(i).Set(item.FieldByName(selected[i].Name))
+		}
+		return projected
+	}
+
+	if v.Kind() != reflect.Slice {
+		return project(v).Interface(), nil
+	}
+
+	projected := reflect.MakeSlice(reflect.SliceOf(selectedType), v.Len(), v.Len())
+	for i := 0; i < v.Len(); i++ {
+		projected.Index(i).Set(project(v.Index(i)))
+	}
+	return projected.Interface(), nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/creekorful/open-dydns/proto"
+	"testing"
+)
+
+func TestSelectFields(t *testing.T) {
+	alias := proto.AliasDto{Domain: "foo.example.org", Value: "1.2.3.4", TTL: 60, Enabled: true, Version: 3}
+
+	value, err := selectFields(alias, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != alias {
+		t.Errorf("the value should have been returned as is: %v", value)
+	}
+
+	value, err = selectFields(alias, "domain, ttl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"domain":"foo.example.org","ttl":60}` {
+		t.Errorf("wrong JSON: %s", b)
+	}
+	b, err = xml.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `<AliasDto><domain>foo.example.org</domain><ttl>60</ttl></AliasDto>` {
+		t.Errorf("wrong XML: %s", b)
+	}
+
+	value, err = selectFields([]proto.AliasDto{alias, {Domain: "bar.example.org", Value: "::1"}}, "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `[{"value":"1.2.3.4"},{"value":"::1"}]` {
+		t.Errorf("wrong JSON: %s", b)
+	}
+
+	// the field names are the JSON ones
+	for _, fields := range []string{"Domain", "domain,password", "domain,"} {
+		if _, err := selectFields(alias, fields); err != proto.ErrUnknownField {
+			t.Errorf("selectFields(%s) should have returned ErrUnknownField: %v", fields, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net"
+	"strings"
+)
+
+// newIPExtractor return an echo.IPExtractor honoring the X-Forwarded-For header
+// only when the request is coming from one of given proxies (address or CIDR range)
+func newIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy `%s`: %s", proxy, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// newIPDto create the IPDto of given IP
+// IPv4-mapped IPv6 addresses are returned as plain IPv4
+func newIPDto(value string) (proto.IPDto, bool) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return proto.IPDto{}, false
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return proto.IPDto{IP: v4.String(), Version: 4}, true
+	}
+
+	return proto.IPDto{IP: ip.String(), Version: 6}, true
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPI_GetIP_Direct(t *testing.T) {
+	a := newIPTestAPI(t, config.APIConfig{SigningKey: "test", AnonymousIPLookup: true})
+
+	tests := []struct {
+		remoteAddr string
+		ip         proto.IPDto
+	}{
+		{"192.0.2.1:1234", proto.IPDto{IP: "192.0.2.1", Version: 4}},
+		{"[2001:db8::1]:1234", proto.IPDto{IP: "2001:db8::1", Version: 6}},
+		{"[::ffff:192.0.2.1]:1234", proto.IPDto{IP: "192.0.2.1", Version: 4}},
+	}
+
+	for _, test := range tests {
+		rec := doGetIP(a, test.remoteAddr, "", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("wrong status code: %d", rec.Code)
+		}
+
+		var ip proto.IPDto
+		if err := json.Unmarshal(rec.Body.Bytes(), &ip); err != nil {
+			t.Fatal(err)
+		}
+		if ip != test.ip {
+			t.Errorf("wrong IP returned for %s: %v", test.remoteAddr, ip)
+		}
+	}
+
+	// no trusted proxy: the forwarded headers are ignored
+	rec := doGetIP(a, "192.0.2.1:1234", "198.51.100.7", "")
+	var ip proto.IPDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &ip); err != nil {
+		t.Fatal(err)
+	}
+	if ip.IP != "192.0.2.1" {
+		t.Errorf("the spoofed X-Forwarded-For header should have been ignored: %s", ip.IP)
+	}
+
+	// plain text
+	rec = doGetIP(a, "192.0.2.1:1234", "", echo.MIMETextPlain)
+	if body := rec.Body.String(); body != "192.0.2.1\n" {
+		t.Errorf("wrong body: %s", body)
+	}
+}
+
+func TestAPI_GetIP_Proxied(t *testing.T) {
+	a := newIPTestAPI(t, config.APIConfig{
+		SigningKey:        "test",
+		AnonymousIPLookup: true,
+		TrustedProxies:    []string{"10.0.0.0/8", "2001:db8::53"},
+	})
+
+	tests := []struct {
+		remoteAddr string
+		xff        string
+		ip         string
+	}{
+		// forwarded by trusted proxies
+		{"10.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"[2001:db8::53]:1234", "2001:db8:1::7", "2001:db8:1::7"},
+		{"10.0.0.1:1234", "198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		// header spoofed by an untrusted client
+		{"192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+		{"10.0.0.1:1234", "198.51.100.7, 192.0.2.1", "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		rec := doGetIP(a, test.remoteAddr, test.xff, "")
+		var ip proto.IPDto
+		if err := json.Unmarshal(rec.Body.Bytes(), &ip); err != nil {
+			t.Fatal(err)
+		}
+		if ip.IP != test.ip {
+			t.Errorf("wrong IP returned for %s (X-Forwarded-For: %s): %s", test.remoteAddr, test.xff, ip.IP)
+		}
+	}
+}
+
+func TestAPI_GetIP_Authenticated(t *testing.T) {
+	a := newIPTestAPI(t, config.APIConfig{SigningKey: "test"})
+
+	if rec := doGetIP(a, "192.0.2.1:1234", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestNewIPExtractor(t *testing.T) {
+	if _, err := newIPExtractor([]string{"10.0.0.0/8", "192.0.2.1", "::1"}); err != nil {
+		t.Errorf("newIPExtractor() should not have failed: %s", err)
+	}
+	if _, err := newIPExtractor([]string{"not-an-ip"}); err == nil {
+		t.Error("newIPExtractor() should have failed")
+	}
+}
+
+func newIPTestAPI(t *testing.T, conf config.APIConfig) *API {
+	mockCtrl := gomock.NewController(t)
+	t.Cleanup(mockCtrl.Finish)
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	a, err := NewAPI(daemonMock, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func doGetIP(a *API, remoteAddr, xff, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = remoteAddr
+	if xff != "" {
+		req.Header.Set(echo.HeaderXForwardedFor, xff)
+	}
+	if accept != "" {
+		req.Header.Set(echo.HeaderAccept, accept)
+	}
+
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	return rec
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog"
+	"io"
+	"time"
 )
 
 func newZeroLogMiddleware(logger *zerolog.Logger) echo.MiddlewareFunc {
@@ -13,6 +16,7 @@ func newZeroLogMiddleware(logger *zerolog.Logger) echo.MiddlewareFunc {
 			}
 
 			logger.Debug().
+				Str("RequestID", c.Response().Header().Get(echo.HeaderXRequestID)).
 				Str("RemoteAddr", c.RealIP()).
 				Int("Status", c.Response().Status).
 				Int64("Length", c.Response().Size).
@@ -21,3 +25,56 @@ func newZeroLogMiddleware(logger *zerolog.Logger) echo.MiddlewareFunc {
 		}
 	}
 }
+
+// newAccessLogMiddleware return a middleware writing an access log entry per request
+// the entries are JSON objects with a stable set of fields:
+// method, path, status, latency (milliseconds), bytes, request_id, user_id, remote_addr & user_agent
+func newAccessLogMiddleware(w io.Writer) echo.MiddlewareFunc {
+	logger := zerolog.New(w).With().Timestamp().Logger()
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			entry := logger.Log().
+				Str("method", c.Request().Method).
+				Str("path", c.Request().URL.Path).
+				Int("status", c.Response().Status).
+				Float64("latency", float64(time.Since(start).Microseconds())/1000).
+				Int64("bytes", c.Response().Size).
+				Str("request_id", c.Response().Header().Get(echo.HeaderXRequestID)).
+				Str("remote_addr", c.RealIP()).
+				Str("user_agent", c.Request().UserAgent())
+
+			// only available for authenticated requests
+			if userID, ok := getUserID(c); ok {
+				entry = entry.Uint("user_id", userID)
+			}
+
+			entry.Msg("")
+			return nil
+		}
+	}
+}
+
+// getUserID return the ID of the authenticated user if any
+func getUserID(c echo.Context) (uint, bool) {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return 0, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return 0, false
+	}
+
+	return uint(userID), true
+}
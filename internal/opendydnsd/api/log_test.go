@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+
+	e := echo.New()
+	e.Use(middleware.RequestID())
+	e.Use(newAccessLogMiddleware(&logs))
+
+	e.GET("/aliases", func(c echo.Context) error {
+		return c.String(http.StatusOK, "hello")
+	}, getAuthMiddleware("test", nil, nil))
+	e.GET("/version", func(c echo.Context) error {
+		return proto.ErrAliasNotFound
+	})
+
+	token, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/aliases", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token.Token)
+	req.Header.Set("User-Agent", "opendydns-cli/0.3.0")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	entry := decodeAccessLog(t, &logs)
+	for _, field := range []string{"time", "latency", "remote_addr"} {
+		if _, exist := entry[field]; !exist {
+			t.Errorf("missing field %s", field)
+		}
+	}
+	if entry["method"] != "GET" || entry["path"] != "/aliases" || entry["status"] != float64(200) ||
+		entry["bytes"] != float64(5) || entry["user_id"] != float64(42) ||
+		entry["request_id"] != rec.Header().Get(echo.HeaderXRequestID) || entry["user_agent"] != "opendydns-cli/0.3.0" {
+		t.Errorf("wrong access log entry: %v", entry)
+	}
+	if _, exist := entry["level"]; exist {
+		t.Errorf("access log entries must not have a level")
+	}
+
+	// errors must be logged with their final status, and anonymous requests without user
+	logs.Reset()
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	entry = decodeAccessLog(t, &logs)
+	if entry["status"] != float64(404) || entry["path"] != "/version" {
+		t.Errorf("wrong access log entry: %v", entry)
+	}
+	if _, exist := entry["user_id"]; exist {
+		t.Errorf("anonymous request logged with an user: %v", entry)
+	}
+}
+
+func decodeAccessLog(t *testing.T, logs *bytes.Buffer) map[string]interface{} {
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid access log entry `%s`: %s", logs.String(), err)
+	}
+
+	return entry
+}
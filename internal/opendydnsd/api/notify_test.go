@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPI_NotifyAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := makeToken(proto.UserContext{UserID: 42}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// payload driven
+	daemonMock.EXPECT().
+		UpdateAlias(proto.UserContext{UserID: 42, RemoteAddr: "192.0.2.1"}, proto.AliasDto{Domain: "foo.example.org", Value: "1.2.3.4"}).
+		Return(proto.AliasDto{Domain: "foo.example.org", Value: "1.2.3.4"}, nil)
+
+	rec := doNotify(a, tok, "192.0.2.1:1234", `{"value": "1.2.3.4"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+	var alias proto.AliasDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &alias); err != nil {
+		t.Fatal(err)
+	}
+	if alias.Value != "1.2.3.4" {
+		t.Errorf("wrong alias returned: %v", alias)
+	}
+
+	// request IP driven
+	daemonMock.EXPECT().
+		UpdateAlias(proto.UserContext{UserID: 42, RemoteAddr: "192.0.2.1"}, proto.AliasDto{Domain: "foo.example.org", Value: "192.0.2.1"}).
+		Return(proto.AliasDto{Domain: "foo.example.org", Value: "192.0.2.1"}, nil)
+
+	if rec := doNotify(a, tok, "192.0.2.1:1234", ""); rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	// invalid payload
+	if rec := doNotify(a, tok, "192.0.2.1:1234", `{"value": "not-an-ip"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func doNotify(a *API, tok proto.TokenDto, remoteAddr, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/aliases/foo.example.org/notify", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	return rec
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"sync"
+	"time"
+)
+
+// rateLimiter count the requests of each IP address over a fixed window
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mutex   sync.Mutex
+	windows map[string]*rateWindow
+	// pruned is the last time the expired windows have been dropped
+	pruned time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		now:     time.Now,
+		windows: map[string]*rateWindow{},
+	}
+}
+
+// allow count a request of given IP address and tell whether it can be served
+func (rl *rateLimiter) allow(ip string) bool {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+
+	// drop the expired windows from time to time so the addresses seen once don't pile up
+	if now.Sub(rl.pruned) >= rl.window {
+		for key, window := range rl.windows {
+			if now.Sub(window.start) >= rl.window {
+				delete(rl.windows, key)
+			}
+		}
+		rl.pruned = now
+	}
+
+	window, exist := rl.windows[ip]
+	if !exist || now.Sub(window.start) >= rl.window {
+		window = &rateWindow{start: now}
+		rl.windows[ip] = window
+	}
+
+	window.count++
+	return window.count <= rl.limit
+}
+
+// newRateLimitMiddleware return a middleware rejecting the clients having made more than limit requests
+// during the window, counted per IP address across the routes using it
+func newRateLimitMiddleware(limit int, window time.Duration) echo.MiddlewareFunc {
+	return newRateLimiter(limit, window).middleware
+}
+
+func (rl *rateLimiter) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// the address of the connection, unless forwarded by a trusted proxy (see NewAPI)
+		if !rl.allow(c.RealIP()) {
+			return proto.ErrTooManyRequests
+		}
+
+		return next(c)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(2, time.Hour)
+	rl.now = func() time.Time { return now }
+
+	e := echo.New()
+	e.POST("/users", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	}, rl.middleware)
+	e.POST("/users/verify", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, rl.middleware)
+
+	do := func(path, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the requests are counted across the routes
+	if code := do("/users", "192.0.2.1:1234"); code != http.StatusCreated {
+		t.Errorf("wrong status code: %d", code)
+	}
+	if code := do("/users/verify", "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("wrong status code: %d", code)
+	}
+	if code := do("/users", "192.0.2.1:4321"); code != http.StatusTooManyRequests {
+		t.Errorf("wrong status code: %d", code)
+	}
+
+	// the limit applies per IP address
+	if code := do("/users", "192.0.2.2:1234"); code != http.StatusCreated {
+		t.Errorf("wrong status code: %d", code)
+	}
+
+	now = now.Add(time.Hour)
+	if code := do("/users", "192.0.2.1:1234"); code != http.StatusCreated {
+		t.Errorf("the requests should be served again: %d", code)
+	}
+	// the expired windows are dropped
+	if len(rl.windows) != 1 {
+		t.Errorf("wrong number of windows kept: %d", len(rl.windows))
+	}
+}
+
+func TestAPI_RegisterUser_RateLimit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test", Registration: config.RegistrationConfig{RateLimit: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().RegisterUser(gomock.Any()).Return(proto.UserContext{}, true, nil)
+
+	// no trusted proxy: rotating the forwarded header doesn't reset the limit
+	for i, xff := range []string{"198.51.100.1", "198.51.100.2"} {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"email": "test@example.org", "password": "Correct horse battery"}`))
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set(echo.HeaderXForwardedFor, xff)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		a.e.ServeHTTP(rec, req)
+
+		if expected := []int{http.StatusCreated, http.StatusTooManyRequests}[i]; rec.Code != expected {
+			t.Errorf("wrong status code for X-Forwarded-For %s: %d", xff, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"runtime/debug"
+)
+
+// newRecoverMiddleware return a middleware recovering from panics
+// the panic is logged and a clean 500 is returned, without leaking any internals
+// it must be registered first to catch the panics of the other middlewares too
+func newRecoverMiddleware(logger *zerolog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+
+				logger.Error().
+					Str("RequestID", c.Response().Header().Get(echo.HeaderXRequestID)).
+					Str("Panic", fmt.Sprint(r)).
+					Str("Stack", string(debug.Stack())).
+					Msgf("recovered from panic while serving %s %s", c.Request().Method, c.Path())
+
+				err = proto.ErrInternal
+			}()
+
+			return next(c)
+		}
+	}
+}
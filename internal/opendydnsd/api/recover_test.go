@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	e := echo.New()
+	e.Use(newRecoverMiddleware(&logger))
+	e.Use(middleware.RequestID())
+
+	panicking := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			panic("middleware bug")
+		}
+	}
+
+	e.GET("/handler", func(c echo.Context) error {
+		var m map[string]string
+		m["boom"] = "boom" // nil map
+		return nil
+	})
+	e.GET("/middleware", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, panicking)
+
+	for _, path := range []string{"/handler", "/middleware"} {
+		logs.Reset()
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("wrong status code for %s: %d", path, rec.Code)
+		}
+
+		var errDto proto.ErrorDto
+		if err := json.Unmarshal(rec.Body.Bytes(), &errDto); err != nil {
+			t.Fatal(err)
+		}
+		if errDto.Message != "internal server error" {
+			t.Errorf("wrong error message for %s: %s", path, errDto.Message)
+		}
+		if strings.Contains(rec.Body.String(), "goroutine") || strings.Contains(rec.Body.String(), "nil map") {
+			t.Errorf("internals leaked for %s: %s", path, rec.Body.String())
+		}
+
+		requestID := rec.Header().Get(echo.HeaderXRequestID)
+		if requestID == "" || !strings.Contains(logs.String(), requestID) {
+			t.Errorf("panic not logged with the request id for %s: %s", path, logs.String())
+		}
+	}
+}
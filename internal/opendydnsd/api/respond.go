@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// xmlList is used to give a root element to slices rendered as XML
+type xmlList struct {
+	XMLName xml.Name    `xml:"list"`
+	Items   interface{} `xml:"item"`
+}
+
+// respond write given value using the best content type accepted by the client
+// JSON is used by default, XML and plain text are available on demand
+func respond(c echo.Context, code int, value interface{}) error {
+	for _, mimeType := range acceptedTypes(c.Request().Header.Get(echo.HeaderAccept)) {
+		switch mimeType {
+		case echo.MIMEApplicationXML, "text/xml":
+			if reflect.ValueOf(value).Kind() == reflect.Slice {
+				return c.XML(code, xmlList{Items: value})
+			}
+			return c.XML(code, value)
+		case echo.MIMETextPlain:
+			if text, ok := toText(value); ok {
+				return c.String(code, text)
+			}
+		case echo.MIMEApplicationJSON, "*/*":
+			return c.JSON(code, value)
+		}
+	}
+
+	return c.JSON(code, value)
+}
+
+// handleError render the errors returned by the handlers (and the middlewares) as an ErrorDto,
+// using the content type accepted by the client like the other responses
+func (a *API) handleError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	he, ok := err.(*echo.HTTPError)
+	if !ok {
+		// the internals are never leaked
+		he = proto.ErrInternal
+	}
+	if internal, ok := he.Internal.(*echo.HTTPError); ok {
+		he = internal
+	}
+
+	errDto, ok := he.Message.(proto.ErrorDto)
+	if !ok {
+		errDto = proto.ErrorDto{Message: fmt.Sprint(he.Message)}
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(he.Code)
+	} else {
+		err = respond(c, he.Code, errDto)
+	}
+	if err != nil {
+		a.logger.Err(err).Msg("error while sending error response.")
+	}
+}
+
+// acceptedTypes parse given Accept header and return the mime types ordered by preference
+func acceptedTypes(header string) []string {
+	type acceptedType struct {
+		mimeType string
+		q        float64
+	}
+
+	var types []acceptedType
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		t := acceptedType{mimeType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if t.mimeType == "" {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					t.q = q
+				}
+			}
+		}
+
+		if t.q > 0 {
+			types = append(types, t)
+		}
+	}
+
+	sort.SliceStable(types, func(i, j int) bool {
+		return types[i].q > types[j].q
+	})
+
+	var mimeTypes []string
+	for _, t := range types {
+		mimeTypes = append(mimeTypes, t.mimeType)
+	}
+	return mimeTypes
+}
+
+// toText render given value (or slice of values) as plain text, one value per line
+// this only works for values implementing fmt.Stringer
+func toText(value interface{}) (string, bool) {
+	if s, ok := value.(fmt.Stringer); ok {
+		return s.String() + "\n", true
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice {
+		return "", false
+	}
+
+	var sb strings.Builder
+	for i := 0; i < v.Len(); i++ {
+		s, ok := v.Index(i).Interface().(fmt.Stringer)
+		if !ok {
+			return "", false
+		}
+		sb.WriteString(s.String() + "\n")
+	}
+
+	return sb.String(), true
+}
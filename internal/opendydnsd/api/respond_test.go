@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testAliases = []proto.AliasDto{
+	{Domain: "foo.example.org", Value: "127.0.0.1"},
+	{Domain: "bar.example.org", Value: "::1"},
+}
+
+func TestRespond_JSON(t *testing.T) {
+	for _, accept := range []string{"", "application/json", "*/*", "image/png", "text/plain;q=0"} {
+		rec := doRespond(t, accept, testAliases)
+
+		if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationJSON) {
+			t.Errorf("wrong content type for Accept `%s`: %s", accept, ct)
+		}
+
+		var aliases []proto.AliasDto
+		if err := json.Unmarshal(rec.Body.Bytes(), &aliases); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(aliases, testAliases) {
+			t.Errorf("wrong aliases returned: %v", aliases)
+		}
+	}
+}
+
+func TestRespond_XML(t *testing.T) {
+	rec := doRespond(t, "application/json;q=0.5, application/xml", testAliases)
+
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationXML) {
+		t.Errorf("wrong content type: %s", ct)
+	}
+
+	var list struct {
+		Items []proto.AliasDto `xml:"item"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Items, testAliases) {
+		t.Errorf("wrong aliases returned: %v", list.Items)
+	}
+}
+
+func TestRespond_PlainText(t *testing.T) {
+	rec := doRespond(t, "text/plain", testAliases)
+
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMETextPlain) {
+		t.Errorf("wrong content type: %s", ct)
+	}
+	if body := rec.Body.String(); body != "foo.example.org 127.0.0.1\nbar.example.org ::1\n" {
+		t.Errorf("wrong body: %s", body)
+	}
+
+	// value not renderable as text: fallback to JSON
+	rec = doRespond(t, "text/plain", proto.TokenDto{Token: "test"})
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationJSON) {
+		t.Errorf("wrong content type: %s", ct)
+	}
+}
+
+func TestAcceptedTypes(t *testing.T) {
+	types := acceptedTypes("text/plain;q=0.2, application/xml, */*;q=0.1, text/html;q=0")
+
+	if !reflect.DeepEqual(types, []string{"application/xml", "text/plain", "*/*"}) {
+		t.Errorf("wrong accepted types: %v", types)
+	}
+}
+
+func doRespond(t *testing.T, accept string, value interface{}) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if accept != "" {
+		req.Header.Set(echo.HeaderAccept, accept)
+	}
+	rec := httptest.NewRecorder()
+
+	if err := respond(echo.New().NewContext(req, rec), http.StatusOK, value); err != nil {
+		t.Fatal(err)
+	}
+
+	return rec
+}
+
+func TestAPI_HandleError(t *testing.T) {
+	logger := zerolog.New(ioutil.Discard)
+	a := API{logger: &logger}
+
+	do := func(accept string, err error) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAccept, accept)
+		rec := httptest.NewRecorder()
+		a.handleError(err, echo.New().NewContext(req, rec))
+		return rec
+	}
+
+	rec := do("application/xml", echo.NewHTTPError(http.StatusUnprocessableEntity, proto.ErrorDto{
+		Message: "invalid request",
+		Details: map[string]string{"value": "ip", "domain": "fqdn"},
+	}))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationXML) {
+		t.Errorf("wrong content type: %s", ct)
+	}
+	if body := rec.Body.String(); !strings.HasSuffix(body, `<error><message>invalid request</message><details><detail field="domain">fqdn</detail><detail field="value">ip</detail></details></error>`) {
+		t.Errorf("wrong body: %s", body)
+	}
+
+	rec = do("text/plain", proto.ErrAliasNotFound)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMETextPlain) {
+		t.Errorf("wrong content type: %s", ct)
+	}
+	if body := rec.Body.String(); body != "alias not found\n" {
+		t.Errorf("wrong body: %s", body)
+	}
+
+	// the internal errors are never leaked
+	rec = do("application/json", errors.New("database is locked"))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+	if body := rec.Body.String(); body != "{\"message\":\"internal server error\"}\n" {
+		t.Errorf("wrong body: %s", body)
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"strings"
+)
+
+// tokenScope is the restriction of a token
+// a token without scopes is unrestricted (full account access)
+type tokenScope struct {
+	Scopes []string
+	// Aliases are the only aliases the token can target, any if empty
+	Aliases []string
+}
+
+func (ts tokenScope) restricted() bool {
+	return len(ts.Scopes) > 0
+}
+
+func (ts tokenScope) hasScope(scope string) bool {
+	for _, s := range ts.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// allowAlias determinate if the token can target given alias
+func (ts tokenScope) allowAlias(name string) bool {
+	if len(ts.Aliases) == 0 {
+		return true
+	}
+
+	for _, alias := range ts.Aliases {
+		if strings.EqualFold(alias, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireScope restrict the route to the unrestricted tokens and to the tokens having given scope
+// an empty scope means only the unrestricted tokens are accepted
+// the alias targeted by the route (name parameter), if any, must be allowed by the token
+func requireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ts := getTokenScope(c)
+			if !ts.restricted() {
+				return next(c)
+			}
+
+			if scope == "" || !ts.hasScope(scope) {
+				return proto.ErrForbidden
+			}
+			if name := c.Param("name"); name != "" && !ts.allowAlias(name) {
+				return proto.ErrForbidden
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// checkAliasScope make sure the token can target given alias
+// used by the routes whose target is in the payload
+func checkAliasScope(c echo.Context, name string) error {
+	if !getTokenScope(c).allowAlias(name) {
+		return proto.ErrForbidden
+	}
+	return nil
+}
+
+// filterAliases return the aliases the token can target
+func filterAliases(c echo.Context, aliases []proto.AliasDto) []proto.AliasDto {
+	ts := getTokenScope(c)
+	if len(ts.Aliases) == 0 {
+		return aliases
+	}
+
+	var filtered []proto.AliasDto
+	for _, alias := range aliases {
+		if ts.allowAlias(alias.Domain) {
+			filtered = append(filtered, alias)
+		}
+	}
+	return filtered
+}
+
+// filterEvents return the events of the aliases the token can target
+func filterEvents(c echo.Context, events []proto.EventDto) []proto.EventDto {
+	ts := getTokenScope(c)
+	if len(ts.Aliases) == 0 {
+		return events
+	}
+
+	var filtered []proto.EventDto
+	for _, event := range events {
+		if ts.allowAlias(event.Alias) {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
+
+// getTokenScope extract the scope of the token of current request
+func getTokenScope(c echo.Context) tokenScope {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return tokenScope{}
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return tokenScope{}
+	}
+
+	return tokenScope{
+		Scopes:  getStringsClaim(claims, "scopes"),
+		Aliases: getStringsClaim(claims, "aliases"),
+	}
+}
+
+func getStringsClaim(claims jwt.MapClaims, name string) []string {
+	values, _ := claims[name].([]interface{})
+
+	var result []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPI_Scopes(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().ValidateUserContext(gomock.Any()).Return(nil).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userCtx := proto.UserContext{UserID: 42}
+	full := mustMakeScopedToken(t, tokenScope{})
+	readOnly := mustMakeScopedToken(t, tokenScope{Scopes: []string{proto.ScopeAliasesRead}})
+	homeOnly := mustMakeScopedToken(t, tokenScope{
+		Scopes:  []string{proto.ScopeAliasesRead, proto.ScopeAliasesUpdate},
+		Aliases: []string{"home.example.org"},
+	})
+
+	aliases := []proto.AliasDto{
+		{Domain: "home.example.org", Value: "127.0.0.1"},
+		{Domain: "www.example.org", Value: "127.0.0.2"},
+	}
+	daemonMock.EXPECT().GetAliases(userCtx).Return(aliases, nil).AnyTimes()
+	// the updates also carry the client information
+	clientCtx := proto.UserContext{UserID: 42, RemoteAddr: "192.0.2.1"}
+	daemonMock.EXPECT().UpdateAlias(clientCtx, gomock.Any()).Return(proto.AliasDto{}, nil).AnyTimes()
+	daemonMock.EXPECT().DeleteAlias(userCtx, gomock.Any()).Return(nil).AnyTimes()
+
+	tests := []struct {
+		name   string
+		token  proto.TokenDto
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"full access can delete", full, http.MethodDelete, "/aliases/www.example.org", "", http.StatusNoContent},
+		{"read only can list", readOnly, http.MethodGet, "/aliases", "", http.StatusOK},
+		{"read only cannot update", readOnly, http.MethodPut, "/aliases", `{"domain": "www.example.org", "value": "127.0.0.3"}`, http.StatusForbidden},
+		{"read only cannot notify", readOnly, http.MethodPost, "/aliases/www.example.org/notify", `{"value": "127.0.0.3"}`, http.StatusForbidden},
+		{"scoped cannot delete", homeOnly, http.MethodDelete, "/aliases/home.example.org", "", http.StatusForbidden},
+		{"scoped cannot revoke", homeOnly, http.MethodPost, "/users/me/revoke-all", "", http.StatusForbidden},
+		{"scoped can notify its alias", homeOnly, http.MethodPost, "/aliases/HOME.example.org/notify", `{"value": "127.0.0.3"}`, http.StatusOK},
+		{"scoped cannot notify another alias", homeOnly, http.MethodPost, "/aliases/www.example.org/notify", `{"value": "127.0.0.3"}`, http.StatusForbidden},
+		{"scoped can update its alias", homeOnly, http.MethodPut, "/aliases", `{"domain": "home.example.org", "value": "127.0.0.3"}`, http.StatusOK},
+		{"scoped cannot update another alias", homeOnly, http.MethodPut, "/aliases", `{"domain": "www.example.org", "value": "127.0.0.3"}`, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		rec := doScopedRequest(a, test.token, test.method, test.path, test.body)
+		if rec.Code != test.code {
+			t.Errorf("%s: wrong status code: %d", test.name, rec.Code)
+		}
+	}
+
+	// the listing only contains the aliases the token can target
+	rec := doScopedRequest(a, homeOnly, http.MethodGet, "/aliases", "")
+	var listed []proto.AliasDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Domain != "home.example.org" {
+		t.Errorf("wrong aliases listed: %v", listed)
+	}
+}
+
+func TestAPI_Authenticate_Scoped(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := zerolog.New(ioutil.Discard)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().Authenticate(gomock.Any()).Return(proto.UserContext{UserID: 42}, nil)
+
+	rec := doScopedRequest(a, proto.TokenDto{}, http.MethodPost, "/sessions",
+		`{"email": "test@example.org", "password": "test", "scopes": ["aliases:update"], "aliases": ["home.example.org"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var tok proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &tok); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := parseToken(tok.Token, [][]byte{[]byte("test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	c.Set("user", token)
+
+	scope := getTokenScope(c)
+	if !scope.hasScope(proto.ScopeAliasesUpdate) || scope.hasScope(proto.ScopeAliasesRead) ||
+		!scope.allowAlias("home.example.org") || scope.allowAlias("www.example.org") {
+		t.Errorf("wrong token scope: %v", scope)
+	}
+
+	// unknown scopes and aliases without scopes are rejected
+	for _, body := range []string{
+		`{"email": "test@example.org", "password": "test", "scopes": ["admin"]}`,
+		`{"email": "test@example.org", "password": "test", "aliases": ["home.example.org"]}`,
+	} {
+		if rec := doScopedRequest(a, proto.TokenDto{}, http.MethodPost, "/sessions", body); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("wrong status code for %s: %d", body, rec.Code)
+		}
+	}
+}
+
+func mustMakeScopedToken(t *testing.T, scope tokenScope) proto.TokenDto {
+	tok, err := makeScopedToken(proto.UserContext{UserID: 42}, scope, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func doScopedRequest(a *API, tok proto.TokenDto, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if tok.Token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+tok.Token)
+	}
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	return rec
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"math"
+)
+
+// minSigningKeyLength is the minimum length of the signing keys: HS256 needs a key of (at least) 256 bits
+const minSigningKeyLength = 32
+
+// minSigningKeyEntropy is the minimum entropy (in bits per character) of the signing keys
+// it rejects the keys made of a few repeated characters, whatever their length
+const minSigningKeyEntropy = 3.0
+
+// generatedSigningKeyLength is the number of random bytes of the generated signing keys
+const generatedSigningKeyLength = 32
+
+// ErrWeakSigningKey is returned when a signing key is too short or too predictable to sign tokens securely
+var ErrWeakSigningKey = errors.New("signing key is too weak")
+
+// CheckSigningKeys make sure the keys used to sign (or validate) the tokens are strong enough
+// a weak previous key allows to forge tokens as much as a weak current one
+func CheckSigningKeys(conf config.APIConfig) error {
+	for _, key := range append([]string{conf.SigningKey}, conf.PreviousSigningKeys...) {
+		if err := checkSigningKey(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkSigningKey(key string) error {
+	if len(key) < minSigningKeyLength {
+		return fmt.Errorf("%w: at least %d characters are required", ErrWeakSigningKey, minSigningKeyLength)
+	}
+	if entropy(key) < minSigningKeyEntropy {
+		return fmt.Errorf("%w: too few distinct characters", ErrWeakSigningKey)
+	}
+
+	return nil
+}
+
+// entropy return the Shannon entropy of given string, in bits per character
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+
+	e := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		e -= p * math.Log2(p)
+	}
+
+	return e
+}
+
+// GenerateSigningKey return a random key suitable to sign the tokens
+func GenerateSigningKey() (string, error) {
+	b := make([]byte, generatedSigningKeyLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"strings"
+	"testing"
+)
+
+func TestCheckSigningKeys(t *testing.T) {
+	tests := []struct {
+		conf  config.APIConfig
+		valid bool
+	}{
+		{config.APIConfig{SigningKey: ""}, false},
+		{config.APIConfig{SigningKey: "test"}, false},
+		{config.APIConfig{SigningKey: "0123456789abcdef0123456789abcde"}, false},
+		{config.APIConfig{SigningKey: strings.Repeat("a", 64)}, false},
+		{config.APIConfig{SigningKey: strings.Repeat("password", 8)}, false},
+		{config.APIConfig{SigningKey: "7f3c9a1e5b2d8f4a6c0e9b3d7a1f5c2e"}, true},
+		{config.APIConfig{SigningKey: "H8vQ-2kLp_Zr7TnW4xYc9MbJ1sDfGe0aUo3iRq6VtNw"}, true},
+		{config.APIConfig{SigningKey: "H8vQ-2kLp_Zr7TnW4xYc9MbJ1sDfGe0aUo3iRq6VtNw", PreviousSigningKeys: []string{"test"}}, false},
+	}
+
+	for _, test := range tests {
+		err := CheckSigningKeys(test.conf)
+		if test.valid && err != nil {
+			t.Errorf("%v: unexpected error: %s", test.conf.SigningKey, err)
+		}
+		if !test.valid && !errors.Is(err, ErrWeakSigningKey) {
+			t.Errorf("%v: CheckSigningKeys() should have returned ErrWeakSigningKey: %v", test.conf.SigningKey, err)
+		}
+	}
+}
+
+func TestGenerateSigningKey(t *testing.T) {
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 32 random bytes, base64 encoded without padding
+	if len(key) != 43 {
+		t.Errorf("wrong key length: %d", len(key))
+	}
+	if err := checkSigningKey(key); err != nil {
+		t.Errorf("the generated key should be strong: %s", err)
+	}
+
+	other, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == other {
+		t.Error("the generated keys should be random")
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// selfSignedValidity is the validity of the generated self-signed certificates
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// generateSelfSignedCertificate generate an in-memory self-signed certificate
+// valid for localhost, the loopback addresses and given hostname if any
+func generateSelfSignedCertificate(hostname string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"OpenDyDNS self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	if hostname != "" {
+		if ip := net.ParseIP(hostname); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// prepareCertCacheDir create the certificate cache directory if needed and make sure
+// it's writable, so AutoTLS fails fast instead of after an ACME round trip
+func prepareCertCacheDir(dir string, logger *zerolog.Logger) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("unable to create certificate cache directory %s: %w", dir, err)
+	}
+
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("certificate cache %s is not a directory", dir)
+	}
+
+	f, err := ioutil.TempFile(dir, ".write-test")
+	if err != nil {
+		return fmt.Errorf("certificate cache directory %s is not writable: %w", dir, err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	// the private keys live there
+	if stat.Mode().Perm()&0007 != 0 {
+		logger.Warn().
+			Str("Path", dir).
+			Str("Mode", stat.Mode().Perm().String()).
+			Msg("certificate cache directory is accessible by anyone, consider restricting it to 0700.")
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrepareCertCacheDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	// the directory is created with restricted permissions
+	cacheDir := filepath.Join(dir, "certs")
+	if err := prepareCertCacheDir(cacheDir, &logger); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stat.IsDir() || stat.Mode().Perm() != 0700 {
+		t.Errorf("wrong cache directory created: %s", stat.Mode())
+	}
+	if logs.Len() != 0 {
+		t.Errorf("no warning should have been logged: %s", logs.String())
+	}
+
+	// insecure permissions
+	if err := os.Chmod(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareCertCacheDir(cacheDir, &logger); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "accessible by anyone") {
+		t.Errorf("missing insecure permissions warning: %s", logs.String())
+	}
+
+	// not a directory
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareCertCacheDir(file, &logger); err == nil {
+		t.Error("prepareCertCacheDir() should have failed")
+	}
+
+	// unwritable (permissions are not enforced for root)
+	if os.Geteuid() != 0 {
+		if err := os.Chmod(cacheDir, 0500); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(cacheDir, 0700)
+
+		if err := prepareCertCacheDir(cacheDir, &logger); err == nil {
+			t.Error("prepareCertCacheDir() should have failed")
+		}
+	}
+}
+
+func TestAPI_StartH2C(t *testing.T) {
+	// find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	_ = l.Close()
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.GET("/version", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Request().Proto)
+	})
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	a := API{
+		e:      e,
+		conf:   config.APIConfig{H2C: true},
+		logger: &logger,
+	}
+
+	go func() {
+		_ = a.Start(address)
+	}()
+	defer a.Shutdown(context.Background())
+
+	// HTTP/2 using prior knowledge, without TLS
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+
+	for client, proto := range map[*http.Client]string{h2cClient: "HTTP/2.0", http.DefaultClient: "HTTP/1.1"} {
+		var res *http.Response
+		for i := 0; i < 50; i++ {
+			res, err = client.Get("http://" + address + "/version")
+			if err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("request failed: %s", err)
+		}
+
+		body, err := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != proto {
+			t.Errorf("wrong protocol used: %s (expected %s)", body, proto)
+		}
+	}
+}
+
+func TestGenerateSelfSignedCertificate(t *testing.T) {
+	cert, err := generateSelfSignedCertificate("dydns.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "dydns.example.org"} {
+		if err := c.VerifyHostname(host); err != nil {
+			t.Errorf("certificate not valid for %s: %s", host, err)
+		}
+	}
+}
+
+func TestAPI_StartSelfSignedTLS(t *testing.T) {
+	// find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	_ = l.Close()
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	a := API{
+		e:      e,
+		conf:   config.APIConfig{SelfSignedTLS: true},
+		logger: &logger,
+	}
+
+	go func() {
+		_ = a.Start(address)
+	}()
+	defer a.Shutdown(context.Background())
+
+	// wait for the server to be up & make sure the TLS handshake succeed
+	var conn *tls.Conn
+	for i := 0; i < 50; i++ {
+		conn, err = tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %s", err)
+	}
+	defer conn.Close()
+
+	// the certificate presented must be valid for the loopback address
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		t.Fatal("no certificate presented")
+	}
+	if err := certs[0].VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+}
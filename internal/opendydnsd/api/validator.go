@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// structValidator is the echo.Validator implementation
+// using the `validate` struct tags of the DTOs
+type structValidator struct {
+	validate *validator.Validate
+}
+
+func newStructValidator() *structValidator {
+	v := validator.New()
+
+	// Report the JSON field names instead of the Go ones
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	return &structValidator{validate: v}
+}
+
+func (sv *structValidator) Validate(i interface{}) error {
+	return sv.validate.Struct(i)
+}
+
+// bindAndValidate bind the request payload into given value and validate it
+// this either return nil or an error ready to be returned by the handler
+func bindAndValidate(c echo.Context, value interface{}) error {
+	if err := c.Bind(value); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, proto.ErrorDto{Message: "malformed request payload"})
+	}
+
+	if err := c.Validate(value); err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			return err
+		}
+
+		var fields []string
+		details := map[string]string{}
+		for _, fieldErr := range validationErrors {
+			fields = append(fields, fmt.Sprintf("%s (%s)", fieldErr.Field(), fieldErr.Tag()))
+			details[fieldErr.Field()] = describeFieldError(fieldErr)
+		}
+
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, proto.ErrorDto{
+			Message: fmt.Sprintf("invalid field(s): %s", strings.Join(fields, ", ")),
+			Details: details,
+		})
+	}
+
+	return nil
+}
+
+// describeFieldError return an human readable explanation of given field error
+func describeFieldError(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "ip":
+		return "must be a valid IPv4 or IPv6 address"
+	case "fqdn":
+		return "must be a fully qualified domain name"
+	case "gte":
+		return fmt.Sprintf("must be greater than or equal to %s", fieldErr.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.Join(strings.Fields(fieldErr.Param()), ", "))
+	case "required_with":
+		return fmt.Sprintf("is required when %s is set", strings.ToLower(fieldErr.Param()))
+	default:
+		return fmt.Sprintf("failed on the %s validation", fieldErr.Tag())
+	}
+}
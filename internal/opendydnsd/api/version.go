@@ -0,0 +1,30 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"strconv"
+)
+
+// newVersionMiddleware return a middleware advertising the supported API versions
+// and rejecting the clients using an unsupported one
+// clients not sending their version (released before the negotiation) are accepted
+func newVersionMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set(proto.HeaderAPIVersions, proto.APIVersions())
+
+			header := c.Request().Header.Get(proto.HeaderAPIVersion)
+			if header == "" {
+				return next(c)
+			}
+
+			version, err := strconv.Atoi(header)
+			if err != nil || version < proto.MinAPIVersion || version > proto.APIVersion {
+				return proto.ErrUnsupportedAPIVersion
+			}
+
+			return next(c)
+		}
+	}
+}
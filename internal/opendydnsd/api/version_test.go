@@ -0,0 +1,42 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestVersionMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(newVersionMiddleware())
+	e.GET("/version", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	tests := map[string]int{
+		"":                                    http.StatusOK, // client released before the negotiation
+		strconv.Itoa(proto.APIVersion):        http.StatusOK,
+		strconv.Itoa(proto.MinAPIVersion - 1): http.StatusBadRequest,
+		strconv.Itoa(proto.APIVersion + 1):    http.StatusBadRequest,
+		"latest":                              http.StatusBadRequest,
+	}
+
+	for version, status := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/version", nil)
+		if version != "" {
+			req.Header.Set(proto.HeaderAPIVersion, version)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != status {
+			t.Errorf("wrong status code for version `%s`: %d", version, rec.Code)
+		}
+		if versions := rec.Header().Get(proto.HeaderAPIVersions); versions != proto.APIVersions() {
+			t.Errorf("wrong supported versions advertised: %s", versions)
+		}
+	}
+}
@@ -24,6 +24,43 @@ func TestConfig_Valid(t *testing.T) {
 	if !c.Valid() {
 		t.Error("validate() should have work")
 	}
+
+	c.DaemonConfig.ReservedNames = []string{"www", "mail["}
+	if c.Valid() {
+		t.Error("validate() should have failed")
+	}
+	c.DaemonConfig.ReservedNames = nil
+
+	c.DaemonConfig.UpdateSource = "partial"
+	if c.Valid() {
+		t.Error("validate() should have failed")
+	}
+	c.DaemonConfig.UpdateSource = UpdateSourceAnonymized
+
+	c.DaemonConfig.DNSProvisioners = []DNSProvisionerConfig{
+		{Name: "dummy", Domains: []DomainConfig{{Domain: "example.org", MinLabels: 2}}},
+	}
+	if c.Valid() {
+		t.Error("validate() should have failed")
+	}
+	c.DaemonConfig.DNSProvisioners[0].Domains[0].MaxLabels = 3
+	if !c.Valid() {
+		t.Error("validate() should have work")
+	}
+
+	c.DaemonConfig.DNSServer.ListenAddr = ":53"
+	if c.Valid() {
+		t.Error("validate() should have failed")
+	}
+	c.DaemonConfig.DNSServer.Nameservers = []string{"ns1.example.org"}
+	if !c.Valid() {
+		t.Error("validate() should have work")
+	}
+
+	c.DaemonConfig.PasswordHashing.Algorithm = "md5"
+	if c.Valid() {
+		t.Error("validate() should have failed")
+	}
 }
 
 func TestAPIConfig_SSLEnabled(t *testing.T) {
@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// generatedPasswordLength is the number of random bytes of the generated passwords
+const generatedPasswordLength = 18
+
+// bootstrapAdmin create the configured admin account if the database has no user at all
+// so that a fresh deployment can be administrated. Nothing is done once any user exists
+func (d *daemon) bootstrapAdmin() error {
+	conf := d.config.BootstrapAdmin
+	if conf.Email == "" {
+		return nil
+	}
+
+	count, err := d.conn.CountUsers()
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return err
+	}
+	if count > 0 {
+		d.logger.Debug().Msg("users already exist. not creating the bootstrap admin account.")
+		return nil
+	}
+
+	password := conf.Password
+	if password == "" {
+		if password, err = generatePassword(); err != nil {
+			d.logger.Err(err).Msg("error while generating password.")
+			return err
+		}
+	}
+
+	hash, err := d.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.conn.CreateUser(conf.Email, hash); err != nil {
+		d.logger.Err(err).Str("Email", conf.Email).Msg("unable to create the bootstrap admin account.")
+		return err
+	}
+	if err := d.conn.SetAdmin(conf.Email, true); err != nil {
+		d.logger.Err(err).Str("Email", conf.Email).Msg("unable to grant admin privileges.")
+		return err
+	}
+
+	event := d.logger.Warn().Str("Email", conf.Email)
+	if conf.Password == "" {
+		// the password is not stored in clear anywhere else
+		event = event.Str("Password", password)
+	}
+	event.Msg("bootstrap admin account created.")
+
+	return nil
+}
+
+// generatePassword return a strong random password
+func generatePassword() (string, error) {
+	b := make([]byte, generatedPasswordLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
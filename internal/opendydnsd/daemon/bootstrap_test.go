@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDaemon_BootstrapAdmin(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			BootstrapAdmin: config.BootstrapAdminConfig{Email: "admin@example.org", Password: "secret"},
+		},
+	}
+
+	dbMock.EXPECT().CountUsers().Return(int64(0), nil)
+	dbMock.EXPECT().CreateUser("admin@example.org", gomock.Any()).DoAndReturn(func(email, hash string) (database.User, error) {
+		if !d.validatePassword(hash, "secret") {
+			t.Error("the configured password should have been used")
+		}
+		return database.User{Email: email, Password: hash}, nil
+	})
+	dbMock.EXPECT().SetAdmin("admin@example.org", true).Return(nil)
+
+	if err := d.bootstrapAdmin(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDaemon_BootstrapAdmin_GeneratedPassword(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{BootstrapAdmin: config.BootstrapAdminConfig{Email: "admin@example.org"}},
+	}
+
+	var hash string
+	dbMock.EXPECT().CountUsers().Return(int64(0), nil)
+	dbMock.EXPECT().CreateUser("admin@example.org", gomock.Any()).DoAndReturn(func(email, h string) (database.User, error) {
+		hash = h
+		return database.User{Email: email, Password: h}, nil
+	})
+	dbMock.EXPECT().SetAdmin("admin@example.org", true).Return(nil)
+
+	if err := d.bootstrapAdmin(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the generated password is logged once
+	var entry struct {
+		Password string
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Password) < 24 || !d.validatePassword(hash, entry.Password) {
+		t.Errorf("wrong password logged: %s", entry.Password)
+	}
+}
+
+func TestDaemon_BootstrapAdmin_Noop(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	// disabled: the database is not even looked at
+	d := daemon{logger: &logger, conn: dbMock}
+	if err := d.bootstrapAdmin(); err != nil {
+		t.Fatal(err)
+	}
+
+	// users already exist
+	d.config.BootstrapAdmin = config.BootstrapAdminConfig{Email: "admin@example.org", Password: "secret"}
+	dbMock.EXPECT().CountUsers().Return(int64(1), nil)
+	if err := d.bootstrapAdmin(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"github.com/rs/zerolog"
+	"sync"
+	"time"
+)
+
+// subscriberQueueSize is the number of events a subscriber can lag behind
+// before the new events are dropped for it
+const subscriberQueueSize = 64
+
+// AliasChanged is the event published on each alias mutation
+type AliasChanged struct {
+	// Type is one of the proto.EventAlias* types
+	Type          string
+	Alias         string
+	Value         string
+	PreviousValue string
+	// PreviousAlias is the name of the alias before a rename
+	PreviousAlias string
+	TTL           int
+	UserID        uint
+	Time          time.Time
+}
+
+// eventBus dispatch the published events to its subscribers
+// each subscriber consume its own queue from a dedicated goroutine,
+// so a slow subscriber never blocks the publisher (nor the other subscribers)
+type eventBus struct {
+	logger      *zerolog.Logger
+	mutex       sync.RWMutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	name   string
+	events chan AliasChanged
+}
+
+func newEventBus(logger *zerolog.Logger) *eventBus {
+	return &eventBus{logger: logger}
+}
+
+// Subscribe call given handler with each published event, in order
+// the returned function stops the subscription
+func (b *eventBus) Subscribe(name string, handler func(event AliasChanged)) func() {
+	s := &subscriber{name: name, events: make(chan AliasChanged, subscriberQueueSize)}
+
+	b.mutex.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mutex.Unlock()
+
+	go func() {
+		for event := range s.events {
+			b.dispatch(s, handler, event)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+
+			for i, other := range b.subscribers {
+				if other == s {
+					b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+					break
+				}
+			}
+			close(s.events)
+		})
+	}
+}
+
+// Publish queue given event for each subscriber without waiting for them
+// the event is dropped for the subscribers whose queue is full
+func (b *eventBus) Publish(event AliasChanged) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, s := range b.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			b.logger.Warn().
+				Str("Subscriber", s.name).
+				Str("Type", event.Type).
+				Str("Alias", event.Alias).
+				Msg("subscriber is lagging behind, dropping event.")
+		}
+	}
+}
+
+// dispatch call the handler, making sure a faulty subscriber doesn't stop the others
+func (b *eventBus) dispatch(s *subscriber, handler func(event AliasChanged), event AliasChanged) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Error().
+				Str("Subscriber", s.name).
+				Interface("Panic", r).
+				Msg("subscriber panicked while handling event.")
+		}
+	}()
+
+	handler(event)
+}
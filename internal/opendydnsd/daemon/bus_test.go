@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDaemon_UpdateAlias_Subscribers(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		bus:    newEventBus(&logger),
+	}
+	provisionerMock := useProvisionerMock(&d, mockCtrl, "bar.baz")
+
+	var mutex sync.Mutex
+	received := map[string][]AliasChanged{}
+	for _, name := range []string{"webhooks", "audit", "metrics"} {
+		name := name
+		defer d.Subscribe(name, func(event AliasChanged) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			received[name] = append(received[name], event)
+		})()
+	}
+
+	dbMock.EXPECT().
+		FindAlias("foo", "bar.baz").
+		Return(database.Alias{Domain: "bar.baz", Host: "foo", Value: "127.0.0.1", UserID: 1, Enabled: true}, nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8", 0).Return(nil)
+	dbMock.EXPECT().UpdateAlias(gomock.Any()).DoAndReturn(func(alias database.Alias) (database.Alias, error) {
+		return alias, nil
+	})
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	if _, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the subscribers, then make sure nothing else comes
+	time.Sleep(100 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(received) != 3 {
+		t.Errorf("wrong number of notified subscribers: %d", len(received))
+	}
+	for name, events := range received {
+		if len(events) != 1 {
+			t.Errorf("subscriber %s notified %d times", name, len(events))
+			continue
+		}
+		event := events[0]
+		if event.Type != proto.EventAliasUpdated || event.Alias != "foo.bar.baz" || event.Value != "8.8.8.8" ||
+			event.PreviousValue != "127.0.0.1" || event.Time.IsZero() {
+			t.Errorf("wrong event received by %s: %v", name, event)
+		}
+	}
+}
+
+func TestEventBus_SlowSubscriber(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	bus := newEventBus(&logger)
+
+	block := make(chan struct{})
+	defer close(block)
+	defer bus.Subscribe("slow", func(event AliasChanged) { <-block })()
+
+	received := make(chan AliasChanged, 1)
+	defer bus.Subscribe("fast", func(event AliasChanged) { received <- event })()
+
+	// overflow the queue of the slow subscriber
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*subscriberQueueSize; i++ {
+			bus.Publish(AliasChanged{Type: proto.EventAliasUpdated})
+			if i == 0 {
+				<-received
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish() has been blocked by the slow subscriber")
+	}
+}
+
+func TestEventBus_Unsubscribe(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	bus := newEventBus(&logger)
+
+	received := make(chan AliasChanged, 10)
+	unsubscribe := bus.Subscribe("test", func(event AliasChanged) {
+		received <- event
+		panic("faulty subscriber")
+	})
+
+	bus.Publish(AliasChanged{Type: proto.EventAliasCreated})
+	bus.Publish(AliasChanged{Type: proto.EventAliasDeleted})
+
+	// a panicking subscriber keeps receiving the events
+	for _, eventType := range []string{proto.EventAliasCreated, proto.EventAliasDeleted} {
+		select {
+		case event := <-received:
+			if event.Type != eventType {
+				t.Errorf("wrong event received: %v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event not received")
+		}
+	}
+
+	unsubscribe()
+	unsubscribe() // no-op
+	bus.Publish(AliasChanged{Type: proto.EventAliasCreated})
+
+	select {
+	case event := <-received:
+		t.Errorf("unexpected event received: %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
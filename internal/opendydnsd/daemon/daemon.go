@@ -3,27 +3,92 @@ package daemon
 import (
 	"errors"
 	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/rs/zerolog"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"net"
+	"path"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 //go:generate mockgen -source daemon.go -destination=../daemon_mock/daemon_mock.go -package=daemon_mock
 
+// maxEventsLimit is the maximum number of events returned at once
+const (
+	maxEventsLimit  = 100
+	maxAliasesLimit = 100
+	// maxUserAgentLength is the maximum length of the stored user-agents
+	maxUserAgentLength = 255
+	// maxPatchAttempts is the number of times a patch is applied if the alias is changed meanwhile
+	maxPatchAttempts = 3
+	// defaultMaxValueLength is the maximum length of the alias values
+	// if the DNS provider does not define one (the length of a DNS character-string)
+	defaultMaxValueLength = 255
+)
+
+// valueRules are the checks applied to the alias values depending on their record type
+var valueRules = map[string]func(value string) bool{
+	"A": func(value string) bool {
+		ip := net.ParseIP(value)
+		return ip != nil && ip.To4() != nil
+	},
+	"AAAA": func(value string) bool {
+		ip := net.ParseIP(value)
+		return ip != nil && ip.To4() == nil
+	},
+}
+
 // Daemon represent OpenDyDNSD
 type Daemon interface {
 	CreateUser(cred proto.CredentialsDto) (proto.UserContext, error)
+	// RegisterUser create the account of a self registered user
+	// the returned boolean is true if the user must verify its email address before logging in
+	RegisterUser(registration proto.RegistrationDto) (proto.UserContext, bool, error)
+	VerifyEmail(token string) (proto.UserContext, error)
 	Authenticate(cred proto.CredentialsDto) (proto.UserContext, error)
 	GetAliases(userCtx proto.UserContext) ([]proto.AliasDto, error)
+	GetAliasesPage(userCtx proto.UserContext, cursor uint, limit int) ([]proto.AliasDto, uint, error)
+	GetAliasesByName(userCtx proto.UserContext, names []string) ([]proto.AliasDto, []string, error)
 	RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto) (proto.AliasDto, error)
 	UpdateAlias(userCtx proto.UserContext, alias proto.AliasDto) (proto.AliasDto, error)
+	PatchAlias(userCtx proto.UserContext, aliasName string, patch proto.AliasPatchDto) (proto.AliasDto, error)
 	DeleteAlias(userCtx proto.UserContext, aliasName string) error
+	SetAliasEnabled(userCtx proto.UserContext, aliasName string, enabled bool) (proto.AliasDto, error)
+	RenameAlias(userCtx proto.UserContext, aliasName, newName string) (proto.AliasDto, error)
 	GetDomains(userCtx proto.UserContext) ([]proto.DomainDto, error)
+	GetEvents(userCtx proto.UserContext, cursor uint, limit int) ([]proto.EventDto, error)
+	GetAllEvents(userCtx proto.UserContext, cursor uint, limit int) ([]proto.OwnedEventDto, error)
+	GetLimits(userCtx proto.UserContext) (proto.UserLimitsDto, error)
+	RevokeTokens(userCtx proto.UserContext) error
+	GetAllAliases(userCtx proto.UserContext, cursor uint, limit int, filter proto.AliasFilterDto) ([]proto.OwnedAliasDto, uint, error)
+	GetUserAliases(userCtx proto.UserContext, email string, cursor uint, limit int) ([]proto.AliasDto, uint, error)
+	GetDNSStatus(userCtx proto.UserContext) (proto.DNSStatusDto, error)
+	GetDelegations(userCtx proto.UserContext) ([]proto.DelegationDto, error)
+	ExportZone(userCtx proto.UserContext, domain string) (proto.ZoneDto, error)
+	GetWebhooks(userCtx proto.UserContext) ([]proto.WebhookDto, error)
+	CreateWebhook(userCtx proto.UserContext, webhook proto.WebhookDto) (proto.WebhookDto, error)
+	DeleteWebhook(userCtx proto.UserContext, id uint) error
+	CreateShare(userCtx proto.UserContext, aliasName string, expiresIn time.Duration) (proto.ShareDto, error)
+	GetShares(userCtx proto.UserContext) ([]proto.ShareDto, error)
+	DeleteShare(userCtx proto.UserContext, id uint) error
+	GetSharedAlias(token string) (proto.SharedAliasDto, error)
+	SetAdmin(email string, admin bool) error
+	SetUserDisabled(email string, disabled bool) error
+	ResetPassword(email, password string) error
+	ValidateUserContext(userCtx proto.UserContext) error
+	// Subscribe call given handler (in the background) with each alias change
+	// the returned function stops the subscription
+	Subscribe(name string, handler func(event AliasChanged)) func()
+	// StartDNSServer start the embedded DNS server, the returned function shuts it down
+	StartDNSServer() (func() error, error)
 	Logger() *zerolog.Logger
 }
 
@@ -32,22 +97,95 @@ type daemon struct {
 	logger      *zerolog.Logger
 	config      config.DaemonConfig
 	dnsProvider dns.Provider
+	// breaker is nil if the circuit breaker is disabled
+	breaker dns.BreakerProvider
+	// bus dispatch the alias changes to the interested parties
+	bus *eventBus
+	// delegation is nil if the delegation check is disabled
+	delegation *delegationChecker
+	// followResolver is nil if the aliases cannot follow another name
+	followResolver addrResolver
+	// webhooks is nil if the users cannot register webhooks
+	webhooks *webhookDispatcher
+	// registration configure the self registration of the users
+	registration config.RegistrationConfig
 }
 
 // NewDaemon return a new Daemon instance with given configuration
 func NewDaemon(c config.Config, logger *zerolog.Logger) (Daemon, error) {
+	d := &daemon{
+		logger:       logger,
+		config:       c.DaemonConfig,
+		dnsProvider:  dns.NewProvider(),
+		bus:          newEventBus(logger),
+		registration: c.APIConfig.Registration,
+	}
+
+	if c.DaemonConfig.Hook.Command != "" {
+		d.Subscribe("hook", d.runHook)
+	}
+	if wc := c.DaemonConfig.Webhooks; wc.Enabled {
+		d.webhooks = newWebhookDispatcher(logger, wc.GetTimeout(), wc.GetRateLimit(), wc.AllowPrivateNetworks)
+		d.Subscribe("webhooks", d.notifyWebhooks)
+	}
+
+	if limit := c.DaemonConfig.MaxConcurrentDNSCalls; limit > 0 {
+		d.dnsProvider = dns.NewLimitedProvider(d.dnsProvider, limit)
+	}
+	if cb := c.DaemonConfig.CircuitBreaker; cb.Threshold > 0 {
+		d.breaker = dns.NewBreakerProvider(d.dnsProvider, cb.Threshold, cb.GetCooldown())
+		d.dnsProvider = d.breaker
+	}
+
+	// fail now rather than on each alias change
+	if err := d.checkDNSProvisioners(); err != nil {
+		logger.Err(err).Msg("invalid DNS provisioner configuration.")
+		return nil, err
+	}
+	if err := d.checkDefaultTTLs(); err != nil {
+		logger.Err(err).Msg("invalid TTL configuration.")
+		return nil, err
+	}
+	d.checkDNSSEC()
+
+	if dc := c.DaemonConfig.DelegationCheck; dc.Interval > 0 {
+		d.delegation = &delegationChecker{resolver: common.NewResolver(dc.GetResolver(), 5*time.Second)}
+		go d.checkDelegationsPeriodically(dc.Interval)
+	}
+
+	if fc := c.DaemonConfig.Follow; fc.Interval > 0 {
+		d.followResolver = common.NewResolver(fc.GetResolver(), 5*time.Second)
+	}
+
 	logger.Debug().Msg("connecting to the database.")
 	conn, err := database.OpenConnection(c.DatabaseConfig, logger)
 	if err != nil {
 		return nil, err
 	}
 	logger.Info().Str("Driver", c.DatabaseConfig.Driver).Msg("database connection established!")
+	d.conn = conn
 
-	d := &daemon{
-		conn:        conn,
-		logger:      logger,
-		config:      c.DaemonConfig,
-		dnsProvider: dns.NewProvider(),
+	if err := d.bootstrapAdmin(); err != nil {
+		return nil, err
+	}
+
+	if d.breaker != nil {
+		go d.replayPendingRecordsPeriodically(c.DaemonConfig.CircuitBreaker.GetCooldown())
+	}
+
+	if d.followResolver != nil {
+		go d.refreshFollowingAliasesPeriodically(c.DaemonConfig.Follow.Interval)
+	}
+
+	if c.DatabaseConfig.VacuumInterval != 0 {
+		go d.vacuumPeriodically(c.DatabaseConfig.VacuumInterval)
+	}
+
+	if c.DaemonConfig.Telemetry.Enabled {
+		logger.Info().
+			Str("Endpoint", c.DaemonConfig.Telemetry.Endpoint).
+			Msg("anonymous usage telemetry is enabled.")
+		go d.reportTelemetryPeriodically()
 	}
 
 	return d, nil
@@ -61,7 +199,7 @@ func (d *daemon) CreateUser(cred proto.CredentialsDto) (proto.UserContext, error
 
 	// Make sure user doesn't already exist
 	_, err := d.conn.FindUser(cred.Email)
-	if err != nil && !errors.As(err, &gorm.ErrRecordNotFound) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		d.logger.Err(err).Msg("error while fetching database.")
 		return proto.UserContext{}, err
 	} else if err == nil {
@@ -89,7 +227,7 @@ func (d *daemon) Authenticate(cred proto.CredentialsDto) (proto.UserContext, err
 	}
 
 	user, err := d.conn.FindUser(cred.Email)
-	if errors.As(err, &gorm.ErrRecordNotFound) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return proto.UserContext{}, proto.ErrInvalidParameters // not 404 to prevent email discovery
 	}
 	if err != nil {
@@ -102,35 +240,131 @@ func (d *daemon) Authenticate(cred proto.CredentialsDto) (proto.UserContext, err
 		return proto.UserContext{}, proto.ErrInvalidParameters // not 404 to prevent email discovery
 	}
 
+	// only told once the password is known, to prevent email discovery
+	if !user.Verified() {
+		return proto.UserContext{}, proto.ErrEmailNotVerified
+	}
+	if user.Disabled {
+		d.logger.Warn().Str("Email", user.Email).Msg("invalid authentication request: account disabled.")
+		return proto.UserContext{}, proto.ErrAccountDisabled
+	}
+
+	// Transparently upgrade the hash to the configured algorithm
+	if d.needsRehash(user.Password) {
+		d.upgradePassword(user, cred.Password)
+	}
+
 	d.logger.Debug().Str("Email", user.Email).Msg("successfully authenticated.")
 
 	return proto.UserContext{
-		UserID: user.ID,
+		UserID:       user.ID,
+		TokenVersion: user.TokenVersion,
 	}, nil
 }
 
+// upgradePassword re-hash the user password using the configured algorithm
+// a failure is not fatal since the previous hash is still valid
+func (d *daemon) upgradePassword(user database.User, plainPassword string) {
+	hash, err := d.hashPassword(plainPassword)
+	if err != nil {
+		return
+	}
+
+	if err := d.conn.UpdatePassword(user.ID, hash); err != nil {
+		d.logger.Err(err).Str("Email", user.Email).Msg("error while upgrading password hash.")
+		return
+	}
+
+	d.logger.Debug().Str("Email", user.Email).Msg("password hash upgraded.")
+}
+
 func (d *daemon) GetAliases(userCtx proto.UserContext) ([]proto.AliasDto, error) {
 	aliases, err := d.conn.FindUserAliases(userCtx.UserID)
 
-	if err != nil && !errors.As(err, &gorm.ErrRecordNotFound) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		d.logger.Err(err).Msg("error while fetching database.")
 		return nil, err
 	}
 
 	var aliasesDto []proto.AliasDto
 	for _, alias := range aliases {
-		aliasesDto = append(aliasesDto, newAliasDto(alias))
+		aliasesDto = append(aliasesDto, d.toAliasDto(alias))
 	}
 
 	return aliasesDto, nil
 }
 
+// GetAliasesPage return a page of the user aliases along with the cursor
+// of the next page, which is zero when there are no more aliases
+func (d *daemon) GetAliasesPage(userCtx proto.UserContext, cursor uint, limit int) ([]proto.AliasDto, uint, error) {
+	if limit <= 0 || limit > maxAliasesLimit {
+		limit = maxAliasesLimit
+	}
+
+	aliases, err := d.conn.FindUserAliasesAfter(userCtx.UserID, cursor, limit)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, 0, err
+	}
+
+	var aliasesDto []proto.AliasDto
+	for _, alias := range aliases {
+		aliasesDto = append(aliasesDto, d.toAliasDto(alias))
+	}
+
+	var next uint
+	if len(aliases) == limit {
+		next = aliases[len(aliases)-1].ID
+	}
+
+	return aliasesDto, next, nil
+}
+
+// GetAliasesByName return the user aliases matching given names, along with the
+// names which cannot be found. The aliases owned by someone else are reported
+// as missing to not disclose their existence.
+func (d *daemon) GetAliasesByName(userCtx proto.UserContext, names []string) ([]proto.AliasDto, []string, error) {
+	aliases, err := d.conn.FindUserAliases(userCtx.UserID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, nil, err
+	}
+
+	byName := map[string]database.Alias{}
+	for _, alias := range aliases {
+		byName[fmt.Sprintf("%s.%s", alias.Host, alias.Domain)] = alias
+	}
+
+	var aliasesDto []proto.AliasDto
+	var missing []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if alias, exist := byName[key]; exist {
+			aliasesDto = append(aliasesDto, d.toAliasDto(alias))
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	return aliasesDto, missing, nil
+}
+
 func (d *daemon) RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto) (proto.AliasDto, error) {
 	if !isAliasValid(alias) {
 		d.logger.Warn().Msg("invalid register alias request: bad request.")
 		return proto.AliasDto{}, proto.ErrInvalidParameters
 	}
 
+	if err := d.prepareFollow(&alias); err != nil {
+		return proto.AliasDto{}, err
+	}
+
 	a := newAlias(alias)
 
 	provisioner, domainConf, err := d.findDNSProvisioner(a.Domain)
@@ -139,10 +373,33 @@ func (d *daemon) RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto)
 		return proto.AliasDto{}, proto.ErrDomainNotFound
 	}
 
+	if err := d.checkLabels(a, domainConf); err != nil {
+		return proto.AliasDto{}, err
+	}
+
+	if err := d.checkTTL(&a); err != nil {
+		return proto.AliasDto{}, err
+	}
+
+	if err := d.checkValue(a); err != nil {
+		return proto.AliasDto{}, err
+	}
+
+	// reserved names can only be registered by admins
+	if d.isReservedName(a) {
+		if err := d.checkAdmin(userCtx); err != nil {
+			if err == proto.ErrForbidden {
+				d.logger.Warn().Str("Domain", a.Domain).Str("Host", a.Host).Msg("alias name is reserved.")
+				return proto.AliasDto{}, proto.ErrAliasReserved
+			}
+			return proto.AliasDto{}, err
+		}
+	}
+
 	res, err := d.conn.FindAlias(a.Host, a.Domain)
 
 	// technical error
-	if err != nil && !errors.As(err, &gorm.ErrRecordNotFound) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		d.logger.Err(err).Msg("error while fetching database.")
 		return proto.AliasDto{}, err
 	}
@@ -160,16 +417,18 @@ func (d *daemon) RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto)
 
 	// alias available: perform registration
 	host, domain := getRealHostAndDomain(alias, domainConf)
-	if err := provisioner.AddRecord(host, domain, a.Value); err != nil {
+	if err := d.addRecords(provisioner, host, domain, a); err != nil {
 		d.logger.Err(err).
 			Str("Domain", domain).
 			Str("Host", host).
 			Str("Value", a.Value).
+			Str("Value6", a.Value6).
 			Msg("error while adding DNS record.")
 		return proto.AliasDto{}, err
 	}
 
-	a, err = d.conn.CreateAlias(newAlias(alias), userCtx.UserID)
+	d.setUpdateSource(&a, userCtx)
+	a, err = d.conn.CreateAlias(a, userCtx.UserID)
 	if err != nil {
 		return proto.AliasDto{}, err
 	}
@@ -178,192 +437,1308 @@ func (d *daemon) RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto)
 		Str("Domain", a.Domain).
 		Str("Host", a.Host).
 		Str("Value", a.Value).
+		Str("Value6", a.Value6).
 		Msg("new alias created.")
 
-	return newAliasDto(a), nil
+	d.recordEvent(userCtx, proto.EventAliasCreated, a, "")
+
+	return d.toAliasDto(a), nil
 }
 
+// UpdateAlias update the value of an alias
+// the address of the other family is kept if only an IPv4 (or IPv6) one is given
+// an alias following another name is turned back into a static one if no name is given
 func (d *daemon) UpdateAlias(userCtx proto.UserContext, alias proto.AliasDto) (proto.AliasDto, error) {
+	return d.updateAlias(userCtx, alias, true)
+}
+
+// updateAlias update the alias with given DTO
+// the empty addresses are either kept or withdrawn from the DNS
+func (d *daemon) updateAlias(userCtx proto.UserContext, alias proto.AliasDto, keepAddresses bool) (proto.AliasDto, error) {
 	if !isAliasValid(alias) {
 		d.logger.Warn().Msg("invalid update alias request: bad request.")
 		return proto.AliasDto{}, proto.ErrInvalidParameters
 	}
 
+	if err := d.prepareFollow(&alias); err != nil {
+		return proto.AliasDto{}, err
+	}
+
 	al, err := d.findUserAlias(alias, userCtx.UserID)
 	if err != nil {
 		return proto.AliasDto{}, err
 	}
 
+	// the client has not seen the latest changes
+	if alias.Version != 0 && alias.Version != al.Version {
+		d.logger.Debug().
+			Str("Domain", al.Domain).
+			Str("Host", al.Host).
+			Uint("Version", alias.Version).
+			Uint("CurrentVersion", al.Version).
+			Msg("stale alias version.")
+		return proto.AliasDto{}, proto.ErrAliasConflict
+	}
+
 	// Update the alias
-	updateAlias(&al, alias)
+	previous := al
+	updateAlias(&al, alias, keepAddresses)
 
-	provisioner, domainConf, err := d.findDNSProvisioner(al.Domain)
-	if err != nil {
-		d.logger.Err(err).Msg("error while finding DNS provisioner.")
+	if err := d.checkTTL(&al); err != nil {
 		return proto.AliasDto{}, err
 	}
 
-	host, domain := getRealHostAndDomain(alias, domainConf)
-	if err := provisioner.UpdateRecord(host, domain, al.Value); err != nil {
-		d.logger.Err(err).
-			Str("Domain", domain).
-			Str("Host", host).
-			Str("Value", al.Value).
-			Msg("error while updating DNS record.")
+	if err := d.checkValue(al); err != nil {
 		return proto.AliasDto{}, err
 	}
 
-	al, err = d.conn.UpdateAlias(al)
+	// nothing has changed: no need to bother the DNS provider
+	// a TTL only change must still go through
+	if al.Value == previous.Value && al.Value6 == previous.Value6 && al.TTL == previous.TTL && al.Follow == previous.Follow {
+		d.logger.Debug().
+			Str("Domain", al.Domain).
+			Str("Host", al.Host).
+			Msg("alias is already up-to-date.")
+		return d.toAliasDto(al), nil
+	}
+
+	// a disabled alias is not published: the new value will be when re-enabled
+	var provisioner dns.Provisioner
+	var host, domain string
+	if al.Enabled {
+		var domainConf config.DomainConfig
+		provisioner, domainConf, err = d.findDNSProvisioner(al.Domain)
+		if err != nil {
+			d.logger.Err(err).Msg("error while finding DNS provisioner.")
+			return proto.AliasDto{}, err
+		}
+
+		host, domain = getRealHostAndDomain(alias, domainConf)
+		if err := d.updateRecords(provisioner, host, domain, previous, al); err != nil {
+			d.logger.Err(err).
+				Str("Domain", domain).
+				Str("Host", host).
+				Str("Value", al.Value).
+				Str("Value6", al.Value6).
+				Msg("error while updating DNS record.")
+			return proto.AliasDto{}, err
+		}
+	}
+
+	d.setUpdateSource(&al, userCtx)
+	updated, err := d.saveAlias(al)
 	if err != nil {
 		d.logger.Err(err).Msg("error while updating alias.")
+
+		if al.Enabled {
+			d.rollbackRecords(provisioner, host, domain, al, previous, err)
+		}
 		return proto.AliasDto{}, err
 	}
+	al = updated
 
 	d.logger.Info().
 		Uint("UserID", userCtx.UserID).
 		Str("Domain", al.Domain).
 		Str("Host", al.Host).
-		Str("Value", alias.Value).
+		Str("Value", al.Value).
+		Str("Value6", al.Value6).
+		Int("TTL", al.TTL).
 		Msg("successfully updated alias.")
 
-	return newAliasDto(al), err
+	// a change of the IPv6 address only is reported as such
+	value, previousValue := al.Value, previous.Value
+	if value == previousValue && al.Value6 != previous.Value6 {
+		value, previousValue = al.Value6, previous.Value6
+	}
+	d.publishChange(AliasChanged{
+		Type:          proto.EventAliasUpdated,
+		Alias:         newAliasDto(al).Domain,
+		Value:         value,
+		PreviousValue: previousValue,
+		TTL:           d.effectiveTTL(al),
+		UserID:        userCtx.UserID,
+	})
+
+	return d.toAliasDto(al), err
 }
 
-func (d *daemon) DeleteAlias(userCtx proto.UserContext, aliasName string) error {
-	a := newAlias(proto.AliasDto{Domain: aliasName})
+// PatchAlias update the given fields of an alias, the others are left unchanged
+// the patch is applied again if the alias has been changed meanwhile, unless a version is given
+func (d *daemon) PatchAlias(userCtx proto.UserContext, aliasName string, patch proto.AliasPatchDto) (proto.AliasDto, error) {
+	for attempt := 1; ; attempt++ {
+		al, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
+		if err != nil {
+			return proto.AliasDto{}, err
+		}
 
-	provisioner, domainConf, err := d.findDNSProvisioner(a.Domain)
+		// the version makes sure the fields left unchanged are the current ones
+		alias := newAliasDto(al)
+		if patch.Version != 0 {
+			alias.Version = patch.Version
+		}
+
+		if patch.Follow != nil {
+			alias.Follow = *patch.Follow
+			if alias.Follow != "" {
+				alias.Value, alias.Value6 = "", ""
+			}
+		}
+		if patch.Value != nil {
+			// an IPv6 address given as the value updates the AAAA record
+			if patch.Value6 == nil && dns.RecordType(*patch.Value) == "AAAA" {
+				alias.Value6 = *patch.Value
+			} else {
+				alias.Value = *patch.Value
+			}
+		}
+		if patch.Value6 != nil {
+			alias.Value6 = *patch.Value6
+		}
+		if patch.TTL != nil {
+			alias.TTL = *patch.TTL
+		}
+
+		updated, err := d.updateAlias(userCtx, alias, false)
+		if err == proto.ErrAliasConflict && patch.Version == 0 && attempt < maxPatchAttempts {
+			d.logger.Debug().Str("Domain", aliasName).Msg("alias has been changed meanwhile, patching again.")
+			continue
+		}
+
+		return updated, err
+	}
+}
+
+func (d *daemon) SetAliasEnabled(userCtx proto.UserContext, aliasName string, enabled bool) (proto.AliasDto, error) {
+	al, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
+	if err != nil {
+		return proto.AliasDto{}, err
+	}
+
+	if al.Enabled == enabled {
+		d.logger.Debug().
+			Str("Domain", al.Domain).
+			Str("Host", al.Host).
+			Bool("Enabled", enabled).
+			Msg("alias is already up-to-date.")
+		return d.toAliasDto(al), nil
+	}
+
+	provisioner, domainConf, err := d.findDNSProvisioner(al.Domain)
 	if err != nil {
 		d.logger.Err(err).Msg("error while finding DNS provisioner.")
-		return err
+		return proto.AliasDto{}, err
 	}
 
+	// re-publish the stored addresses, or withdraw the records
 	host, domain := getRealHostAndDomain(proto.AliasDto{Domain: aliasName}, domainConf)
-	if err := provisioner.DeleteRecord(host, domain); err != nil {
+	if enabled {
+		err = d.addRecords(provisioner, host, domain, al)
+	} else {
+		err = provisioner.DeleteRecord(host, domain)
+	}
+	if err != nil {
 		d.logger.Err(err).
 			Str("Domain", domain).
 			Str("Host", host).
-			Msg("error while deleting DNS record.")
-		return err
+			Bool("Enabled", enabled).
+			Msg("error while toggling DNS record.")
+		return proto.AliasDto{}, err
 	}
 
-	if err := d.conn.DeleteAlias(a.Host, a.Domain, userCtx.UserID); err != nil {
-		d.logger.Warn().
-			Str("Domain", a.Domain).
-			Str("Host", a.Host).
-			Msg("unable to delete alias.")
-		return err
+	al.Enabled = enabled
+	updated, err := d.saveAlias(al)
+	if err != nil {
+		d.logger.Err(err).Msg("error while updating alias.")
+
+		previous := al
+		previous.Enabled = !enabled
+		d.rollbackRecords(provisioner, host, domain, al, previous, err)
+		return proto.AliasDto{}, err
 	}
+	al = updated
 
 	d.logger.Info().
 		Uint("UserID", userCtx.UserID).
-		Str("Domain", a.Domain).
-		Str("Host", a.Host).
-		Msg("successfully deleted alias.")
-
-	return nil
-}
-
-func (d *daemon) GetDomains(_ proto.UserContext) ([]proto.DomainDto, error) {
-	var domains []proto.DomainDto
+		Str("Domain", al.Domain).
+		Str("Host", al.Host).
+		Bool("Enabled", enabled).
+		Msg("successfully toggled alias.")
 
-	for _, dnsProvisioner := range d.config.DNSProvisioners {
-		for _, domain := range dnsProvisioner.Domains {
-			domains = append(domains, proto.DomainDto{
-				Domain: domain.String(),
-			})
-		}
+	eventType := proto.EventAliasDisabled
+	if enabled {
+		eventType = proto.EventAliasEnabled
 	}
+	d.recordEvent(userCtx, eventType, al, "")
 
-	return domains, nil
-}
-
-func (d *daemon) Logger() *zerolog.Logger {
-	return d.logger
+	return d.toAliasDto(al), nil
 }
 
-func (d *daemon) hashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+func (d *daemon) RenameAlias(userCtx proto.UserContext, aliasName, newName string) (proto.AliasDto, error) {
+	al, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
 	if err != nil {
-		d.logger.Err(err).Msg("error while hashing password.")
-		return "", err
+		return proto.AliasDto{}, err
 	}
 
-	return string(hash), nil
-}
+	// only the host can be changed: the DNS provisioner stay the same
+	target := newAlias(proto.AliasDto{Domain: newName})
+	if strings.Count(newName, ".") < 2 || target.Domain != al.Domain {
+		d.logger.Warn().Str("Domain", al.Domain).Str("NewName", newName).Msg("invalid rename alias request: bad request.")
+		return proto.AliasDto{}, proto.ErrInvalidParameters
+	}
 
-func (d *daemon) validatePassword(hashedPassword, plainPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
-	if err != nil {
-		return false
+	if target.Host == al.Host {
+		return d.toAliasDto(al), nil
 	}
 
-	return true
-}
+	if d.isReservedName(target) {
+		if err := d.checkAdmin(userCtx); err != nil {
+			if err == proto.ErrForbidden {
+				d.logger.Warn().Str("Domain", target.Domain).Str("Host", target.Host).Msg("alias name is reserved.")
+				return proto.AliasDto{}, proto.ErrAliasReserved
+			}
+			return proto.AliasDto{}, err
+		}
+	}
 
-func (d *daemon) findUserAlias(alias proto.AliasDto, userID uint) (database.Alias, error) {
-	a := newAlias(alias)
-	al, err := d.conn.FindAlias(a.Host, a.Domain)
-	if err != nil {
-		if errors.As(err, &gorm.ErrRecordNotFound) {
-			return database.Alias{}, proto.ErrAliasNotFound
+	res, err := d.conn.FindAlias(target.Host, target.Domain)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.AliasDto{}, err
+	}
+	if err == nil {
+		if res.UserID != userCtx.UserID {
+			d.logger.Debug().Msg("alias taken.")
+			return proto.AliasDto{}, proto.ErrAliasTaken
 		}
 
-		return database.Alias{}, err
+		d.logger.Debug().Msg("alias already exist.")
+		return proto.AliasDto{}, proto.ErrAliasAlreadyExist
 	}
 
-	if al.UserID != userID {
-		return database.Alias{}, proto.ErrAliasNotFound
+	// a disabled alias is not published: only the name has to be changed
+	if al.Enabled {
+		if err := d.moveRecord(al, aliasName, newName); err != nil {
+			return proto.AliasDto{}, err
+		}
 	}
 
-	return al, nil
-}
+	previousName, previousHost := newAliasDto(al).Domain, al.Host
+	al.Host = target.Host
+	updated, err := d.saveAlias(al)
+	if err != nil {
+		d.logger.Err(err).Msg("error while updating alias.")
 
-func (d *daemon) findDNSProvisioner(domain string) (dns.Provisioner, config.DomainConfig, error) {
-	for _, dnsProvisioner := range d.config.DNSProvisioners {
-		for _, domainConf := range dnsProvisioner.Domains {
-			if domainConf.String() == domain {
-				p, err := d.dnsProvider.GetProvisioner(dnsProvisioner.Name, dnsProvisioner.Config)
-				return p, domainConf, err
-			}
+		// restore the previous DNS record to keep it in sync with the database
+		if al.Enabled {
+			_ = d.moveRecord(al, newName, aliasName)
 		}
+		return proto.AliasDto{}, err
 	}
+	al = updated
 
-	return nil, config.DomainConfig{}, fmt.Errorf("no DNS provisioner found for domain %s", domain)
+	d.logger.Info().
+		Uint("UserID", userCtx.UserID).
+		Str("Domain", al.Domain).
+		Str("PreviousHost", previousHost).
+		Str("Host", al.Host).
+		Msg("successfully renamed alias.")
+
+	d.publishChange(AliasChanged{
+		Type:          proto.EventAliasRenamed,
+		Alias:         newAliasDto(al).Domain,
+		Value:         aliasAddress(al),
+		PreviousAlias: previousName,
+		TTL:           d.effectiveTTL(al),
+		UserID:        userCtx.UserID,
+	})
+
+	return d.toAliasDto(al), nil
 }
 
-// Alias -> AliasDto
-func newAliasDto(alias database.Alias) proto.AliasDto {
-	return proto.AliasDto{
-		Domain: fmt.Sprintf("%s.%s", alias.Host, alias.Domain),
-		Value:  alias.Value,
+// saveAlias persist given alias, reporting a concurrent change of the alias as a conflict
+func (d *daemon) saveAlias(alias database.Alias) (database.Alias, error) {
+	updated, err := d.conn.UpdateAlias(alias)
+	if errors.Is(err, database.ErrAliasConflict) {
+		d.logger.Warn().
+			Str("Domain", alias.Domain).
+			Str("Host", alias.Host).
+			Msg("alias has been changed meanwhile.")
+		return database.Alias{}, proto.ErrAliasConflict
 	}
+
+	return updated, err
 }
 
-// AliasDto -> Alias
-func newAlias(alias proto.AliasDto) database.Alias {
-	parts := strings.Split(alias.Domain, ".")
-	return database.Alias{
-		Host:   parts[0],
-		Domain: strings.Replace(alias.Domain, parts[0]+".", "", 1),
-		Value:  alias.Value,
+// rollbackRecords publish the records of the alias as stored, once the published one could not be saved
+// on a conflict the alias saved by the concurrent writer is published again (this update may have overwritten it),
+// the previous records are restored otherwise
+func (d *daemon) rollbackRecords(provisioner dns.Provisioner, host, domain string, published, previous database.Alias, saveErr error) {
+	stored := previous
+	if saveErr == proto.ErrAliasConflict {
+		current, err := d.conn.FindAlias(previous.Host, previous.Domain)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			d.logger.Err(err).Msg("error while fetching database.")
+			return
+		}
+		// deleted (or renamed) meanwhile: nothing is published under this name anymore
+		stored = current
+	}
+
+	var err error
+	switch {
+	case published.Enabled && stored.Enabled:
+		err = d.updateRecords(provisioner, host, domain, published, stored)
+	case published.Enabled:
+		err = provisioner.DeleteRecord(host, domain)
+	case stored.Enabled:
+		err = d.addRecords(provisioner, host, domain, stored)
+	}
+	if err != nil {
+		d.logger.Err(err).
+			Str("Domain", domain).
+			Str("Host", host).
+			Msg("error while rolling back DNS record.")
 	}
 }
 
-// Update an existing alias using given DTO
-func updateAlias(alias *database.Alias, dto proto.AliasDto) {
-	a := newAlias(dto)
+// moveRecord create the DNS record of the new alias name then delete the previous one
+// the new record is removed if the previous one cannot be, so the DNS is left unchanged
+func (d *daemon) moveRecord(al database.Alias, aliasName, newName string) error {
+	provisioner, domainConf, err := d.findDNSProvisioner(al.Domain)
+	if err != nil {
+		d.logger.Err(err).Msg("error while finding DNS provisioner.")
+		return err
+	}
 
-	alias.Host = a.Host
-	alias.Value = a.Value
-}
+	host, domain := getRealHostAndDomain(proto.AliasDto{Domain: aliasName}, domainConf)
+	newHost, _ := getRealHostAndDomain(proto.AliasDto{Domain: newName}, domainConf)
 
-func isAliasValid(alias proto.AliasDto) bool {
-	// TODO make sure value is valid IPv4 / IpV6
-	return alias.Domain != "" && strings.Count(alias.Domain, ".") >= 2 && alias.Value != ""
+	if err := d.addRecords(provisioner, newHost, domain, al); err != nil {
+		d.logger.Err(err).
+			Str("Domain", domain).
+			Str("Host", newHost).
+			Str("Value", al.Value).
+			Str("Value6", al.Value6).
+			Msg("error while adding DNS record.")
+		return err
+	}
+
+	if err := provisioner.DeleteRecord(host, domain); err != nil {
+		d.logger.Err(err).
+			Str("Domain", domain).
+			Str("Host", host).
+			Msg("error while deleting DNS record.")
+
+		if err := provisioner.DeleteRecord(newHost, domain); err != nil {
+			d.logger.Err(err).
+				Str("Domain", domain).
+				Str("Host", newHost).
+				Msg("error while rolling back DNS record.")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// addRecords publish the addresses of given alias
+// the records already added are removed if one cannot be, so the DNS is left unchanged
+func (d *daemon) addRecords(provisioner dns.Provisioner, host, domain string, alias database.Alias) error {
+	for i, value := range aliasAddresses(alias) {
+		if err := provisioner.AddRecord(host, domain, value, d.effectiveTTL(alias)); err != nil {
+			if i > 0 {
+				if err := provisioner.DeleteRecord(host, domain); err != nil {
+					d.logger.Err(err).
+						Str("Domain", domain).
+						Str("Host", host).
+						Msg("error while rolling back DNS record.")
+				}
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateRecords publish the changed addresses of given alias
+// withdrawing an address removes the records of the alias then publishes the remaining one
+func (d *daemon) updateRecords(provisioner dns.Provisioner, host, domain string, previous, alias database.Alias) error {
+	if (previous.Value != "" && alias.Value == "") || (previous.Value6 != "" && alias.Value6 == "") {
+		if err := provisioner.DeleteRecord(host, domain); err != nil {
+			return err
+		}
+		return d.addRecords(provisioner, host, domain, alias)
+	}
+
+	ttl := d.effectiveTTL(alias)
+	for _, values := range [][2]string{{previous.Value, alias.Value}, {previous.Value6, alias.Value6}} {
+		previousValue, value := values[0], values[1]
+
+		var err error
+		switch {
+		case value == "":
+			continue
+		case previousValue == "":
+			err = provisioner.AddRecord(host, domain, value, ttl)
+		case previousValue != value || ttl != d.effectiveTTL(previous):
+			err = provisioner.UpdateRecord(host, domain, value, ttl)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *daemon) DeleteAlias(userCtx proto.UserContext, aliasName string) error {
+	a := newAlias(proto.AliasDto{Domain: aliasName})
+
+	al, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
+	if err != nil {
+		// the alias is already gone: nothing to do unless told otherwise
+		if err == proto.ErrAliasNotFound && !d.config.StrictDelete {
+			d.logger.Debug().Str("Domain", a.Domain).Str("Host", a.Host).Msg("alias already deleted.")
+			return nil
+		}
+		return err
+	}
+
+	// a disabled alias has already been withdrawn from the DNS
+	if al.Enabled {
+		provisioner, domainConf, err := d.findDNSProvisioner(a.Domain)
+		if err != nil {
+			d.logger.Err(err).Msg("error while finding DNS provisioner.")
+			return err
+		}
+
+		host, domain := getRealHostAndDomain(proto.AliasDto{Domain: aliasName}, domainConf)
+		if err := provisioner.DeleteRecord(host, domain); err != nil {
+			d.logger.Err(err).
+				Str("Domain", domain).
+				Str("Host", host).
+				Msg("error while deleting DNS record.")
+			return err
+		}
+	}
+
+	if err := d.conn.DeleteAlias(a.Host, a.Domain, userCtx.UserID); err != nil {
+		d.logger.Warn().
+			Str("Domain", a.Domain).
+			Str("Host", a.Host).
+			Msg("unable to delete alias.")
+		return err
+	}
+
+	d.logger.Info().
+		Uint("UserID", userCtx.UserID).
+		Str("Domain", a.Domain).
+		Str("Host", a.Host).
+		Msg("successfully deleted alias.")
+
+	d.recordEvent(userCtx, proto.EventAliasDeleted, database.Alias{Host: a.Host, Domain: a.Domain}, aliasAddress(al))
+
+	return nil
+}
+
+func (d *daemon) GetDomains(_ proto.UserContext) ([]proto.DomainDto, error) {
+	var domains []proto.DomainDto
+
+	for _, dnsProvisioner := range d.config.DNSProvisioners {
+		for _, domain := range dnsProvisioner.Domains {
+			domains = append(domains, proto.DomainDto{
+				Domain: domain.String(),
+			})
+		}
+	}
+
+	return domains, nil
+}
+
+// GetLimits return the usage of the user account and the restrictions applying to it
+func (d *daemon) GetLimits(userCtx proto.UserContext) (proto.UserLimitsDto, error) {
+	user, err := d.conn.FindUserByID(userCtx.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return proto.UserLimitsDto{}, proto.ErrUserNotFound
+		}
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.UserLimitsDto{}, err
+	}
+
+	count, err := d.conn.CountUserAliases(userCtx.UserID)
+	if err != nil {
+		d.logger.Err(err).Msg("error while counting user aliases.")
+		return proto.UserLimitsDto{}, err
+	}
+
+	limits := proto.UserLimitsDto{Aliases: count, Domains: []string{}, Admin: user.Admin}
+	for _, dnsProvisioner := range d.config.DNSProvisioners {
+		for _, domain := range dnsProvisioner.Domains {
+			limits.Domains = append(limits.Domains, domain.String())
+		}
+	}
+
+	// admins can register the reserved names
+	if !user.Admin {
+		limits.ReservedNames = d.config.ReservedNames
+	}
+
+	return limits, nil
+}
+
+func (d *daemon) GetEvents(userCtx proto.UserContext, cursor uint, limit int) ([]proto.EventDto, error) {
+	if limit <= 0 || limit > maxEventsLimit {
+		limit = maxEventsLimit
+	}
+
+	events, err := d.conn.FindUserEvents(userCtx.UserID, cursor, limit)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, err
+	}
+
+	var eventsDto []proto.EventDto
+	for _, event := range events {
+		eventsDto = append(eventsDto, newEventDto(event))
+	}
+
+	return eventsDto, nil
+}
+
+// GetAllEvents return the events of every user that happened after given cursor, as an audit feed
+func (d *daemon) GetAllEvents(userCtx proto.UserContext, cursor uint, limit int) ([]proto.OwnedEventDto, error) {
+	if err := d.checkAdmin(userCtx); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > maxEventsLimit {
+		limit = maxEventsLimit
+	}
+
+	events, err := d.conn.FindEvents(cursor, limit)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, err
+	}
+
+	var eventsDto []proto.OwnedEventDto
+	for _, event := range events {
+		eventsDto = append(eventsDto, proto.OwnedEventDto{
+			EventDto: newEventDto(event.Event),
+			Owner:    event.OwnerEmail,
+		})
+	}
+
+	return eventsDto, nil
+}
+
+func newEventDto(event database.Event) proto.EventDto {
+	return proto.EventDto{
+		ID:            event.ID,
+		Type:          event.Type,
+		Alias:         event.Alias,
+		Value:         event.Value,
+		PreviousValue: event.PreviousValue,
+		Time:          event.CreatedAt,
+	}
+}
+
+// GetAllAliases return a page of the aliases of every user matching given filter,
+// along with the cursor of the next page, which is zero when there are no more aliases
+func (d *daemon) GetAllAliases(userCtx proto.UserContext, cursor uint, limit int, filter proto.AliasFilterDto) ([]proto.OwnedAliasDto, uint, error) {
+	if err := d.checkAdmin(userCtx); err != nil {
+		return nil, 0, err
+	}
+
+	if limit <= 0 || limit > maxAliasesLimit {
+		limit = maxAliasesLimit
+	}
+
+	aliases, err := d.conn.FindAliasesAfter(cursor, limit, database.AliasFilter{Domain: filter.Domain, Owner: filter.Owner})
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, 0, err
+	}
+
+	var aliasesDto []proto.OwnedAliasDto
+	for _, alias := range aliases {
+		aliasesDto = append(aliasesDto, proto.OwnedAliasDto{
+			AliasDto: d.toAliasDto(alias.Alias),
+			Owner:    alias.OwnerEmail,
+		})
+	}
+
+	var next uint
+	if len(aliases) == limit {
+		next = aliases[len(aliases)-1].ID
+	}
+
+	return aliasesDto, next, nil
+}
+
+// GetDNSStatus return the state of the circuit breaker and the number of pending record changes
+func (d *daemon) GetDNSStatus(userCtx proto.UserContext) (proto.DNSStatusDto, error) {
+	if err := d.checkAdmin(userCtx); err != nil {
+		return proto.DNSStatusDto{}, err
+	}
+
+	if d.breaker == nil {
+		return proto.DNSStatusDto{CircuitBreaker: "disabled"}, nil
+	}
+
+	pending, err := d.conn.CountPendingRecords()
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.DNSStatusDto{}, err
+	}
+
+	return proto.DNSStatusDto{
+		CircuitBreaker: string(d.breaker.State()),
+		PendingRecords: pending,
+	}, nil
+}
+
+// GetUserAliases return a page of the aliases of given user (see GetAliasesPage)
+func (d *daemon) GetUserAliases(userCtx proto.UserContext, email string, cursor uint, limit int) ([]proto.AliasDto, uint, error) {
+	if err := d.checkAdmin(userCtx); err != nil {
+		return nil, 0, err
+	}
+
+	user, err := d.conn.FindUser(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, 0, proto.ErrUserNotFound
+		}
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, 0, err
+	}
+
+	return d.GetAliasesPage(proto.UserContext{UserID: user.ID}, cursor, limit)
+}
+
+func (d *daemon) SetAdmin(email string, admin bool) error {
+	if err := d.conn.SetAdmin(email, admin); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return proto.ErrInvalidParameters
+		}
+		return err
+	}
+
+	d.logger.Info().Str("Email", email).Bool("Admin", admin).Msg("successfully updated admin status.")
+
+	return nil
+}
+
+// SetUserDisabled disable (or enable back) the user account
+// the tokens of a disabled user are rejected, their aliases are left untouched
+func (d *daemon) SetUserDisabled(email string, disabled bool) error {
+	if err := d.conn.SetDisabled(email, disabled); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return proto.ErrUserNotFound
+		}
+		d.logger.Err(err).Str("Email", email).Msg("error while updating user.")
+		return err
+	}
+
+	d.logger.Info().Str("Email", email).Bool("Disabled", disabled).Msg("successfully updated disabled status.")
+
+	return nil
+}
+
+// ResetPassword replace the user password and revoke all their tokens
+func (d *daemon) ResetPassword(email, password string) error {
+	if password == "" {
+		return proto.ErrInvalidParameters
+	}
+
+	user, err := d.conn.FindUser(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.ErrUserNotFound
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return err
+	}
+
+	hash, err := d.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := d.conn.UpdatePassword(user.ID, hash); err != nil {
+		d.logger.Err(err).Str("Email", email).Msg("error while updating password.")
+		return err
+	}
+
+	return d.RevokeTokens(proto.UserContext{UserID: user.ID})
+}
+
+// isReservedName determinate if the alias name match one of the configured reserved names
+func (d *daemon) isReservedName(alias database.Alias) bool {
+	name := fmt.Sprintf("%s.%s", alias.Host, alias.Domain)
+
+	for _, pattern := range d.config.ReservedNames {
+		pattern = strings.ToLower(pattern)
+		if ok, _ := path.Match(pattern, alias.Host); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkAdmin make sure the user is an admin
+// the status is read from the database so a demotion applies immediately
+func (d *daemon) checkAdmin(userCtx proto.UserContext) error {
+	user, err := d.conn.FindUserByID(userCtx.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return proto.ErrForbidden
+		}
+		d.logger.Err(err).Msg("error while fetching database.")
+		return err
+	}
+
+	if !user.Admin {
+		d.logger.Warn().Uint("UserID", userCtx.UserID).Msg("non admin user tried to access an admin resource.")
+		return proto.ErrForbidden
+	}
+
+	return nil
+}
+
+func (d *daemon) RevokeTokens(userCtx proto.UserContext) error {
+	if err := d.conn.IncrementTokenVersion(userCtx.UserID); err != nil {
+		d.logger.Err(err).Uint("UserID", userCtx.UserID).Msg("error while revoking tokens.")
+		return err
+	}
+
+	d.logger.Info().Uint("UserID", userCtx.UserID).Msg("successfully revoked all tokens.")
+
+	return nil
+}
+
+// ValidateUserContext make sure the token the user context comes from has not been revoked
+func (d *daemon) ValidateUserContext(userCtx proto.UserContext) error {
+	user, err := d.conn.FindUserByID(userCtx.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.ErrTokenRevoked
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return err
+	}
+
+	if user.TokenVersion != userCtx.TokenVersion {
+		return proto.ErrTokenRevoked
+	}
+	if user.Disabled {
+		return proto.ErrAccountDisabled
+	}
+
+	return nil
+}
+
+func (d *daemon) Logger() *zerolog.Logger {
+	return d.logger
+}
+
+// recordEvent publish an alias change along with the value the alias had before the change
+func (d *daemon) recordEvent(userCtx proto.UserContext, eventType string, alias database.Alias, previousValue string) {
+	d.publishChange(AliasChanged{
+		Type:          eventType,
+		Alias:         newAliasDto(alias).Domain,
+		Value:         aliasAddress(alias),
+		PreviousValue: previousValue,
+		TTL:           d.effectiveTTL(alias),
+		UserID:        userCtx.UserID,
+	})
+}
+
+// publishChange persist the change for the consumers of the events feed
+// then publish it to the subscribers of the event bus
+// failing to do so doesn't cancel the change
+func (d *daemon) publishChange(event AliasChanged) {
+	event.Time = time.Now()
+
+	dbEvent := database.Event{
+		Type:          event.Type,
+		Alias:         event.Alias,
+		Value:         event.Value,
+		PreviousValue: event.PreviousValue,
+		UserID:        event.UserID,
+	}
+	// the events feed expose the previous name of a renamed alias as its value
+	if event.Type == proto.EventAliasRenamed {
+		dbEvent.Value = event.PreviousAlias
+	}
+
+	if _, err := d.conn.CreateEvent(dbEvent); err != nil {
+		d.logger.Err(err).
+			Str("Type", event.Type).
+			Str("Alias", event.Alias).
+			Msg("error while recording event.")
+	}
+
+	d.bus.Publish(event)
+}
+
+// Subscribe see eventBus.Subscribe
+func (d *daemon) Subscribe(name string, handler func(event AliasChanged)) func() {
+	return d.bus.Subscribe(name, handler)
+}
+
+func (d *daemon) vacuumPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := d.conn.Vacuum()
+		if errors.Is(err, database.ErrVacuumNotSupported) {
+			d.logger.Warn().Msg("background vacuum is not supported by the database driver, stopping it.")
+			return
+		}
+		if errors.Is(err, database.ErrDatabaseBusy) {
+			d.logger.Debug().Msg("database busy, skipping background vacuum.")
+			continue
+		}
+		if err != nil {
+			d.logger.Err(err).Msg("error while vacuuming the database.")
+			continue
+		}
+
+		d.logger.Debug().Msg("database successfully vacuumed.")
+	}
+}
+
+func (d *daemon) findUserAlias(alias proto.AliasDto, userID uint) (database.Alias, error) {
+	a := newAlias(alias)
+	al, err := d.conn.FindAlias(a.Host, a.Domain)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return database.Alias{}, proto.ErrAliasNotFound
+		}
+
+		return database.Alias{}, err
+	}
+
+	if al.UserID != userID {
+		return database.Alias{}, proto.ErrAliasNotFound
+	}
+
+	return al, nil
+}
+
+func (d *daemon) findDomainConfig(domain string) (config.DomainConfig, bool) {
+	_, domainConf, exist := d.lookupDomain(domain)
+	return domainConf, exist
+}
+
+// effectiveTTL return the TTL of given alias, falling back on the domain then global defaults
+func (d *daemon) effectiveTTL(alias database.Alias) int {
+	if alias.TTL != 0 {
+		return alias.TTL
+	}
+
+	if domainConf, exist := d.findDomainConfig(alias.Domain); exist && domainConf.TTL != 0 {
+		return domainConf.TTL
+	}
+
+	return d.config.DefaultTTL
+}
+
+// toAliasDto convert given alias into a DTO exposing its effective TTL
+// and whether its zone is DNSSEC signed
+func (d *daemon) toAliasDto(alias database.Alias) proto.AliasDto {
+	dto := newAliasDto(alias)
+	dto.TTL = d.effectiveTTL(alias)
+	if domainConf, exist := d.findDomainConfig(alias.Domain); exist {
+		dto.DNSSEC = domainConf.DNSSEC
+	}
+	// the previously stored sources are hidden once the storage is disabled
+	if d.config.UpdateSource != "" {
+		dto.SourceIP = alias.SourceIP
+		dto.SourceUserAgent = alias.SourceUserAgent
+	}
+	return dto
+}
+
+// setUpdateSource record the client updating the alias, depending on the configuration
+// the previous source is cleared if the storage is disabled
+func (d *daemon) setUpdateSource(alias *database.Alias, userCtx proto.UserContext) {
+	switch d.config.UpdateSource {
+	case config.UpdateSourceFull:
+		alias.SourceIP = userCtx.RemoteAddr
+	case config.UpdateSourceAnonymized:
+		alias.SourceIP = anonymizeIP(userCtx.RemoteAddr)
+	default:
+		alias.SourceIP, alias.SourceUserAgent = "", ""
+		return
+	}
+
+	alias.SourceUserAgent = userCtx.UserAgent
+	if len(alias.SourceUserAgent) > maxUserAgentLength {
+		alias.SourceUserAgent = alias.SourceUserAgent[:maxUserAgentLength]
+	}
+}
+
+// anonymizeIP truncate given IP address to its /24 (IPv4) or /48 (IPv6) network
+func anonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// checkDNSProvisioners make sure each configured DNS provisioner can be resolved
+// i.e that it exists and that its configuration is complete, and that the enabled
+// features relying on them are backed by a provisioner supporting them
+func (d *daemon) checkDNSProvisioners() error {
+	if len(d.config.DNSProvisioners) == 0 {
+		return fmt.Errorf("no DNS provisioner configured: aliases cannot be registered")
+	}
+
+	domains := 0
+	for _, dnsProvisioner := range d.config.DNSProvisioners {
+		if _, err := d.dnsProvider.GetProvisioner(dnsProvisioner.Name, dnsProvisioner.Config); err != nil {
+			return fmt.Errorf("DNS provisioner `%s`: %w", dnsProvisioner.Name, err)
+		}
+
+		// the records of these domains only exist in the answers of the embedded DNS server
+		if dnsProvisioner.Name == dns.EmbeddedProvisionerName && !d.config.DNSServer.Enabled() {
+			return fmt.Errorf("DNS provisioner `%s` requires the embedded DNS server (DnsServer.ListenAddr)", dnsProvisioner.Name)
+		}
+
+		if len(dnsProvisioner.Domains) == 0 {
+			d.logger.Warn().Str("Provisioner", dnsProvisioner.Name).Msg("DNS provisioner doesn't manage any domain.")
+		}
+		domains += len(dnsProvisioner.Domains)
+	}
+
+	if domains == 0 {
+		return fmt.Errorf("no domain managed by the DNS provisioners: aliases cannot be registered")
+	}
+
+	return nil
+}
+
+// checkDNSSEC warn about the DNSSEC signed domains whose provisioner
+// doesn't keep the signatures up to date: the updated records would fail validation
+func (d *daemon) checkDNSSEC() {
+	for _, domain := range d.unmanagedDNSSECDomains() {
+		d.logger.Warn().
+			Str("Domain", domain).
+			Msg("domain is DNSSEC signed but its DNS provisioner doesn't manage DNSSEC. make sure the zone is re-signed on update.")
+	}
+}
+
+func (d *daemon) unmanagedDNSSECDomains() []string {
+	var domains []string
+
+	for _, dnsProvisioner := range d.config.DNSProvisioners {
+		for _, domainConf := range dnsProvisioner.Domains {
+			if !domainConf.DNSSEC {
+				continue
+			}
+
+			p, err := d.dnsProvider.GetProvisioner(dnsProvisioner.Name, dnsProvisioner.Config)
+			if err != nil || !dns.ManagesDNSSEC(p) {
+				domains = append(domains, domainConf.String())
+			}
+		}
+	}
+
+	return domains
+}
+
+// checkTTL make sure the alias TTL is within the bounds of its DNS provider
+// the TTL is either clamped or rejected depending on the provider configuration
+func (d *daemon) checkTTL(alias *database.Alias) error {
+	if alias.TTL == 0 {
+		return nil // the defaults are up to the operator
+	}
+
+	conf, exist := d.findDNSProvisionerConfig(alias.Domain)
+	if !exist {
+		return nil
+	}
+
+	ttl := clampTTL(conf, alias.TTL)
+	if ttl == alias.TTL {
+		return nil
+	}
+
+	if !conf.ClampTTL {
+		d.logger.Warn().
+			Int("TTL", alias.TTL).
+			Int("MinTTL", conf.MinTTL).
+			Int("MaxTTL", conf.MaxTTL).
+			Msg("TTL out of range.")
+		return proto.ErrTTLOutOfRange
+	}
+
+	d.logger.Debug().Int("TTL", alias.TTL).Int("ClampedTTL", ttl).Msg("TTL clamped.")
+	alias.TTL = ttl
+
+	return nil
+}
+
+// checkDefaultTTLs make sure the default TTL of each domain (its own, else the global one) is within
+// the bounds of its DNS provider, the TTL is either clamped or rejected depending on the provider configuration
+func (d *daemon) checkDefaultTTLs() error {
+	// the clamped TTLs are set on copies to leave the caller configuration untouched
+	provisioners := append([]config.DNSProvisionerConfig(nil), d.config.DNSProvisioners...)
+
+	for i, dnsProvisioner := range provisioners {
+		domains := append([]config.DomainConfig(nil), dnsProvisioner.Domains...)
+
+		for j, domainConf := range domains {
+			ttl := domainConf.TTL
+			if ttl == 0 {
+				ttl = d.config.DefaultTTL
+			}
+			if ttl == 0 {
+				continue
+			}
+
+			clamped := clampTTL(dnsProvisioner, ttl)
+			if clamped == ttl {
+				continue
+			}
+
+			if !dnsProvisioner.ClampTTL {
+				return fmt.Errorf("default TTL %d of domain `%s` out of the bounds of DNS provisioner `%s` (MinTTL: %d, MaxTTL: %d)",
+					ttl, domainConf.String(), dnsProvisioner.Name, dnsProvisioner.MinTTL, dnsProvisioner.MaxTTL)
+			}
+
+			d.logger.Warn().
+				Str("Domain", domainConf.String()).
+				Int("TTL", ttl).
+				Int("ClampedTTL", clamped).
+				Msg("default TTL out of the bounds of the DNS provisioner, clamped.")
+			domains[j].TTL = clamped
+		}
+
+		provisioners[i].Domains = domains
+	}
+
+	d.config.DNSProvisioners = provisioners
+
+	return nil
+}
+
+// clampTTL return given TTL restricted to the bounds of given DNS provisioner
+func clampTTL(conf config.DNSProvisionerConfig, ttl int) int {
+	if conf.MinTTL != 0 && ttl < conf.MinTTL {
+		return conf.MinTTL
+	}
+	if conf.MaxTTL != 0 && ttl > conf.MaxTTL {
+		return conf.MaxTTL
+	}
+
+	return ttl
+}
+
+// checkLabels make sure the number of labels of the alias name under its domain is within the domain bounds
+func (d *daemon) checkLabels(alias database.Alias, domainConf config.DomainConfig) error {
+	labels := strings.Count(alias.Domain, ".") - strings.Count(domainConf.String(), ".") + 1
+	if labels < domainConf.GetMinLabels() || labels > domainConf.GetMaxLabels() {
+		d.logger.Warn().
+			Str("Domain", alias.Domain).
+			Str("Host", alias.Host).
+			Int("Labels", labels).
+			Int("MinLabels", domainConf.GetMinLabels()).
+			Int("MaxLabels", domainConf.GetMaxLabels()).
+			Msg("alias name depth out of range.")
+		return proto.ErrLabelsOutOfRange
+	}
+
+	return nil
+}
+
+// checkValue make sure the alias addresses can be published by its DNS provider:
+// no control characters, within the provider length limit and matching the rules of their record type
+func (d *daemon) checkValue(alias database.Alias) error {
+	maxLength := defaultMaxValueLength
+	if conf, exist := d.findDNSProvisionerConfig(alias.Domain); exist && conf.MaxValueLength > 0 {
+		maxLength = conf.MaxValueLength
+	}
+
+	if alias.Value == "" && alias.Value6 == "" {
+		d.logger.Warn().Str("Domain", alias.Domain).Str("Host", alias.Host).Msg("alias has no address.")
+		return proto.ErrInvalidValue
+	}
+
+	for _, record := range [][2]string{{"A", alias.Value}, {"AAAA", alias.Value6}} {
+		recordType, value := record[0], record[1]
+		if value == "" {
+			continue
+		}
+
+		if len(value) > maxLength {
+			d.logger.Warn().Int("Length", len(value)).Int("MaxLength", maxLength).Msg("alias value too long.")
+			return proto.ErrValueTooLong
+		}
+
+		for _, r := range value {
+			if !unicode.IsPrint(r) || r == utf8.RuneError {
+				d.logger.Warn().Str("Value", strconv.Quote(value)).Msg("alias value contains forbidden characters.")
+				return proto.ErrInvalidValue
+			}
+		}
+
+		if !valueRules[recordType](value) {
+			d.logger.Warn().Str("Value", value).Str("Type", recordType).Msg("alias value does not match its record type.")
+			return proto.ErrInvalidValue
+		}
+	}
+
+	return nil
+}
+
+func (d *daemon) findDNSProvisionerConfig(domain string) (config.DNSProvisionerConfig, bool) {
+	provisionerConf, _, exist := d.lookupDomain(domain)
+	return provisionerConf, exist
+}
+
+// lookupDomain return the configuration of the managed domain of given alias domain:
+// the one matching exactly, else the closest parent one (for the nested alias names)
+func (d *daemon) lookupDomain(domain string) (config.DNSProvisionerConfig, config.DomainConfig, bool) {
+	var provisionerConf config.DNSProvisionerConfig
+	var domainConf config.DomainConfig
+	found := false
+
+	domain = strings.ToLower(domain)
+	for _, dnsProvisioner := range d.config.DNSProvisioners {
+		for _, dc := range dnsProvisioner.Domains {
+			name := strings.ToLower(dc.String())
+			if name == domain {
+				return dnsProvisioner, dc, true
+			}
+
+			if strings.HasSuffix(domain, "."+name) && (!found || len(name) > len(domainConf.String())) {
+				provisionerConf, domainConf, found = dnsProvisioner, dc, true
+			}
+		}
+	}
+
+	return provisionerConf, domainConf, found
+}
+
+// findDNSProvisioner return the provisioner of given alias domain
+// the record changes are queued while the DNS provider is unavailable
+func (d *daemon) findDNSProvisioner(domain string) (dns.Provisioner, config.DomainConfig, error) {
+	p, domainConf, err := d.resolveDNSProvisioner(domain)
+	if err != nil || d.breaker == nil {
+		return p, domainConf, err
+	}
+
+	return &queuingProvisioner{provisioner: p, daemon: d, aliasDomain: domain}, domainConf, nil
+}
+
+func (d *daemon) resolveDNSProvisioner(domain string) (dns.Provisioner, config.DomainConfig, error) {
+	provisionerConf, domainConf, exist := d.lookupDomain(domain)
+	if !exist {
+		return nil, config.DomainConfig{}, fmt.Errorf("no DNS provisioner found for domain %s", domain)
+	}
+
+	p, err := d.dnsProvider.GetProvisioner(provisionerConf.Name, provisionerConf.Config)
+	return p, domainConf, err
+}
+
+// Alias -> AliasDto
+func newAliasDto(alias database.Alias) proto.AliasDto {
+	return proto.AliasDto{
+		Domain:  fmt.Sprintf("%s.%s", alias.Host, alias.Domain),
+		Value:   alias.Value,
+		Value6:  alias.Value6,
+		TTL:     alias.TTL,
+		Enabled: alias.Enabled,
+		Follow:  alias.Follow,
+		Version: alias.Version,
+	}
+}
+
+// AliasDto -> Alias
+// the alias name is normalized to lowercase since DNS is case-insensitive
+// and an IPv6 address given as the value is stored as the IPv6 one
+func newAlias(alias proto.AliasDto) database.Alias {
+	name := strings.ToLower(alias.Domain)
+	parts := strings.Split(name, ".")
+
+	value, value6 := alias.Value, alias.Value6
+	if value6 == "" && dns.RecordType(value) == "AAAA" {
+		value, value6 = "", value
+	}
+
+	return database.Alias{
+		Host:    parts[0],
+		Domain:  strings.Replace(name, parts[0]+".", "", 1),
+		Value:   value,
+		Value6:  value6,
+		TTL:     alias.TTL,
+		Enabled: true,
+		Follow:  strings.ToLower(strings.TrimSuffix(alias.Follow, ".")),
+	}
+}
+
+// Update an existing alias using given DTO
+// the addresses not given are kept if wanted, unless the alias follows another name
+func updateAlias(alias *database.Alias, dto proto.AliasDto, keepAddresses bool) {
+	a := newAlias(dto)
+
+	alias.Host = a.Host
+	if !keepAddresses || a.Follow != "" || a.Value != "" {
+		alias.Value = a.Value
+	}
+	if !keepAddresses || a.Follow != "" || a.Value6 != "" {
+		alias.Value6 = a.Value6
+	}
+	alias.Follow = a.Follow
+
+	// keep the current TTL if none specified
+	if a.TTL != 0 {
+		alias.TTL = a.TTL
+	}
+}
+
+func isAliasValid(alias proto.AliasDto) bool {
+	// the value of an alias following another name is resolved if not given
+	return alias.Domain != "" && strings.Count(alias.Domain, ".") >= 2 &&
+		(alias.Value != "" || alias.Value6 != "" || strings.Contains(alias.Follow, ".")) && alias.TTL >= 0
+}
+
+// aliasAddresses return the addresses published by given alias, the IPv4 one first
+func aliasAddresses(alias database.Alias) []string {
+	var addresses []string
+	for _, value := range []string{alias.Value, alias.Value6} {
+		if value != "" {
+			addresses = append(addresses, value)
+		}
+	}
+	return addresses
+}
+
+// aliasAddress return the main address of given alias: the IPv4 one if any
+func aliasAddress(alias database.Alias) string {
+	if alias.Value != "" {
+		return alias.Value
+	}
+	return alias.Value6
 }
 
 func getRealHostAndDomain(alias proto.AliasDto, domainConf config.DomainConfig) (string, string) {
-	host := strings.Replace(alias.Domain, "."+domainConf.Domain, "", 1)
+	host := strings.Replace(strings.ToLower(alias.Domain), "."+strings.ToLower(domainConf.Domain), "", 1)
 	return host, domainConf.Domain
 }
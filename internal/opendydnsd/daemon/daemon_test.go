@@ -5,6 +5,7 @@ import (
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/dns_mock"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/golang/mock/gomock"
@@ -12,6 +13,8 @@ import (
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 	"io/ioutil"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -63,6 +66,17 @@ func TestNewAlias(t *testing.T) {
 	}
 }
 
+func TestNewAlias_Uppercase(t *testing.T) {
+	alias := newAlias(proto.AliasDto{
+		Domain: "Foo.BAR.baz",
+		Value:  "value",
+	})
+
+	if alias.Domain != "bar.baz" || alias.Host != "foo" {
+		t.Errorf("alias name not normalized: %s.%s", alias.Host, alias.Domain)
+	}
+}
+
 func TestNewAlias_WithSubDomain(t *testing.T) {
 	alias := newAlias(proto.AliasDto{
 		Domain: "demo.foo.bar.baz",
@@ -276,6 +290,255 @@ func TestDaemon_Authenticate(t *testing.T) {
 	}
 }
 
+func TestDaemon_ValidateUserContext(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().IncrementTokenVersion(uint(1)).Return(nil)
+	if err := d.RevokeTokens(proto.UserContext{UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, TokenVersion: 1}, nil).Times(2)
+
+	// token issued before the revocation
+	if err := d.ValidateUserContext(proto.UserContext{UserID: 1, TokenVersion: 0}); err != proto.ErrTokenRevoked {
+		t.Error("ValidateUserContext() should have returned ErrTokenRevoked")
+	}
+
+	// token issued after the revocation
+	if err := d.ValidateUserContext(proto.UserContext{UserID: 1, TokenVersion: 1}); err != nil {
+		t.Error(err)
+	}
+
+	// deleted user
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{}, gorm.ErrRecordNotFound)
+	if err := d.ValidateUserContext(proto.UserContext{UserID: 2}); err != proto.ErrTokenRevoked {
+		t.Error("ValidateUserContext() should have returned ErrTokenRevoked")
+	}
+
+	// disabled user
+	dbMock.EXPECT().FindUserByID(uint(3)).Return(database.User{Model: gorm.Model{ID: 3}, Disabled: true}, nil)
+	if err := d.ValidateUserContext(proto.UserContext{UserID: 3}); err != proto.ErrAccountDisabled {
+		t.Error("ValidateUserContext() should have returned ErrAccountDisabled")
+	}
+}
+
+func TestDaemon_SetUserDisabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().SetDisabled("lunamicard@gmail.com", true).Return(nil)
+	if err := d.SetUserDisabled("lunamicard@gmail.com", true); err != nil {
+		t.Fatal(err)
+	}
+
+	dbMock.EXPECT().SetDisabled("unknown@gmail.com", true).Return(gorm.ErrRecordNotFound)
+	if err := d.SetUserDisabled("unknown@gmail.com", true); err != proto.ErrUserNotFound {
+		t.Errorf("SetUserDisabled() should have returned ErrUserNotFound: %v", err)
+	}
+
+	// the disabled users cannot log in
+	dbMock.EXPECT().FindUser("lunamicard@gmail.com").Return(database.User{
+		Password: "$2a$04$5eQwROjKESuWP2y.sAVsPeqhG48UXWw.htYp5G./JsRjWwUMOi7xC",
+		Disabled: true,
+	}, nil)
+	if _, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}); err != proto.ErrAccountDisabled {
+		t.Errorf("Authenticate() should have returned ErrAccountDisabled: %v", err)
+	}
+}
+
+func TestDaemon_ResetPassword(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	var hash string
+	dbMock.EXPECT().FindUser("lunamicard@gmail.com").Return(database.User{Model: gorm.Model{ID: 12}}, nil)
+	dbMock.EXPECT().UpdatePassword(uint(12), gomock.Any()).DoAndReturn(func(userID uint, password string) error {
+		hash = password
+		return nil
+	})
+	// the tokens are revoked
+	dbMock.EXPECT().IncrementTokenVersion(uint(12)).Return(nil)
+
+	if err := d.ResetPassword("lunamicard@gmail.com", "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.validatePassword(hash, "new password") {
+		t.Errorf("wrong password hash stored: %s", hash)
+	}
+
+	dbMock.EXPECT().FindUser("unknown@gmail.com").Return(database.User{}, gorm.ErrRecordNotFound)
+	if err := d.ResetPassword("unknown@gmail.com", "new password"); err != proto.ErrUserNotFound {
+		t.Errorf("ResetPassword() should have returned ErrUserNotFound: %v", err)
+	}
+}
+
+func TestDaemon_GetAllAliases_NotAdmin(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}}, nil)
+
+	if _, _, err := d.GetAllAliases(proto.UserContext{UserID: 1}, 0, 0, proto.AliasFilterDto{}); err != proto.ErrForbidden {
+		t.Error("GetAllAliases() should have returned ErrForbidden")
+	}
+}
+
+func TestDaemon_GetAllEvents(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Admin: true}, nil)
+	dbMock.EXPECT().FindEvents(uint(3), maxEventsLimit).Return([]database.OwnedEvent{
+		{Event: database.Event{Model: gorm.Model{ID: 4}, Type: proto.EventAliasUpdated, Alias: "foo.example.org", UserID: 2}, OwnerEmail: "alois@micard.lu"},
+	}, nil)
+
+	events, err := d.GetAllEvents(proto.UserContext{UserID: 1}, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != 4 || events[0].Alias != "foo.example.org" || events[0].Owner != "alois@micard.lu" {
+		t.Errorf("wrong events returned: %v", events)
+	}
+
+	// not an admin
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{Model: gorm.Model{ID: 2}}, nil)
+
+	if _, err := d.GetAllEvents(proto.UserContext{UserID: 2}, 0, 0); err != proto.ErrForbidden {
+		t.Errorf("GetAllEvents() should have returned ErrForbidden: %v", err)
+	}
+}
+
+func TestDaemon_GetUserAliases(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Admin: true}, nil).Times(2)
+	dbMock.EXPECT().FindUser("alois@micard.lu").Return(database.User{Model: gorm.Model{ID: 2}}, nil)
+	dbMock.EXPECT().FindUserAliasesAfter(uint(2), uint(3), 1).Return([]database.Alias{
+		{Model: gorm.Model{ID: 5}, Host: "foo", Domain: "example.org", Value: "127.0.0.1", UserID: 2},
+	}, nil)
+
+	aliases, next, err := d.GetUserAliases(proto.UserContext{UserID: 1}, "alois@micard.lu", 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0].Domain != "foo.example.org" {
+		t.Errorf("wrong aliases returned: %v", aliases)
+	}
+	if next != 5 {
+		t.Errorf("wrong next cursor: %d", next)
+	}
+
+	// unknown user
+	dbMock.EXPECT().FindUser("nobody@example.org").Return(database.User{}, gorm.ErrRecordNotFound)
+
+	if _, _, err := d.GetUserAliases(proto.UserContext{UserID: 1}, "nobody@example.org", 0, 0); err != proto.ErrUserNotFound {
+		t.Error("GetUserAliases() should have returned ErrUserNotFound")
+	}
+
+	// not an admin
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{Model: gorm.Model{ID: 2}}, nil)
+
+	if _, _, err := d.GetUserAliases(proto.UserContext{UserID: 2}, "alois@micard.lu", 0, 0); err != proto.ErrForbidden {
+		t.Error("GetUserAliases() should have returned ErrForbidden")
+	}
+}
+
+func TestDaemon_GetAllAliases(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{DefaultTTL: 3600},
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Admin: true}, nil).Times(2)
+	dbMock.EXPECT().FindAliasesAfter(uint(0), 2, database.AliasFilter{Domain: "example.org"}).Return([]database.OwnedAlias{
+		{Alias: database.Alias{Model: gorm.Model{ID: 4}, Host: "foo", Domain: "example.org", Value: "127.0.0.1", UserID: 2}, OwnerEmail: "alois@micard.lu"},
+		{Alias: database.Alias{Model: gorm.Model{ID: 7}, Host: "bar", Domain: "example.org", Value: "::1", UserID: 3}, OwnerEmail: "lunamicard@gmail.com"},
+	}, nil)
+
+	aliases, next, err := d.GetAllAliases(proto.UserContext{UserID: 1}, 0, 2, proto.AliasFilterDto{Domain: "example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a full page: there may be more aliases
+	if next != 7 {
+		t.Errorf("wrong next cursor: %d", next)
+	}
+
+	if len(aliases) != 2 {
+		t.Fatalf("wrong number of aliases: %d", len(aliases))
+	}
+	if aliases[0].Domain != "foo.example.org" || aliases[0].Owner != "alois@micard.lu" || aliases[0].TTL != 3600 {
+		t.Errorf("wrong alias returned: %v", aliases[0])
+	}
+	if aliases[1].Domain != "bar.example.org" || aliases[1].Owner != "lunamicard@gmail.com" {
+		t.Errorf("wrong alias returned: %v", aliases[1])
+	}
+
+	// the limit is capped, the last page has no next cursor
+	dbMock.EXPECT().FindAliasesAfter(uint(7), maxAliasesLimit, database.AliasFilter{Owner: "alois@micard.lu"}).Return(nil, nil)
+	if _, next, err := d.GetAllAliases(proto.UserContext{UserID: 1}, 7, 1000, proto.AliasFilterDto{Owner: "alois@micard.lu"}); err != nil || next != 0 {
+		t.Errorf("wrong next cursor: %d (%v)", next, err)
+	}
+}
+
 func TestDaemon_GetAliases(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -307,6 +570,40 @@ func TestDaemon_GetAliases(t *testing.T) {
 	}
 }
 
+func TestDaemon_GetAliasesByName(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	// taken.bar.baz exists but is owned by someone else
+	dbMock.EXPECT().
+		FindUserAliases(uint(1)).
+		Return([]database.Alias{
+			{Domain: "bar.baz", Host: "foo", Value: "8.8.8.8"},
+			{Domain: "bar.baz", Host: "other", Value: "1.1.1.1"},
+		}, nil)
+
+	aliases, missing, err := d.GetAliasesByName(proto.UserContext{UserID: 1},
+		[]string{"FOO.bar.baz", "missing.bar.baz", "taken.bar.baz", "foo.bar.baz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 1 || aliases[0].Domain != "foo.bar.baz" || aliases[0].Value != "8.8.8.8" {
+		t.Errorf("wrong aliases returned: %v", aliases)
+	}
+	if len(missing) != 2 || missing[0] != "missing.bar.baz" || missing[1] != "taken.bar.baz" {
+		t.Errorf("wrong missing names returned: %v", missing)
+	}
+}
+
 func TestDaemon_RegisterAlias_InvalidRequest(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -410,6 +707,43 @@ func TestDaemon_RegisterAlias_AliasAlreadyExist(t *testing.T) {
 	}
 }
 
+func TestDaemon_GetAliasesPage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserAliasesAfter(uint(1), uint(0), 2).Return([]database.Alias{
+		{Model: gorm.Model{ID: 3}, Host: "foo", Domain: "example.org"},
+		{Model: gorm.Model{ID: 7}, Host: "bar", Domain: "example.org"},
+	}, nil)
+	dbMock.EXPECT().FindUserAliasesAfter(uint(1), uint(7), 2).Return([]database.Alias{
+		{Model: gorm.Model{ID: 9}, Host: "baz", Domain: "example.org"},
+	}, nil)
+
+	aliases, next, err := d.GetAliasesPage(proto.UserContext{UserID: 1}, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 2 || next != 7 {
+		t.Errorf("wrong page returned: %v (next: %d)", aliases, next)
+	}
+
+	aliases, next, err = d.GetAliasesPage(proto.UserContext{UserID: 1}, next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || next != 0 {
+		t.Errorf("wrong page returned: %v (next: %d)", aliases, next)
+	}
+}
+
 func TestDaemon_RegisterAlias(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -439,10 +773,10 @@ func TestDaemon_RegisterAlias(t *testing.T) {
 		Return(database.Alias{}, gorm.ErrRecordNotFound)
 
 	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
-	provisionerMock.EXPECT().AddRecord("test.demo", "dydns.org", "127.0.0.1").Return(nil)
+	provisionerMock.EXPECT().AddRecord("test.demo", "dydns.org", "127.0.0.1", 0).Return(nil)
 
 	dbMock.EXPECT().
-		CreateAlias(database.Alias{Domain: "demo.dydns.org", Host: "test", Value: "127.0.0.1"}, uint(1)).
+		CreateAlias(database.Alias{Domain: "demo.dydns.org", Host: "test", Value: "127.0.0.1", Enabled: true}, uint(1)).
 		Return(database.Alias{
 			Model:  gorm.Model{ID: 12},
 			Domain: "demo.dydns.org",
@@ -451,6 +785,13 @@ func TestDaemon_RegisterAlias(t *testing.T) {
 			UserID: 1,
 		}, nil)
 
+	dbMock.EXPECT().CreateEvent(database.Event{
+		Type:   proto.EventAliasCreated,
+		Alias:  "test.demo.dydns.org",
+		Value:  "127.0.0.1",
+		UserID: 1,
+	}).Return(database.Event{}, nil)
+
 	r, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{
 		Domain: "test.demo.dydns.org", Value: "127.0.0.1",
 	})
@@ -528,7 +869,26 @@ func TestDaemon_UpdateAlias_AliasNotOwned(t *testing.T) {
 	}
 }
 
-func TestDaemon_UpdateAlias(t *testing.T) {
+func TestDaemon_IsReservedName(t *testing.T) {
+	d := daemon{config: config.DaemonConfig{ReservedNames: []string{"www", "Mail*", "admin.example.org"}}}
+
+	for name, reserved := range map[string]bool{
+		"www.example.org":     true,
+		"mail.example.org":    true,
+		"mail2.example.org":   true,
+		"admin.example.org":   true,
+		"admin.example.com":   false,
+		"email.example.org":   false,
+		"wwww.example.org":    false,
+		"foo.www.example.org": false,
+	} {
+		if d.isReservedName(newAlias(proto.AliasDto{Domain: name})) != reserved {
+			t.Errorf("wrong reservation status for %s: expected %v", name, reserved)
+		}
+	}
+}
+
+func TestDaemon_RegisterAlias_Reserved(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -545,84 +905,1262 @@ func TestDaemon_UpdateAlias(t *testing.T) {
 				{
 					Name:    "dummy",
 					Config:  map[string]string{},
-					Domains: []config.DomainConfig{{Domain: "bar.baz"}},
+					Domains: []config.DomainConfig{{Domain: "example.org"}},
 				},
 			},
+			ReservedNames: []string{"www"},
 		},
 		dnsProvider: providerMock,
 	}
 
-	dbMock.EXPECT().
-		FindAlias("foo", "bar.baz").
-		Return(database.Alias{
-			Model:  gorm.Model{ID: 42},
-			Domain: "bar.baz",
-			Host:   "foo",
-			Value:  "127.0.0.1",
-			UserID: 1,
-		}, nil)
-
-	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
-	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8").Return(nil)
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil).Times(2)
 
-	dbMock.EXPECT().UpdateAlias(database.Alias{
-		Model:  gorm.Model{ID: 42},
-		Domain: "bar.baz",
-		Host:   "foo",
-		Value:  "8.8.8.8",
-		UserID: uint(1),
-	}).Return(database.Alias{
-		Model:  gorm.Model{ID: 42},
-		Domain: "bar.baz",
-		Host:   "foo",
-		Value:  "8.8.8.8",
-		UserID: 1,
-	}, nil)
+	// rejected for regular users, before the uniqueness check
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}}, nil)
 
-	a, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"})
-	if err != nil {
-		t.Error(err)
+	_, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "www.example.org", Value: "127.0.0.1"})
+	if err != proto.ErrAliasReserved {
+		t.Errorf("RegisterAlias() should have returned ErrAliasReserved")
 	}
 
-	if a.Domain != "foo.bar.baz" || a.Value != "8.8.8.8" {
-		t.Error("Alias not updated")
+	// allowed for admins
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{Model: gorm.Model{ID: 2}, Admin: true}, nil)
+	dbMock.EXPECT().FindAlias("www", "example.org").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	provisionerMock.EXPECT().AddRecord("www", "example.org", "127.0.0.1", 0).Return(nil)
+	dbMock.EXPECT().
+		CreateAlias(database.Alias{Domain: "example.org", Host: "www", Value: "127.0.0.1", Enabled: true}, uint(2)).
+		Return(database.Alias{Domain: "example.org", Host: "www", Value: "127.0.0.1", UserID: 2, Enabled: true}, nil)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	if _, err := d.RegisterAlias(proto.UserContext{UserID: 2}, proto.AliasDto{Domain: "www.example.org", Value: "127.0.0.1"}); err != nil {
+		t.Errorf("RegisterAlias() should have succeeded for an admin: %s", err)
 	}
 }
 
-func TestDaemon_DeleteAlias(t *testing.T) {
-	mockCtrl := gomock.NewController(t)
-	defer mockCtrl.Finish()
-
+func TestDaemon_CheckTTL(t *testing.T) {
 	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
-	dbMock := database_mock.NewMockConnection(mockCtrl)
-	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
-	providerMock := dns_mock.NewMockProvider(mockCtrl)
 
+	provisioner := config.DNSProvisionerConfig{
+		Name:    "dummy",
+		Domains: []config.DomainConfig{{Domain: "example.org"}},
+		MinTTL:  60,
+		MaxTTL:  86400,
+	}
 	d := daemon{
 		logger: &logger,
-		conn:   dbMock,
-		config: config.DaemonConfig{
-			DNSProvisioners: []config.DNSProvisionerConfig{
-				{
-					Name:    "dummy",
-					Config:  map[string]string{},
-					Domains: []config.DomainConfig{{Domain: "creekorful.be"}},
-				},
-			},
-		},
-		dnsProvider: providerMock,
+		config: config.DaemonConfig{DNSProvisioners: []config.DNSProvisionerConfig{provisioner}},
 	}
 
-	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
-	provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(nil)
+	// rejection
+	for ttl, valid := range map[int]bool{0: true, 30: false, 60: true, 3600: true, 86400: true, 100000: false} {
+		alias := database.Alias{Host: "foo", Domain: "example.org", TTL: ttl}
+		err := d.checkTTL(&alias)
+		if valid && err != nil {
+			t.Errorf("TTL %d should have been accepted: %s", ttl, err)
+		}
+		if !valid && err != proto.ErrTTLOutOfRange {
+			t.Errorf("TTL %d should have been rejected", ttl)
+		}
+		if alias.TTL != ttl {
+			t.Errorf("TTL %d should not have been changed", ttl)
+		}
+	}
 
-	dbMock.EXPECT().DeleteAlias("www", "creekorful.be", uint(1)).Return(nil)
+	// clamping
+	d.config.DNSProvisioners[0].ClampTTL = true
+	for ttl, expected := range map[int]int{0: 0, 30: 60, 3600: 3600, 100000: 86400} {
+		alias := database.Alias{Host: "foo", Domain: "example.org", TTL: ttl}
+		if err := d.checkTTL(&alias); err != nil {
+			t.Errorf("TTL %d should have been clamped: %s", ttl, err)
+		}
+		if alias.TTL != expected {
+			t.Errorf("TTL %d wrongly clamped: %d", ttl, alias.TTL)
+		}
+	}
 
-	if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "www.creekorful.be"); err != nil {
+	// unknown domain: no bounds
+	alias := database.Alias{Host: "foo", Domain: "example.com", TTL: 1}
+	if err := d.checkTTL(&alias); err != nil || alias.TTL != 1 {
+		t.Errorf("TTL should not have been checked")
+	}
+}
+
+func TestDaemon_CheckDefaultTTLs(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	provisioners := []config.DNSProvisionerConfig{
+		{
+			Name:    "dummy",
+			Domains: []config.DomainConfig{{Domain: "example.org"}, {Domain: "example.com", TTL: 30}},
+			MinTTL:  60,
+			MaxTTL:  1800,
+		},
+		{Name: "other", Domains: []config.DomainConfig{{Domain: "example.net"}}},
+	}
+	d := daemon{
+		logger: &logger,
+		config: config.DaemonConfig{DefaultTTL: 3600, DNSProvisioners: provisioners},
+	}
+
+	// rejection
+	if err := d.checkDefaultTTLs(); err == nil || !strings.Contains(err.Error(), "example.org") {
+		t.Errorf("checkDefaultTTLs() should have rejected the global default TTL: %v", err)
+	}
+
+	d.config.DefaultTTL = 600
+	if err := d.checkDefaultTTLs(); err == nil || !strings.Contains(err.Error(), "example.com") {
+		t.Errorf("checkDefaultTTLs() should have rejected the domain TTL: %v", err)
+	}
+
+	// clamping
+	d.config.DefaultTTL = 3600
+	d.config.DNSProvisioners[0].ClampTTL = true
+	if err := d.checkDefaultTTLs(); err != nil {
+		t.Fatalf("checkDefaultTTLs() should have succeeded: %s", err)
+	}
+
+	for domain, expected := range map[string]int{"www.example.org": 1800, "www.example.com": 60, "www.example.net": 3600} {
+		if ttl := d.effectiveTTL(database.Alias{Domain: domain}); ttl != expected {
+			t.Errorf("wrong TTL for %s: %d (expected %d)", domain, ttl, expected)
+		}
+	}
+
+	// the configuration given by the caller is left untouched
+	if provisioners[0].Domains[0].TTL != 0 || provisioners[0].Domains[1].TTL != 30 {
+		t.Errorf("the caller configuration should not have been changed: %v", provisioners[0].Domains)
+	}
+}
+
+func TestDaemon_CheckValue(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	d := daemon{
+		logger: &logger,
+		config: config.DaemonConfig{DNSProvisioners: []config.DNSProvisionerConfig{
+			{Name: "dummy", Domains: []config.DomainConfig{{Domain: "example.org"}}, MaxValueLength: 15},
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		domain   string
+		value    string
+		expected error
+	}{
+		{"A", "example.org", "192.0.2.1", nil},
+		{"A leading zeros", "example.org", "192.0.2.01", proto.ErrInvalidValue},
+		{"A truncated", "example.org", "192.0.2", proto.ErrInvalidValue},
+		{"A hostname", "example.org", "foo.example.net", proto.ErrInvalidValue},
+		{"A empty", "example.org", "", proto.ErrInvalidValue},
+		{"A control character", "example.org", "192.0.2.1\n", proto.ErrInvalidValue},
+		{"A at provider limit", "example.org", "192.168.100.200", nil},
+		{"AAAA", "example.org", "2001:db8::1", nil},
+		{"AAAA provider limit", "example.org", "2001:db8:0:0:0:0:0:1", proto.ErrValueTooLong},
+		{"AAAA default limit", "example.com", "2001:db8:0:0:0:0:0:1", nil},
+		{"AAAA zone", "example.com", "fe80::1%eth0", proto.ErrInvalidValue},
+		{"AAAA IPv4-mapped", "example.com", "::ffff:192.0.2.1", proto.ErrInvalidValue},
+		{"AAAA invalid UTF-8", "example.com", "2001:db8::\xff", proto.ErrInvalidValue},
+		{"default limit", "example.com", strings.Repeat("1", defaultMaxValueLength+1), proto.ErrValueTooLong},
+	}
+
+	for _, test := range tests {
+		alias := newAlias(proto.AliasDto{Domain: "foo." + test.domain, Value: test.value})
+		if err := d.checkValue(alias); err != test.expected {
+			t.Errorf("%s: checkValue(%q) returned %v instead of %v", test.name, test.value, err, test.expected)
+		}
+	}
+
+	// both addresses are checked against the rules of their own record type
+	alias := database.Alias{Host: "foo", Domain: "example.org", Value: "192.0.2.1", Value6: "2001:db8::1"}
+	if err := d.checkValue(alias); err != nil {
+		t.Errorf("checkValue() returned %v", err)
+	}
+	alias.Value6 = "192.0.2.2"
+	if err := d.checkValue(alias); err != proto.ErrInvalidValue {
+		t.Errorf("checkValue() should have returned ErrInvalidValue: %v", err)
+	}
+	alias.Value, alias.Value6 = "2001:db8::1", "2001:db8::2"
+	if err := d.checkValue(alias); err != proto.ErrInvalidValue {
+		t.Errorf("checkValue() should have returned ErrInvalidValue: %v", err)
+	}
+}
+
+func TestDaemon_RegisterAlias_Labels(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:   "dummy",
+					Config: map[string]string{},
+					Domains: []config.DomainConfig{
+						{Domain: "example.org"},
+						{Domain: "example.org", Host: "dyn", MinLabels: 2, MaxLabels: 3},
+					},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		domain   string
+		expected error
+	}{
+		{"foo.example.org", "foo", "example.org", nil},
+		{"foo.bar.example.org", "foo", "bar.example.org", proto.ErrLabelsOutOfRange},
+		{"foo.dyn.example.org", "foo", "dyn.example.org", proto.ErrLabelsOutOfRange},
+		{"foo.bar.dyn.example.org", "foo", "bar.dyn.example.org", nil},
+		{"foo.bar.baz.dyn.example.org", "foo", "bar.baz.dyn.example.org", nil},
+		{"a.foo.bar.baz.dyn.example.org", "a", "foo.bar.baz.dyn.example.org", proto.ErrLabelsOutOfRange},
+	}
+
+	for _, test := range tests {
+		providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+
+		if test.expected == nil {
+			recordHost := strings.TrimSuffix(test.name, ".example.org")
+			dbMock.EXPECT().FindAlias(test.host, test.domain).Return(database.Alias{}, gorm.ErrRecordNotFound)
+			provisionerMock.EXPECT().AddRecord(recordHost, "example.org", "127.0.0.1", 0).Return(nil)
+			dbMock.EXPECT().CreateAlias(gomock.Any(), uint(1)).DoAndReturn(func(alias database.Alias, userID uint) (database.Alias, error) {
+				return alias, nil
+			})
+			dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+		}
+
+		_, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: test.name, Value: "127.0.0.1"})
+		if err != test.expected {
+			t.Errorf("RegisterAlias(%s) returned %v instead of %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestDaemon_RegisterAlias_ClampedTTL(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:     "dummy",
+					Config:   map[string]string{},
+					Domains:  []config.DomainConfig{{Domain: "example.org"}},
+					MaxTTL:   3600,
+					ClampTTL: true,
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+	dbMock.EXPECT().FindAlias("test", "example.org").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	provisionerMock.EXPECT().AddRecord("test", "example.org", "127.0.0.1", 3600).Return(nil)
+	dbMock.EXPECT().
+		CreateAlias(database.Alias{Domain: "example.org", Host: "test", Value: "127.0.0.1", TTL: 3600, Enabled: true}, uint(1)).
+		Return(database.Alias{Domain: "example.org", Host: "test", Value: "127.0.0.1", TTL: 3600, UserID: 1, Enabled: true}, nil)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	a, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "test.example.org", Value: "127.0.0.1", TTL: 7200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.TTL != 3600 {
+		t.Errorf("the clamped TTL should have been returned: %d", a.TTL)
+	}
+}
+
+func TestDaemon_UpdateAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "bar.baz"}},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	dbMock.EXPECT().
+		FindAlias("foo", "bar.baz").
+		Return(database.Alias{
+			Model:   gorm.Model{ID: 42},
+			Domain:  "bar.baz",
+			Host:    "foo",
+			Value:   "127.0.0.1",
+			UserID:  1,
+			Enabled: true,
+		}, nil)
+
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8", 0).Return(nil)
+
+	dbMock.EXPECT().UpdateAlias(database.Alias{
+		Model:   gorm.Model{ID: 42},
+		Domain:  "bar.baz",
+		Host:    "foo",
+		Value:   "8.8.8.8",
+		UserID:  uint(1),
+		Enabled: true,
+	}).Return(database.Alias{
+		Model:   gorm.Model{ID: 42},
+		Domain:  "bar.baz",
+		Host:    "foo",
+		Value:   "8.8.8.8",
+		UserID:  1,
+		Enabled: true,
+	}, nil)
+
+	dbMock.EXPECT().CreateEvent(database.Event{
+		Type:          proto.EventAliasUpdated,
+		Alias:         "foo.bar.baz",
+		Value:         "8.8.8.8",
+		PreviousValue: "127.0.0.1",
+		UserID:        1,
+	}).Return(database.Event{}, nil)
+
+	a, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if a.Domain != "foo.bar.baz" || a.Value != "8.8.8.8" {
+		t.Error("Alias not updated")
+	}
+}
+
+func TestDaemon_DualStackAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "bar.baz"}},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+	userCtx := proto.UserContext{UserID: 1}
+	save := func(alias database.Alias) (database.Alias, error) { return alias, nil }
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil).AnyTimes()
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil).Times(3)
+
+	// an IPv6 address is published as an AAAA record
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "2001:db8::1", 0).Return(nil)
+	dbMock.EXPECT().CreateAlias(database.Alias{Host: "foo", Domain: "bar.baz", Value6: "2001:db8::1", Enabled: true}, uint(1)).
+		DoAndReturn(func(alias database.Alias, _ uint) (database.Alias, error) { return alias, nil })
+
+	a, err := d.RegisterAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Value != "" || a.Value6 != "2001:db8::1" {
+		t.Errorf("wrong alias registered: %v", a)
+	}
+
+	// the IPv4 address is added alongside
+	alias := database.Alias{Host: "foo", Domain: "bar.baz", Value6: "2001:db8::1", UserID: 1, Enabled: true}
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "192.0.2.1", 0).Return(nil)
+	alias.Value = "192.0.2.1"
+	dbMock.EXPECT().UpdateAlias(alias).DoAndReturn(save)
+
+	if _, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the AAAA record is updated
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "2001:db8::2", 0).Return(nil)
+	alias.Value6 = "2001:db8::2"
+	dbMock.EXPECT().UpdateAlias(alias).DoAndReturn(save)
+
+	if _, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "2001:db8::2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// withdrawing the IPv6 address keeps the A record only
+	ttl := 120
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil).Times(2)
+	gomock.InOrder(
+		provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz").Return(nil),
+		provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "192.0.2.1", 120).Return(nil),
+	)
+	expected := alias
+	expected.Value6, expected.TTL = "", 120
+	dbMock.EXPECT().UpdateAlias(expected).DoAndReturn(save)
+	dbMock.EXPECT().CreateEvent(database.Event{
+		Type:          proto.EventAliasUpdated,
+		Alias:         "foo.bar.baz",
+		Value:         "",
+		PreviousValue: "2001:db8::2",
+		UserID:        1,
+	}).Return(database.Event{}, nil)
+
+	empty := ""
+	a, err = d.PatchAlias(userCtx, "foo.bar.baz", proto.AliasPatchDto{Value6: &empty, TTL: &ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Value != "192.0.2.1" || a.Value6 != "" {
+		t.Errorf("wrong alias returned: %v", a)
+	}
+
+	// an alias cannot be left without address
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(expected, nil)
+	if _, err := d.PatchAlias(userCtx, "foo.bar.baz", proto.AliasPatchDto{Value: &empty}); err != proto.ErrInvalidParameters {
+		t.Errorf("PatchAlias() should have returned ErrInvalidParameters: %v", err)
+	}
+}
+
+func TestDaemon_UpdateAlias_UpdateSource(t *testing.T) {
+	tests := []struct {
+		mode      string
+		ip        string
+		userAgent string
+	}{
+		{"", "", ""},
+		{config.UpdateSourceFull, "192.0.2.42", "opendydns-cli/1.0.0"},
+		{config.UpdateSourceAnonymized, "192.0.2.0", "opendydns-cli/1.0.0"},
+	}
+
+	for _, test := range tests {
+		mockCtrl := gomock.NewController(t)
+
+		logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+		dbMock := database_mock.NewMockConnection(mockCtrl)
+
+		d := daemon{
+			logger: &logger,
+			conn:   dbMock,
+			config: config.DaemonConfig{UpdateSource: test.mode},
+		}
+		provisionerMock := useProvisionerMock(&d, mockCtrl, "bar.baz")
+
+		dbMock.EXPECT().
+			FindAlias("foo", "bar.baz").
+			Return(database.Alias{
+				Model:           gorm.Model{ID: 42},
+				Domain:          "bar.baz",
+				Host:            "foo",
+				Value:           "127.0.0.1",
+				UserID:          1,
+				Enabled:         true,
+				SourceIP:        "198.51.100.1",
+				SourceUserAgent: "curl/7.68.0",
+			}, nil)
+		provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8", 0).Return(nil)
+
+		dbMock.EXPECT().UpdateAlias(database.Alias{
+			Model:           gorm.Model{ID: 42},
+			Domain:          "bar.baz",
+			Host:            "foo",
+			Value:           "8.8.8.8",
+			UserID:          1,
+			Enabled:         true,
+			SourceIP:        test.ip,
+			SourceUserAgent: test.userAgent,
+		}).DoAndReturn(func(alias database.Alias) (database.Alias, error) {
+			return alias, nil
+		})
+		dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+		userCtx := proto.UserContext{UserID: 1, RemoteAddr: "192.0.2.42", UserAgent: "opendydns-cli/1.0.0"}
+		a, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"})
+		if err != nil {
+			t.Error(err)
+		}
+		if a.SourceIP != test.ip || a.SourceUserAgent != test.userAgent {
+			t.Errorf("wrong update source returned for mode `%s`: %v", test.mode, a)
+		}
+
+		mockCtrl.Finish()
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.42":        "192.0.2.0",
+		"2001:db8:1:2:3::4": "2001:db8:1::",
+		"::ffff:192.0.2.42": "192.0.2.0",
+		"not-an-ip":         "",
+	}
+
+	for ip, want := range tests {
+		if got := anonymizeIP(ip); got != want {
+			t.Errorf("anonymizeIP(%s) = %s, want %s", ip, got, want)
+		}
+	}
+}
+
+func TestDaemon_UpdateAlias_NoChange(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{DefaultTTL: 3600},
+	}
+
+	dbMock.EXPECT().
+		FindAlias("foo", "bar.baz").
+		Return(database.Alias{
+			Model:   gorm.Model{ID: 42},
+			Domain:  "bar.baz",
+			Host:    "foo",
+			Value:   "127.0.0.1",
+			TTL:     60,
+			UserID:  1,
+			Enabled: true,
+		}, nil)
+
+	// neither the provisioner nor the database must be called
+	a, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "127.0.0.1"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if a.Value != "127.0.0.1" || a.TTL != 60 {
+		t.Errorf("wrong alias returned: %v", a)
+	}
+}
+
+func TestDaemon_UpdateAlias_TTLOnly(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DefaultTTL: 3600,
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "bar.baz"}},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	dbMock.EXPECT().
+		FindAlias("foo", "bar.baz").
+		Return(database.Alias{
+			Model:   gorm.Model{ID: 42},
+			Domain:  "bar.baz",
+			Host:    "foo",
+			Value:   "127.0.0.1",
+			UserID:  1,
+			Enabled: true,
+		}, nil)
+
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "127.0.0.1", 120).Return(nil)
+
+	updated := database.Alias{
+		Model:   gorm.Model{ID: 42},
+		Domain:  "bar.baz",
+		Host:    "foo",
+		Value:   "127.0.0.1",
+		TTL:     120,
+		UserID:  1,
+		Enabled: true,
+	}
+	dbMock.EXPECT().UpdateAlias(updated).Return(updated, nil)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	a, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "127.0.0.1", TTL: 120})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if a.Value != "127.0.0.1" || a.TTL != 120 {
+		t.Errorf("wrong alias returned: %v", a)
+	}
+}
+
+func TestDaemon_DeleteAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "creekorful.be"}},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+	provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(nil)
+
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(database.Alias{
+		Host:    "www",
+		Domain:  "creekorful.be",
+		Value:   "127.0.0.1",
+		UserID:  1,
+		Enabled: true,
+	}, nil)
+	dbMock.EXPECT().DeleteAlias("www", "creekorful.be", uint(1)).Return(nil)
+	dbMock.EXPECT().CreateEvent(database.Event{
+		Type:          proto.EventAliasDeleted,
+		Alias:         "www.creekorful.be",
+		PreviousValue: "127.0.0.1",
+		UserID:        1,
+	}).Return(database.Event{}, nil)
+
+	if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "www.creekorful.be"); err != nil {
+		t.Error(err)
+	}
+
+	// disabled alias: its record has already been withdrawn, the provisioner is not called
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(database.Alias{
+		Host:   "www",
+		Domain: "creekorful.be",
+		Value:  "127.0.0.1",
+		UserID: 1,
+	}, nil)
+	dbMock.EXPECT().DeleteAlias("www", "creekorful.be", uint(1)).Return(nil)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "www.creekorful.be"); err != nil {
 		t.Error(err)
 	}
 }
 
+func TestDaemon_UpdateAlias_Conflict(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock}
+	provisionerMock := useProvisionerMock(&d, mockCtrl, "bar.baz")
+
+	alias := database.Alias{Host: "foo", Domain: "bar.baz", Value: "127.0.0.1", UserID: 1, Enabled: true, Version: 3}
+	userCtx := proto.UserContext{UserID: 1}
+
+	// stale version given by the client: the DNS is not touched
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	if _, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8", Version: 2}); err != proto.ErrAliasConflict {
+		t.Errorf("UpdateAlias() should have returned ErrAliasConflict: %v", err)
+	}
+
+	// two concurrent updates read the same version: the first one to be saved wins
+	winner := alias
+	winner.Value, winner.Version = "9.9.9.9", 4
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil).Times(2)
+	gomock.InOrder(
+		provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "9.9.9.9", 0).Return(nil),
+		dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(winner, nil),
+		provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8", 0).Return(nil),
+		dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(database.Alias{}, database.ErrAliasConflict),
+		// the value of the winner, overwritten by the loser, is published again
+		dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(winner, nil),
+		provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "9.9.9.9", 0).Return(nil),
+	)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	if _, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "9.9.9.9", Version: 3}); err != nil {
+		t.Errorf("the first update should have been saved: %v", err)
+	}
+	if _, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8", Version: 3}); err != proto.ErrAliasConflict {
+		t.Errorf("UpdateAlias() should have returned ErrAliasConflict: %v", err)
+	}
+
+	// the alias cannot be saved: the previous value is published again
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	gomock.InOrder(
+		provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8", 0).Return(nil),
+		dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(database.Alias{}, errors.New("database is locked")),
+		provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "127.0.0.1", 0).Return(nil),
+	)
+	if _, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8", Version: 3}); err == nil {
+		t.Error("UpdateAlias() should have failed")
+	}
+
+	// current version
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8", 0).Return(nil)
+	dbMock.EXPECT().UpdateAlias(gomock.Any()).DoAndReturn(func(alias database.Alias) (database.Alias, error) {
+		alias.Version++
+		return alias, nil
+	})
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	updated, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8", Version: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Value != "8.8.8.8" || updated.Version != 4 {
+		t.Errorf("wrong alias returned: %v", updated)
+	}
+}
+
+func TestDaemon_SetAliasEnabled_ConflictRollback(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := newProvisionedTestDaemon(&logger, dbMock, providerMock)
+
+	alias := database.Alias{Host: "www", Domain: "creekorful.be", Value: "127.0.0.1", UserID: 1, Enabled: true}
+	userCtx := proto.UserContext{UserID: 1}
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil).Times(3)
+
+	// disabling while the value has been updated: the stored value is published again
+	current := alias
+	current.Value = "192.0.2.1"
+	gomock.InOrder(
+		dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil),
+		provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(nil),
+		dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(database.Alias{}, database.ErrAliasConflict),
+		dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(current, nil),
+		provisionerMock.EXPECT().AddRecord("www", "creekorful.be", "192.0.2.1", 3600).Return(nil),
+	)
+
+	if _, err := d.SetAliasEnabled(userCtx, "www.creekorful.be", false); err != proto.ErrAliasConflict {
+		t.Errorf("SetAliasEnabled() should have returned ErrAliasConflict: %v", err)
+	}
+
+	// enabling while the alias has been deleted: the published record is withdrawn again
+	alias.Enabled = false
+	gomock.InOrder(
+		dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil),
+		provisionerMock.EXPECT().AddRecord("www", "creekorful.be", "127.0.0.1", 3600).Return(nil),
+		dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(database.Alias{}, database.ErrAliasConflict),
+		dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(database.Alias{}, gorm.ErrRecordNotFound),
+		provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(nil),
+	)
+
+	if _, err := d.SetAliasEnabled(userCtx, "www.creekorful.be", true); err != proto.ErrAliasConflict {
+		t.Errorf("SetAliasEnabled() should have returned ErrAliasConflict: %v", err)
+	}
+
+	// the alias cannot be saved: the previous state is restored
+	gomock.InOrder(
+		dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil),
+		provisionerMock.EXPECT().AddRecord("www", "creekorful.be", "127.0.0.1", 3600).Return(nil),
+		dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(database.Alias{}, errors.New("database is locked")),
+		provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(nil),
+	)
+
+	if _, err := d.SetAliasEnabled(userCtx, "www.creekorful.be", true); err == nil {
+		t.Error("SetAliasEnabled() should have failed")
+	}
+}
+
+func TestDaemon_PatchAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock, followResolver: mockAddrResolver{}}
+	provisionerMock := useProvisionerMock(&d, mockCtrl, "bar.baz")
+	userCtx := proto.UserContext{UserID: 1}
+
+	alias := database.Alias{Host: "foo", Domain: "bar.baz", Value: "192.0.2.1", UserID: 1, Enabled: true, Follow: "home.example.net", Version: 3}
+
+	// only the TTL changes: the alias keeps following its name
+	ttl := 120
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil).Times(2)
+	dbMock.EXPECT().FindAlias("home", "example.net").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "192.0.2.1", 120).Return(nil)
+	expected := alias
+	expected.TTL = 120
+	dbMock.EXPECT().UpdateAlias(expected).Return(expected, nil)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	updated, err := d.PatchAlias(userCtx, "foo.bar.baz", proto.AliasPatchDto{TTL: &ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.TTL != 120 || updated.Value != "192.0.2.1" || updated.Follow != "home.example.net" {
+		t.Errorf("wrong alias returned: %v", updated)
+	}
+
+	// only the value changes: the TTL is kept
+	alias.Follow, alias.TTL = "", 300
+	value := "198.51.100.7"
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil).Times(2)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", value, 300).Return(nil)
+	expected = alias
+	expected.Value = value
+	dbMock.EXPECT().UpdateAlias(expected).Return(expected, nil)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	updated, err = d.PatchAlias(userCtx, "foo.bar.baz", proto.AliasPatchDto{Value: &value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.TTL != 300 || updated.Value != value {
+		t.Errorf("wrong alias returned: %v", updated)
+	}
+
+	dbMock.EXPECT().FindAlias("bar", "bar.baz").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	if _, err := d.PatchAlias(userCtx, "bar.bar.baz", proto.AliasPatchDto{Value: &value}); err != proto.ErrAliasNotFound {
+		t.Errorf("PatchAlias() should have returned ErrAliasNotFound: %v", err)
+	}
+}
+
+func TestDaemon_PatchAlias_Conflict(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock}
+	userCtx := proto.UserContext{UserID: 1}
+
+	alias := database.Alias{Host: "foo", Domain: "bar.baz", Value: "192.0.2.1", UserID: 1, Version: 3}
+	changed := alias
+	changed.Value, changed.Version = "192.0.2.2", 4
+	ttl := 120
+
+	// the alias is changed meanwhile: the patch is applied on the new value
+	gomock.InOrder(
+		dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil).Times(2),
+		dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(database.Alias{}, database.ErrAliasConflict),
+		dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(changed, nil).Times(2),
+		dbMock.EXPECT().UpdateAlias(gomock.Any()).DoAndReturn(func(alias database.Alias) (database.Alias, error) {
+			if alias.Value != "192.0.2.2" || alias.TTL != 120 || alias.Version != 4 {
+				t.Errorf("wrong alias saved: %v", alias)
+			}
+			alias.Version++
+			return alias, nil
+		}),
+	)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	updated, err := d.PatchAlias(userCtx, "foo.bar.baz", proto.AliasPatchDto{TTL: &ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Value != "192.0.2.2" || updated.TTL != 120 || updated.Version != 5 {
+		t.Errorf("wrong alias returned: %v", updated)
+	}
+
+	// the version given by the client is stale: no retry
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(changed, nil).Times(2)
+	if _, err := d.PatchAlias(userCtx, "foo.bar.baz", proto.AliasPatchDto{TTL: &ttl, Version: 3}); err != proto.ErrAliasConflict {
+		t.Errorf("PatchAlias() should have returned ErrAliasConflict: %v", err)
+	}
+}
+
+func TestDaemon_GetLimits(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			ReservedNames: []string{"www", "mail*"},
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{Name: "ovh", Domains: []config.DomainConfig{{Domain: "example.org"}, {Domain: "example.net"}}},
+			},
+		},
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{}, nil)
+	dbMock.EXPECT().CountUserAliases(uint(1)).Return(int64(3), nil)
+
+	limits, err := d.GetLimits(proto.UserContext{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.Aliases != 3 || limits.Admin || !reflect.DeepEqual(limits.Domains, []string{"example.org", "example.net"}) ||
+		!reflect.DeepEqual(limits.ReservedNames, []string{"www", "mail*"}) {
+		t.Errorf("wrong limits returned: %+v", limits)
+	}
+
+	// admins can register the reserved names
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{Admin: true}, nil)
+	dbMock.EXPECT().CountUserAliases(uint(2)).Return(int64(0), nil)
+
+	limits, err = d.GetLimits(proto.UserContext{UserID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !limits.Admin || len(limits.ReservedNames) != 0 {
+		t.Errorf("wrong limits returned: %+v", limits)
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(3)).Return(database.User{}, gorm.ErrRecordNotFound)
+	if _, err := d.GetLimits(proto.UserContext{UserID: 3}); err != proto.ErrUserNotFound {
+		t.Errorf("GetLimits() should have returned ErrUserNotFound: %v", err)
+	}
+}
+
+func TestDaemon_DeleteAlias_Missing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock}
+
+	// already deleted or owned by someone else: neither the DNS nor the database are touched
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(database.Alias{Host: "www", Domain: "creekorful.be", UserID: 2}, nil)
+	for i := 0; i < 2; i++ {
+		if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "www.creekorful.be"); err != nil {
+			t.Errorf("DeleteAlias() should have succeeded: %s", err)
+		}
+	}
+
+	d.config.StrictDelete = true
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "www.creekorful.be"); err != proto.ErrAliasNotFound {
+		t.Errorf("DeleteAlias() should have returned ErrAliasNotFound: %v", err)
+	}
+}
+
+func TestDaemon_SetAliasEnabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DefaultTTL: 3600,
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "creekorful.be"}},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	alias := database.Alias{Host: "www", Domain: "creekorful.be", Value: "127.0.0.1", UserID: 1, Enabled: true}
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil).Times(2)
+
+	// disabling removes the DNS record but keeps the alias
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil)
+	provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(nil)
+	disabled := alias
+	disabled.Enabled = false
+	dbMock.EXPECT().UpdateAlias(disabled).Return(disabled, nil)
+	dbMock.EXPECT().CreateEvent(database.Event{
+		Type:   proto.EventAliasDisabled,
+		Alias:  "www.creekorful.be",
+		Value:  "127.0.0.1",
+		UserID: 1,
+	}).Return(database.Event{}, nil)
+
+	res, err := d.SetAliasEnabled(proto.UserContext{UserID: 1}, "www.creekorful.be", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Enabled {
+		t.Error("alias should be disabled")
+	}
+
+	// an update of a disabled alias is stored but not published
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(disabled, nil)
+	updated := disabled
+	updated.Value = "127.0.0.2"
+	dbMock.EXPECT().UpdateAlias(updated).Return(updated, nil)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	if _, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "www.creekorful.be", Value: "127.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// enabling restores the record using the stored value
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(updated, nil)
+	provisionerMock.EXPECT().AddRecord("www", "creekorful.be", "127.0.0.2", 3600).Return(nil)
+	enabled := updated
+	enabled.Enabled = true
+	dbMock.EXPECT().UpdateAlias(enabled).Return(enabled, nil)
+	dbMock.EXPECT().CreateEvent(database.Event{
+		Type:   proto.EventAliasEnabled,
+		Alias:  "www.creekorful.be",
+		Value:  "127.0.0.2",
+		UserID: 1,
+	}).Return(database.Event{}, nil)
+
+	res, err = d.SetAliasEnabled(proto.UserContext{UserID: 1}, "www.creekorful.be", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Enabled || res.Value != "127.0.0.2" {
+		t.Errorf("wrong alias returned: %v", res)
+	}
+
+	// already enabled: nothing to do
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(enabled, nil)
+
+	if _, err := d.SetAliasEnabled(proto.UserContext{UserID: 1}, "www.creekorful.be", true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDaemon_RenameAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := newProvisionedTestDaemon(&logger, dbMock, providerMock)
+
+	alias := database.Alias{Host: "www", Domain: "creekorful.be", Value: "127.0.0.1", TTL: 60, UserID: 1, Enabled: true}
+
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil)
+	dbMock.EXPECT().FindAlias("home", "creekorful.be").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+
+	// the new record is created before the previous one is removed
+	gomock.InOrder(
+		provisionerMock.EXPECT().AddRecord("home", "creekorful.be", "127.0.0.1", 60).Return(nil),
+		provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(nil),
+	)
+
+	renamed := alias
+	renamed.Host = "home"
+	dbMock.EXPECT().UpdateAlias(renamed).Return(renamed, nil)
+	dbMock.EXPECT().CreateEvent(database.Event{
+		Type:   proto.EventAliasRenamed,
+		Alias:  "home.creekorful.be",
+		Value:  "www.creekorful.be",
+		UserID: 1,
+	}).Return(database.Event{}, nil)
+
+	res, err := d.RenameAlias(proto.UserContext{UserID: 1}, "www.creekorful.be", "Home.creekorful.be")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Domain != "home.creekorful.be" || res.Value != "127.0.0.1" || res.TTL != 60 {
+		t.Errorf("wrong alias returned: %v", res)
+	}
+}
+
+func TestDaemon_RenameAlias_Disabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := newProvisionedTestDaemon(&logger, dbMock, providerMock)
+
+	// a disabled alias is not published: the DNS provider is not called
+	alias := database.Alias{Host: "www", Domain: "creekorful.be", Value: "127.0.0.1", UserID: 1}
+
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil)
+	dbMock.EXPECT().FindAlias("home", "creekorful.be").Return(database.Alias{}, gorm.ErrRecordNotFound)
+
+	renamed := alias
+	renamed.Host = "home"
+	dbMock.EXPECT().UpdateAlias(renamed).Return(renamed, nil)
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	if _, err := d.RenameAlias(proto.UserContext{UserID: 1}, "www.creekorful.be", "home.creekorful.be"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDaemon_RenameAlias_Conflict(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := newProvisionedTestDaemon(&logger, dbMock, providerMock)
+
+	alias := database.Alias{Host: "www", Domain: "creekorful.be", Value: "127.0.0.1", UserID: 1, Enabled: true}
+
+	// taken by someone else
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil)
+	dbMock.EXPECT().FindAlias("home", "creekorful.be").Return(database.Alias{Host: "home", Domain: "creekorful.be", UserID: 2}, nil)
+
+	if _, err := d.RenameAlias(proto.UserContext{UserID: 1}, "www.creekorful.be", "home.creekorful.be"); err != proto.ErrAliasTaken {
+		t.Errorf("RenameAlias() should have returned ErrAliasTaken, got: %v", err)
+	}
+
+	// already owned by the user
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil)
+	dbMock.EXPECT().FindAlias("home", "creekorful.be").Return(database.Alias{Host: "home", Domain: "creekorful.be", UserID: 1}, nil)
+
+	if _, err := d.RenameAlias(proto.UserContext{UserID: 1}, "www.creekorful.be", "home.creekorful.be"); err != proto.ErrAliasAlreadyExist {
+		t.Errorf("RenameAlias() should have returned ErrAliasAlreadyExist, got: %v", err)
+	}
+
+	// the domain cannot be changed
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil)
+
+	if _, err := d.RenameAlias(proto.UserContext{UserID: 1}, "www.creekorful.be", "www.creekorful.fr"); err != proto.ErrInvalidParameters {
+		t.Errorf("RenameAlias() should have returned ErrInvalidParameters, got: %v", err)
+	}
+
+	// not owned
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil)
+
+	if _, err := d.RenameAlias(proto.UserContext{UserID: 2}, "www.creekorful.be", "home.creekorful.be"); err != proto.ErrAliasNotFound {
+		t.Errorf("RenameAlias() should have returned ErrAliasNotFound, got: %v", err)
+	}
+}
+
+func TestDaemon_RenameAlias_DNSFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := newProvisionedTestDaemon(&logger, dbMock, providerMock)
+
+	alias := database.Alias{Host: "www", Domain: "creekorful.be", Value: "127.0.0.1", UserID: 1, Enabled: true}
+
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(alias, nil)
+	dbMock.EXPECT().FindAlias("home", "creekorful.be").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+
+	// the new record is rolled back and the alias is left untouched
+	dnsErr := errors.New("provider unavailable")
+	gomock.InOrder(
+		provisionerMock.EXPECT().AddRecord("home", "creekorful.be", "127.0.0.1", 3600).Return(nil),
+		provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(dnsErr),
+		provisionerMock.EXPECT().DeleteRecord("home", "creekorful.be").Return(nil),
+	)
+
+	if _, err := d.RenameAlias(proto.UserContext{UserID: 1}, "www.creekorful.be", "home.creekorful.be"); err != dnsErr {
+		t.Errorf("RenameAlias() should have returned the DNS error, got: %v", err)
+	}
+}
+
+// useProvisionerMock make the daemon publish the aliases of given domain using the returned provisioner mock
+func useProvisionerMock(d *daemon, mockCtrl *gomock.Controller, domain string) *dns_mock.MockProvisioner {
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil).AnyTimes()
+
+	d.dnsProvider = providerMock
+	d.config.DNSProvisioners = append(d.config.DNSProvisioners, config.DNSProvisionerConfig{
+		Name:    "dummy",
+		Config:  map[string]string{},
+		Domains: []config.DomainConfig{{Domain: domain}},
+	})
+
+	return provisionerMock
+}
+
+// newProvisionedTestDaemon return a daemon managing creekorful.be using the dummy provisioner
+func newProvisionedTestDaemon(logger *zerolog.Logger, dbMock *database_mock.MockConnection, providerMock *dns_mock.MockProvider) daemon {
+	return daemon{
+		logger: logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DefaultTTL: 3600,
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "creekorful.be"}},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+}
+
 func TestDaemon_GetDomains(t *testing.T) {
 	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
 
@@ -658,3 +2196,253 @@ func TestDaemon_GetDomains(t *testing.T) {
 
 	// TODO assert on domains
 }
+
+func TestDaemon_EffectiveTTL(t *testing.T) {
+	d := daemon{
+		config: config.DaemonConfig{
+			DefaultTTL: 3600,
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name: "dummy",
+					Domains: []config.DomainConfig{
+						{Domain: "example.org", TTL: 60},
+						{Domain: "dydns.org", Host: "demo", TTL: 300},
+						{Domain: "creekorful.fr"},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		alias database.Alias
+		ttl   int
+	}{
+		{database.Alias{Host: "foo", Domain: "example.org"}, 60},
+		{database.Alias{Host: "foo", Domain: "demo.dydns.org"}, 300},
+		{database.Alias{Host: "foo", Domain: "creekorful.fr"}, 3600},
+		{database.Alias{Host: "foo", Domain: "example.org", TTL: 120}, 120},
+	}
+
+	for _, test := range tests {
+		if ttl := d.effectiveTTL(test.alias); ttl != test.ttl {
+			t.Errorf("wrong TTL for %s.%s: %d", test.alias.Host, test.alias.Domain, ttl)
+		}
+	}
+}
+
+func TestDaemon_ToAliasDto_DNSSEC(t *testing.T) {
+	d := daemon{
+		config: config.DaemonConfig{
+			DefaultTTL: 3600,
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name: "dummy",
+					Domains: []config.DomainConfig{
+						{Domain: "example.org", DNSSEC: true},
+						{Domain: "creekorful.fr"},
+					},
+				},
+			},
+		},
+	}
+
+	if dto := d.toAliasDto(database.Alias{Host: "foo", Domain: "example.org"}); !dto.DNSSEC {
+		t.Error("foo.example.org zone should be DNSSEC signed")
+	}
+	if dto := d.toAliasDto(database.Alias{Host: "foo", Domain: "creekorful.fr"}); dto.DNSSEC {
+		t.Error("foo.creekorful.fr zone should not be DNSSEC signed")
+	}
+}
+
+func TestDaemon_UnmanagedDNSSECDomains(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name: "dummy",
+					Domains: []config.DomainConfig{
+						{Domain: "example.org", DNSSEC: true},
+						{Domain: "creekorful.fr"},
+					},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	// the mock doesn't implement dns.DNSSECManager
+	providerMock.EXPECT().GetProvisioner("dummy", gomock.Any()).Return(provisionerMock, nil)
+
+	domains := d.unmanagedDNSSECDomains()
+	if len(domains) != 1 || domains[0] != "example.org" {
+		t.Errorf("wrong unmanaged domains: %v", domains)
+	}
+}
+
+func TestDaemon_RegisterAlias_DomainDefaultTTL(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DefaultTTL: 3600,
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "example.org", TTL: 60}},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	dbMock.EXPECT().
+		FindAlias("test", "example.org").
+		Return(database.Alias{}, gorm.ErrRecordNotFound)
+
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+	provisionerMock.EXPECT().AddRecord("test", "example.org", "127.0.0.1", 60).Return(nil)
+
+	dbMock.EXPECT().
+		CreateAlias(database.Alias{Domain: "example.org", Host: "test", Value: "127.0.0.1", Enabled: true}, uint(1)).
+		Return(database.Alias{Domain: "example.org", Host: "test", Value: "127.0.0.1", UserID: 1}, nil)
+
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	r, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{
+		Domain: "test.example.org", Value: "127.0.0.1",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if r.TTL != 60 {
+		t.Errorf("alias should have inherited the domain TTL: %d", r.TTL)
+	}
+}
+
+func TestDaemon_GetEvents(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserEvents(uint(1), uint(12), maxEventsLimit).Return([]database.Event{
+		{Model: gorm.Model{ID: 13}, Type: proto.EventAliasCreated, Alias: "foo.example.org", Value: "127.0.0.1", UserID: 1},
+		{Model: gorm.Model{ID: 15}, Type: proto.EventAliasDeleted, Alias: "foo.example.org", PreviousValue: "127.0.0.1", UserID: 1},
+	}, nil)
+
+	events, err := d.GetEvents(proto.UserContext{UserID: 1}, 12, 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatal("wrong number of events returned")
+	}
+	if events[0].ID != 13 || events[0].Type != proto.EventAliasCreated || events[0].Value != "127.0.0.1" {
+		t.Errorf("wrong event returned: %v", events[0])
+	}
+	if events[1].ID != 15 || events[1].Type != proto.EventAliasDeleted || events[1].Value != "" || events[1].PreviousValue != "127.0.0.1" {
+		t.Errorf("wrong event returned: %v", events[1])
+	}
+}
+
+func TestDaemon_CheckDNSProvisioners(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{Name: "dummy", Config: map[string]string{"key": "value"}, Domains: []config.DomainConfig{{Domain: "example.org"}}},
+				{Name: "unknown", Domains: []config.DomainConfig{{Domain: "creekorful.fr"}}},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{"key": "value"}).Return(provisionerMock, nil)
+	providerMock.EXPECT().GetProvisioner("unknown", gomock.Any()).Return(nil, errors.New("no provisioner named unknown found"))
+
+	if err := d.checkDNSProvisioners(); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("checkDNSProvisioners() should have failed because of the unknown provisioner: %v", err)
+	}
+
+	// the configuration of the valid one is fine
+	d.config.DNSProvisioners = d.config.DNSProvisioners[:1]
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{"key": "value"}).Return(provisionerMock, nil)
+
+	if err := d.checkDNSProvisioners(); err != nil {
+		t.Errorf("checkDNSProvisioners() should have succeeded: %s", err)
+	}
+
+	// the embedded provisioner is backed by the embedded DNS server
+	d.config.DNSProvisioners = []config.DNSProvisionerConfig{
+		{Name: dns.EmbeddedProvisionerName, Domains: []config.DomainConfig{{Domain: "example.org"}}},
+	}
+	providerMock.EXPECT().GetProvisioner(dns.EmbeddedProvisionerName, gomock.Any()).Return(provisionerMock, nil).Times(2)
+
+	if err := d.checkDNSProvisioners(); err == nil || !strings.Contains(err.Error(), "embedded DNS server") {
+		t.Errorf("checkDNSProvisioners() should have failed because the embedded DNS server is disabled: %v", err)
+	}
+
+	d.config.DNSServer = config.DNSServerConfig{ListenAddr: ":5353", Nameservers: []string{"ns1.example.org"}}
+	if err := d.checkDNSProvisioners(); err != nil {
+		t.Errorf("checkDNSProvisioners() should have succeeded: %s", err)
+	}
+
+	// the aliases cannot be registered without domain
+	d.config.DNSProvisioners = []config.DNSProvisionerConfig{{Name: "dummy"}}
+	providerMock.EXPECT().GetProvisioner("dummy", gomock.Any()).Return(provisionerMock, nil)
+
+	if err := d.checkDNSProvisioners(); err == nil || !strings.Contains(err.Error(), "no domain") {
+		t.Errorf("checkDNSProvisioners() should have failed because no domain is managed: %v", err)
+	}
+
+	// nor without provisioner
+	d.config.DNSProvisioners = nil
+	if err := d.checkDNSProvisioners(); err == nil || !strings.Contains(err.Error(), "no DNS provisioner") {
+		t.Errorf("checkDNSProvisioners() should have failed because no provisioner is configured: %v", err)
+	}
+}
+
+func TestNewDaemon_InvalidDNSProvisioner(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	c := config.DefaultConfig
+	c.DatabaseConfig.DSN = ":memory:"
+	c.DaemonConfig.DNSProvisioners = []config.DNSProvisionerConfig{
+		{Name: "ovh", Config: map[string]string{"endpoint": "ovh-eu"}}, // missing credentials
+	}
+
+	if _, err := NewDaemon(c, &logger); err == nil {
+		t.Error("NewDaemon() should have refused the incomplete provisioner configuration")
+	}
+}
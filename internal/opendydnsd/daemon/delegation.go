@@ -0,0 +1,123 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/common"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/proto"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nsResolver return the nameservers of a domain
+type nsResolver interface {
+	LookupNS(name string) ([]string, error)
+}
+
+// delegationChecker keep the result of the last delegation check of the managed domains
+type delegationChecker struct {
+	resolver nsResolver
+	mutex    sync.RWMutex
+	results  []proto.DelegationDto
+}
+
+// checkDelegation determinate if given domain is delegated to the expected nameservers
+// any nameserver is accepted if none are expected
+func checkDelegation(resolver nsResolver, domainConf config.DomainConfig) proto.DelegationDto {
+	result := proto.DelegationDto{Domain: domainConf.Domain, CheckedAt: time.Now()}
+
+	nameservers, err := resolver.LookupNS(domainConf.Domain)
+	if err != nil && !errors.Is(err, common.ErrNameNotFound) {
+		result.Status = proto.DelegationError
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Nameservers = nameservers
+	if len(nameservers) == 0 {
+		result.Status = proto.DelegationMissing
+		return result
+	}
+
+	result.Status = proto.DelegationOK
+	if len(domainConf.Nameservers) == 0 {
+		return result
+	}
+
+	for _, nameserver := range nameservers {
+		if !matchNameserver(domainConf.Nameservers, nameserver) {
+			result.Status = proto.DelegationMismatch
+			break
+		}
+	}
+
+	return result
+}
+
+// matchNameserver determinate if given nameserver matches one of the expected ones
+func matchNameserver(expected []string, nameserver string) bool {
+	nameserver = strings.ToLower(strings.TrimSuffix(nameserver, "."))
+	for _, pattern := range expected {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if ok, _ := path.Match(pattern, nameserver); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDelegations check the delegation of each managed domain and log the misconfigured ones
+func (d *daemon) checkDelegations() {
+	var results []proto.DelegationDto
+
+	for _, dnsProvisioner := range d.config.DNSProvisioners {
+		for _, domainConf := range dnsProvisioner.Domains {
+			result := checkDelegation(d.delegation.resolver, domainConf)
+			results = append(results, result)
+
+			if result.Status != proto.DelegationOK {
+				d.logger.Warn().
+					Str("Domain", result.Domain).
+					Str("Status", result.Status).
+					Strs("Nameservers", result.Nameservers).
+					Str("Error", result.Error).
+					Msg("domain is not delegated as expected. its aliases may not be published.")
+			}
+		}
+	}
+
+	d.delegation.mutex.Lock()
+	d.delegation.results = results
+	d.delegation.mutex.Unlock()
+}
+
+func (d *daemon) checkDelegationsPeriodically(interval time.Duration) {
+	d.checkDelegations()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.checkDelegations()
+	}
+}
+
+// GetDelegations return the result of the last delegation check (admin only)
+// nothing is returned if the check is disabled
+func (d *daemon) GetDelegations(userCtx proto.UserContext) ([]proto.DelegationDto, error) {
+	if err := d.checkAdmin(userCtx); err != nil {
+		return nil, err
+	}
+
+	if d.delegation == nil {
+		return []proto.DelegationDto{}, nil
+	}
+
+	d.delegation.mutex.RLock()
+	defer d.delegation.mutex.RUnlock()
+
+	return append([]proto.DelegationDto{}, d.delegation.results...), nil
+}
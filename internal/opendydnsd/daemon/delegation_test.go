@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/common"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"testing"
+)
+
+type mockNSResolver map[string][]string
+
+func (m mockNSResolver) LookupNS(name string) ([]string, error) {
+	if name == "broken.org" {
+		return nil, errors.New("i/o timeout")
+	}
+
+	nameservers, exist := m[name]
+	if !exist {
+		return nil, common.ErrNameNotFound
+	}
+	return nameservers, nil
+}
+
+func TestDaemon_CheckDelegations(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	ovhNameservers := []string{"*.ovh.net"}
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name: "ovh",
+					Domains: []config.DomainConfig{
+						{Domain: "example.org", Nameservers: ovhNameservers},
+						{Domain: "example.net", Nameservers: ovhNameservers},
+						{Domain: "unknown.org"},
+						{Domain: "broken.org"},
+						{Domain: "any.org"},
+					},
+				},
+			},
+		},
+		delegation: &delegationChecker{resolver: mockNSResolver{
+			"example.org": {"dns10.ovh.net", "NS10.OVH.NET."},
+			"example.net": {"dns10.ovh.net", "ns1.example.com"},
+			"any.org":     {"ns1.example.com"},
+		}},
+	}
+
+	d.checkDelegations()
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Admin: true}, nil)
+
+	delegations, err := d.GetDelegations(proto.UserContext{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"example.org": proto.DelegationOK,
+		"example.net": proto.DelegationMismatch,
+		"unknown.org": proto.DelegationMissing,
+		"broken.org":  proto.DelegationError,
+		"any.org":     proto.DelegationOK,
+	}
+	if len(delegations) != len(expected) {
+		t.Fatalf("wrong number of delegations: %d", len(delegations))
+	}
+	for _, delegation := range delegations {
+		if delegation.Status != expected[delegation.Domain] {
+			t.Errorf("wrong status for %s: %s", delegation.Domain, delegation.Status)
+		}
+		if delegation.CheckedAt.IsZero() {
+			t.Errorf("missing check time for %s", delegation.Domain)
+		}
+	}
+}
+
+func TestDaemon_GetDelegations_Disabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Admin: false}, nil)
+	if _, err := d.GetDelegations(proto.UserContext{UserID: 1}); err != proto.ErrForbidden {
+		t.Errorf("GetDelegations() should have returned ErrForbidden")
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Admin: true}, nil)
+	delegations, err := d.GetDelegations(proto.UserContext{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delegations) != 0 {
+		t.Errorf("no delegations should have been returned: %v", delegations)
+	}
+}
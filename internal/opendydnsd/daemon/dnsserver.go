@@ -0,0 +1,236 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	mdns "github.com/miekg/dns"
+	"gorm.io/gorm"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the timers (in seconds) of the SOA records of the zones served by the embedded DNS server
+// the zones are not transferred: they only matter to the monitoring tools
+const (
+	soaRefresh = 3600
+	soaRetry   = 600
+	soaExpire  = 604800
+	// defaultZoneTTL is the TTL of the SOA & NS records if neither the domain nor the daemon define one
+	defaultZoneTTL = 3600
+)
+
+// StartDNSServer start the embedded authoritative DNS server on the configured address (UDP & TCP)
+// the returned function shuts it down
+func (d *daemon) StartDNSServer() (func() error, error) {
+	addr := d.config.DNSServer.ListenAddr
+
+	udpConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
+	}
+
+	var started sync.WaitGroup
+	handler := mdns.HandlerFunc(d.serveDNS)
+	servers := []*mdns.Server{
+		{PacketConn: udpConn, Handler: handler, NotifyStartedFunc: started.Done},
+		{Listener: tcpListener, Handler: handler, NotifyStartedFunc: started.Done},
+	}
+
+	started.Add(len(servers))
+	for _, server := range servers {
+		go func(server *mdns.Server) {
+			if err := server.ActivateAndServe(); err != nil {
+				d.logger.Err(err).Str("Addr", addr).Msg("embedded DNS server stopped.")
+			}
+		}(server)
+	}
+	started.Wait()
+
+	return func() error {
+		var err error
+		for _, server := range servers {
+			if e := server.Shutdown(); e != nil {
+				err = e
+			}
+		}
+		return err
+	}, nil
+}
+
+func (d *daemon) serveDNS(w mdns.ResponseWriter, r *mdns.Msg) {
+	if err := w.WriteMsg(d.answerQuery(r)); err != nil {
+		d.logger.Debug().Str("Error", err.Error()).Msg("unable to answer the DNS query.")
+	}
+}
+
+// answerQuery answer given query using the aliases of the database
+// the queries for the names outside of the managed domains are refused
+func (d *daemon) answerQuery(r *mdns.Msg) *mdns.Msg {
+	m := new(mdns.Msg)
+	m.SetReply(r)
+
+	if r.Opcode != mdns.OpcodeQuery {
+		m.SetRcode(r, mdns.RcodeNotImplemented)
+		return m
+	}
+	if len(r.Question) != 1 {
+		m.SetRcode(r, mdns.RcodeFormatError)
+		return m
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+
+	domainConf, exist := d.findDomainConfig(name)
+	if !exist {
+		m.SetRcode(r, mdns.RcodeRefused)
+		return m
+	}
+	m.Authoritative = true
+
+	zone := strings.ToLower(domainConf.String())
+	ttl := uint32(defaultZoneTTL)
+	if t := domainConf.TTL; t != 0 {
+		ttl = uint32(t)
+	} else if t := d.config.DefaultTTL; t != 0 {
+		ttl = uint32(t)
+	}
+	soa := d.soaRecord(zone, ttl)
+
+	var answers []mdns.RR
+	if name == zone {
+		ns := d.nsRecords(zone, ttl)
+		switch q.Qtype {
+		case mdns.TypeSOA:
+			answers = []mdns.RR{soa}
+		case mdns.TypeNS:
+			answers = ns
+		case mdns.TypeANY:
+			answers = append([]mdns.RR{soa}, ns...)
+		}
+	} else {
+		records, err := d.aliasRecords(name)
+		if err == gorm.ErrRecordNotFound {
+			m.SetRcode(r, mdns.RcodeNameError)
+			m.Ns = []mdns.RR{soa}
+			return m
+		}
+		if err != nil {
+			d.logger.Err(err).Str("Name", name).Msg("error while fetching database.")
+			m.SetRcode(r, mdns.RcodeServerFailure)
+			return m
+		}
+
+		for _, rr := range records {
+			if q.Qtype == mdns.TypeANY || rr.Header().Rrtype == q.Qtype {
+				answers = append(answers, rr)
+			}
+		}
+	}
+
+	// no record of the requested type
+	if len(answers) == 0 {
+		m.Ns = []mdns.RR{soa}
+	}
+	m.Answer = answers
+
+	return m
+}
+
+// aliasRecords return the records of the alias of given name, gorm.ErrRecordNotFound if there is none
+// the disabled aliases are not published
+func (d *daemon) aliasRecords(name string) ([]mdns.RR, error) {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	alias, err := d.conn.FindAlias(parts[0], parts[1])
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if !alias.Enabled {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return d.addressRecords(name, alias), nil
+}
+
+// addressRecords return the A & AAAA records publishing the addresses of given alias
+func (d *daemon) addressRecords(name string, alias database.Alias) []mdns.RR {
+	hdr := mdns.RR_Header{Name: mdns.Fqdn(name), Class: mdns.ClassINET, Ttl: uint32(d.effectiveTTL(alias))}
+
+	var records []mdns.RR
+	if ip := net.ParseIP(alias.Value).To4(); ip != nil {
+		h := hdr
+		h.Rrtype = mdns.TypeA
+		records = append(records, &mdns.A{Hdr: h, A: ip})
+	}
+	if ip := net.ParseIP(alias.Value6); ip != nil {
+		h := hdr
+		h.Rrtype = mdns.TypeAAAA
+		records = append(records, &mdns.AAAA{Hdr: h, AAAA: ip})
+	}
+
+	return records
+}
+
+// soaRecord return the SOA record of given zone
+// the serial is the current time: the records are always the latest ones
+func (d *daemon) soaRecord(zone string, ttl uint32) mdns.RR {
+	conf := d.config.DNSServer
+
+	primary := "ns." + zone
+	if len(conf.Nameservers) > 0 {
+		primary = conf.Nameservers[0]
+	}
+
+	return &mdns.SOA{
+		Hdr:     mdns.RR_Header{Name: mdns.Fqdn(zone), Rrtype: mdns.TypeSOA, Class: mdns.ClassINET, Ttl: ttl},
+		Ns:      mdns.Fqdn(primary),
+		Mbox:    hostmasterMbox(conf.Hostmaster, zone),
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: soaRefresh,
+		Retry:   soaRetry,
+		Expire:  soaExpire,
+		Minttl:  uint32(conf.GetNegativeTTL()),
+	}
+}
+
+// nsRecords return the NS records of given zone
+func (d *daemon) nsRecords(zone string, ttl uint32) []mdns.RR {
+	var records []mdns.RR
+	for _, ns := range d.config.DNSServer.Nameservers {
+		records = append(records, &mdns.NS{
+			Hdr: mdns.RR_Header{Name: mdns.Fqdn(zone), Rrtype: mdns.TypeNS, Class: mdns.ClassINET, Ttl: ttl},
+			Ns:  mdns.Fqdn(ns),
+		})
+	}
+	return records
+}
+
+// hostmasterMbox return the SOA mailbox of given email address (e.g. hostmaster.example.org.)
+// the dots of the local part are escaped, defaults to hostmaster@<zone>
+func hostmasterMbox(email, zone string) string {
+	if email == "" {
+		return mdns.Fqdn("hostmaster." + zone)
+	}
+
+	parts := strings.SplitN(email, "@", 2)
+	if len(parts) != 2 {
+		return mdns.Fqdn(email)
+	}
+
+	return mdns.Fqdn(fmt.Sprintf("%s.%s", strings.ReplaceAll(parts[0], ".", `\.`), parts[1]))
+}
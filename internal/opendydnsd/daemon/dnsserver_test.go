@@ -0,0 +1,125 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/golang/mock/gomock"
+	mdns "github.com/miekg/dns"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func records(rrs []mdns.RR) []string {
+	var s []string
+	for _, rr := range rrs {
+		s = append(s, rr.String())
+	}
+	return s
+}
+
+func TestDaemon_AnswerQuery(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DefaultTTL: 3600,
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "embedded",
+					Domains: []config.DomainConfig{{Domain: "example.org", TTL: 300, MaxLabels: 2}},
+				},
+			},
+			DNSServer: config.DNSServerConfig{
+				ListenAddr:  ":53",
+				Nameservers: []string{"ns1.example.net", "ns2.example.net."},
+				Hostmaster:  "john.doe@example.net",
+			},
+		},
+	}
+
+	query := func(name string, qtype uint16) *mdns.Msg {
+		m := new(mdns.Msg)
+		m.SetQuestion(name, qtype)
+		return d.answerQuery(m)
+	}
+
+	dual := database.Alias{Host: "home", Domain: "example.org", Value: "192.0.2.1", Value6: "2001:db8::1", Enabled: true}
+	dbMock.EXPECT().FindAlias("home", "example.org").Return(dual, nil).Times(3)
+
+	m := query("Home.Example.org.", mdns.TypeA)
+	if !m.Authoritative || m.Rcode != mdns.RcodeSuccess {
+		t.Errorf("wrong answer: %v", m)
+	}
+	if r := records(m.Answer); !reflect.DeepEqual(r, []string{"home.example.org.\t300\tIN\tA\t192.0.2.1"}) {
+		t.Errorf("wrong records: %v", r)
+	}
+
+	m = query("home.example.org.", mdns.TypeAAAA)
+	if r := records(m.Answer); !reflect.DeepEqual(r, []string{"home.example.org.\t300\tIN\tAAAA\t2001:db8::1"}) {
+		t.Errorf("wrong records: %v", r)
+	}
+
+	// no record of the requested type
+	m = query("home.example.org.", mdns.TypeMX)
+	if m.Rcode != mdns.RcodeSuccess || len(m.Answer) != 0 || len(m.Ns) != 1 || m.Ns[0].Header().Rrtype != mdns.TypeSOA {
+		t.Errorf("wrong answer: %v", m)
+	}
+
+	// the alias TTL is used, if any
+	dbMock.EXPECT().FindAlias("nas", "lab.example.org").
+		Return(database.Alias{Host: "nas", Domain: "lab.example.org", Value6: "2001:db8::2", TTL: 60, Enabled: true}, nil)
+	m = query("nas.lab.example.org.", mdns.TypeANY)
+	if r := records(m.Answer); !reflect.DeepEqual(r, []string{"nas.lab.example.org.\t60\tIN\tAAAA\t2001:db8::2"}) {
+		t.Errorf("wrong records: %v", r)
+	}
+
+	// unknown & disabled aliases do not exist
+	dbMock.EXPECT().FindAlias("foo", "example.org").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindAlias("off", "example.org").Return(database.Alias{Host: "off", Value: "192.0.2.3"}, nil)
+	for _, name := range []string{"foo.example.org.", "off.example.org."} {
+		if m := query(name, mdns.TypeA); m.Rcode != mdns.RcodeNameError || len(m.Answer) != 0 || len(m.Ns) != 1 {
+			t.Errorf("wrong answer: %v", m)
+		}
+	}
+
+	// the zone apex
+	m = query("example.org.", mdns.TypeSOA)
+	soa, ok := m.Answer[0].(*mdns.SOA)
+	if !ok || soa.Ns != "ns1.example.net." || soa.Mbox != `john\.doe.example.net.` || soa.Minttl != 60 || soa.Hdr.Ttl != 300 {
+		t.Errorf("wrong SOA record: %v", m.Answer)
+	}
+
+	m = query("example.org.", mdns.TypeNS)
+	expected := []string{"example.org.\t300\tIN\tNS\tns1.example.net.", "example.org.\t300\tIN\tNS\tns2.example.net."}
+	if r := records(m.Answer); !reflect.DeepEqual(r, expected) {
+		t.Errorf("wrong records: %v", r)
+	}
+
+	// the other domains are refused
+	if m := query("example.com.", mdns.TypeA); m.Rcode != mdns.RcodeRefused || m.Authoritative {
+		t.Errorf("wrong answer: %v", m)
+	}
+}
+
+func TestHostmasterMbox(t *testing.T) {
+	for email, expected := range map[string]string{
+		"":                     "hostmaster.example.org.",
+		"admin@example.net":    "admin.example.net.",
+		"john.doe@example.net": `john\.doe.example.net.`,
+	} {
+		if mbox := hostmasterMbox(email, "example.org"); mbox != expected {
+			t.Errorf("wrong mailbox for %s: %s", email, mbox)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxFollowDepth is the maximum number of aliases following each other
+const maxFollowDepth = 8
+
+// addrResolver return the addresses of a name
+type addrResolver interface {
+	LookupA(name string) ([]string, error)
+	LookupAAAA(name string) ([]string, error)
+}
+
+// resolveFollow return the address of the followed name, preferring IPv4
+// the lowest address is picked to keep the value stable across resolutions
+func (d *daemon) resolveFollow(name string) (string, error) {
+	for _, lookup := range []func(string) ([]string, error){d.followResolver.LookupA, d.followResolver.LookupAAAA} {
+		addresses, err := lookup(name)
+		if err != nil && !errors.Is(err, common.ErrNameNotFound) {
+			return "", err
+		}
+
+		if len(addresses) > 0 {
+			sort.Strings(addresses)
+			return addresses[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("%s: %w", name, common.ErrNameNotFound)
+}
+
+// prepareFollow make sure the alias can follow the wanted name
+// and resolve its value if none is given
+func (d *daemon) prepareFollow(alias *proto.AliasDto) error {
+	if alias.Follow == "" {
+		return nil
+	}
+
+	if d.followResolver == nil {
+		d.logger.Warn().Str("Domain", alias.Domain).Msg("following another name is not enabled.")
+		return proto.ErrFollowDisabled
+	}
+
+	alias.Follow = strings.ToLower(strings.TrimSuffix(alias.Follow, "."))
+	if err := d.checkFollowLoop(strings.ToLower(alias.Domain), alias.Follow); err != nil {
+		return err
+	}
+
+	if alias.Value != "" || alias.Value6 != "" {
+		return nil
+	}
+
+	value, err := d.resolveFollow(alias.Follow)
+	if err != nil {
+		d.logger.Warn().Str("Follow", alias.Follow).Str("Error", err.Error()).Msg("unable to resolve followed name.")
+		return proto.ErrFollowUnresolvable
+	}
+	alias.Value = value
+
+	return nil
+}
+
+// checkFollowLoop walk the chain of the managed aliases followed by the alias
+// and fail if it leads back to the alias or is too long
+func (d *daemon) checkFollowLoop(name, follow string) error {
+	for depth := 0; depth < maxFollowDepth; depth++ {
+		if follow == name {
+			d.logger.Warn().Str("Domain", name).Msg("the followed name leads back to the alias.")
+			return proto.ErrFollowLoop
+		}
+
+		a := newAlias(proto.AliasDto{Domain: follow})
+		followed, err := d.conn.FindAlias(a.Host, a.Domain)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // not a managed alias: nothing to walk
+		}
+		if err != nil {
+			d.logger.Err(err).Msg("error while fetching database.")
+			return err
+		}
+
+		if followed.Follow == "" {
+			return nil
+		}
+		follow = followed.Follow
+	}
+
+	d.logger.Warn().Str("Domain", name).Msg("too many aliases following each other.")
+	return proto.ErrFollowLoop
+}
+
+// refreshFollowingAliases resolve the names followed by the aliases
+// and update the aliases whose value has changed. On failure, the last value is kept
+func (d *daemon) refreshFollowingAliases() {
+	aliases, err := d.conn.FindFollowingAliases()
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching the following aliases.")
+		return
+	}
+
+	for _, alias := range aliases {
+		name := fmt.Sprintf("%s.%s", alias.Host, alias.Domain)
+
+		value, err := d.resolveFollow(alias.Follow)
+		if err != nil {
+			d.logger.Warn().
+				Str("Domain", name).
+				Str("Follow", alias.Follow).
+				Str("Error", err.Error()).
+				Msg("unable to resolve followed name. keeping the current value.")
+			continue
+		}
+
+		if value == aliasAddress(alias) {
+			continue
+		}
+
+		if _, err := d.UpdateAlias(proto.UserContext{UserID: alias.UserID}, proto.AliasDto{
+			Domain: name,
+			Value:  value,
+			Follow: alias.Follow,
+			// do not overwrite a change made since the resolution
+			Version: alias.Version,
+		}); err != nil {
+			d.logger.Err(err).Str("Domain", name).Str("Follow", alias.Follow).Msg("error while updating following alias.")
+		}
+	}
+}
+
+func (d *daemon) refreshFollowingAliasesPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.refreshFollowingAliases()
+	}
+}
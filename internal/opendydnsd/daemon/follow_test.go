@@ -0,0 +1,258 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/common"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"testing"
+)
+
+type mockAddrResolver map[string][]string
+
+func (m mockAddrResolver) LookupA(name string) ([]string, error) {
+	return m.lookup(name, false)
+}
+
+func (m mockAddrResolver) LookupAAAA(name string) ([]string, error) {
+	return m.lookup(name, true)
+}
+
+func (m mockAddrResolver) lookup(name string, ipv6 bool) ([]string, error) {
+	if name == "broken.example.net" {
+		return nil, errors.New("i/o timeout")
+	}
+
+	addresses, exist := m[name]
+	if !exist {
+		return nil, common.ErrNameNotFound
+	}
+
+	var values []string
+	for _, address := range addresses {
+		if ipv6 == (len(address) > 15) {
+			values = append(values, address)
+		}
+	}
+	return values, nil
+}
+
+func TestDaemon_ResolveFollow(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	d := daemon{logger: &logger, followResolver: mockAddrResolver{
+		"both.example.net": {"2001:db8:0:0:0:0:0:1", "192.0.2.20", "192.0.2.10"},
+		"ipv6.example.net": {"2001:db8:0:0:0:0:0:2"},
+	}}
+
+	for name, expected := range map[string]string{
+		"both.example.net": "192.0.2.10",
+		"ipv6.example.net": "2001:db8:0:0:0:0:0:2",
+	} {
+		value, err := d.resolveFollow(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Errorf("wrong value for %s: %s", name, value)
+		}
+	}
+
+	if _, err := d.resolveFollow("unknown.example.net"); !errors.Is(err, common.ErrNameNotFound) {
+		t.Errorf("resolveFollow() should have returned ErrNameNotFound: %v", err)
+	}
+	if _, err := d.resolveFollow("broken.example.net"); err == nil {
+		t.Error("resolveFollow() should have failed")
+	}
+}
+
+func TestDaemon_RegisterAlias_Follow(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "bar.baz"}},
+				},
+			},
+		},
+		dnsProvider:    providerMock,
+		followResolver: mockAddrResolver{"home.example.net": {"192.0.2.1"}},
+	}
+
+	dbMock.EXPECT().FindAlias("home", "example.net").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "192.0.2.1", 0).Return(nil)
+	dbMock.EXPECT().
+		CreateAlias(database.Alias{Host: "foo", Domain: "bar.baz", Value: "192.0.2.1", Enabled: true, Follow: "home.example.net"}, uint(1)).
+		DoAndReturn(func(alias database.Alias, userID uint) (database.Alias, error) {
+			alias.UserID = userID
+			return alias, nil
+		})
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	alias, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Follow: "Home.example.net."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.Value != "192.0.2.1" || alias.Follow != "home.example.net" {
+		t.Errorf("wrong alias registered: %v", alias)
+	}
+}
+
+func TestDaemon_RegisterAlias_FollowInvalid(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock}
+
+	userCtx := proto.UserContext{UserID: 1}
+	if _, err := d.RegisterAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Follow: "home.example.net"}); err != proto.ErrFollowDisabled {
+		t.Errorf("RegisterAlias() should have returned ErrFollowDisabled: %v", err)
+	}
+
+	d.followResolver = mockAddrResolver{}
+
+	// following itself
+	if _, err := d.RegisterAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Follow: "FOO.bar.baz"}); err != proto.ErrFollowLoop {
+		t.Errorf("RegisterAlias() should have returned ErrFollowLoop: %v", err)
+	}
+
+	// following an alias which follows it back
+	dbMock.EXPECT().
+		FindAlias("other", "bar.baz").
+		Return(database.Alias{Host: "other", Domain: "bar.baz", Follow: "foo.bar.baz"}, nil)
+	if _, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Follow: "other.bar.baz"}); err != proto.ErrFollowLoop {
+		t.Errorf("UpdateAlias() should have returned ErrFollowLoop: %v", err)
+	}
+
+	// aliases following each other endlessly
+	dbMock.EXPECT().
+		FindAlias("other", "bar.baz").
+		Return(database.Alias{Host: "other", Domain: "bar.baz", Follow: "other.bar.baz"}, nil).
+		Times(maxFollowDepth)
+	if _, err := d.RegisterAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Follow: "other.bar.baz"}); err != proto.ErrFollowLoop {
+		t.Errorf("RegisterAlias() should have returned ErrFollowLoop: %v", err)
+	}
+
+	// nothing to copy
+	dbMock.EXPECT().FindAlias("home", "example.net").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	if _, err := d.RegisterAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Follow: "home.example.net"}); err != proto.ErrFollowUnresolvable {
+		t.Errorf("RegisterAlias() should have returned ErrFollowUnresolvable: %v", err)
+	}
+}
+
+func TestDaemon_RefreshFollowingAliases(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	resolver := mockAddrResolver{"home.example.net": {"192.0.2.1"}}
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "bar.baz"}},
+				},
+			},
+		},
+		dnsProvider:    providerMock,
+		followResolver: resolver,
+	}
+
+	alias := database.Alias{
+		Model:   gorm.Model{ID: 42},
+		Host:    "foo",
+		Domain:  "bar.baz",
+		Value:   "192.0.2.1",
+		UserID:  1,
+		Enabled: true,
+		Follow:  "home.example.net",
+	}
+	broken := database.Alias{Model: gorm.Model{ID: 43}, Host: "broken", Domain: "bar.baz", Value: "127.0.0.1", UserID: 2, Enabled: true, Follow: "broken.example.net"}
+
+	// followed name unchanged & resolution failure: nothing to do
+	dbMock.EXPECT().FindFollowingAliases().Return([]database.Alias{alias, broken}, nil)
+	d.refreshFollowingAliases()
+
+	// the followed name has changed: the new address is propagated
+	resolver["home.example.net"] = []string{"198.51.100.7"}
+
+	dbMock.EXPECT().FindFollowingAliases().Return([]database.Alias{alias, broken}, nil)
+	dbMock.EXPECT().FindAlias("home", "example.net").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "198.51.100.7", 0).Return(nil)
+
+	updated := alias
+	updated.Value = "198.51.100.7"
+	dbMock.EXPECT().UpdateAlias(updated).Return(updated, nil)
+	dbMock.EXPECT().CreateEvent(database.Event{
+		Type:          proto.EventAliasUpdated,
+		Alias:         "foo.bar.baz",
+		Value:         "198.51.100.7",
+		PreviousValue: "192.0.2.1",
+		UserID:        1,
+	}).Return(database.Event{}, nil)
+
+	d.refreshFollowingAliases()
+}
+
+func TestDaemon_UpdateAlias_StopFollowing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock, followResolver: mockAddrResolver{}}
+
+	// same value: only the source changes
+	alias := database.Alias{Host: "foo", Domain: "bar.baz", Value: "192.0.2.1", UserID: 1, Follow: "home.example.net"}
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	dbMock.EXPECT().UpdateAlias(gomock.Any()).DoAndReturn(func(alias database.Alias) (database.Alias, error) {
+		if alias.Follow != "" {
+			t.Errorf("alias should not follow any name: %v", alias)
+		}
+		return alias, nil
+	})
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	updated, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Follow != "" {
+		t.Errorf("alias should not follow any name: %v", updated)
+	}
+}
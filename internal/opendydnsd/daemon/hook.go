@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// maxHookOutput is the maximum length of the hook output written to the logs
+const maxHookOutput = 4096
+
+// hookEnv return the environment variables describing the change
+// the values are sanitized since they end up in operator scripts
+func hookEnv(event AliasChanged) []string {
+	return []string{
+		"OPENDYDNS_EVENT=" + sanitizeHookValue(event.Type),
+		"OPENDYDNS_ALIAS=" + sanitizeHookValue(event.Alias),
+		"OPENDYDNS_VALUE=" + sanitizeHookValue(event.Value),
+		"OPENDYDNS_PREVIOUS_VALUE=" + sanitizeHookValue(event.PreviousValue),
+		"OPENDYDNS_PREVIOUS_ALIAS=" + sanitizeHookValue(event.PreviousAlias),
+		fmt.Sprintf("OPENDYDNS_TTL=%d", event.TTL),
+		fmt.Sprintf("OPENDYDNS_USER_ID=%d", event.UserID),
+	}
+}
+
+// sanitizeHookValue drop the characters which cannot be part of an alias name,
+// an IP address or an event type
+func sanitizeHookValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == ':', r == '-', r == '_':
+			return r
+		}
+		return -1
+	}, value)
+}
+
+// runHook run the configured command, as a subscriber of the event bus
+// the runs are therefore serialized
+func (d *daemon) runHook(event AliasChanged) {
+	if err := d.execHook(event); err != nil {
+		d.logger.Warn().
+			Str("Event", event.Type).
+			Str("Alias", event.Alias).
+			Str("Error", err.Error()).
+			Msg("error while running hook.")
+	}
+}
+
+// execHook run the configured command with the change details as environment variables
+func (d *daemon) execHook(event AliasChanged) error {
+	output, err := execCommand(d.config.Hook, hookEnv(event))
+	if err != nil {
+		return err
+	}
+
+	d.logger.Debug().
+		Str("Event", event.Type).
+		Str("Alias", event.Alias).
+		Str("Output", output).
+		Msg("hook successfully run.")
+
+	return nil
+}
+
+// execCommand run the command of given hook with given environment variables and return its output
+// the command is killed once the timeout is reached
+func execCommand(hook config.HookConfig, env []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.GetTimeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	// the daemon environment is not forwarded since it may contain secrets
+	cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, env...)
+
+	// the output is written to a file rather than a pipe: otherwise waiting for the
+	// command would also wait for the processes it started, even once killed
+	out, err := ioutil.TempFile("", "opendydns-hook")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+
+	output, _ := ioutil.ReadFile(out.Name())
+	if len(output) > maxHookOutput {
+		output = output[:maxHookOutput]
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("hook timed out after %s", hook.GetTimeout())
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
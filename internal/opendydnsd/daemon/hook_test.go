@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemon_UpdateAlias_Hook(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	dir, err := ioutil.TempDir("", "opendydns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "env")
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DefaultTTL: 300,
+			Hook:       config.HookConfig{Command: "/bin/sh", Args: []string{"-c", "env > " + output}},
+		},
+		bus: newEventBus(&logger),
+	}
+	defer d.Subscribe("hook", d.runHook)()
+	provisionerMock := useProvisionerMock(&d, mockCtrl, "bar.baz")
+
+	dbMock.EXPECT().
+		FindAlias("foo", "bar.baz").
+		Return(database.Alias{Domain: "bar.baz", Host: "foo", Value: "127.0.0.1", UserID: 1, Enabled: true}, nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8", 300).Return(nil)
+	dbMock.EXPECT().UpdateAlias(gomock.Any()).DoAndReturn(func(alias database.Alias) (database.Alias, error) {
+		return alias, nil
+	})
+	dbMock.EXPECT().CreateEvent(gomock.Any()).Return(database.Event{}, nil)
+
+	if _, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hook is run in the background
+	var env []byte
+	for i := 0; i < 100 && len(env) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		env, _ = ioutil.ReadFile(output)
+	}
+
+	for _, variable := range []string{
+		"OPENDYDNS_EVENT=alias.updated",
+		"OPENDYDNS_ALIAS=foo.bar.baz",
+		"OPENDYDNS_VALUE=8.8.8.8",
+		"OPENDYDNS_PREVIOUS_VALUE=127.0.0.1",
+		"OPENDYDNS_TTL=300",
+		"OPENDYDNS_USER_ID=1",
+	} {
+		if !strings.Contains(string(env), variable+"\n") {
+			t.Errorf("missing %s in hook environment: %s", variable, env)
+		}
+	}
+}
+
+func TestDaemon_ExecHook_Timeout(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	d := daemon{
+		logger: &logger,
+		config: config.DaemonConfig{
+			Hook: config.HookConfig{Command: "/bin/sh", Args: []string{"-c", "sleep 5"}, Timeout: 50 * time.Millisecond},
+		},
+	}
+
+	start := time.Now()
+	if err := d.execHook(AliasChanged{Type: proto.EventAliasCreated}); err == nil {
+		t.Error("execHook() should have timed out")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("hook has not been killed")
+	}
+
+	d.config.Hook.Args = []string{"-c", "echo failure; exit 1"}
+	if err := d.execHook(AliasChanged{}); err == nil || !strings.Contains(err.Error(), "failure") {
+		t.Errorf("execHook() should have returned the hook output: %v", err)
+	}
+}
+
+func TestSanitizeHookValue(t *testing.T) {
+	tests := map[string]string{
+		"foo.example.org":       "foo.example.org",
+		"2001:db8::1":           "2001:db8::1",
+		"alias.updated":         "alias.updated",
+		"foo$(rm -rf /)\nbar;`": "foorm-rfbar",
+	}
+
+	for value, want := range tests {
+		if got := sanitizeHookValue(value); got != want {
+			t.Errorf("sanitizeHookValue(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
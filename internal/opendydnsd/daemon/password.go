@@ -0,0 +1,166 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var errInvalidHash = errors.New("invalid password hash")
+
+func (d *daemon) hashPassword(password string) (string, error) {
+	var hash string
+	var err error
+
+	conf := d.config.PasswordHashing
+	switch conf.GetAlgorithm() {
+	case config.HashingArgon2id:
+		hash, err = hashArgon2id(password, conf)
+	default:
+		var b []byte
+		b, err = bcrypt.GenerateFromPassword([]byte(password), conf.GetBcryptCost())
+		hash = string(b)
+	}
+
+	if err != nil {
+		d.logger.Err(err).Msg("error while hashing password.")
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// validatePassword dispatch the verification using the algorithm
+// identifier stored in the hash, so hashes made using a previously
+// configured algorithm keep verifying
+func (d *daemon) validatePassword(hashedPassword, plainPassword string) bool {
+	if hashAlgorithm(hashedPassword) == config.HashingArgon2id {
+		return compareArgon2id(hashedPassword, plainPassword) == nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+// needsRehash determinate if given hash has not been made using the configured algorithm
+func (d *daemon) needsRehash(hashedPassword string) bool {
+	return hashAlgorithm(hashedPassword) != d.config.PasswordHashing.GetAlgorithm()
+}
+
+func hashAlgorithm(hashedPassword string) string {
+	if strings.HasPrefix(hashedPassword, "$argon2id$") {
+		return config.HashingArgon2id
+	}
+
+	return config.HashingBcrypt
+}
+
+// hashArgon2id hash given password and encode it using the PHC string format
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+// hashToken return the hash of a random token (share, email verification), as stored
+// a fast hash is enough since, unlike the passwords, the tokens cannot be guessed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashArgon2id(password string, conf config.PasswordHashingConfig) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	time, memory, threads := conf.GetArgon2Parameters()
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, memory, time, threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func compareArgon2id(hashedPassword, plainPassword string) error {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return errInvalidHash
+	}
+
+	var time, memory uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return errInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return errInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return errInvalidHash
+	}
+
+	otherKey := argon2.IDKey([]byte(plainPassword), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+
+	return nil
+}
+
+// checkPasswordStrength return why given password is too weak, or an empty string if it is strong enough
+// the password must mix at least two kinds of characters and must not contain the email address
+func checkPasswordStrength(password, email string, minLength int) string {
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Sprintf("must be at least %d characters long", minLength)
+	}
+
+	kinds := map[string]bool{}
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			kinds["lower"] = true
+		case unicode.IsUpper(r):
+			kinds["upper"] = true
+		case unicode.IsDigit(r):
+			kinds["digit"] = true
+		default:
+			kinds["symbol"] = true
+		}
+	}
+	if len(kinds) < 2 {
+		return "must mix lower case, upper case letters, digits or symbols"
+	}
+
+	if local := strings.ToLower(strings.SplitN(email, "@", 2)[0]); len(local) >= 3 &&
+		strings.Contains(strings.ToLower(password), local) {
+		return "must not contain the email address"
+	}
+
+	return ""
+}
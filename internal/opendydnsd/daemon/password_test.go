@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// cheap argon2 parameters to keep the tests fast
+var testArgon2Config = config.PasswordHashingConfig{
+	Algorithm:     config.HashingArgon2id,
+	Argon2Memory:  1024,
+	Argon2Time:    1,
+	Argon2Threads: 1,
+}
+
+func TestDaemon_HashPassword(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	for _, conf := range []config.PasswordHashingConfig{{}, testArgon2Config} {
+		d := daemon{
+			logger: &logger,
+			config: config.DaemonConfig{PasswordHashing: conf},
+		}
+
+		hash, err := d.hashPassword("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashAlgorithm(hash) != conf.GetAlgorithm() {
+			t.Errorf("wrong algorithm used: %s", hash)
+		}
+		if d.needsRehash(hash) {
+			t.Errorf("hash should not need to be upgraded: %s", hash)
+		}
+
+		if !d.validatePassword(hash, "test") {
+			t.Errorf("password should have been validated (%s)", conf.GetAlgorithm())
+		}
+		if d.validatePassword(hash, "wrong") {
+			t.Errorf("wrong password should have been rejected (%s)", conf.GetAlgorithm())
+		}
+	}
+}
+
+func TestDaemon_ValidatePassword_MixedAlgorithms(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	d := daemon{
+		logger: &logger,
+		config: config.DaemonConfig{PasswordHashing: testArgon2Config},
+	}
+
+	// existing bcrypt hashes keep verifying
+	bcryptHash := "$2a$04$5eQwROjKESuWP2y.sAVsPeqhG48UXWw.htYp5G./JsRjWwUMOi7xC"
+	if !d.validatePassword(bcryptHash, "test") {
+		t.Error("bcrypt hash should have been validated")
+	}
+	if !d.needsRehash(bcryptHash) {
+		t.Error("bcrypt hash should need to be upgraded")
+	}
+
+	// malformed argon2id hashes are rejected
+	for _, hash := range []string{"$argon2id$", "$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=1024$c2FsdA$a2V5"} {
+		if d.validatePassword(hash, "test") {
+			t.Errorf("malformed hash should have been rejected: %s", hash)
+		}
+	}
+}
+
+func TestDaemon_Authenticate_UpgradeHash(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{PasswordHashing: testArgon2Config},
+	}
+
+	var upgraded string
+	dbMock.EXPECT().
+		FindUser("lunamicard@gmail.com").
+		Return(database.User{
+			Model:    gorm.Model{ID: 12},
+			Email:    "lunamicard@gmail.com",
+			Password: "$2a$04$5eQwROjKESuWP2y.sAVsPeqhG48UXWw.htYp5G./JsRjWwUMOi7xC",
+		}, nil)
+	dbMock.EXPECT().
+		UpdatePassword(uint(12), gomock.Any()).
+		DoAndReturn(func(userID uint, password string) error {
+			upgraded = password
+			return nil
+		})
+
+	userCtx, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userCtx.UserID != 12 {
+		t.Errorf("wrong user returned: %d", userCtx.UserID)
+	}
+
+	if !strings.HasPrefix(upgraded, "$argon2id$") {
+		t.Fatalf("hash not upgraded to argon2id: %s", upgraded)
+	}
+	if !d.validatePassword(upgraded, "test") {
+		t.Error("upgraded hash should validate the password")
+	}
+
+	// already upgraded: no further update
+	dbMock.EXPECT().
+		FindUser("lunamicard@gmail.com").
+		Return(database.User{Model: gorm.Model{ID: 12}, Password: upgraded}, nil)
+
+	if _, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
+	"time"
+)
+
+// The DNS record operations
+const (
+	recordAdd    = "add"
+	recordUpdate = "update"
+	recordDelete = "delete"
+)
+
+const (
+	// pendingRecordsBatch is the maximum number of pending records replayed at once
+	pendingRecordsBatch = 100
+	// maxPendingRecordAttempts is the number of failed replays after which a pending record is dropped
+	maxPendingRecordAttempts = 5
+)
+
+// queuingProvisioner queue the DNS record changes while the DNS provider is unavailable
+// the changes are queued as long as older ones are pending, so they are applied in order
+type queuingProvisioner struct {
+	provisioner dns.Provisioner
+	daemon      *daemon
+	aliasDomain string
+}
+
+// ManagesDNSSEC see dns.DNSSECManager
+func (qp *queuingProvisioner) ManagesDNSSEC() bool {
+	return dns.ManagesDNSSEC(qp.provisioner)
+}
+
+func (qp *queuingProvisioner) AddRecord(host, domain, value string, ttl int) error {
+	return qp.apply(database.PendingRecord{Operation: recordAdd, Host: host, Domain: domain, Value: value, TTL: ttl})
+}
+
+func (qp *queuingProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	return qp.apply(database.PendingRecord{Operation: recordUpdate, Host: host, Domain: domain, Value: value, TTL: ttl})
+}
+
+func (qp *queuingProvisioner) DeleteRecord(host, domain string) error {
+	return qp.apply(database.PendingRecord{Operation: recordDelete, Host: host, Domain: domain})
+}
+
+func (qp *queuingProvisioner) apply(record database.PendingRecord) error {
+	record.AliasDomain = qp.aliasDomain
+
+	pending, err := qp.daemon.conn.CountPendingRecords()
+	if err != nil {
+		return err
+	}
+
+	if pending == 0 {
+		err := applyRecord(qp.provisioner, record)
+		if !errors.Is(err, dns.ErrCircuitOpen) {
+			return err
+		}
+	}
+
+	if _, err := qp.daemon.conn.CreatePendingRecord(record); err != nil {
+		return err
+	}
+
+	qp.daemon.logger.Warn().
+		Str("Domain", record.Domain).
+		Str("Host", record.Host).
+		Str("Operation", record.Operation).
+		Msg("DNS provider unavailable, the record change is queued.")
+
+	return nil
+}
+
+// replayPendingRecordsPeriodically apply the pending records once the DNS provider has recovered
+func (d *daemon) replayPendingRecordsPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := d.replayPendingRecords(); err != nil && !errors.Is(err, dns.ErrCircuitOpen) {
+			d.logger.Err(err).Msg("error while replaying the pending DNS records.")
+		}
+	}
+}
+
+// replayPendingRecords apply the pending records in order
+// it stops on the first failure so the remaining ones are not applied out of order
+func (d *daemon) replayPendingRecords() error {
+	records, err := d.conn.FindPendingRecords(pendingRecordsBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		provisioner, _, err := d.resolveDNSProvisioner(record.AliasDomain)
+		if err == nil {
+			err = applyRecord(provisioner, record)
+		}
+
+		if err != nil {
+			if errors.Is(err, dns.ErrCircuitOpen) {
+				return err
+			}
+
+			if record.Attempts+1 < maxPendingRecordAttempts {
+				if incErr := d.conn.IncrementPendingRecordAttempts(record.ID); incErr != nil {
+					return incErr
+				}
+				return err
+			}
+
+			d.logger.Err(err).
+				Str("Domain", record.Domain).
+				Str("Host", record.Host).
+				Str("Operation", record.Operation).
+				Msg("giving up on pending DNS record change.")
+		} else {
+			d.logger.Info().
+				Str("Domain", record.Domain).
+				Str("Host", record.Host).
+				Str("Operation", record.Operation).
+				Msg("pending DNS record change applied.")
+		}
+
+		if err := d.conn.DeletePendingRecord(record.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyRecord(provisioner dns.Provisioner, record database.PendingRecord) error {
+	switch record.Operation {
+	case recordAdd:
+		return provisioner.AddRecord(record.Host, record.Domain, record.Value, record.TTL)
+	case recordUpdate:
+		return provisioner.UpdateRecord(record.Host, record.Domain, record.Value, record.TTL)
+	default:
+		return provisioner.DeleteRecord(record.Host, record.Domain)
+	}
+}
@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestQueuingProvisioner(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+
+	qp := &queuingProvisioner{
+		provisioner: provisionerMock,
+		daemon:      &daemon{logger: &logger, conn: dbMock},
+		aliasDomain: "example.org",
+	}
+
+	// circuit open: the change is queued
+	dbMock.EXPECT().CountPendingRecords().Return(int64(0), nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "example.org", "127.0.0.1", 60).Return(dns.ErrCircuitOpen)
+	dbMock.EXPECT().CreatePendingRecord(database.PendingRecord{
+		Operation:   recordUpdate,
+		AliasDomain: "example.org",
+		Host:        "foo",
+		Domain:      "example.org",
+		Value:       "127.0.0.1",
+		TTL:         60,
+	}).Return(database.PendingRecord{}, nil)
+
+	if err := qp.UpdateRecord("foo", "example.org", "127.0.0.1", 60); err != nil {
+		t.Errorf("UpdateRecord() should have queued the change: %s", err)
+	}
+
+	// older changes pending: queued without calling the provisioner
+	dbMock.EXPECT().CountPendingRecords().Return(int64(1), nil)
+	dbMock.EXPECT().CreatePendingRecord(database.PendingRecord{
+		Operation:   recordDelete,
+		AliasDomain: "example.org",
+		Host:        "foo",
+		Domain:      "example.org",
+	}).Return(database.PendingRecord{}, nil)
+
+	if err := qp.DeleteRecord("foo", "example.org"); err != nil {
+		t.Errorf("DeleteRecord() should have queued the change: %s", err)
+	}
+
+	// other failures are not queued
+	backendErr := errors.New("record not found")
+	dbMock.EXPECT().CountPendingRecords().Return(int64(0), nil)
+	provisionerMock.EXPECT().DeleteRecord("foo", "example.org").Return(backendErr)
+
+	if err := qp.DeleteRecord("foo", "example.org"); err != backendErr {
+		t.Errorf("DeleteRecord() should have returned the provisioner error: %v", err)
+	}
+}
+
+func TestDaemon_ReplayPendingRecords(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger:      &logger,
+		conn:        dbMock,
+		config:      newPendingTestConfig(),
+		dnsProvider: providerMock,
+	}
+
+	dbMock.EXPECT().FindPendingRecords(pendingRecordsBatch).Return([]database.PendingRecord{
+		{Model: gorm.Model{ID: 1}, Operation: recordAdd, AliasDomain: "example.org", Host: "foo", Domain: "example.org", Value: "127.0.0.1"},
+		{Model: gorm.Model{ID: 2}, Operation: recordUpdate, AliasDomain: "example.org", Host: "foo", Domain: "example.org", Value: "127.0.0.2"},
+		{Model: gorm.Model{ID: 3}, Operation: recordDelete, AliasDomain: "example.org", Host: "foo", Domain: "example.org"},
+	}, nil)
+	providerMock.EXPECT().GetProvisioner("dummy", gomock.Any()).Return(provisionerMock, nil).Times(2)
+
+	// the first one is applied, the second fails: the third must wait
+	backendErr := errors.New("backend down")
+	provisionerMock.EXPECT().AddRecord("foo", "example.org", "127.0.0.1", 0).Return(nil)
+	dbMock.EXPECT().DeletePendingRecord(uint(1)).Return(nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "example.org", "127.0.0.2", 0).Return(backendErr)
+	dbMock.EXPECT().IncrementPendingRecordAttempts(uint(2)).Return(nil)
+
+	if err := d.replayPendingRecords(); err != backendErr {
+		t.Errorf("replayPendingRecords() should have returned the provisioner error: %v", err)
+	}
+}
+
+func TestDaemon_ReplayPendingRecords_GiveUp(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger:      &logger,
+		conn:        dbMock,
+		config:      newPendingTestConfig(),
+		dnsProvider: providerMock,
+	}
+
+	dbMock.EXPECT().FindPendingRecords(pendingRecordsBatch).Return([]database.PendingRecord{
+		{Model: gorm.Model{ID: 1}, Operation: recordDelete, AliasDomain: "example.org", Host: "foo", Domain: "example.org", Attempts: maxPendingRecordAttempts - 1},
+		{Model: gorm.Model{ID: 2}, Operation: recordAdd, AliasDomain: "example.org", Host: "bar", Domain: "example.org", Value: "::1"},
+	}, nil)
+	providerMock.EXPECT().GetProvisioner("dummy", gomock.Any()).Return(provisionerMock, nil).Times(2)
+
+	provisionerMock.EXPECT().DeleteRecord("foo", "example.org").Return(errors.New("record not found"))
+	dbMock.EXPECT().DeletePendingRecord(uint(1)).Return(nil)
+	provisionerMock.EXPECT().AddRecord("bar", "example.org", "::1", 0).Return(nil)
+	dbMock.EXPECT().DeletePendingRecord(uint(2)).Return(nil)
+
+	if err := d.replayPendingRecords(); err != nil {
+		t.Errorf("replayPendingRecords() should have succeeded: %s", err)
+	}
+}
+
+func TestDaemon_GetDNSStatus(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger:      &logger,
+		conn:        dbMock,
+		dnsProvider: providerMock,
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Admin: true}, nil).Times(2)
+
+	status, err := d.GetDNSStatus(proto.UserContext{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.CircuitBreaker != "disabled" {
+		t.Errorf("wrong circuit breaker state: %s", status.CircuitBreaker)
+	}
+
+	d.breaker = dns.NewBreakerProvider(providerMock, 3, time.Minute)
+	dbMock.EXPECT().CountPendingRecords().Return(int64(2), nil)
+
+	status, err = d.GetDNSStatus(proto.UserContext{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.CircuitBreaker != string(dns.BreakerClosed) || status.PendingRecords != 2 {
+		t.Errorf("wrong DNS status: %v", status)
+	}
+
+	// admin only
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{}, nil)
+	if _, err := d.GetDNSStatus(proto.UserContext{UserID: 2}); err != proto.ErrForbidden {
+		t.Errorf("GetDNSStatus() should have returned ErrForbidden: %v", err)
+	}
+}
+
+func newPendingTestConfig() config.DaemonConfig {
+	return config.DaemonConfig{
+		DNSProvisioners: []config.DNSProvisionerConfig{
+			{Name: "dummy", Domains: []config.DomainConfig{{Domain: "example.org"}}},
+		},
+	}
+}
@@ -0,0 +1,157 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// verificationTokenLength is the number of random bytes of the verification tokens
+const verificationTokenLength = 16
+
+// RegisterUser create the account of a self registered user
+// if the email address must be verified, the account cannot be used until the token sent
+// by the verification hook is verified. An unverified registration can be made again once expired
+func (d *daemon) RegisterUser(registration proto.RegistrationDto) (proto.UserContext, bool, error) {
+	conf := d.registration
+	if !conf.Enabled {
+		return proto.UserContext{}, false, proto.ErrRegistrationDisabled
+	}
+
+	if !validInviteCode(conf.InviteCodes, registration.InviteCode) {
+		d.logger.Warn().Msg("invalid registration request: invalid invite code.")
+		return proto.UserContext{}, false, proto.ErrInvalidInviteCode
+	}
+
+	if reason := checkPasswordStrength(registration.Password, registration.Email, conf.GetMinPasswordLength()); reason != "" {
+		return proto.UserContext{}, false, echo.NewHTTPError(http.StatusBadRequest, proto.ErrorDto{
+			Message: proto.ErrWeakPassword.Message.(string),
+			Details: map[string]string{"password": reason},
+		})
+	}
+
+	// the account is usable right away: whether the address is taken cannot be hidden
+	cred := proto.CredentialsDto{Email: registration.Email, Password: registration.Password}
+	if !conf.VerificationRequired() {
+		userCtx, err := d.CreateUser(cred)
+		return userCtx, false, err
+	}
+
+	// the email address ends up in the verification hook
+	if registration.Email == "" || sanitizeEmail(registration.Email) != registration.Email {
+		d.logger.Warn().Msg("invalid registration request: bad request.")
+		return proto.UserContext{}, false, proto.ErrInvalidParameters
+	}
+
+	user, err := d.conn.FindUser(registration.Email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.UserContext{}, false, err
+	}
+	exist := err == nil
+	if exist && (user.Verified() || user.VerificationExpiry == nil || user.VerificationExpiry.After(time.Now())) {
+		// answer as if registered so the taken addresses cannot be discovered, but no token is sent
+		d.logger.Warn().Msg("email address already taken.")
+		return proto.UserContext{}, true, nil
+	}
+
+	hash, err := d.hashPassword(registration.Password)
+	if err != nil {
+		return proto.UserContext{}, false, err
+	}
+
+	token, err := generateVerificationToken()
+	if err != nil {
+		d.logger.Err(err).Msg("error while generating verification token.")
+		return proto.UserContext{}, false, err
+	}
+
+	// the token is sent first so the registration can be made again if the hook fails
+	output, err := execCommand(conf.VerificationHook, []string{
+		"OPENDYDNS_EMAIL=" + registration.Email,
+		"OPENDYDNS_VERIFICATION_TOKEN=" + token,
+	})
+	if err != nil {
+		d.logger.Err(err).Str("Email", registration.Email).Msg("error while running verification hook.")
+		return proto.UserContext{}, false, proto.ErrInternal
+	}
+	d.logger.Debug().Str("Email", registration.Email).Str("Output", output).Msg("verification hook successfully run.")
+
+	expiresAt := time.Now().Add(conf.GetVerificationTTL())
+	if exist {
+		err = d.conn.ResetRegistration(user.ID, hash, hashToken(token), expiresAt)
+	} else {
+		_, err = d.conn.CreateUnverifiedUser(registration.Email, hash, hashToken(token), expiresAt)
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while creating user.")
+		return proto.UserContext{}, false, err
+	}
+
+	return proto.UserContext{}, true, nil
+}
+
+// VerifyEmail activate the account of the user having given verification token
+func (d *daemon) VerifyEmail(token string) (proto.UserContext, error) {
+	user, err := d.conn.VerifyUser(hashToken(token), time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.UserContext{}, proto.ErrInvalidVerificationToken
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while verifying user.")
+		return proto.UserContext{}, err
+	}
+
+	d.logger.Debug().Str("Email", user.Email).Msg("email address successfully verified.")
+
+	return proto.UserContext{
+		UserID:       user.ID,
+		TokenVersion: user.TokenVersion,
+	}, nil
+}
+
+// validInviteCode determinate if given invite code is one of the configured ones
+// any code is valid if there is none
+func validInviteCode(codes []string, code string) bool {
+	if len(codes) == 0 {
+		return true
+	}
+
+	valid := false
+	for _, c := range codes {
+		if subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
+
+// sanitizeEmail drop the characters which are not expected in an email address
+func sanitizeEmail(email string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '+' {
+			return r
+		}
+		if sanitizeHookValue(string(r)) == "" {
+			return -1
+		}
+		return r
+	}, email)
+}
+
+func generateVerificationToken() (string, error) {
+	b := make([]byte, verificationTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,262 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		weak     bool
+	}{
+		{"Sh0rt", true},
+		{"alllowercaseletters", true},
+		{"123456789012", true},
+		{"lunamicard-2020", true},
+		{"Correct horse battery", false},
+		{"correct-horse-battery", false},
+		{"élan vital 42", false},
+	}
+
+	for _, test := range tests {
+		if reason := checkPasswordStrength(test.password, "lunamicard@gmail.com", 12); (reason != "") != test.weak {
+			t.Errorf("wrong strength of %s: %q", test.password, reason)
+		}
+	}
+}
+
+func TestValidInviteCode(t *testing.T) {
+	if !validInviteCode(nil, "") {
+		t.Error("any code should be valid without configured codes")
+	}
+	if !validInviteCode([]string{"foo", "bar"}, "bar") {
+		t.Error("configured code should be valid")
+	}
+	for _, code := range []string{"", "ba", "baz"} {
+		if validInviteCode([]string{"foo", "bar"}, code) {
+			t.Errorf("code %s should not be valid", code)
+		}
+	}
+}
+
+func TestDaemon_RegisterUser(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{PasswordHashing: testArgon2Config},
+	}
+
+	registration := proto.RegistrationDto{Email: "lunamicard@gmail.com", Password: "Correct horse battery", InviteCode: "invite"}
+
+	if _, _, err := d.RegisterUser(registration); err != proto.ErrRegistrationDisabled {
+		t.Errorf("RegisterUser() should have returned ErrRegistrationDisabled: %v", err)
+	}
+
+	d.registration = config.RegistrationConfig{Enabled: true, InviteCodes: []string{"other"}}
+	if _, _, err := d.RegisterUser(registration); err != proto.ErrInvalidInviteCode {
+		t.Errorf("RegisterUser() should have returned ErrInvalidInviteCode: %v", err)
+	}
+
+	d.registration.InviteCodes = append(d.registration.InviteCodes, "invite")
+	weak := registration
+	weak.Password = "password"
+	_, _, err := d.RegisterUser(weak)
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("RegisterUser() should have returned ErrWeakPassword: %v", err)
+	}
+	if errDto := httpErr.Message.(proto.ErrorDto); !errDto.Is(proto.ErrWeakPassword) || errDto.Details["password"] == "" {
+		t.Errorf("wrong error returned: %v", errDto)
+	}
+
+	// without verification, the account is active right away
+	dbMock.EXPECT().FindUser("lunamicard@gmail.com").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().CreateUser("lunamicard@gmail.com", gomock.Any()).
+		DoAndReturn(func(email, password string) (database.User, error) {
+			dbMock.EXPECT().FindUser(email).Return(database.User{Model: gorm.Model{ID: 12}, Email: email, Password: password}, nil)
+			return database.User{}, nil
+		})
+
+	userCtx, verificationRequired, err := d.RegisterUser(registration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verificationRequired || userCtx.UserID != 12 {
+		t.Errorf("the user should have been logged in: %v", userCtx)
+	}
+}
+
+func TestDaemon_RegisterUser_Verification(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	dir, err := ioutil.TempDir("", "opendydns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "env")
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{PasswordHashing: testArgon2Config},
+		registration: config.RegistrationConfig{
+			Enabled:          true,
+			VerificationHook: config.HookConfig{Command: "/bin/sh", Args: []string{"-c", "env > " + output}},
+		},
+	}
+
+	registration := proto.RegistrationDto{Email: "luna+dydns@gmail.com", Password: "Correct horse battery"}
+
+	var tokenHash string
+	dbMock.EXPECT().FindUser("luna+dydns@gmail.com").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().CreateUnverifiedUser("luna+dydns@gmail.com", gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(email, password, hash string, expiresAt time.Time) (database.User, error) {
+			tokenHash = hash
+			if time.Until(expiresAt) < 23*time.Hour {
+				t.Errorf("wrong expiry: %s", expiresAt)
+			}
+			return database.User{}, nil
+		})
+
+	if _, verificationRequired, err := d.RegisterUser(registration); err != nil || !verificationRequired {
+		t.Fatalf("the email address should need to be verified: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := string(b)
+	if !strings.Contains(env, "OPENDYDNS_EMAIL=luna+dydns@gmail.com\n") {
+		t.Errorf("missing email address: %s", env)
+	}
+	i := strings.Index(env, "OPENDYDNS_VERIFICATION_TOKEN=")
+	if i == -1 {
+		t.Fatalf("missing verification token: %s", env)
+	}
+	token := strings.SplitN(env[i+len("OPENDYDNS_VERIFICATION_TOKEN="):], "\n", 2)[0]
+	if hashToken(token) != tokenHash {
+		t.Errorf("the stored hash is not the one of the sent token: %s", token)
+	}
+
+	// the registration is pending, or the address verified: answered as a new registration but nothing is done
+	expiresAt := time.Now().Add(time.Hour)
+	dbMock.EXPECT().FindUser("luna+dydns@gmail.com").
+		Return(database.User{Model: gorm.Model{ID: 12}, VerificationTokenHash: tokenHash, VerificationExpiry: &expiresAt}, nil)
+	dbMock.EXPECT().FindUser("luna+dydns@gmail.com").Return(database.User{Model: gorm.Model{ID: 12}}, nil)
+
+	for i := 0; i < 2; i++ {
+		if _, verificationRequired, err := d.RegisterUser(registration); err != nil || !verificationRequired {
+			t.Errorf("RegisterUser() should have required a verification: %v", err)
+		}
+	}
+	if b, err := ioutil.ReadFile(output); err != nil || string(b) != env {
+		t.Errorf("the verification hook should not have been run: %s", b)
+	}
+
+	// the registration has expired: it can be made again
+	expiresAt = time.Now().Add(-time.Hour)
+	dbMock.EXPECT().FindUser("luna+dydns@gmail.com").
+		Return(database.User{Model: gorm.Model{ID: 12}, VerificationTokenHash: tokenHash, VerificationExpiry: &expiresAt}, nil)
+	dbMock.EXPECT().ResetRegistration(uint(12), gomock.Any(), gomock.Not(tokenHash), gomock.Any()).Return(nil)
+
+	if _, _, err := d.RegisterUser(registration); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hook fails: nothing is stored
+	d.registration.VerificationHook.Args = []string{"-c", "exit 1"}
+	dbMock.EXPECT().FindUser("luna+dydns@gmail.com").Return(database.User{}, gorm.ErrRecordNotFound)
+
+	if _, _, err := d.RegisterUser(registration); err != proto.ErrInternal {
+		t.Errorf("RegisterUser() should have returned ErrInternal: %v", err)
+	}
+
+	// the email address ends up in the hook
+	registration.Email = "luna;reboot@gmail.com"
+	if _, _, err := d.RegisterUser(registration); err != proto.ErrInvalidParameters {
+		t.Errorf("RegisterUser() should have returned ErrInvalidParameters: %v", err)
+	}
+}
+
+func TestDaemon_VerifyEmail(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().VerifyUser(hashToken("token"), gomock.Any()).
+		Return(database.User{Model: gorm.Model{ID: 12}, TokenVersion: 2}, nil)
+
+	userCtx, err := d.VerifyEmail("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userCtx.UserID != 12 || userCtx.TokenVersion != 2 {
+		t.Errorf("wrong user context returned: %v", userCtx)
+	}
+
+	dbMock.EXPECT().VerifyUser(hashToken("token"), gomock.Any()).Return(database.User{}, gorm.ErrRecordNotFound)
+
+	if _, err := d.VerifyEmail("token"); err != proto.ErrInvalidVerificationToken {
+		t.Errorf("VerifyEmail() should have returned ErrInvalidVerificationToken: %v", err)
+	}
+}
+
+func TestDaemon_Authenticate_NotVerified(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUser("lunamicard@gmail.com").Return(database.User{
+		Password:              "$2a$04$5eQwROjKESuWP2y.sAVsPeqhG48UXWw.htYp5G./JsRjWwUMOi7xC",
+		VerificationTokenHash: "hash",
+	}, nil).Times(2)
+
+	// the verification status is not disclosed without the password
+	if _, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "wrong"}); err != proto.ErrInvalidParameters {
+		t.Errorf("Authenticate() should have returned ErrInvalidParameters: %v", err)
+	}
+	if _, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}); err != proto.ErrEmailNotVerified {
+		t.Errorf("Authenticate() should have returned ErrEmailNotVerified: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	"time"
+)
+
+// shareTokenLength is the number of random bytes of the share tokens
+const shareTokenLength = 24
+
+func generateShareToken() (string, error) {
+	b := make([]byte, shareTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newShareDto(share database.Share, alias database.Alias) proto.ShareDto {
+	return proto.ShareDto{
+		ID:        share.ID,
+		Alias:     newAliasDto(alias).Domain,
+		ExpiresAt: share.ExpiresAt,
+	}
+}
+
+// CreateShare mint a read-only token giving access to the value of the user given alias
+// the share follows the alias if renamed, and stops working once it is deleted
+func (d *daemon) CreateShare(userCtx proto.UserContext, aliasName string, expiresIn time.Duration) (proto.ShareDto, error) {
+	if expiresIn < 0 {
+		return proto.ShareDto{}, proto.ErrInvalidParameters
+	}
+
+	al, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
+	if err != nil {
+		return proto.ShareDto{}, err
+	}
+
+	token, err := generateShareToken()
+	if err != nil {
+		d.logger.Err(err).Msg("error while generating share token.")
+		return proto.ShareDto{}, err
+	}
+
+	share := database.Share{UserID: userCtx.UserID, AliasID: al.ID, TokenHash: hashToken(token)}
+	if expiresIn > 0 {
+		expiresAt := time.Now().Add(expiresIn)
+		share.ExpiresAt = &expiresAt
+	}
+
+	share, err = d.conn.CreateShare(share)
+	if err != nil {
+		d.logger.Err(err).Msg("error while creating share.")
+		return proto.ShareDto{}, err
+	}
+
+	shareDto := newShareDto(share, al)
+	shareDto.Token = token
+	return shareDto, nil
+}
+
+// GetShares return the shares of the user aliases, the ones of the deleted aliases excluded
+func (d *daemon) GetShares(userCtx proto.UserContext) ([]proto.ShareDto, error) {
+	shares, err := d.conn.FindUserShares(userCtx.UserID)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, err
+	}
+
+	sharesDto := []proto.ShareDto{}
+	for _, share := range shares {
+		al, err := d.conn.FindAliasByID(share.AliasID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			d.logger.Err(err).Msg("error while fetching database.")
+			return nil, err
+		}
+
+		sharesDto = append(sharesDto, newShareDto(share, al))
+	}
+
+	return sharesDto, nil
+}
+
+func (d *daemon) DeleteShare(userCtx proto.UserContext, id uint) error {
+	err := d.conn.DeleteShare(id, userCtx.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.ErrShareNotFound
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while deleting share.")
+		return err
+	}
+
+	return nil
+}
+
+// GetSharedAlias return the alias given share token gives access to
+// an unknown, revoked or expired token are not told apart
+func (d *daemon) GetSharedAlias(token string) (proto.SharedAliasDto, error) {
+	share, err := d.conn.FindShare(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.SharedAliasDto{}, proto.ErrShareNotFound
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.SharedAliasDto{}, err
+	}
+
+	if share.ExpiresAt != nil && !time.Now().Before(*share.ExpiresAt) {
+		return proto.SharedAliasDto{}, proto.ErrShareNotFound
+	}
+
+	al, err := d.conn.FindAliasByID(share.AliasID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.SharedAliasDto{}, proto.ErrShareNotFound
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.SharedAliasDto{}, err
+	}
+
+	return proto.SharedAliasDto{
+		Alias:   newAliasDto(al).Domain,
+		Value:   al.Value,
+		Value6:  al.Value6,
+		Enabled: al.Enabled,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestDaemon_CreateShare(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock}
+
+	if _, err := d.CreateShare(proto.UserContext{UserID: 1}, "foo.example.org", -time.Second); err != proto.ErrInvalidParameters {
+		t.Errorf("CreateShare() should have returned ErrInvalidParameters: %v", err)
+	}
+
+	// owned by someone else
+	dbMock.EXPECT().FindAlias("foo", "example.org").
+		Return(database.Alias{Model: gorm.Model{ID: 7}, Host: "foo", Domain: "example.org", UserID: 2}, nil)
+	if _, err := d.CreateShare(proto.UserContext{UserID: 1}, "foo.example.org", 0); err != proto.ErrAliasNotFound {
+		t.Errorf("CreateShare() should have returned ErrAliasNotFound: %v", err)
+	}
+
+	var created database.Share
+	dbMock.EXPECT().FindAlias("foo", "example.org").
+		Return(database.Alias{Model: gorm.Model{ID: 7}, Host: "foo", Domain: "example.org", UserID: 1}, nil)
+	dbMock.EXPECT().CreateShare(gomock.Any()).DoAndReturn(func(share database.Share) (database.Share, error) {
+		share.ID = 3
+		created = share
+		return share, nil
+	})
+
+	share, err := d.CreateShare(proto.UserContext{UserID: 1}, "foo.example.org", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if share.ID != 3 || share.Alias != "foo.example.org" || share.Token == "" || share.ExpiresAt == nil {
+		t.Errorf("wrong share returned: %v", share)
+	}
+
+	// only the hash of the token is stored
+	if created.UserID != 1 || created.AliasID != 7 || created.TokenHash != hashToken(share.Token) || created.TokenHash == share.Token {
+		t.Errorf("wrong share stored: %v", created)
+	}
+	if until := time.Until(*created.ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("wrong expiry: %s", created.ExpiresAt)
+	}
+}
+
+func TestDaemon_GetSharedAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock}
+
+	future, past := time.Now().Add(time.Hour), time.Now().Add(-time.Second)
+
+	// the share only gives access to its target alias
+	dbMock.EXPECT().FindShare(hashToken("valid")).Return(database.Share{AliasID: 7, ExpiresAt: &future}, nil)
+	dbMock.EXPECT().FindAliasByID(uint(7)).
+		Return(database.Alias{Host: "foo", Domain: "example.org", Value: "192.0.2.1", Enabled: true}, nil)
+
+	alias, err := d.GetSharedAlias("valid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias != (proto.SharedAliasDto{Alias: "foo.example.org", Value: "192.0.2.1", Enabled: true}) {
+		t.Errorf("wrong alias returned: %v", alias)
+	}
+
+	// unknown or revoked
+	dbMock.EXPECT().FindShare(hashToken("revoked")).Return(database.Share{}, gorm.ErrRecordNotFound)
+	if _, err := d.GetSharedAlias("revoked"); err != proto.ErrShareNotFound {
+		t.Errorf("GetSharedAlias() should have returned ErrShareNotFound: %v", err)
+	}
+
+	dbMock.EXPECT().FindShare(hashToken("expired")).Return(database.Share{AliasID: 7, ExpiresAt: &past}, nil)
+	if _, err := d.GetSharedAlias("expired"); err != proto.ErrShareNotFound {
+		t.Errorf("GetSharedAlias() should have returned ErrShareNotFound: %v", err)
+	}
+
+	// the alias has been deleted since
+	dbMock.EXPECT().FindShare(hashToken("deleted")).Return(database.Share{AliasID: 8}, nil)
+	dbMock.EXPECT().FindAliasByID(uint(8)).Return(database.Alias{}, gorm.ErrRecordNotFound)
+	if _, err := d.GetSharedAlias("deleted"); err != proto.ErrShareNotFound {
+		t.Errorf("GetSharedAlias() should have returned ErrShareNotFound: %v", err)
+	}
+}
+
+func TestDaemon_DeleteShare(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{logger: &logger, conn: dbMock}
+
+	dbMock.EXPECT().DeleteShare(uint(3), uint(1)).Return(nil)
+	if err := d.DeleteShare(proto.UserContext{UserID: 1}, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// owned by someone else
+	dbMock.EXPECT().DeleteShare(uint(3), uint(2)).Return(gorm.ErrRecordNotFound)
+	if err := d.DeleteShare(proto.UserContext{UserID: 2}, 3); err != proto.ErrShareNotFound {
+		t.Errorf("DeleteShare() should have returned ErrShareNotFound: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTelemetryInterval = 24 * time.Hour
+	// users having updated an alias during this period are considered active
+	activeUsersPeriod = 30 * 24 * time.Hour
+)
+
+// telemetryReport is the anonymous usage report
+// it must never contain user-identifying data
+type telemetryReport struct {
+	Version     string `json:"version"`
+	Aliases     int64  `json:"aliases"`
+	ActiveUsers int64  `json:"active_users"`
+}
+
+// reportTelemetry send the anonymous usage report if the telemetry is enabled
+func (d *daemon) reportTelemetry(client *http.Client) error {
+	if !d.config.Telemetry.Enabled || d.config.Telemetry.Endpoint == "" {
+		return nil
+	}
+
+	aliases, err := d.conn.CountAliases()
+	if err != nil {
+		return err
+	}
+
+	activeUsers, err := d.conn.CountActiveUsers(time.Now().Add(-activeUsersPeriod))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(telemetryReport{
+		Version:     common.Version,
+		Aliases:     aliases,
+		ActiveUsers: activeUsers,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Post(d.config.Telemetry.Endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("telemetry endpoint returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *daemon) reportTelemetryPeriodically() {
+	interval := d.config.Telemetry.Interval
+	if interval == 0 {
+		interval = defaultTelemetryInterval
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := d.reportTelemetry(client); err != nil {
+			d.logger.Warn().Str("Error", err.Error()).Msg("unable to send telemetry report.")
+			continue
+		}
+
+		d.logger.Debug().Msg("telemetry report sent.")
+	}
+}
@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/internal/common"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDaemon_ReportTelemetry_Disabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("nothing should have been sent")
+	}))
+	defer server.Close()
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			Telemetry: config.TelemetryConfig{Endpoint: server.URL},
+		},
+	}
+
+	// the database must not even be queried
+	if err := d.reportTelemetry(server.Client()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_ReportTelemetry(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	var report map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			Telemetry: config.TelemetryConfig{Enabled: true, Endpoint: server.URL},
+		},
+	}
+
+	dbMock.EXPECT().CountAliases().Return(int64(42), nil)
+	dbMock.EXPECT().CountActiveUsers(gomock.Any()).Return(int64(12), nil)
+
+	if err := d.reportTelemetry(server.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the aggregated counts and the version must be sent
+	expected := map[string]interface{}{
+		"version":      common.Version,
+		"aliases":      float64(42),
+		"active_users": float64(12),
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("wrong report sent: %v", report)
+	}
+}
@@ -0,0 +1,344 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// maxWebhookURLLength is the maximum length of the webhook URLs
+const maxWebhookURLLength = 2048
+
+// webhookRateWindow is the period over which the notifications of each user are counted
+const webhookRateWindow = time.Minute
+
+// webhookWorkers is the number of notifications delivered concurrently
+const webhookWorkers = 4
+
+// webhookQueueSize is the number of notifications waiting for a worker
+// before the new ones are dropped
+const webhookQueueSize = 256
+
+// errPrivateWebhookAddress is returned when a webhook resolves to a non public address
+var errPrivateWebhookAddress = errors.New("webhook address is not public")
+
+// privateNetworks are the networks the webhooks cannot target unless allowed:
+// the users could otherwise reach the services of the daemon network (or the cloud metadata endpoints)
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+// webhookDispatcher send the alias changes to the webhooks of their owner
+// the notifications are queued by the webhooks subscriber of the event bus (hence the rate windows
+// are not synchronized) and delivered by a pool of workers, so a slow webhook never blocks the subscriber
+type webhookDispatcher struct {
+	logger *zerolog.Logger
+	client *http.Client
+	// limit is the maximum number of notifications per user during the rate window
+	limit      int
+	windows    map[uint]*webhookWindow
+	now        func() time.Time
+	deliveries chan webhookDelivery
+}
+
+type webhookDelivery struct {
+	webhook database.Webhook
+	alias   string
+	body    []byte
+}
+
+type webhookWindow struct {
+	start time.Time
+	count int
+}
+
+func newWebhookDispatcher(logger *zerolog.Logger, timeout time.Duration, limit int, allowPrivateNetworks bool) *webhookDispatcher {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivateNetworks {
+		dialer.Control = checkWebhookAddress
+	}
+
+	wd := &webhookDispatcher{
+		logger: logger,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			// a webhook must not be able to send the daemon elsewhere
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		limit:      limit,
+		windows:    map[uint]*webhookWindow{},
+		now:        time.Now,
+		deliveries: make(chan webhookDelivery, webhookQueueSize),
+	}
+
+	for i := 0; i < webhookWorkers; i++ {
+		go wd.work()
+	}
+
+	return wd
+}
+
+// enqueue queue the notification for delivery without waiting for the webhook
+// the notification is dropped if the queue is full
+func (wd *webhookDispatcher) enqueue(delivery webhookDelivery) bool {
+	select {
+	case wd.deliveries <- delivery:
+		return true
+	default:
+		return false
+	}
+}
+
+// work deliver the queued notifications
+func (wd *webhookDispatcher) work() {
+	for delivery := range wd.deliveries {
+		if err := wd.deliver(delivery.webhook, delivery.body); err != nil {
+			wd.logger.Warn().
+				Uint("Webhook", delivery.webhook.ID).
+				Str("Alias", delivery.alias).
+				Str("Error", err.Error()).
+				Msg("error while notifying webhook.")
+		}
+	}
+}
+
+// allow count a notification of given user and tell whether it can be sent
+func (wd *webhookDispatcher) allow(userID uint) bool {
+	now := wd.now()
+
+	window, exist := wd.windows[userID]
+	if !exist || now.Sub(window.start) >= webhookRateWindow {
+		window = &webhookWindow{start: now}
+		wd.windows[userID] = window
+	}
+
+	window.count++
+	return window.count <= wd.limit
+}
+
+// deliver POST given notification to the webhook, signed using its secret if any
+func (wd *webhookDispatcher) deliver(webhook database.Webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "opendydnsd/"+common.Version)
+	if webhook.Secret != "" {
+		req.Header.Set(proto.HeaderSignature, "sha256="+signWebhookBody(webhook.Secret, body))
+	}
+
+	res, err := wd.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// signWebhookBody return the hex encoded HMAC-SHA256 of the body
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyWebhooks send the alias change to the webhooks of the alias owner, as a subscriber of the event bus
+// the notifications are delivered in the background, the ones exceeding the rate limit of the user are dropped
+func (d *daemon) notifyWebhooks(event AliasChanged) {
+	webhooks, err := d.conn.FindUserWebhooks(event.UserID)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	if !d.webhooks.allow(event.UserID) {
+		d.logger.Warn().
+			Uint("UserID", event.UserID).
+			Str("Type", event.Type).
+			Str("Alias", event.Alias).
+			Msg("webhooks rate limit exceeded, dropping notification.")
+		return
+	}
+
+	body, err := json.Marshal(proto.WebhookEventDto{
+		Type:          event.Type,
+		Alias:         event.Alias,
+		Value:         event.Value,
+		PreviousValue: event.PreviousValue,
+		PreviousAlias: event.PreviousAlias,
+		TTL:           event.TTL,
+		Time:          event.Time,
+	})
+	if err != nil {
+		d.logger.Err(err).Msg("error while encoding webhook notification.")
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !d.webhooks.enqueue(webhookDelivery{webhook: webhook, alias: event.Alias, body: body}) {
+			d.logger.Warn().
+				Uint("Webhook", webhook.ID).
+				Str("Alias", event.Alias).
+				Msg("webhooks are lagging behind, dropping notification.")
+		}
+	}
+}
+
+// checkWebhookURL make sure the webhook target is an absolute http(s) URL
+// the credentials are rejected since the URLs are displayed back to the user
+// the non public addresses are rejected early, the names being checked once resolved by checkWebhookAddress
+func checkWebhookURL(rawURL string, allowPrivateNetworks bool) error {
+	if len(rawURL) > maxWebhookURLLength {
+		return proto.ErrInvalidWebhook
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return proto.ErrInvalidWebhook
+	}
+
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !allowPrivateNetworks && !isPublicAddress(ip) {
+		return proto.ErrPrivateWebhook
+	}
+
+	return nil
+}
+
+// checkWebhookAddress is the dialer hook refusing the connections to the non public addresses
+// it is run on the resolved address, so a name cannot be (re)bound to a private address
+func checkWebhookAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !isPublicAddress(ip) {
+		return fmt.Errorf("%w: %s", errPrivateWebhookAddress, host)
+	}
+
+	return nil
+}
+
+// isPublicAddress determinate if given address is neither a loopback, private, link-local nor multicast one
+func isPublicAddress(ip net.IP) bool {
+	if ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func newWebhookDto(webhook database.Webhook) proto.WebhookDto {
+	return proto.WebhookDto{
+		ID:     webhook.ID,
+		URL:    webhook.URL,
+		Signed: webhook.Secret != "",
+	}
+}
+
+func (d *daemon) GetWebhooks(userCtx proto.UserContext) ([]proto.WebhookDto, error) {
+	webhooks, err := d.conn.FindUserWebhooks(userCtx.UserID)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, err
+	}
+
+	webhooksDto := []proto.WebhookDto{}
+	for _, webhook := range webhooks {
+		webhooksDto = append(webhooksDto, newWebhookDto(webhook))
+	}
+
+	return webhooksDto, nil
+}
+
+func (d *daemon) CreateWebhook(userCtx proto.UserContext, webhook proto.WebhookDto) (proto.WebhookDto, error) {
+	if !d.config.Webhooks.Enabled {
+		d.logger.Warn().Msg("webhooks are not enabled.")
+		return proto.WebhookDto{}, proto.ErrWebhooksDisabled
+	}
+
+	if err := checkWebhookURL(webhook.URL, d.config.Webhooks.AllowPrivateNetworks); err != nil {
+		d.logger.Warn().Str("URL", webhook.URL).Msg("invalid webhook URL.")
+		return proto.WebhookDto{}, err
+	}
+
+	count, err := d.conn.CountUserWebhooks(userCtx.UserID)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.WebhookDto{}, err
+	}
+	if count >= int64(d.config.Webhooks.GetMaxPerUser()) {
+		d.logger.Warn().Uint("UserID", userCtx.UserID).Msg("too many webhooks registered.")
+		return proto.WebhookDto{}, proto.ErrTooManyWebhooks
+	}
+
+	created, err := d.conn.CreateWebhook(database.Webhook{
+		UserID: userCtx.UserID,
+		URL:    webhook.URL,
+		Secret: webhook.Secret,
+	})
+	if err != nil {
+		d.logger.Err(err).Msg("error while creating webhook.")
+		return proto.WebhookDto{}, err
+	}
+
+	return newWebhookDto(created), nil
+}
+
+func (d *daemon) DeleteWebhook(userCtx proto.UserContext, id uint) error {
+	err := d.conn.DeleteWebhook(id, userCtx.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.ErrWebhookNotFound
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while deleting webhook.")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
+	"github.com/creekorful/open-dydns/proto"
+	"strings"
+)
+
+// ExportZone render the published aliases of given managed domain as a zone file fragment (admin only)
+// the names are fully qualified and the TTL is omitted if neither the alias, its domain nor the daemon define one
+func (d *daemon) ExportZone(userCtx proto.UserContext, domain string) (proto.ZoneDto, error) {
+	if err := d.checkAdmin(userCtx); err != nil {
+		return proto.ZoneDto{}, err
+	}
+
+	domainConf, exist := d.findDomainConfig(domain)
+	if !exist || !strings.EqualFold(domainConf.String(), domain) {
+		d.logger.Warn().Str("Domain", domain).Msg("domain is not supported.")
+		return proto.ZoneDto{}, proto.ErrDomainNotFound
+	}
+
+	aliases, err := d.conn.ListAllAliases()
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.ZoneDto{}, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("; aliases of %s exported by opendydnsd\n", domainConf.String()))
+
+	for _, alias := range aliases {
+		// disabled aliases are not published
+		if !alias.Enabled {
+			continue
+		}
+		if conf, exist := d.findDomainConfig(alias.Domain); !exist || conf.String() != domainConf.String() {
+			continue
+		}
+
+		name := newAliasDto(alias.Alias).Domain + "."
+		ttl := ""
+		if t := d.effectiveTTL(alias.Alias); t > 0 {
+			ttl = fmt.Sprintf("%d\t", t)
+		}
+
+		for _, value := range aliasAddresses(alias.Alias) {
+			sb.WriteString(fmt.Sprintf("%s\t%sIN\t%s\t%s\n", name, ttl, dns.RecordType(value), value))
+		}
+	}
+
+	return proto.ZoneDto{Domain: domainConf.String(), Zone: sb.String()}, nil
+}
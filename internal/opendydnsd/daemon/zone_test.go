@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDaemon_ExportZone(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name: "dummy",
+					Domains: []config.DomainConfig{
+						{Domain: "example.org", TTL: 300, MaxLabels: 2},
+						{Domain: "example.org", Host: "dyn"},
+					},
+				},
+			},
+		},
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Admin: false}, nil)
+	if _, err := d.ExportZone(proto.UserContext{UserID: 1}, "example.org"); err != proto.ErrForbidden {
+		t.Errorf("ExportZone() should have returned ErrForbidden: %v", err)
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Admin: true}, nil)
+	if _, err := d.ExportZone(proto.UserContext{UserID: 1}, "example.com"); err != proto.ErrDomainNotFound {
+		t.Errorf("ExportZone() should have returned ErrDomainNotFound: %v", err)
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Admin: true}, nil)
+	dbMock.EXPECT().ListAllAliases().Return([]database.OwnedAlias{
+		{Alias: database.Alias{Host: "bar", Domain: "example.org", Value6: "2001:db8::1", Enabled: true, TTL: 60}},
+		{Alias: database.Alias{Host: "dual", Domain: "example.org", Value: "192.0.2.5", Value6: "2001:db8::5", Enabled: true}},
+		{Alias: database.Alias{Host: "foo", Domain: "example.org", Value: "192.0.2.1", Enabled: true}},
+		{Alias: database.Alias{Host: "off", Domain: "example.org", Value: "192.0.2.2", Enabled: false}},
+		{Alias: database.Alias{Host: "a", Domain: "b.example.org", Value: "192.0.2.3", Enabled: true}},
+		{Alias: database.Alias{Host: "home", Domain: "dyn.example.org", Value: "192.0.2.4", Enabled: true}},
+	}, nil)
+
+	zone, err := d.ExportZone(proto.UserContext{UserID: 1}, "Example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.Domain != "example.org" {
+		t.Errorf("wrong domain: %s", zone.Domain)
+	}
+
+	// the fragment must be usable as is in the authoritative zone
+	var records []string
+	parser := dns.NewZoneParser(strings.NewReader(zone.Zone), "example.org.", "")
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		records = append(records, rr.String())
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"bar.example.org.\t60\tIN\tAAAA\t2001:db8::1",
+		"dual.example.org.\t300\tIN\tA\t192.0.2.5",
+		"dual.example.org.\t300\tIN\tAAAA\t2001:db8::5",
+		"foo.example.org.\t300\tIN\tA\t192.0.2.1",
+		"a.b.example.org.\t300\tIN\tA\t192.0.2.3",
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("wrong records exported: %v", records)
+	}
+}
@@ -1,11 +1,21 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog"
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 //go:generate mockgen -source database.go -destination=../database_mock/database_mock.go -package=database_mock
@@ -16,57 +26,231 @@ type User struct {
 
 	Email    string `gorm:"unique"`
 	Password string
+	// TokenVersion is bumped to revoke all the user tokens at once
+	TokenVersion uint
+	Admin        bool
+	// Disabled users cannot log in and their tokens are rejected
+	Disabled bool
+	// VerificationTokenHash is the hash of the token verifying the email address of a
+	// self registered user, who cannot log in until then. Empty once verified
+	VerificationTokenHash string `gorm:"index"`
+	// VerificationExpiry is the time after which the unverified registration can be made again
+	VerificationExpiry *time.Time
 
 	Aliases []Alias
 }
 
+// Verified determinate if the user email address has been verified
+// the users not created through the self registration are always verified
+func (u User) Verified() bool {
+	return u.VerificationTokenHash == ""
+}
+
 // Alias is the mapping of a DyDNS alias
+// deleted aliases are soft deleted: the queries exclude them and their name is available again
 type Alias struct {
 	gorm.Model
 
 	Host   string
 	Domain string
+	// Value is the IPv4 address published as an A record
+	// and Value6 the IPv6 one published as an AAAA record. Either may be empty
 	Value  string
+	Value6 string
+	TTL    int  // 0 means the domain default
 	UserID uint // FK
+	// Enabled is false when the alias is withdrawn from the DNS
+	Enabled bool `gorm:"default:true"`
+	// SourceIP and SourceUserAgent identify the client which last updated the alias
+	// only stored if enabled in the configuration
+	SourceIP        string
+	SourceUserAgent string
+	// Follow is the name whose address is copied into the value, empty for a static alias
+	Follow string
+	// Version is incremented on each update, to detect the concurrent ones
+	Version uint `gorm:"not null;default:1"`
+}
+
+// OwnedAlias is an alias along with the email of its owner
+type OwnedAlias struct {
+	Alias
+	OwnerEmail string
+}
+
+// OwnedEvent is an event along with the email of the user it belongs to
+// the email is empty if the user has been deleted since
+type OwnedEvent struct {
+	Event
+	OwnerEmail string
+}
+
+// AliasFilter restrict the listed aliases, the empty fields match every alias
+type AliasFilter struct {
+	// Domain is the base domain of the aliases
+	Domain string
+	// Owner is the email of the alias owner
+	Owner string
+}
+
+// ErrAliasConflict is returned when updating an alias which has been changed (or deleted) meanwhile
+var ErrAliasConflict = errors.New("alias has been changed meanwhile")
+
+// ErrVacuumNotSupported is returned when trying to vacuum a database whose driver doesn't support it
+var ErrVacuumNotSupported = errors.New("vacuum is only supported by the sqlite driver")
+
+// ErrInvalidDSN is returned when the DSN cannot be parsed by the configured driver
+var ErrInvalidDSN = errors.New("invalid DSN")
+
+// vacuumBusyTimeout is the time given to the writers to release the database before giving up a vacuum
+var vacuumBusyTimeout = 5 * time.Second
+
+// ErrDatabaseBusy is returned when trying to vacuum the database while it is locked by writers
+// (this process or another one) for longer than the busy timeout
+var ErrDatabaseBusy = errors.New("database is busy")
+
+// Event is the mapping of an alias change event
+// its ID is used as a monotonic cursor by the consumers
+type Event struct {
+	gorm.Model
+
+	Type  string
+	Alias string
+	Value string
+	// PreviousValue is the value of the alias before the change
+	PreviousValue string
+	UserID        uint `gorm:"index"`
+}
+
+// PendingRecord is the mapping of a DNS record change which has not been applied
+// because the DNS provider was unavailable. They are replayed in order.
+type PendingRecord struct {
+	gorm.Model
+
+	Operation string // add, update or delete
+	// AliasDomain is the domain of the alias, used to find the DNS provisioner
+	AliasDomain string
+	Host        string
+	Domain      string
+	Value       string
+	TTL         int
+	Attempts    int
+}
+
+// Webhook is the mapping of an endpoint notified of the changes of its user aliases
+type Webhook struct {
+	gorm.Model
+
+	UserID uint `gorm:"index"`
+	URL    string
+	// Secret is used to sign the notifications, they are not signed if empty
+	Secret string
+}
+
+// Share is the mapping of a read-only access to the value of an alias
+// only the hash of its token is stored
+type Share struct {
+	gorm.Model
+
+	UserID    uint `gorm:"index"`
+	AliasID   uint
+	TokenHash string `gorm:"uniqueIndex;size:64"`
+	// ExpiresAt is nil if the share never expires
+	ExpiresAt *time.Time
 }
 
 // Connection represent a connection to the database
 // to perform CRUD
 type Connection interface {
 	CreateUser(email, hashedPassword string) (User, error)
+	CreateUnverifiedUser(email, hashedPassword, tokenHash string, expiresAt time.Time) (User, error)
+	ResetRegistration(userID uint, hashedPassword, tokenHash string, expiresAt time.Time) error
+	VerifyUser(tokenHash string, now time.Time) (User, error)
 	FindUser(email string) (User, error)
+	FindUserByID(userID uint) (User, error)
+	IncrementTokenVersion(userID uint) error
+	SetAdmin(email string, admin bool) error
+	SetDisabled(email string, disabled bool) error
+	ListUsers() ([]User, error)
+	UpdatePassword(userID uint, password string) error
 	FindUserAliases(userID uint) ([]Alias, error)
+	FindUserAliasesAfter(userID, cursor uint, limit int) ([]Alias, error)
 	FindAlias(host, domain string) (Alias, error)
+	FindAliasByID(id uint) (Alias, error)
+	FindFollowingAliases() ([]Alias, error)
+	ListAllAliases() ([]OwnedAlias, error)
+	FindAliasesAfter(cursor uint, limit int, filter AliasFilter) ([]OwnedAlias, error)
 	CreateAlias(alias Alias, userID uint) (Alias, error)
 	DeleteAlias(host, domain string, userID uint) error
 	UpdateAlias(alias Alias) (Alias, error)
+	CreateEvent(event Event) (Event, error)
+	FindUserEvents(userID, cursor uint, limit int) ([]Event, error)
+	FindEvents(cursor uint, limit int) ([]OwnedEvent, error)
+	CountAliases() (int64, error)
+	CountUsers() (int64, error)
+	CountUserAliases(userID uint) (int64, error)
+	CountActiveUsers(since time.Time) (int64, error)
+	CreatePendingRecord(record PendingRecord) (PendingRecord, error)
+	FindPendingRecords(limit int) ([]PendingRecord, error)
+	IncrementPendingRecordAttempts(id uint) error
+	DeletePendingRecord(id uint) error
+	CountPendingRecords() (int64, error)
+	CreateWebhook(webhook Webhook) (Webhook, error)
+	FindUserWebhooks(userID uint) ([]Webhook, error)
+	CountUserWebhooks(userID uint) (int64, error)
+	DeleteWebhook(id, userID uint) error
+	CreateShare(share Share) (Share, error)
+	FindShare(tokenHash string) (Share, error)
+	FindUserShares(userID uint) ([]Share, error)
+	DeleteShare(id, userID uint) error
+	Vacuum() error
 }
 
 type connection struct {
-	connection *gorm.DB
+	connection *gorm.DB   // the primary, used for writes
+	replicas   []*gorm.DB // the read replicas, if any
+	next       uint32     // index of the next replica to read from
+	driver     string
 }
 
 // OpenConnection tries to open a new database connection using given config
+// the pending migrations are applied unless they must be applied manually
 func OpenConnection(conf config.DatabaseConfig, logger *zerolog.Logger) (Connection, error) {
-	driver, err := getDriver(conf)
+	conn, err := openDatabase(conf, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := gorm.Open(driver, &gorm.Config{
-		Logger: &zeroLogger{logger: logger},
-	})
+	migrator := &Migrator{db: conn, logger: logger}
+	pending, err := migrator.Pending()
 	if err != nil {
 		return nil, err
 	}
+	if pending {
+		if conf.ManualMigrations {
+			return nil, ErrPendingMigrations
+		}
+		if err := migrator.Migrate(migrator.LatestVersion()); err != nil {
+			return nil, err
+		}
+	}
 
-	// TODO remove? better?
-	if err := conn.AutoMigrate(&Alias{}, &User{}); err != nil {
-		return nil, err
+	// the replicas schema is managed by the replication
+	var replicas []*gorm.DB
+	for _, dsn := range conf.ReplicaDSNs {
+		replicaConf := conf
+		replicaConf.DSN = dsn
+
+		replica, err := openDatabase(replicaConf, logger)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
 	}
 
 	return &connection{
 		connection: conn,
+		replicas:   replicas,
+		driver:     conf.Driver,
 	}, nil
 }
 
@@ -80,47 +264,541 @@ func (c *connection) CreateUser(email, hashedPassword string) (User, error) {
 	return user, result.Error
 }
 
+// CreateUnverifiedUser create an user who cannot log in until given token is verified
+func (c *connection) CreateUnverifiedUser(email, hashedPassword, tokenHash string, expiresAt time.Time) (User, error) {
+	user := User{
+		Email:                 email,
+		Password:              hashedPassword,
+		VerificationTokenHash: tokenHash,
+		VerificationExpiry:    &expiresAt,
+	}
+
+	result := c.connection.Create(&user)
+	return user, result.Error
+}
+
+// ResetRegistration replace the password and the verification token of an unverified user
+// gorm.ErrRecordNotFound is returned if the user has been verified meanwhile
+func (c *connection) ResetRegistration(userID uint, hashedPassword, tokenHash string, expiresAt time.Time) error {
+	result := c.connection.Model(&User{}).
+		Where("id = ? AND verification_token_hash <> ''", userID).
+		Updates(map[string]interface{}{
+			"password":                hashedPassword,
+			"verification_token_hash": tokenHash,
+			"verification_expiry":     expiresAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// VerifyUser mark the user having given verification token as verified
+// gorm.ErrRecordNotFound is returned if there is none or if the token has expired
+func (c *connection) VerifyUser(tokenHash string, now time.Time) (User, error) {
+	var user User
+	if tokenHash == "" {
+		return user, gorm.ErrRecordNotFound
+	}
+
+	result := c.connection.Where("verification_token_hash = ? AND verification_expiry > ?", tokenHash, now).First(&user)
+	if result.Error != nil {
+		return user, result.Error
+	}
+
+	// the token may be used concurrently
+	result = c.connection.Model(&User{}).
+		Where("id = ? AND verification_token_hash = ?", user.ID, tokenHash).
+		Updates(map[string]interface{}{"verification_token_hash": "", "verification_expiry": nil})
+	if result.Error != nil {
+		return user, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return user, gorm.ErrRecordNotFound
+	}
+
+	user.VerificationTokenHash = ""
+	user.VerificationExpiry = nil
+	return user, nil
+}
+
+// FindUser always read from the primary since it's used to check
+// the password and the uniqueness of the email, which must not lag behind a change
 func (c *connection) FindUser(email string) (User, error) {
 	var user User
 	result := c.connection.Where("email = ?", email).First(&user)
 	return user, result.Error
 }
 
+// FindUserByID always read from the primary since it's used to check
+// the token version, which must not lag behind a revocation
+func (c *connection) FindUserByID(userID uint) (User, error) {
+	var user User
+	result := c.connection.First(&user, userID)
+	return user, result.Error
+}
+
+func (c *connection) IncrementTokenVersion(userID uint) error {
+	result := c.connection.Model(&User{Model: gorm.Model{ID: userID}}).
+		UpdateColumn("token_version", gorm.Expr("token_version + ?", 1))
+	return result.Error
+}
+
+func (c *connection) UpdatePassword(userID uint, password string) error {
+	result := c.connection.Model(&User{Model: gorm.Model{ID: userID}}).UpdateColumn("password", password)
+	return result.Error
+}
+
+func (c *connection) SetAdmin(email string, admin bool) error {
+	result := c.connection.Model(&User{}).Where("email = ?", email).UpdateColumn("admin", admin)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetDisabled disable (or enable back) the user having given email
+// gorm.ErrRecordNotFound is returned if there is none
+func (c *connection) SetDisabled(email string, disabled bool) error {
+	result := c.connection.Model(&User{}).Where("email = ?", email).UpdateColumn("disabled", disabled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ListUsers return every user ordered by email, the deleted ones excluded
+func (c *connection) ListUsers() ([]User, error) {
+	var users []User
+	result := c.reader().Order("email").Find(&users)
+	return users, result.Error
+}
+
+// FindUserAliases return the user aliases, the deleted ones excluded
 func (c *connection) FindUserAliases(userID uint) ([]Alias, error) {
 	var aliases []Alias
-	err := c.connection.Model(&User{Model: gorm.Model{ID: userID}}).Association("Aliases").Find(&aliases)
+	err := c.reader().Model(&User{Model: gorm.Model{ID: userID}}).Association("Aliases").Find(&aliases)
 	return aliases, err
 }
 
+// FindUserAliasesAfter return a page of the user aliases ordered by ID
+// starting after given cursor. Unlike an offset, the cursor stays valid
+// when aliases are inserted or removed between two calls.
+func (c *connection) FindUserAliasesAfter(userID, cursor uint, limit int) ([]Alias, error) {
+	var aliases []Alias
+	result := c.reader().
+		Where("user_id = ? AND id > ?", userID, cursor).
+		Order("id").
+		Limit(limit).
+		Find(&aliases)
+	return aliases, result.Error
+}
+
+// FindAlias always read from the primary since the result is used
+// to decide upon writes (uniqueness and ownership checks)
+// deleted aliases are ignored so their name can be registered again
 func (c *connection) FindAlias(host, domain string) (Alias, error) {
 	var alias Alias
-	result := c.connection.Where("host = ? AND domain = ?", host, domain).First(&alias)
+	result := c.connection.
+		Where("LOWER(host) = ? AND LOWER(domain) = ?", strings.ToLower(host), strings.ToLower(domain)).
+		First(&alias)
+	return alias, result.Error
+}
+
+// FindAliasByID return the alias, unless deleted
+func (c *connection) FindAliasByID(id uint) (Alias, error) {
+	var alias Alias
+	result := c.reader().First(&alias, id)
 	return alias, result.Error
 }
 
+// ListAllAliases return the aliases of every user, ordered by name
+// the soft delete scope only applies to the aliases, hence the explicit users condition
+func (c *connection) ListAllAliases() ([]OwnedAlias, error) {
+	var aliases []OwnedAlias
+	result := c.reader().Model(&Alias{}).
+		Select("aliases.*, users.email AS owner_email").
+		Joins("JOIN users ON users.id = aliases.user_id AND users.deleted_at IS NULL").
+		Order("aliases.domain, aliases.host").
+		Scan(&aliases)
+	return aliases, result.Error
+}
+
+// FindAliasesAfter return a page of the aliases of every user matching given filter,
+// ordered by ID starting after given cursor (see FindUserAliasesAfter)
+func (c *connection) FindAliasesAfter(cursor uint, limit int, filter AliasFilter) ([]OwnedAlias, error) {
+	query := c.reader().Model(&Alias{}).
+		Select("aliases.*, users.email AS owner_email").
+		Joins("JOIN users ON users.id = aliases.user_id AND users.deleted_at IS NULL").
+		Where("aliases.id > ?", cursor)
+	if filter.Domain != "" {
+		query = query.Where("aliases.domain = ?", strings.ToLower(filter.Domain))
+	}
+	if filter.Owner != "" {
+		query = query.Where("users.email = ?", filter.Owner)
+	}
+
+	var aliases []OwnedAlias
+	result := query.Order("aliases.id").Limit(limit).Scan(&aliases)
+	return aliases, result.Error
+}
+
 func (c *connection) CreateAlias(alias Alias, userID uint) (Alias, error) {
+	alias.Host = strings.ToLower(alias.Host)
+	alias.Domain = strings.ToLower(alias.Domain)
+	alias.Version = 1
+
 	err := c.connection.Model(&User{Model: gorm.Model{ID: userID}}).Association("Aliases").Append(&alias)
 	return alias, err
 }
 
+// DeleteAlias soft delete the user alias
+// the already deleted aliases sharing the same name are left untouched
 func (c *connection) DeleteAlias(host, domain string, userID uint) error {
-	result := c.connection.Where("host = ? AND domain = ? AND user_id = ?", host, domain, userID).Delete(Alias{})
+	result := c.connection.
+		Where("LOWER(host) = ? AND LOWER(domain) = ? AND user_id = ?", strings.ToLower(host), strings.ToLower(domain), userID).
+		Delete(Alias{})
 	return result.Error
 }
 
+// FindFollowingAliases return the aliases following another name
+func (c *connection) FindFollowingAliases() ([]Alias, error) {
+	var aliases []Alias
+	result := c.reader().Where("follow <> ?", "").Order("id").Find(&aliases)
+	return aliases, result.Error
+}
+
+// UpdateAlias update given alias, provided its version has not changed since it was read
+// ErrAliasConflict is returned otherwise
 func (c *connection) UpdateAlias(alias Alias) (Alias, error) {
-	result := c.connection.Model(&alias).Updates(Alias{
-		Domain: alias.Domain,
-		Value:  alias.Value,
+	alias.Host = strings.ToLower(alias.Host)
+	alias.Domain = strings.ToLower(alias.Domain)
+	version := alias.Version
+
+	result := c.connection.Model(&alias).
+		// the soft deleted aliases are not excluded from the updates by gorm
+		Where("version = ? AND deleted_at IS NULL", version).
+		Select("host", "domain", "value", "value6", "ttl", "enabled", "source_ip", "source_user_agent", "follow", "version").
+		Updates(Alias{
+			Host:            alias.Host,
+			Domain:          alias.Domain,
+			Value:           alias.Value,
+			Value6:          alias.Value6,
+			TTL:             alias.TTL,
+			Enabled:         alias.Enabled,
+			SourceIP:        alias.SourceIP,
+			SourceUserAgent: alias.SourceUserAgent,
+			Follow:          alias.Follow,
+			Version:         version + 1,
+		})
+	if result.Error != nil {
+		return alias, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return alias, ErrAliasConflict
+	}
+
+	alias.Version = version + 1
+	return alias, nil
+}
+
+func (c *connection) CreateEvent(event Event) (Event, error) {
+	result := c.connection.Create(&event)
+	return event, result.Error
+}
+
+func (c *connection) FindUserEvents(userID, cursor uint, limit int) ([]Event, error) {
+	var events []Event
+	result := c.reader().
+		Where("user_id = ? AND id > ?", userID, cursor).
+		Order("id").
+		Limit(limit).
+		Find(&events)
+	return events, result.Error
+}
+
+// FindEvents return the events of every user that happened after given cursor, ordered by ID
+func (c *connection) FindEvents(cursor uint, limit int) ([]OwnedEvent, error) {
+	var events []OwnedEvent
+	result := c.reader().Model(&Event{}).
+		Select("events.*, users.email AS owner_email").
+		Joins("LEFT JOIN users ON users.id = events.user_id AND users.deleted_at IS NULL").
+		Where("events.id > ?", cursor).
+		Order("events.id").
+		Limit(limit).
+		Scan(&events)
+	return events, result.Error
+}
+
+func (c *connection) CountAliases() (int64, error) {
+	var count int64
+	result := c.reader().Model(&Alias{}).Count(&count)
+	return count, result.Error
+}
+
+// CountUsers count the users, the deleted ones included
+// always read from the primary since it is used to decide whether to create the first user
+func (c *connection) CountUsers() (int64, error) {
+	var count int64
+	result := c.connection.Unscoped().Model(&User{}).Count(&count)
+	return count, result.Error
+}
+
+// CountUserAliases count the aliases owned by given user
+func (c *connection) CountUserAliases(userID uint) (int64, error) {
+	var count int64
+	result := c.reader().Model(&Alias{}).Where("user_id = ?", userID).Count(&count)
+	return count, result.Error
+}
+
+// CountActiveUsers count the users having updated at least one alias since given time
+func (c *connection) CountActiveUsers(since time.Time) (int64, error) {
+	var count int64
+	result := c.reader().Model(&Alias{}).
+		Where("updated_at > ?", since).
+		Distinct("user_id").
+		Count(&count)
+	return count, result.Error
+}
+
+func (c *connection) CreatePendingRecord(record PendingRecord) (PendingRecord, error) {
+	result := c.connection.Create(&record)
+	return record, result.Error
+}
+
+// FindPendingRecords return the oldest pending records, in the order they must be replayed
+// always read from the primary since the records are deleted once replayed
+func (c *connection) FindPendingRecords(limit int) ([]PendingRecord, error) {
+	var records []PendingRecord
+	result := c.connection.Order("id").Limit(limit).Find(&records)
+	return records, result.Error
+}
+
+func (c *connection) IncrementPendingRecordAttempts(id uint) error {
+	result := c.connection.Model(&PendingRecord{Model: gorm.Model{ID: id}}).
+		UpdateColumn("attempts", gorm.Expr("attempts + ?", 1))
+	return result.Error
+}
+
+// DeletePendingRecord permanently delete the pending record, once replayed or given up
+func (c *connection) DeletePendingRecord(id uint) error {
+	result := c.connection.Unscoped().Delete(&PendingRecord{}, id)
+	return result.Error
+}
+
+// CountPendingRecords always read from the primary since the result is used
+// to decide whether a DNS record change must be queued
+func (c *connection) CountPendingRecords() (int64, error) {
+	var count int64
+	result := c.connection.Model(&PendingRecord{}).Count(&count)
+	return count, result.Error
+}
+
+func (c *connection) CreateWebhook(webhook Webhook) (Webhook, error) {
+	result := c.connection.Create(&webhook)
+	return webhook, result.Error
+}
+
+func (c *connection) FindUserWebhooks(userID uint) ([]Webhook, error) {
+	var webhooks []Webhook
+	result := c.reader().Where("user_id = ?", userID).Order("id").Find(&webhooks)
+	return webhooks, result.Error
+}
+
+func (c *connection) CountUserWebhooks(userID uint) (int64, error) {
+	var count int64
+	result := c.reader().Model(&Webhook{}).Where("user_id = ?", userID).Count(&count)
+	return count, result.Error
+}
+
+// DeleteWebhook delete the webhook if owned by given user
+// gorm.ErrRecordNotFound is returned otherwise
+func (c *connection) DeleteWebhook(id, userID uint) error {
+	result := c.connection.Where("user_id = ?", userID).Delete(&Webhook{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (c *connection) CreateShare(share Share) (Share, error) {
+	result := c.connection.Create(&share)
+	return share, result.Error
+}
+
+// FindShare always read from the primary since a revoked share must stop working at once
+func (c *connection) FindShare(tokenHash string) (Share, error) {
+	var share Share
+	result := c.connection.Where("token_hash = ?", tokenHash).First(&share)
+	return share, result.Error
+}
+
+func (c *connection) FindUserShares(userID uint) ([]Share, error) {
+	var shares []Share
+	result := c.reader().Where("user_id = ?", userID).Order("id").Find(&shares)
+	return shares, result.Error
+}
+
+// DeleteShare permanently delete the share if owned by given user
+// gorm.ErrRecordNotFound is returned otherwise
+func (c *connection) DeleteShare(id, userID uint) error {
+	result := c.connection.Unscoped().Where("user_id = ?", userID).Delete(&Share{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (c *connection) Vacuum() error {
+	if c.driver != "sqlite" {
+		return ErrVacuumNotSupported
+	}
+
+	db, err := c.connection.DB()
+	if err != nil {
+		return err
+	}
+
+	// the busy timeout is set on a connection of its own, not to change the one of the pool
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var previousTimeout int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&previousTimeout); err != nil {
+		return err
+	}
+
+	// VACUUM requires an exclusive lock: give up if the writers don't release the database in time,
+	// whether they are run by this process or by another one (e.g. the daemon while running the vacuum command)
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", vacuumBusyTimeout.Milliseconds())); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", previousTimeout))
+	}()
+
+	// reclaim free pages then refresh the query planner statistics
+	for _, statement := range []string{"VACUUM", "ANALYZE"} {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+				return ErrDatabaseBusy
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reader return the database to use for reads
+// the replicas are used in turn, the primary is used if there is none
+func (c *connection) reader() *gorm.DB {
+	if len(c.replicas) == 0 {
+		return c.connection
+	}
+
+	i := atomic.AddUint32(&c.next, 1)
+	return c.replicas[int(i)%len(c.replicas)]
+}
+
+// openDatabase open the database described by given config, without migrating it
+func openDatabase(conf config.DatabaseConfig, logger *zerolog.Logger) (*gorm.DB, error) {
+	driver, err := getDriver(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(driver, &gorm.Config{
+		Logger: &zeroLogger{logger: logger},
 	})
-	return alias, result.Error
+	if err != nil {
+		return nil, err
+	}
+	if err := configurePool(db, conf); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// configurePool apply the configured connection pool settings to given database
+func configurePool(db *gorm.DB, conf config.DatabaseConfig) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	sqlDB.SetMaxOpenConns(conf.MaxOpenConns)
+	if conf.MaxIdleConns != 0 {
+		sqlDB.SetMaxIdleConns(conf.MaxIdleConns)
+	}
+	sqlDB.SetConnMaxLifetime(conf.ConnMaxLifetime)
+
+	return nil
 }
 
 func getDriver(conf config.DatabaseConfig) (gorm.Dialector, error) {
 	switch conf.Driver {
 	case "sqlite":
 		return sqlite.Open(conf.DSN), nil
+	case "postgres":
+		// report a malformed DSN rather than a connection failure
+		// the parsing error is not returned since it may contain the password
+		if _, err := pgconn.ParseConfig(conf.DSN); err != nil {
+			return nil, ErrInvalidDSN
+		}
+		return postgres.Open(conf.DSN), nil
+	case "mysql":
+		dsn, err := mysqlDSN(conf.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return gormmysql.Open(dsn), nil
 	default:
 		return nil, fmt.Errorf("no database driver named `%s` found", conf.Driver)
 	}
 }
+
+// mysqlDSN return given DSN along with the parameters the daemon relies upon
+// the dates are parsed (parseTime) and the text is exchanged using utf8mb4 unless another charset is given
+func mysqlDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", ErrInvalidDSN
+	}
+
+	cfg.ParseTime = true
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	if _, exist := cfg.Params["charset"]; !exist {
+		cfg.Params["charset"] = "utf8mb4"
+	}
+
+	return cfg.FormatDSN(), nil
+}
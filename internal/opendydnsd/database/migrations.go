@@ -0,0 +1,411 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// ErrPendingMigrations is returned when opening a database whose schema is not up to date
+// while the migrations must be applied manually
+var ErrPendingMigrations = errors.New("database has pending migrations, run `opendydnsd migrate`")
+
+// ErrUnknownMigration is returned when the database has been migrated by a more recent version of the daemon
+// or when targeting a version which doesn't exist
+var ErrUnknownMigration = errors.New("unknown migration version")
+
+// ErrIrreversibleMigration is returned when reverting a migration which cannot be
+var ErrIrreversibleMigration = errors.New("migration cannot be reverted")
+
+// migration is a versioned change of the database schema
+// the migrations must never be changed once released: a change of the models comes with a new migration
+type migration struct {
+	version uint
+	name    string
+	up      func(tx *gorm.DB) error
+	// down is nil if the migration cannot be reverted
+	down func(tx *gorm.DB) error
+}
+
+// schemaMigration is the mapping of an applied migration
+type schemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus is the status of a migration
+type MigrationStatus struct {
+	Version uint
+	Name    string
+	// AppliedAt is nil if the migration is pending
+	AppliedAt *time.Time
+}
+
+// the models as of the first migration, they must not follow the changes of the models
+type (
+	userV1 struct {
+		gorm.Model
+
+		Email                 string `gorm:"unique"`
+		Password              string
+		TokenVersion          uint
+		Admin                 bool
+		Disabled              bool
+		VerificationTokenHash string `gorm:"index"`
+		VerificationExpiry    *time.Time
+
+		Aliases []aliasV1 `gorm:"foreignKey:UserID"`
+	}
+	aliasV1 struct {
+		gorm.Model
+
+		Host            string
+		Domain          string
+		Value           string
+		Value6          string
+		TTL             int
+		UserID          uint
+		Enabled         bool `gorm:"default:true"`
+		SourceIP        string
+		SourceUserAgent string
+		Follow          string
+		Version         uint `gorm:"not null;default:1"`
+	}
+	eventV1 struct {
+		gorm.Model
+
+		Type          string
+		Alias         string
+		Value         string
+		PreviousValue string
+		UserID        uint `gorm:"index"`
+	}
+	pendingRecordV1 struct {
+		gorm.Model
+
+		Operation   string
+		AliasDomain string
+		Host        string
+		Domain      string
+		Value       string
+		TTL         int
+		Attempts    int
+	}
+	webhookV1 struct {
+		gorm.Model
+
+		UserID uint `gorm:"index"`
+		URL    string
+		Secret string
+	}
+	shareV1 struct {
+		gorm.Model
+
+		UserID    uint `gorm:"index"`
+		AliasID   uint
+		TokenHash string `gorm:"uniqueIndex;size:64"`
+		ExpiresAt *time.Time
+	}
+)
+
+func (userV1) TableName() string          { return "users" }
+func (aliasV1) TableName() string         { return "aliases" }
+func (eventV1) TableName() string         { return "events" }
+func (pendingRecordV1) TableName() string { return "pending_records" }
+func (webhookV1) TableName() string       { return "webhooks" }
+func (shareV1) TableName() string         { return "shares" }
+
+// migrations are the migrations of the database schema, ordered by version
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create tables",
+		// the databases created before the migrations were introduced are brought up to date
+		// the aliases reference the users: they are created after them
+		// it cannot be reverted since it would drop the data of these databases along with the tables
+		up: func(tx *gorm.DB) error {
+			return createOrComplete(tx, &userV1{}, &aliasV1{}, &eventV1{}, &pendingRecordV1{}, &webhookV1{}, &shareV1{})
+		},
+	},
+	{
+		version: 2,
+		name:    "aliases case insensitive unique index",
+		// enforce the aliases uniqueness regardless of the case (deleted aliases excluded)
+		// this fails if the database already contains duplicates, which must be merged manually
+		// MySQL doesn't support partial indexes: see migration 4
+		up: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() == "mysql" {
+				return nil
+			}
+			return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_aliases_name " +
+				"ON aliases (LOWER(host), LOWER(domain)) WHERE deleted_at IS NULL").Error
+		},
+		down: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() == "mysql" {
+				return nil
+			}
+			return tx.Exec("DROP INDEX IF EXISTS idx_aliases_name").Error
+		},
+	},
+	{
+		version: 3,
+		name:    "move IPv6 values to value6",
+		// the IPv6 addresses used to be stored as the value
+		up: func(tx *gorm.DB) error {
+			return tx.Exec("UPDATE aliases SET value6 = value, value = '' " +
+				"WHERE value LIKE '%:%' AND (value6 IS NULL OR value6 = '')").Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Exec("UPDATE aliases SET value = value6, value6 = '' " +
+				"WHERE (value IS NULL OR value = '') AND value6 <> ''").Error
+		},
+	},
+	{
+		version: 4,
+		name:    "aliases unique key on mysql",
+		// the unique key includes a generated column which is NULL for the deleted aliases (the NULLs never collide)
+		// the names are compared using the case insensitive collation of the columns, and are stored lower case anyway
+		// the TEXT columns cannot be fully indexed: they are turned into VARCHAR, long enough for any DNS name
+		up: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "mysql" {
+				return nil
+			}
+			return execAll(tx,
+				"ALTER TABLE aliases MODIFY host VARCHAR(255), MODIFY domain VARCHAR(255), "+
+					"ADD COLUMN active TINYINT AS (IF(deleted_at IS NULL, 1, NULL)) VIRTUAL",
+				"CREATE UNIQUE INDEX idx_aliases_name ON aliases (host, domain, active)",
+			)
+		},
+		down: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "mysql" {
+				return nil
+			}
+			return execAll(tx,
+				"DROP INDEX idx_aliases_name ON aliases",
+				"ALTER TABLE aliases DROP COLUMN active, MODIFY host LONGTEXT, MODIFY domain LONGTEXT",
+			)
+		},
+	},
+}
+
+// Migrator apply the migrations to the database schema
+type Migrator struct {
+	db     *gorm.DB
+	logger *zerolog.Logger
+}
+
+// NewMigrator return a migrator of the database (the primary) described by given config
+func NewMigrator(conf config.DatabaseConfig, logger *zerolog.Logger) (*Migrator, error) {
+	db, err := openDatabase(conf, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, logger: logger}, nil
+}
+
+// LatestVersion return the version of the latest migration known
+func (m *Migrator) LatestVersion() uint {
+	return migrations[len(migrations)-1].version
+}
+
+// Status return the status of each migration known, along with the ones applied by a more recent daemon
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, mig := range migrations {
+		status := MigrationStatus{Version: mig.version, Name: mig.name}
+		if record, exist := applied[mig.version]; exist {
+			appliedAt := record.AppliedAt
+			status.AppliedAt = &appliedAt
+			delete(applied, mig.version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	var unknown []MigrationStatus
+	for _, record := range applied {
+		appliedAt := record.AppliedAt
+		unknown = append(unknown, MigrationStatus{Version: record.Version, Name: record.Name, AppliedAt: &appliedAt})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+
+	return append(statuses, unknown...), nil
+}
+
+// Pending determinate if some migrations have not been applied
+// ErrUnknownMigration is returned if the database has been migrated by a more recent daemon
+func (m *Migrator) Pending() (bool, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return false, err
+	}
+
+	for version := range applied {
+		if findMigration(version) == nil {
+			return false, ErrUnknownMigration
+		}
+	}
+
+	return len(applied) != len(migrations), nil
+}
+
+// Migrate apply the pending migrations up to target version (included), in order,
+// and revert the applied ones above it, in reverse order. Each migration is run in its own transaction
+func (m *Migrator) Migrate(target uint) error {
+	if target != 0 && findMigration(target) == nil {
+		return ErrUnknownMigration
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	// the migrations applied by a more recent daemon can only be reverted by it
+	for version := range applied {
+		if version > target && findMigration(version) == nil {
+			return fmt.Errorf("%w: %d cannot be reverted by this version of the daemon", ErrUnknownMigration, version)
+		}
+	}
+
+	// nothing is reverted if one of the migrations cannot be
+	for _, mig := range migrations {
+		if _, exist := applied[mig.version]; exist && mig.version > target && mig.down == nil {
+			return fmt.Errorf("%w: %d (%s)", ErrIrreversibleMigration, mig.version, mig.name)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mig := migrations[i]
+		if _, exist := applied[mig.version]; exist && mig.version > target {
+			if err := m.run(mig, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, mig := range migrations {
+		if _, exist := applied[mig.version]; !exist && mig.version <= target {
+			if err := m.run(mig, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// run apply (or revert) given migration and record it
+func (m *Migrator) run(mig migration, up bool) error {
+	action := "reverting"
+	if up {
+		action = "applying"
+	}
+	m.logger.Info().Uint("Version", mig.version).Str("Name", mig.name).Msgf("%s migration.", action)
+
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if !up {
+			if err := mig.down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, mig.version).Error
+		}
+
+		if err := mig.up(tx); err != nil {
+			return err
+		}
+		return tx.Create(&schemaMigration{Version: mig.version, Name: mig.name, AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("error while %s migration %d (%s): %w", action, mig.version, mig.name, err)
+	}
+
+	return nil
+}
+
+// applied return the applied migrations, by version
+func (m *Migrator) applied() (map[uint]schemaMigration, error) {
+	if err := createOrComplete(m.db, &schemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	var records []schemaMigration
+	if err := m.db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[uint]schemaMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// createOrComplete create the tables of given models, or add their missing columns & indexes
+// unlike gorm AutoMigrate, the existing columns are never altered (which sqlite does in its own transaction)
+func createOrComplete(tx *gorm.DB, models ...interface{}) error {
+	migrator := tx.Migrator()
+	for _, model := range models {
+		if !migrator.HasTable(model) {
+			if err := migrator.CreateTable(model); err != nil {
+				return err
+			}
+			continue
+		}
+
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+
+		for _, dbName := range stmt.Schema.DBNames {
+			if !migrator.HasColumn(model, dbName) {
+				if err := migrator.AddColumn(model, dbName); err != nil {
+					return err
+				}
+			}
+		}
+		for name := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(model, name) {
+				if err := migrator.CreateIndex(model, name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// execAll execute given statements in order, stopping at the first error
+func execAll(tx *gorm.DB, statements ...string) error {
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findMigration(version uint) *migration {
+	for i := range migrations {
+		if migrations[i].version == version {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
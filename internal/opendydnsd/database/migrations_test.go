@@ -0,0 +1,183 @@
+package database
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMigrator_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	migrator := openTestMigrator(t, config.DatabaseConfig{Driver: "sqlite", DSN: filepath.Join(dir, "test.db")})
+
+	if pending, err := migrator.Pending(); err != nil || !pending {
+		t.Fatalf("the migrations should be pending: %v", err)
+	}
+	if err := migrator.Migrate(migrator.LatestVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := migrator.Pending(); err != nil || pending {
+		t.Fatalf("the migrations should have been applied: %v", err)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("wrong statuses returned: %v", statuses)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d should have been applied", status.Version)
+		}
+	}
+
+	// applying them again is a no-op
+	if err := migrator.Migrate(migrator.LatestVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	// revert the unique index
+	if err := migrator.Migrate(1); err != nil {
+		t.Fatal(err)
+	}
+	if migrator.db.Migrator().HasIndex("aliases", "idx_aliases_name") {
+		t.Error("the aliases unique index should have been dropped")
+	}
+	statuses, err = migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range statuses {
+		if (status.AppliedAt != nil) != (i == 0) {
+			t.Errorf("wrong statuses returned: %v", statuses)
+		}
+	}
+
+	// the tables are never dropped
+	if err := migrator.Migrate(0); !errors.Is(err, ErrIrreversibleMigration) {
+		t.Errorf("Migrate() should have returned ErrIrreversibleMigration: %v", err)
+	}
+	for _, table := range []string{"users", "aliases", "events", "pending_records", "webhooks", "shares"} {
+		if !migrator.db.Migrator().HasTable(table) {
+			t.Errorf("table %s should have been kept", table)
+		}
+	}
+	if pending, err := migrator.Pending(); err != nil || !pending {
+		t.Errorf("only the first migration should be applied: %v", err)
+	}
+
+	if err := migrator.Migrate(migrator.LatestVersion() + 1); !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("Migrate() should have returned ErrUnknownMigration: %v", err)
+	}
+}
+
+// TestMigrations_Models make sure the migrations create the schema of the models
+// a change of the models must come with a new migration
+func TestMigrations_Models(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	migrated := openTestMigrator(t, config.DatabaseConfig{Driver: "sqlite", DSN: filepath.Join(dir, "migrated.db")})
+	if err := migrated.Migrate(migrated.LatestVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	models := openTestMigrator(t, config.DatabaseConfig{Driver: "sqlite", DSN: filepath.Join(dir, "models.db")})
+	if err := models.db.AutoMigrate(&Alias{}, &User{}, &Event{}, &PendingRecord{}, &Webhook{}, &Share{}); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := func(m *Migrator) map[string]string {
+		var rows []struct {
+			Name string
+			SQL  string
+		}
+		if err := m.db.Raw("SELECT name, sql FROM sqlite_master WHERE name <> 'idx_aliases_name' " +
+			"AND tbl_name <> 'schema_migrations'").Scan(&rows).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		s := map[string]string{}
+		for _, row := range rows {
+			s[row.Name] = row.SQL
+		}
+		return s
+	}
+
+	if expected, got := schema(models), schema(migrated); !reflect.DeepEqual(expected, got) {
+		t.Errorf("the migrations don't match the models:\n%v\n%v", expected, got)
+	}
+}
+
+func TestOpenConnection_Migrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.DatabaseConfig{Driver: "sqlite", DSN: filepath.Join(dir, "test.db"), ManualMigrations: true}
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	// a database created before the migrations were introduced
+	migrator := openTestMigrator(t, conf)
+	if err := migrator.db.AutoMigrate(&Alias{}, &User{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.db.Create(&User{Email: "alois@example.org"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenConnection(conf, &logger); err != ErrPendingMigrations {
+		t.Errorf("OpenConnection() should have returned ErrPendingMigrations: %v", err)
+	}
+
+	conf.ManualMigrations = false
+	conn := openTestConnectionWithConfig(t, conf)
+	if _, err := conn.FindUser("alois@example.org"); err != nil {
+		t.Errorf("the existing user should have been kept: %v", err)
+	}
+	if _, err := conn.CreateWebhook(Webhook{UserID: 1, URL: "https://example.org"}); err != nil {
+		t.Errorf("the missing tables should have been created: %v", err)
+	}
+
+	// the database has been migrated by a more recent daemon
+	if err := migrator.db.Create(&schemaMigration{Version: migrator.LatestVersion() + 1, Name: "future"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenConnection(conf, &logger); err != ErrUnknownMigration {
+		t.Errorf("OpenConnection() should have returned ErrUnknownMigration: %v", err)
+	}
+	if err := migrator.Migrate(0); !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("Migrate() should have returned ErrUnknownMigration: %v", err)
+	}
+	if !migrator.db.Migrator().HasTable("aliases") {
+		t.Error("nothing should have been reverted")
+	}
+}
+
+func openTestMigrator(t *testing.T, conf config.DatabaseConfig) *Migrator {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	migrator, err := NewMigrator(conf, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return migrator
+}
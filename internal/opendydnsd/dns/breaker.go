@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the DNS provisioner while the circuit breaker is open
+var ErrCircuitOpen = errors.New("DNS provider unavailable: circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+// The circuit breaker states
+const (
+	// BreakerClosed: the calls go through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen: the calls fail fast until the cooldown is elapsed
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen: a single call is let through to probe the recovery
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerProvider is a Provider whose provisioners are protected by a circuit breaker
+type BreakerProvider interface {
+	Provider
+	State() BreakerState
+}
+
+// breakerProvider wrap a Provider so its provisioners stop being called
+// once they failed threshold times in a row, until cooldown is elapsed
+type breakerProvider struct {
+	provider Provider
+	breaker  *breaker
+}
+
+// NewBreakerProvider return a Provider whose provisioners are no longer called
+// after threshold consecutive failures. The calls then fail with ErrCircuitOpen
+// during cooldown, after which a single call is made to probe the recovery.
+func NewBreakerProvider(provider Provider, threshold int, cooldown time.Duration) BreakerProvider {
+	return &breakerProvider{
+		provider: provider,
+		breaker: &breaker{
+			threshold: threshold,
+			cooldown:  cooldown,
+			now:       time.Now,
+		},
+	}
+}
+
+// GetProvisioner see Provider
+func (bp *breakerProvider) GetProvisioner(name string, config map[string]string) (Provisioner, error) {
+	p, err := bp.provider.GetProvisioner(name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &breakerProvisioner{provisioner: p, breaker: bp.breaker}, nil
+}
+
+// State return the current state of the circuit breaker
+func (bp *breakerProvider) State() BreakerState {
+	return bp.breaker.state()
+}
+
+type breakerProvisioner struct {
+	provisioner Provisioner
+	breaker     *breaker
+}
+
+// ManagesDNSSEC see DNSSECManager
+func (bp *breakerProvisioner) ManagesDNSSEC() bool {
+	return ManagesDNSSEC(bp.provisioner)
+}
+
+func (bp *breakerProvisioner) AddRecord(host, domain, value string, ttl int) error {
+	return bp.breaker.do(func() error {
+		return bp.provisioner.AddRecord(host, domain, value, ttl)
+	})
+}
+
+func (bp *breakerProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	return bp.breaker.do(func() error {
+		return bp.provisioner.UpdateRecord(host, domain, value, ttl)
+	})
+}
+
+func (bp *breakerProvisioner) DeleteRecord(host, domain string) error {
+	return bp.breaker.do(func() error {
+		return bp.provisioner.DeleteRecord(host, domain)
+	})
+}
+
+// breaker count the consecutive failures of the calls
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex    sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	probing  bool      // a call is probing the recovery
+}
+
+func (b *breaker) do(f func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := f()
+	b.record(err)
+
+	return err
+}
+
+// allow determinate if a call may go through
+func (b *breaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.currentState() {
+	case BreakerClosed:
+		return nil
+	case BreakerHalfOpen:
+		if !b.probing {
+			b.probing = true
+			return nil
+		}
+	}
+
+	return ErrCircuitOpen
+}
+
+// record the result of a call, re-opening the circuit if the probe failed
+func (b *breaker) record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	probe := b.probing
+	b.probing = false
+
+	if err == nil {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+	if probe || b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+func (b *breaker) state() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.currentState()
+}
+
+// currentState must be called with the mutex held
+func (b *breaker) currentState() BreakerState {
+	switch {
+	case b.openedAt.IsZero():
+		return BreakerClosed
+	case b.now().Sub(b.openedAt) < b.cooldown:
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
+	}
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type failingProvisioner struct {
+	err   error
+	calls int
+}
+
+func (fp *failingProvisioner) AddRecord(host, domain, value string, ttl int) error {
+	fp.calls++
+	return fp.err
+}
+
+func (fp *failingProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	fp.calls++
+	return fp.err
+}
+
+func (fp *failingProvisioner) DeleteRecord(host, domain string) error {
+	fp.calls++
+	return fp.err
+}
+
+func TestBreakerProvider(t *testing.T) {
+	fp := &failingProvisioner{err: errors.New("backend down")}
+	bp := NewBreakerProvider(&staticProvider{provisioner: fp}, 3, time.Minute).(*breakerProvider)
+
+	now := time.Now()
+	bp.breaker.now = func() time.Time { return now }
+
+	p, err := bp.GetProvisioner("dummy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// trip: the circuit opens after 3 consecutive failures
+	for i := 0; i < 3; i++ {
+		if bp.State() != BreakerClosed {
+			t.Fatalf("circuit should be closed after %d failures", i)
+		}
+		if err := p.UpdateRecord("foo", "example.org", "127.0.0.1", 0); err != fp.err {
+			t.Errorf("wrong error returned: %v", err)
+		}
+	}
+	if bp.State() != BreakerOpen {
+		t.Fatalf("circuit should be open, got %s", bp.State())
+	}
+
+	// open: the provisioner is no longer called
+	if err := p.DeleteRecord("foo", "example.org"); err != ErrCircuitOpen {
+		t.Errorf("DeleteRecord() should have returned ErrCircuitOpen: %v", err)
+	}
+	if fp.calls != 3 {
+		t.Errorf("provisioner should not have been called while open (%d calls)", fp.calls)
+	}
+
+	// half-open: a failing probe re-opens the circuit
+	now = now.Add(time.Minute)
+	if bp.State() != BreakerHalfOpen {
+		t.Fatalf("circuit should be half-open, got %s", bp.State())
+	}
+	if err := p.AddRecord("foo", "example.org", "127.0.0.1", 0); err != fp.err {
+		t.Errorf("probe should have reached the provisioner: %v", err)
+	}
+	if bp.State() != BreakerOpen {
+		t.Fatalf("circuit should be open again, got %s", bp.State())
+	}
+
+	// recovery: a successful probe closes the circuit
+	now = now.Add(time.Minute)
+	fp.err = nil
+	if err := p.AddRecord("foo", "example.org", "127.0.0.1", 0); err != nil {
+		t.Errorf("probe should have succeeded: %v", err)
+	}
+	if bp.State() != BreakerClosed {
+		t.Fatalf("circuit should be closed, got %s", bp.State())
+	}
+
+	// the failures count starts over
+	fp.err = errors.New("backend down")
+	_ = p.AddRecord("foo", "example.org", "127.0.0.1", 0)
+	if bp.State() != BreakerClosed {
+		t.Errorf("circuit should still be closed after a single failure")
+	}
+}
+
+func TestBreaker_SingleProbe(t *testing.T) {
+	b := &breaker{threshold: 1, cooldown: time.Minute, now: time.Now}
+	b.openedAt = time.Now().Add(-2 * time.Minute)
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("first call should probe the recovery: %v", err)
+	}
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Errorf("only one call should probe the recovery: %v", err)
+	}
+}
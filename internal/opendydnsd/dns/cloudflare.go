@@ -0,0 +1,232 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cloudflareProvisionerName = "cloudflare"
+	cloudflareEndpoint        = "https://api.cloudflare.com/client/v4"
+	cloudflareTimeout         = 30 * time.Second
+	// cloudflareAutoTTL let Cloudflare choose the TTL, it is the only one allowed for the proxied records
+	cloudflareAutoTTL = 1
+)
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+type cloudflareZone struct {
+	ID string `json:"id"`
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// cloudflareProvisioner manage the records using the Cloudflare API (v4)
+// the zone of the domains is looked up by name unless its ID is configured
+type cloudflareProvisioner struct {
+	endpoint string
+	token    string
+	zoneID   string
+	proxied  bool
+	client   *http.Client
+}
+
+func newCloudflareProvisioner(config map[string]string) (Provisioner, error) {
+	token, err := getConfigOrFail(config, "api-token")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &cloudflareProvisioner{
+		endpoint: cloudflareEndpoint,
+		token:    token,
+		zoneID:   config["zone-id"],
+		client:   &http.Client{Timeout: cloudflareTimeout},
+	}
+
+	if endpoint := config["endpoint"]; endpoint != "" {
+		p.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	if proxied := config["proxied"]; proxied != "" {
+		if p.proxied, err = strconv.ParseBool(proxied); err != nil {
+			return nil, fmt.Errorf("invalid config `proxied`: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// ManagesDNSSEC see DNSSECManager
+// Cloudflare sign the zones itself, on the fly
+func (cf *cloudflareProvisioner) ManagesDNSSEC() bool {
+	return true
+}
+
+func (cf *cloudflareProvisioner) AddRecord(host, domain, value string, ttl int) error {
+	zoneID, err := cf.findZone(domain)
+	if err != nil {
+		return err
+	}
+
+	return cf.do(http.MethodPost, fmt.Sprintf("/zones/%s/dns_records", zoneID), cf.newRecord(host, domain, value, ttl), nil)
+}
+
+func (cf *cloudflareProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	zoneID, err := cf.findZone(domain)
+	if err != nil {
+		return err
+	}
+
+	records, err := cf.findRecords(zoneID, host, domain, RecordType(value))
+	if err != nil {
+		return err
+	}
+
+	if len(records) != 1 {
+		return fmt.Errorf("more or less than 1 record found")
+	}
+
+	path := fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, records[0].ID)
+	return cf.do(http.MethodPut, path, cf.newRecord(host, domain, value, ttl), nil)
+}
+
+func (cf *cloudflareProvisioner) DeleteRecord(host, domain string) error {
+	zoneID, err := cf.findZone(domain)
+	if err != nil {
+		return err
+	}
+
+	// find the records to delete, whatever their type
+	var records []cloudflareRecord
+	for _, recordType := range []string{"A", "AAAA"} {
+		r, err := cf.findRecords(zoneID, host, domain, recordType)
+		if err != nil {
+			return err
+		}
+		records = append(records, r...)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("no record found")
+	}
+
+	for _, record := range records {
+		if err := cf.do(http.MethodDelete, fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, record.ID), nil, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cf *cloudflareProvisioner) newRecord(host, domain, value string, ttl int) cloudflareRecord {
+	// the proxied records TTL is managed by Cloudflare
+	if ttl <= 0 || cf.proxied {
+		ttl = cloudflareAutoTTL
+	}
+
+	return cloudflareRecord{
+		Type:    RecordType(value),
+		Name:    strings.TrimSuffix(recordName(host, domain), "."),
+		Content: value,
+		TTL:     ttl,
+		Proxied: cf.proxied,
+	}
+}
+
+func (cf *cloudflareProvisioner) findZone(domain string) (string, error) {
+	if cf.zoneID != "" {
+		return cf.zoneID, nil
+	}
+
+	var zones []cloudflareZone
+	if err := cf.do(http.MethodGet, "/zones?name="+url.QueryEscape(domain), nil, &zones); err != nil {
+		return "", err
+	}
+
+	if len(zones) != 1 {
+		return "", fmt.Errorf("zone %s not found", domain)
+	}
+
+	return zones[0].ID, nil
+}
+
+func (cf *cloudflareProvisioner) findRecords(zoneID, host, domain, recordType string) ([]cloudflareRecord, error) {
+	query := url.Values{}
+	query.Set("type", recordType)
+	query.Set("name", strings.TrimSuffix(recordName(host, domain), "."))
+
+	var records []cloudflareRecord
+	if err := cf.do(http.MethodGet, fmt.Sprintf("/zones/%s/dns_records?%s", zoneID, query.Encode()), nil, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// do send given request to the API and decode its result into result, if not nil
+func (cf *cloudflareProvisioner) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, cf.endpoint+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cf.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "opendydnsd/"+common.Version)
+
+	resp, err := cf.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("invalid Cloudflare response (%s): %w", resp.Status, err)
+	}
+
+	if !response.Success {
+		var messages []string
+		for _, e := range response.Errors {
+			messages = append(messages, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+		}
+		return fmt.Errorf("cloudflare API returned %s: %s", resp.Status, strings.Join(messages, ", "))
+	}
+
+	if result != nil {
+		return json.Unmarshal(response.Result, result)
+	}
+
+	return nil
+}
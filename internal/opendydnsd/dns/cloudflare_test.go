@@ -0,0 +1,166 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCloudflare is an in memory implementation of the Cloudflare DNS records API
+type fakeCloudflare struct {
+	mutex   sync.Mutex
+	nextID  int
+	zones   map[string]string
+	records map[string]cloudflareRecord
+}
+
+func (fc *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	reply := func(status int, result interface{}) {
+		b, _ := json.Marshal(result)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(cloudflareResponse{Success: status == http.StatusOK, Result: b})
+	}
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":9109,"message":"Invalid access token"}]}`))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "zones":
+		zones := []cloudflareZone{}
+		if id, exist := fc.zones[r.URL.Query().Get("name")]; exist {
+			zones = append(zones, cloudflareZone{ID: id})
+		}
+		reply(http.StatusOK, zones)
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		records := []cloudflareRecord{}
+		for _, record := range fc.records {
+			if record.Type == r.URL.Query().Get("type") && record.Name == r.URL.Query().Get("name") {
+				records = append(records, record)
+			}
+		}
+		reply(http.StatusOK, records)
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		var record cloudflareRecord
+		_ = json.NewDecoder(r.Body).Decode(&record)
+		fc.nextID++
+		record.ID = fmt.Sprintf("%s-%d", parts[1], fc.nextID)
+		fc.records[record.ID] = record
+		reply(http.StatusOK, record)
+	case len(parts) == 4 && r.Method == http.MethodPut:
+		var record cloudflareRecord
+		_ = json.NewDecoder(r.Body).Decode(&record)
+		record.ID = parts[3]
+		fc.records[record.ID] = record
+		reply(http.StatusOK, record)
+	case len(parts) == 4 && r.Method == http.MethodDelete:
+		delete(fc.records, parts[3])
+		reply(http.StatusOK, cloudflareRecord{ID: parts[3]})
+	default:
+		reply(http.StatusNotFound, nil)
+	}
+}
+
+func TestNewCloudflareProvisioner(t *testing.T) {
+	if _, err := newCloudflareProvisioner(map[string]string{}); err == nil {
+		t.Error("newCloudflareProvisioner should have failed")
+	}
+	if _, err := newCloudflareProvisioner(map[string]string{"api-token": "test", "proxied": "maybe"}); err == nil {
+		t.Error("newCloudflareProvisioner should have failed")
+	}
+
+	if _, err := newCloudflareProvisioner(map[string]string{
+		"api-token": "test",
+		"zone-id":   "023e105f4ecef8ad9ca31a8372d0c353",
+		"proxied":   "true",
+	}); err != nil {
+		t.Error("newCloudflareProvisioner has failed")
+	}
+}
+
+func TestCloudflareProvisioner(t *testing.T) {
+	api := &fakeCloudflare{
+		zones:   map[string]string{"example.org": "zone1"},
+		records: map[string]cloudflareRecord{},
+	}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	p, err := newCloudflareProvisioner(map[string]string{"api-token": "test-token", "endpoint": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddRecord("foo", "example.org", "192.0.2.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRecord("foo", "example.org", "2001:db8::1", 120); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateRecord("foo", "example.org", "192.0.2.2", 300); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]cloudflareRecord{
+		"zone1-1": {ID: "zone1-1", Type: "A", Name: "foo.example.org", Content: "192.0.2.2", TTL: 300},
+		"zone1-2": {ID: "zone1-2", Type: "AAAA", Name: "foo.example.org", Content: "2001:db8::1", TTL: 120},
+	}
+	if !reflect.DeepEqual(api.records, expected) {
+		t.Errorf("wrong records: %+v", api.records)
+	}
+
+	if err := p.DeleteRecord("foo", "example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.records) != 0 {
+		t.Errorf("the records should have been deleted: %+v", api.records)
+	}
+	if err := p.DeleteRecord("foo", "example.org"); err == nil {
+		t.Error("DeleteRecord() should have failed")
+	}
+
+	// unknown zone
+	if err := p.AddRecord("foo", "example.com", "192.0.2.1", 0); err == nil {
+		t.Error("AddRecord() should have failed")
+	}
+
+	// the configured zone is used as is, the proxied records TTL is automatic
+	p, err = newCloudflareProvisioner(map[string]string{
+		"api-token": "test-token",
+		"endpoint":  server.URL,
+		"zone-id":   "zone2",
+		"proxied":   "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRecord("", "example.net", "192.0.2.3", 300); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]cloudflareRecord{
+		"zone2-3": {ID: "zone2-3", Type: "A", Name: "example.net", Content: "192.0.2.3", TTL: 1, Proxied: true},
+	}
+	if !reflect.DeepEqual(api.records, expected) {
+		t.Errorf("wrong records: %+v", api.records)
+	}
+
+	// the API errors are reported
+	p, err = newCloudflareProvisioner(map[string]string{"api-token": "wrong", "endpoint": server.URL, "zone-id": "zone1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRecord("foo", "example.org", "192.0.2.1", 0); err == nil || !strings.Contains(err.Error(), "Invalid access token") {
+		t.Errorf("AddRecord() should have failed: %v", err)
+	}
+}
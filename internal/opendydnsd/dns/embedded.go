@@ -0,0 +1,22 @@
+package dns
+
+// EmbeddedProvisionerName is the name of the provisioner of the domains
+// only served by the embedded DNS server of the daemon
+const EmbeddedProvisionerName = "embedded"
+
+// embeddedProvisioner has nothing to provision: the embedded DNS server
+// answers the queries using the aliases of the database
+type embeddedProvisioner struct {
+}
+
+func (e *embeddedProvisioner) AddRecord(host, domain, value string, ttl int) error {
+	return nil
+}
+
+func (e *embeddedProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	return nil
+}
+
+func (e *embeddedProvisioner) DeleteRecord(host, domain string) error {
+	return nil
+}
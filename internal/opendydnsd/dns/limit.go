@@ -0,0 +1,95 @@
+package dns
+
+import "sync"
+
+// limitedProvider wrap a Provider so at most a given number of calls
+// are made to the DNS provisioners at the same time, the others being queued
+type limitedProvider struct {
+	provider Provider
+	limiter  *limiter
+}
+
+// NewLimitedProvider return a Provider whose provisioners never run more than
+// limit calls concurrently. Calls targeting the same record are run in order.
+func NewLimitedProvider(provider Provider, limit int) Provider {
+	return &limitedProvider{
+		provider: provider,
+		limiter: &limiter{
+			sem:   make(chan struct{}, limit),
+			tails: map[string]chan struct{}{},
+		},
+	}
+}
+
+// GetProvisioner see Provider
+func (lp *limitedProvider) GetProvisioner(name string, config map[string]string) (Provisioner, error) {
+	p, err := lp.provider.GetProvisioner(name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedProvisioner{provisioner: p, limiter: lp.limiter}, nil
+}
+
+type limitedProvisioner struct {
+	provisioner Provisioner
+	limiter     *limiter
+}
+
+// ManagesDNSSEC see DNSSECManager
+func (lp *limitedProvisioner) ManagesDNSSEC() bool {
+	return ManagesDNSSEC(lp.provisioner)
+}
+
+func (lp *limitedProvisioner) AddRecord(host, domain, value string, ttl int) error {
+	return lp.limiter.do(host+"."+domain, func() error {
+		return lp.provisioner.AddRecord(host, domain, value, ttl)
+	})
+}
+
+func (lp *limitedProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	return lp.limiter.do(host+"."+domain, func() error {
+		return lp.provisioner.UpdateRecord(host, domain, value, ttl)
+	})
+}
+
+func (lp *limitedProvisioner) DeleteRecord(host, domain string) error {
+	return lp.limiter.do(host+"."+domain, func() error {
+		return lp.provisioner.DeleteRecord(host, domain)
+	})
+}
+
+// limiter is a semaphore which also serialize the calls sharing the same key
+type limiter struct {
+	sem chan struct{}
+
+	mutex sync.Mutex
+	// tails contains, for each key, the channel closed once the last queued call is done
+	tails map[string]chan struct{}
+}
+
+func (l *limiter) do(key string, f func() error) error {
+	// queue behind the previous call for the same key
+	done := make(chan struct{})
+	l.mutex.Lock()
+	previous := l.tails[key]
+	l.tails[key] = done
+	l.mutex.Unlock()
+
+	if previous != nil {
+		<-previous
+	}
+
+	l.sem <- struct{}{}
+	err := f()
+	<-l.sem
+
+	l.mutex.Lock()
+	if l.tails[key] == done {
+		delete(l.tails, key)
+	}
+	l.mutex.Unlock()
+	close(done)
+
+	return err
+}
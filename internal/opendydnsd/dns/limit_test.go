@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingProvisioner struct {
+	running int32
+	max     int32
+
+	mutex  sync.Mutex
+	values []string
+}
+
+func (cp *countingProvisioner) AddRecord(host, domain, value string, ttl int) error {
+	return cp.UpdateRecord(host, domain, value, ttl)
+}
+
+func (cp *countingProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	running := atomic.AddInt32(&cp.running, 1)
+	defer atomic.AddInt32(&cp.running, -1)
+
+	for {
+		max := atomic.LoadInt32(&cp.max)
+		if running <= max || atomic.CompareAndSwapInt32(&cp.max, max, running) {
+			break
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	cp.mutex.Lock()
+	cp.values = append(cp.values, value)
+	cp.mutex.Unlock()
+
+	return nil
+}
+
+func (cp *countingProvisioner) DeleteRecord(host, domain string) error {
+	return cp.UpdateRecord(host, domain, "", 0)
+}
+
+type staticProvider struct {
+	provisioner Provisioner
+}
+
+func (sp *staticProvider) GetProvisioner(name string, config map[string]string) (Provisioner, error) {
+	return sp.provisioner, nil
+}
+
+func TestLimitedProvider_Concurrency(t *testing.T) {
+	cp := &countingProvisioner{}
+	p, err := NewLimitedProvider(&staticProvider{provisioner: cp}, 3).GetProvisioner("dummy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = p.UpdateRecord(fmt.Sprintf("host%d", i), "example.org", "127.0.0.1", 60)
+		}(i)
+	}
+	wg.Wait()
+
+	if cp.max > 3 {
+		t.Errorf("concurrency limit exceeded: %d", cp.max)
+	}
+	if len(cp.values) != 30 {
+		t.Errorf("wrong number of calls: %d", len(cp.values))
+	}
+}
+
+func TestLimitedProvider_Ordering(t *testing.T) {
+	cp := &countingProvisioner{}
+	p, err := NewLimitedProvider(&staticProvider{provisioner: cp}, 5).GetProvisioner("dummy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// calls for the same record must run one after another, in order
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = p.UpdateRecord("foo", "example.org", fmt.Sprintf("127.0.0.%d", i), 60)
+		}(i)
+		time.Sleep(time.Millisecond) // make sure the calls are queued in order
+	}
+	wg.Wait()
+
+	if cp.max != 1 {
+		t.Errorf("calls for the same record should not run concurrently: %d", cp.max)
+	}
+	for i, value := range cp.values {
+		if value != fmt.Sprintf("127.0.0.%d", i) {
+			t.Fatalf("calls not run in order: %v", cp.values)
+		}
+	}
+}
@@ -51,12 +51,19 @@ func newOVHProvisioner(config map[string]string) (Provisioner, error) {
 	}, nil
 }
 
-func (o *ovhProvisioner) AddRecord(host, domain, value string) error {
+// ManagesDNSSEC see DNSSECManager
+// OVH sign the zones itself: they are re-signed on each refresh
+func (o *ovhProvisioner) ManagesDNSSEC() bool {
+	return true
+}
+
+func (o *ovhProvisioner) AddRecord(host, domain, value string, ttl int) error {
 	// add the record
 	if err := o.client.Post(fmt.Sprintf("%s/%s/record", zoneEndpoint, domain), &ovhRecord{
-		FieldType: "A", // TODO AAA if ipv6
+		FieldType: RecordType(value),
 		SubDomain: host,
 		Target:    value,
+		TTL:       int64(ttl),
 	}, nil); err != nil {
 		return err
 	}
@@ -65,14 +72,25 @@ func (o *ovhProvisioner) AddRecord(host, domain, value string) error {
 	return o.refreshZone(domain)
 }
 
-func (o *ovhProvisioner) UpdateRecord(host, domain, value string) error {
-	record, err := o.findRecord(host, domain)
+func (o *ovhProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	recordIds, err := o.findRecords(host, domain, RecordType(value))
 	if err != nil {
 		return err
 	}
 
+	if len(recordIds) != 1 {
+		return fmt.Errorf("more or less than 1 record found")
+	}
+
+	// Query for record details
+	var record ovhRecord
+	if err := o.client.Get(fmt.Sprintf("%s/%s/record/%d", zoneEndpoint, domain, recordIds[0]), &record); err != nil {
+		return err
+	}
+
 	// update target
 	record.Target = value
+	record.TTL = int64(ttl)
 
 	url := fmt.Sprintf("%s/%s/record/%d", zoneEndpoint, domain, record.ID)
 	if err := o.client.Put(url, &record, nil); err != nil {
@@ -83,15 +101,24 @@ func (o *ovhProvisioner) UpdateRecord(host, domain, value string) error {
 }
 
 func (o *ovhProvisioner) DeleteRecord(host, domain string) error {
-	// find the record to delete
-	record, err := o.findRecord(host, domain)
-	if err != nil {
-		return err
+	// find the records to delete, whatever their type
+	var recordIds []int64
+	for _, fieldType := range []string{"A", "AAAA"} {
+		ids, err := o.findRecords(host, domain, fieldType)
+		if err != nil {
+			return err
+		}
+		recordIds = append(recordIds, ids...)
 	}
 
-	// delete the record if found
-	if err := o.client.Delete(fmt.Sprintf("%s/%s/record/%d", zoneEndpoint, domain, record.ID), nil); err != nil {
-		return err
+	if len(recordIds) == 0 {
+		return fmt.Errorf("no record found")
+	}
+
+	for _, id := range recordIds {
+		if err := o.client.Delete(fmt.Sprintf("%s/%s/record/%d", zoneEndpoint, domain, id), nil); err != nil {
+			return err
+		}
 	}
 
 	return o.refreshZone(domain)
@@ -101,24 +128,14 @@ func (o *ovhProvisioner) refreshZone(domain string) error {
 	return o.client.Post(fmt.Sprintf("%s/%s/refresh", zoneEndpoint, domain), nil, nil)
 }
 
-func (o *ovhProvisioner) findRecord(host, domain string) (ovhRecord, error) {
+func (o *ovhProvisioner) findRecords(host, domain, fieldType string) ([]int64, error) {
 	var recordIds []int64
 
-	// Search for the record
-	url := fmt.Sprintf("%s/%s/record?fieldType=A&subDomain=%s", zoneEndpoint, domain, host) // TODO manage Ipv6
+	// Search for the records
+	url := fmt.Sprintf("%s/%s/record?fieldType=%s&subDomain=%s", zoneEndpoint, domain, fieldType, host)
 	if err := o.client.Get(url, &recordIds); err != nil {
-		return ovhRecord{}, err
-	}
-
-	if len(recordIds) != 1 {
-		return ovhRecord{}, fmt.Errorf("more or less than 1 record found")
-	}
-
-	// Query for record details
-	var record ovhRecord
-	if err := o.client.Get(fmt.Sprintf("%s/%s/record/%d", zoneEndpoint, domain, recordIds[0]), &record); err != nil {
-		return ovhRecord{}, err
+		return nil, err
 	}
 
-	return record, nil
+	return recordIds, nil
 }
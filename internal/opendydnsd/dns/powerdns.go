@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	powerDNSProvisionerName = "powerdns"
+	powerDNSDefaultServerID = "localhost"
+	powerDNSTimeout         = 30 * time.Second
+)
+
+type powerDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type powerDNSRRSet struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	TTL        int              `json:"ttl,omitempty"`
+	ChangeType string           `json:"changetype"`
+	Records    []powerDNSRecord `json:"records,omitempty"`
+}
+
+type powerDNSPatch struct {
+	RRSets []powerDNSRRSet `json:"rrsets"`
+}
+
+// powerDNSProvisioner manage the records using the PowerDNS Authoritative HTTP API
+// an alias has at most one address per family: its record sets are replaced as a whole
+type powerDNSProvisioner struct {
+	apiURL   string
+	apiKey   string
+	serverID string
+	client   *http.Client
+}
+
+func newPowerDNSProvisioner(config map[string]string) (Provisioner, error) {
+	apiURL, err := getConfigOrFail(config, "api-url")
+	if err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(apiURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid config `api-url`: %s is not an http(s) URL", apiURL)
+	}
+
+	apiKey, err := getConfigOrFail(config, "api-key")
+	if err != nil {
+		return nil, err
+	}
+
+	serverID := config["server-id"]
+	if serverID == "" {
+		serverID = powerDNSDefaultServerID
+	}
+
+	return &powerDNSProvisioner{
+		apiURL:   strings.TrimSuffix(apiURL, "/"),
+		apiKey:   apiKey,
+		serverID: serverID,
+		client:   &http.Client{Timeout: powerDNSTimeout},
+	}, nil
+}
+
+// ManagesDNSSEC see DNSSECManager
+// PowerDNS sign the answers on the fly
+func (p *powerDNSProvisioner) ManagesDNSSEC() bool {
+	return true
+}
+
+func (p *powerDNSProvisioner) AddRecord(host, domain, value string, ttl int) error {
+	return p.patchZone(domain, p.replaceRRSet(host, domain, value, ttl))
+}
+
+func (p *powerDNSProvisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	return p.patchZone(domain, p.replaceRRSet(host, domain, value, ttl))
+}
+
+func (p *powerDNSProvisioner) DeleteRecord(host, domain string) error {
+	// remove the records, whatever their type
+	name := recordName(host, domain)
+	return p.patchZone(domain,
+		powerDNSRRSet{Name: name, Type: "A", ChangeType: "DELETE"},
+		powerDNSRRSet{Name: name, Type: "AAAA", ChangeType: "DELETE"},
+	)
+}
+
+func (p *powerDNSProvisioner) replaceRRSet(host, domain, value string, ttl int) powerDNSRRSet {
+	if ttl <= 0 {
+		ttl = defaultRecordTTL
+	}
+
+	return powerDNSRRSet{
+		Name:       recordName(host, domain),
+		Type:       RecordType(value),
+		TTL:        ttl,
+		ChangeType: "REPLACE",
+		Records:    []powerDNSRecord{{Content: value}},
+	}
+}
+
+// patchZone apply the changes to the record sets of given zone at once
+func (p *powerDNSProvisioner) patchZone(domain string, rrSets ...powerDNSRRSet) error {
+	b, err := json.Marshal(powerDNSPatch{RRSets: rrSets})
+	if err != nil {
+		return err
+	}
+
+	zoneURL := fmt.Sprintf("%s/api/v1/servers/%s/zones/%s",
+		p.apiURL, url.PathEscape(p.serverID), url.PathEscape(recordName("", domain)))
+	req, err := http.NewRequest(http.MethodPatch, zoneURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "opendydnsd/"+common.Version)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("the PowerDNS API returned %s: %s", resp.Status, apiErr.Error)
+	}
+
+	return nil
+}
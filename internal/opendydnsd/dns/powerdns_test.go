@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNewPowerDNSProvisioner(t *testing.T) {
+	for _, config := range []map[string]string{
+		{},
+		{"api-url": "127.0.0.1:8081", "api-key": "test"},
+		{"api-url": "http://127.0.0.1:8081"},
+	} {
+		if _, err := newPowerDNSProvisioner(config); err == nil {
+			t.Errorf("newPowerDNSProvisioner should have failed for %v", config)
+		}
+	}
+
+	p, err := newPowerDNSProvisioner(map[string]string{"api-url": "http://127.0.0.1:8081/", "api-key": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provisioner := p.(*powerDNSProvisioner); provisioner.apiURL != "http://127.0.0.1:8081" || provisioner.serverID != "localhost" {
+		t.Errorf("wrong provisioner configuration: %+v", provisioner)
+	}
+}
+
+func TestPowerDNSProvisioner(t *testing.T) {
+	var paths []string
+	var patches []powerDNSPatch
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.Header.Get("X-API-Key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "Unauthorized"}`))
+			return
+		}
+
+		var patch powerDNSPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		patches = append(patches, patch)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	p, err := newPowerDNSProvisioner(map[string]string{"api-url": server.URL, "api-key": "test-key", "server-id": "ns1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddRecord("foo", "example.org", "192.0.2.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateRecord("foo", "example.org", "2001:db8::1", 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteRecord("foo", "example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		if path != "/api/v1/servers/ns1/zones/example.org." {
+			t.Errorf("wrong zone patched: %s", path)
+		}
+	}
+
+	expected := []powerDNSPatch{
+		{RRSets: []powerDNSRRSet{{Name: "foo.example.org.", Type: "A", TTL: 300, ChangeType: "REPLACE",
+			Records: []powerDNSRecord{{Content: "192.0.2.1"}}}}},
+		{RRSets: []powerDNSRRSet{{Name: "foo.example.org.", Type: "AAAA", TTL: 60, ChangeType: "REPLACE",
+			Records: []powerDNSRecord{{Content: "2001:db8::1"}}}}},
+		{RRSets: []powerDNSRRSet{
+			{Name: "foo.example.org.", Type: "A", ChangeType: "DELETE"},
+			{Name: "foo.example.org.", Type: "AAAA", ChangeType: "DELETE"},
+		}},
+	}
+	if !reflect.DeepEqual(patches, expected) {
+		t.Errorf("wrong changes sent: %+v", patches)
+	}
+
+	// the API errors are reported
+	p, err = newPowerDNSProvisioner(map[string]string{"api-url": server.URL, "api-key": "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRecord("foo", "example.org", "192.0.2.1", 0); err == nil {
+		t.Error("AddRecord() should have failed")
+	}
+}
@@ -1,17 +1,46 @@
 package dns
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 //go:generate mockgen -source provisioner.go -destination=../dns_mock/provisioner_mock.go -package=dns_mock
 
 // Provisioner represent a DNS provisioner
 // i.e used to abstract different DNS provisioner API solutions
+// the type of the records is given by their value (see RecordType),
+// a host may have both an A and an AAAA record: DeleteRecord removes them all
 type Provisioner interface {
-	AddRecord(host, domain, value string) error
-	UpdateRecord(host, domain, value string) error
+	AddRecord(host, domain, value string, ttl int) error
+	UpdateRecord(host, domain, value string, ttl int) error
 	DeleteRecord(host, domain string) error
 }
 
+// defaultRecordTTL is the TTL of the records whose alias, domain & daemon don't define one,
+// used by the provisioners whose backend cannot fall back on the zone default
+const defaultRecordTTL = 300
+
+// RecordType return the type of the DNS record publishing given address: AAAA for IPv6, else A
+func RecordType(value string) string {
+	if strings.Contains(value, ":") {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// DNSSECManager is implemented by the provisioners whose backend keeps
+// the signatures of DNSSEC signed zones up to date when a record changes
+type DNSSECManager interface {
+	ManagesDNSSEC() bool
+}
+
+// ManagesDNSSEC determinate if given provisioner handles DNSSEC signed zones
+func ManagesDNSSEC(p Provisioner) bool {
+	m, ok := p.(DNSSECManager)
+	return ok && m.ManagesDNSSEC()
+}
+
 // Provider is the abstraction used to resolve a Provisioner
 // based on his name etc. This ease unit testing
 type Provider interface {
@@ -19,6 +48,8 @@ type Provider interface {
 }
 
 type provider struct {
+	// route53 are the batchers shared by the Route53 provisioners
+	route53 route53Batchers
 }
 
 // NewProvider return the default Provider implementation
@@ -31,6 +62,16 @@ func (p *provider) GetProvisioner(name string, config map[string]string) (Provis
 	switch name {
 	case ovhProvisionerName:
 		return newOVHProvisioner(config)
+	case cloudflareProvisionerName:
+		return newCloudflareProvisioner(config)
+	case rfc2136ProvisionerName:
+		return newRFC2136Provisioner(config)
+	case powerDNSProvisionerName:
+		return newPowerDNSProvisioner(config)
+	case route53ProvisionerName:
+		return newRoute53Provisioner(config, &p.route53)
+	case EmbeddedProvisionerName:
+		return &embeddedProvisioner{}, nil
 	default:
 		return nil, fmt.Errorf("no provisioner named %s found", name)
 	}
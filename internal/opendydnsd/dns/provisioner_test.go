@@ -2,6 +2,36 @@ package dns
 
 import "testing"
 
+type dnssecProvisioner struct {
+	countingProvisioner
+}
+
+func (dp *dnssecProvisioner) ManagesDNSSEC() bool {
+	return true
+}
+
+func TestManagesDNSSEC(t *testing.T) {
+	if ManagesDNSSEC(&countingProvisioner{}) {
+		t.Error("provisioner should not manage DNSSEC")
+	}
+	if !ManagesDNSSEC(&dnssecProvisioner{}) {
+		t.Error("provisioner should manage DNSSEC")
+	}
+	if !ManagesDNSSEC(&ovhProvisioner{}) {
+		t.Error("OVH provisioner should manage DNSSEC")
+	}
+
+	// the capability is kept when the provisioner is wrapped
+	limited := &limitedProvisioner{provisioner: &dnssecProvisioner{}}
+	if !ManagesDNSSEC(limited) {
+		t.Error("limited provisioner should manage DNSSEC")
+	}
+	limited = &limitedProvisioner{provisioner: &countingProvisioner{}}
+	if ManagesDNSSEC(limited) {
+		t.Error("limited provisioner should not manage DNSSEC")
+	}
+}
+
 func TestGetConfigOrFail(t *testing.T) {
 	_, err := getConfigOrFail(map[string]string{}, "test")
 	if err == nil {
@@ -13,3 +43,15 @@ func TestGetConfigOrFail(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestRecordType(t *testing.T) {
+	for value, expected := range map[string]string{
+		"192.0.2.1":        "A",
+		"2001:db8::1":      "AAAA",
+		"::ffff:192.0.2.1": "AAAA",
+	} {
+		if recordType := RecordType(value); recordType != expected {
+			t.Errorf("RecordType(%s) returned %s instead of %s", value, recordType, expected)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"fmt"
+	mdns "github.com/miekg/dns"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	rfc2136ProvisionerName = "rfc2136"
+	rfc2136Timeout         = 10 * time.Second
+)
+
+// tsigAlgorithms are the supported TSIG algorithms, by configuration name
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   mdns.HmacSHA1,
+	"hmac-sha256": mdns.HmacSHA256,
+	"hmac-sha512": mdns.HmacSHA512,
+}
+
+// rfc2136Provisioner publish the records using DNS dynamic updates (RFC 2136)
+// sent to the primary server of the zone, signed using TSIG (RFC 2845) if a key is configured
+type rfc2136Provisioner struct {
+	server        string
+	tsigKey       string
+	tsigAlgorithm string
+	client        *mdns.Client
+}
+
+func newRFC2136Provisioner(config map[string]string) (Provisioner, error) {
+	server, err := getConfigOrFail(config, "server")
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	client := &mdns.Client{Net: "tcp", Timeout: rfc2136Timeout}
+	if network := config["network"]; network != "" {
+		if network != "tcp" && network != "udp" {
+			return nil, fmt.Errorf("unsupported network `%s`", network)
+		}
+		client.Net = network
+	}
+
+	p := &rfc2136Provisioner{server: server, client: client}
+
+	// the updates are signed if a key is configured
+	if keyName := config["tsig-key"]; keyName != "" {
+		secret, err := getConfigOrFail(config, "tsig-secret")
+		if err != nil {
+			return nil, err
+		}
+
+		algorithm := "hmac-sha256"
+		if a := config["tsig-algorithm"]; a != "" {
+			algorithm = strings.ToLower(a)
+		}
+		if p.tsigAlgorithm = tsigAlgorithms[algorithm]; p.tsigAlgorithm == "" {
+			return nil, fmt.Errorf("unsupported TSIG algorithm `%s`", algorithm)
+		}
+
+		p.tsigKey = mdns.Fqdn(keyName)
+		client.TsigSecret = map[string]string{p.tsigKey: secret}
+	}
+
+	return p, nil
+}
+
+func (r *rfc2136Provisioner) AddRecord(host, domain, value string, ttl int) error {
+	rr, err := newRR(host, domain, value, ttl)
+	if err != nil {
+		return err
+	}
+
+	m := r.newUpdate(domain)
+	m.Insert([]mdns.RR{rr})
+
+	return r.exchange(m)
+}
+
+func (r *rfc2136Provisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	rr, err := newRR(host, domain, value, ttl)
+	if err != nil {
+		return err
+	}
+
+	// the record set of the value type is replaced atomically
+	m := r.newUpdate(domain)
+	m.RemoveRRset([]mdns.RR{&mdns.ANY{Hdr: mdns.RR_Header{Name: rr.Header().Name, Rrtype: rr.Header().Rrtype}}})
+	m.Insert([]mdns.RR{rr})
+
+	return r.exchange(m)
+}
+
+func (r *rfc2136Provisioner) DeleteRecord(host, domain string) error {
+	name := recordName(host, domain)
+
+	// remove the records, whatever their type
+	m := r.newUpdate(domain)
+	m.RemoveRRset([]mdns.RR{
+		&mdns.ANY{Hdr: mdns.RR_Header{Name: name, Rrtype: mdns.TypeA}},
+		&mdns.ANY{Hdr: mdns.RR_Header{Name: name, Rrtype: mdns.TypeAAAA}},
+	})
+
+	return r.exchange(m)
+}
+
+func (r *rfc2136Provisioner) newUpdate(domain string) *mdns.Msg {
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(domain))
+	return m
+}
+
+func (r *rfc2136Provisioner) exchange(m *mdns.Msg) error {
+	if r.tsigKey != "" {
+		m.SetTsig(r.tsigKey, r.tsigAlgorithm, 300, time.Now().Unix())
+	}
+
+	answer, _, err := r.client.Exchange(m, r.server)
+	if err != nil {
+		return err
+	}
+
+	if answer.Rcode != mdns.RcodeSuccess {
+		return fmt.Errorf("update of zone %s refused by %s: %s", m.Question[0].Name, r.server, mdns.RcodeToString[answer.Rcode])
+	}
+
+	return nil
+}
+
+// newRR return the record publishing given address
+func newRR(host, domain, value string, ttl int) (mdns.RR, error) {
+	if ttl <= 0 {
+		ttl = defaultRecordTTL
+	}
+
+	return mdns.NewRR(fmt.Sprintf("%s %d IN %s %s", recordName(host, domain), ttl, RecordType(value), value))
+}
+
+// recordName return the fully qualified name of given host of the zone
+func recordName(host, domain string) string {
+	if host == "" {
+		return mdns.Fqdn(domain)
+	}
+	return mdns.Fqdn(host + "." + domain)
+}
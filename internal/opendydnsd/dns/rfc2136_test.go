@@ -0,0 +1,157 @@
+package dns
+
+import (
+	mdns "github.com/miekg/dns"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startUpdateServer start a DNS server recording the dynamic updates it receives
+// the updates are refused if rcode is not RcodeSuccess
+func startUpdateServer(t *testing.T, tsigSecret map[string]string, rcode int) (string, func() []*mdns.Msg, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	var updates []*mdns.Msg
+
+	started := make(chan struct{})
+	server := &mdns.Server{
+		Listener:          listener,
+		TsigSecret:        tsigSecret,
+		NotifyStartedFunc: func() { close(started) },
+		// the default function rejects the updates
+		MsgAcceptFunc: func(mdns.Header) mdns.MsgAcceptAction { return mdns.MsgAccept },
+		Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, m *mdns.Msg) {
+			answer := new(mdns.Msg)
+			answer.SetRcode(m, rcode)
+			if tsigSecret != nil {
+				// only the signed updates are accepted
+				if m.IsTsig() == nil || w.TsigStatus() != nil {
+					answer.SetRcode(m, mdns.RcodeNotAuth)
+				} else {
+					answer.SetTsig(m.IsTsig().Hdr.Name, m.IsTsig().Algorithm, 300, time.Now().Unix())
+				}
+			}
+			if answer.Rcode == mdns.RcodeSuccess {
+				mutex.Lock()
+				updates = append(updates, m)
+				mutex.Unlock()
+			}
+			_ = w.WriteMsg(answer)
+		}),
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+
+	received := func() []*mdns.Msg {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return updates
+	}
+	return listener.Addr().String(), received, func() { _ = server.Shutdown() }
+}
+
+// updateRecords return the records of the update section of given message
+func updateRecords(m *mdns.Msg) []string {
+	var records []string
+	for _, rr := range m.Ns {
+		records = append(records, rr.String())
+	}
+	return records
+}
+
+func TestNewRFC2136Provisioner(t *testing.T) {
+	for _, config := range []map[string]string{
+		{},
+		{"server": "ns1.example.org", "network": "sctp"},
+		{"server": "ns1.example.org", "tsig-key": "opendydns"},
+		{"server": "ns1.example.org", "tsig-key": "opendydns", "tsig-secret": "c2VjcmV0", "tsig-algorithm": "hmac-md4"},
+	} {
+		if _, err := newRFC2136Provisioner(config); err == nil {
+			t.Errorf("newRFC2136Provisioner should have failed for %v", config)
+		}
+	}
+
+	p, err := newRFC2136Provisioner(map[string]string{
+		"server":         "ns1.example.org",
+		"tsig-key":       "opendydns",
+		"tsig-secret":    "c2VjcmV0",
+		"tsig-algorithm": "HMAC-SHA512",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provisioner := p.(*rfc2136Provisioner)
+	if provisioner.server != "ns1.example.org:53" {
+		t.Errorf("wrong server: %s", provisioner.server)
+	}
+	if provisioner.tsigKey != "opendydns." || provisioner.tsigAlgorithm != mdns.HmacSHA512 {
+		t.Errorf("wrong TSIG key: %s (%s)", provisioner.tsigKey, provisioner.tsigAlgorithm)
+	}
+}
+
+func TestRFC2136Provisioner(t *testing.T) {
+	secret := map[string]string{"opendydns.": "c2VjcmV0"}
+	address, updates, shutdown := startUpdateServer(t, secret, mdns.RcodeSuccess)
+	defer shutdown()
+
+	p, err := newRFC2136Provisioner(map[string]string{
+		"server":      address,
+		"tsig-key":    "opendydns",
+		"tsig-secret": "c2VjcmV0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddRecord("foo", "example.org", "192.0.2.1", 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateRecord("foo", "example.org", "2001:db8::1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteRecord("foo", "example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"foo.example.org.\t60\tIN\tA\t192.0.2.1"},
+		{
+			"foo.example.org.\t0\tCLASS255\tAAAA\t",
+			"foo.example.org.\t300\tIN\tAAAA\t2001:db8::1",
+		},
+		{
+			"foo.example.org.\t0\tCLASS255\tA\t",
+			"foo.example.org.\t0\tCLASS255\tAAAA\t",
+		},
+	}
+
+	received := updates()
+	if len(received) != len(expected) {
+		t.Fatalf("wrong number of updates received: %d", len(received))
+	}
+	for i, m := range received {
+		if m.Opcode != mdns.OpcodeUpdate || m.Question[0].Name != "example.org." {
+			t.Errorf("not an update of the zone: %v", m)
+		}
+		if records := updateRecords(m); !reflect.DeepEqual(records, expected[i]) {
+			t.Errorf("wrong update sent: %q", records)
+		}
+	}
+
+	// the server refuses the unsigned updates
+	p, err = newRFC2136Provisioner(map[string]string{"server": address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRecord("foo", "example.org", "192.0.2.1", 60); err == nil {
+		t.Error("AddRecord() should have failed")
+	}
+}
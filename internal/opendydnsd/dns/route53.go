@@ -0,0 +1,390 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/common"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	route53ProvisionerName = "route53"
+	route53Endpoint        = "https://route53.amazonaws.com"
+	route53APIVersion      = "2013-04-01"
+	// route53Region is the region the requests are signed for, Route53 being a global service
+	route53Region  = "us-east-1"
+	route53Service = "route53"
+	route53Timeout = 30 * time.Second
+	// route53DefaultBatchDelay is the time the changes are collected before being sent in a single batch
+	route53DefaultBatchDelay = 500 * time.Millisecond
+)
+
+type route53ResourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type route53RecordSet struct {
+	Name            string                  `xml:"Name"`
+	Type            string                  `xml:"Type"`
+	TTL             int                     `xml:"TTL"`
+	ResourceRecords []route53ResourceRecord `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type route53Change struct {
+	Action            string           `xml:"Action"`
+	ResourceRecordSet route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53HostedZones struct {
+	HostedZones []struct {
+		ID          string `xml:"Id"`
+		Name        string `xml:"Name"`
+		PrivateZone bool   `xml:"Config>PrivateZone"`
+	} `xml:"HostedZones>HostedZone"`
+}
+
+type route53RecordSets struct {
+	RecordSets []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+}
+
+// route53Error is the error returned by the API, either a regular or an invalid change batch one
+type route53Error struct {
+	Code     string   `xml:"Error>Code"`
+	Message  string   `xml:"Error>Message"`
+	Messages []string `xml:"Messages>Message"`
+}
+
+// route53Credentials are the AWS credentials used to sign the requests (Signature Version 4)
+type route53Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// route53Client is a minimal client of the Route53 API
+type route53Client struct {
+	endpoint    string
+	credentials route53Credentials
+	client      *http.Client
+	now         func() time.Time
+}
+
+// route53Provisioner manage the records of the hosted zones using the Route53 API
+// the changes made meanwhile to the same zone are sent in a single change batch
+type route53Provisioner struct {
+	client  *route53Client
+	batcher *route53Batcher
+	// zones map the domains to their hosted zone ID, the others are looked up by name
+	zones map[string]string
+}
+
+// newRoute53Provisioner return a provisioner sending its changes through given batchers
+// so the changes of the provisioners sharing the same credentials are batched together
+func newRoute53Provisioner(config map[string]string, batchers *route53Batchers) (Provisioner, error) {
+	accessKeyID, err := getConfigOrFail(config, "access-key-id")
+	if err != nil {
+		return nil, err
+	}
+	secretAccessKey, err := getConfigOrFail(config, "secret-access-key")
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := parseRoute53Zones(config["zones"])
+	if err != nil {
+		return nil, err
+	}
+
+	delay := route53DefaultBatchDelay
+	if d := config["batch-delay"]; d != "" {
+		if delay, err = time.ParseDuration(d); err != nil || delay < 0 {
+			return nil, fmt.Errorf("invalid config `batch-delay`: %s", d)
+		}
+	}
+
+	client := &route53Client{
+		endpoint: route53Endpoint,
+		credentials: route53Credentials{
+			accessKeyID:     accessKeyID,
+			secretAccessKey: secretAccessKey,
+			sessionToken:    config["session-token"],
+		},
+		client: &http.Client{Timeout: route53Timeout},
+		now:    time.Now,
+	}
+	if endpoint := config["endpoint"]; endpoint != "" {
+		client.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+
+	return &route53Provisioner{
+		client:  client,
+		batcher: batchers.get(client, delay),
+		zones:   zones,
+	}, nil
+}
+
+// parseRoute53Zones parse the domain to hosted zone ID mapping (e.g. example.org=Z1D633PJN98FT9,example.com=Z3M3LMPEXAMPLE)
+func parseRoute53Zones(zones string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, zone := range strings.Split(zones, ",") {
+		if zone = strings.TrimSpace(zone); zone == "" {
+			continue
+		}
+
+		parts := strings.SplitN(zone, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid config `zones`: `%s` is not a domain=zone-id pair", zone)
+		}
+		mapping[strings.ToLower(strings.TrimSuffix(parts[0], "."))] = strings.TrimPrefix(parts[1], "/hostedzone/")
+	}
+
+	return mapping, nil
+}
+
+// ManagesDNSSEC see DNSSECManager
+// Route53 sign the zones itself
+func (r *route53Provisioner) ManagesDNSSEC() bool {
+	return true
+}
+
+func (r *route53Provisioner) AddRecord(host, domain, value string, ttl int) error {
+	return r.upsert(host, domain, value, ttl)
+}
+
+func (r *route53Provisioner) UpdateRecord(host, domain, value string, ttl int) error {
+	return r.upsert(host, domain, value, ttl)
+}
+
+func (r *route53Provisioner) DeleteRecord(host, domain string) error {
+	zoneID, err := r.findZone(domain)
+	if err != nil {
+		return err
+	}
+
+	// the records must be deleted as they are: look for them first, whatever their type
+	recordSets, err := r.client.listRecordSets(zoneID, recordName(host, domain))
+	if err != nil {
+		return err
+	}
+
+	var changes []route53Change
+	for _, recordSet := range recordSets {
+		changes = append(changes, route53Change{Action: "DELETE", ResourceRecordSet: recordSet})
+	}
+
+	if len(changes) == 0 {
+		return fmt.Errorf("no record found")
+	}
+
+	return r.batcher.submit(zoneID, changes)
+}
+
+func (r *route53Provisioner) upsert(host, domain, value string, ttl int) error {
+	zoneID, err := r.findZone(domain)
+	if err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		ttl = defaultRecordTTL
+	}
+
+	return r.batcher.submit(zoneID, []route53Change{{
+		Action: "UPSERT",
+		ResourceRecordSet: route53RecordSet{
+			Name:            recordName(host, domain),
+			Type:            RecordType(value),
+			TTL:             ttl,
+			ResourceRecords: []route53ResourceRecord{{Value: value}},
+		},
+	}})
+}
+
+func (r *route53Provisioner) findZone(domain string) (string, error) {
+	if zoneID, exist := r.zones[strings.ToLower(domain)]; exist {
+		return zoneID, nil
+	}
+
+	return r.client.findHostedZone(domain)
+}
+
+// findHostedZone return the ID of the public hosted zone of given domain
+func (c *route53Client) findHostedZone(domain string) (string, error) {
+	query := url.Values{}
+	query.Set("dnsname", domain)
+	query.Set("maxitems", "10")
+
+	var zones route53HostedZones
+	if err := c.do(http.MethodGet, "/hostedzonesbyname", query, nil, &zones); err != nil {
+		return "", err
+	}
+
+	// the zones are sorted by name: the matching ones come first
+	for _, zone := range zones.HostedZones {
+		if strings.EqualFold(zone.Name, recordName("", domain)) && !zone.PrivateZone {
+			return strings.TrimPrefix(zone.ID, "/hostedzone/"), nil
+		}
+	}
+
+	return "", fmt.Errorf("hosted zone %s not found", domain)
+}
+
+// listRecordSets return the A & AAAA record sets of given name
+func (c *route53Client) listRecordSets(zoneID, name string) ([]route53RecordSet, error) {
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("maxitems", "10")
+
+	var recordSets route53RecordSets
+	if err := c.do(http.MethodGet, fmt.Sprintf("/hostedzone/%s/rrset", zoneID), query, nil, &recordSets); err != nil {
+		return nil, err
+	}
+
+	// the record sets are listed from given name, in order
+	var matching []route53RecordSet
+	for _, recordSet := range recordSets.RecordSets {
+		if strings.EqualFold(recordSet.Name, name) && (recordSet.Type == "A" || recordSet.Type == "AAAA") {
+			matching = append(matching, recordSet)
+		}
+	}
+
+	return matching, nil
+}
+
+// changeRecordSets apply given changes to the hosted zone at once: either all of them are applied or none
+func (c *route53Client) changeRecordSets(zoneID string, changes []route53Change) error {
+	return c.do(http.MethodPost, fmt.Sprintf("/hostedzone/%s/rrset", zoneID), nil, route53ChangeRequest{Changes: changes}, nil)
+}
+
+// do send given request to the API and decode its answer into result, if not nil
+func (c *route53Client) do(method, path string, query url.Values, body, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		b, err := xml.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = append([]byte(xml.Header), b...)
+	}
+
+	u := fmt.Sprintf("%s/%s%s", c.endpoint, route53APIVersion, path)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	req.Header.Set("User-Agent", "opendydnsd/"+common.Version)
+	signRequest(req, payload, c.credentials, route53Region, route53Service, c.now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr route53Error
+		_ = xml.Unmarshal(b, &apiErr)
+		messages := apiErr.Messages
+		if apiErr.Message != "" {
+			messages = append([]string{apiErr.Code + ": " + apiErr.Message}, messages...)
+		}
+		return fmt.Errorf("the Route53 API returned %s: %s", resp.Status, strings.Join(messages, ", "))
+	}
+
+	if result != nil {
+		return xml.Unmarshal(b, result)
+	}
+
+	return nil
+}
+
+// signRequest sign given request using the AWS Signature Version 4
+func signRequest(req *http.Request, payload []byte, credentials route53Credentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+
+	// the host and the amz headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.secretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.accessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// canonicalQuery return the query string sorted & encoded as expected by the Signature Version 4
+func canonicalQuery(query url.Values) string {
+	// Encode sort the parameters but encode the spaces as +
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,147 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// route53MaxChanges is the maximum number of changes of a change batch
+const route53MaxChanges = 1000
+
+// route53Batchers are the batchers shared by the Route53 provisioners, by credentials
+type route53Batchers struct {
+	mutex    sync.Mutex
+	batchers map[string]*route53Batcher
+}
+
+// get return the batcher of the provisioners using given client credentials & delay
+func (rb *route53Batchers) get(client *route53Client, delay time.Duration) *route53Batcher {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	key := client.endpoint + "|" + client.credentials.accessKeyID + "|" + delay.String()
+	if batcher, exist := rb.batchers[key]; exist {
+		// pick up the rotated secrets
+		batcher.setClient(client)
+		return batcher
+	}
+
+	if rb.batchers == nil {
+		rb.batchers = map[string]*route53Batcher{}
+	}
+	batcher := &route53Batcher{client: client, delay: delay, pending: map[string]*route53Batch{}}
+	rb.batchers[key] = batcher
+	return batcher
+}
+
+// route53Batcher group the changes submitted to the same hosted zone during delay
+// into a single change batch, so many aliases updated at once cost a single request
+type route53Batcher struct {
+	delay time.Duration
+
+	mutex   sync.Mutex
+	client  *route53Client
+	pending map[string]*route53Batch // by hosted zone ID
+}
+
+// route53Batch is a change batch waiting to be sent, made of the changes of each submission
+type route53Batch struct {
+	submissions [][]route53Change
+	errs        []error
+	done        chan struct{}
+}
+
+func (b *route53Batcher) setClient(client *route53Client) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.client = client
+}
+
+// submit add given changes to the pending batch of the zone and wait for it to be sent
+// the changes of a submission are applied atomically, whatever the other submissions of the batch
+func (b *route53Batcher) submit(zoneID string, changes []route53Change) error {
+	b.mutex.Lock()
+
+	batch, exist := b.pending[zoneID]
+	// a record set cannot be changed twice in the same batch: wait for the pending one to be sent
+	for exist && batch.conflicts(changes) {
+		b.mutex.Unlock()
+		<-batch.done
+		b.mutex.Lock()
+		batch, exist = b.pending[zoneID]
+	}
+
+	if !exist || batch.size()+len(changes) > route53MaxChanges {
+		batch = &route53Batch{done: make(chan struct{})}
+		b.pending[zoneID] = batch
+		time.AfterFunc(b.delay, func() { b.flush(zoneID, batch) })
+	}
+
+	index := len(batch.submissions)
+	batch.submissions = append(batch.submissions, changes)
+	b.mutex.Unlock()
+
+	<-batch.done
+	return batch.errs[index]
+}
+
+// flush send given batch
+// if it is rejected, its submissions are sent one by one so a single invalid change doesn't fail the others
+func (b *route53Batcher) flush(zoneID string, batch *route53Batch) {
+	b.mutex.Lock()
+	if b.pending[zoneID] == batch {
+		delete(b.pending, zoneID)
+	}
+	client := b.client
+	b.mutex.Unlock()
+
+	defer close(batch.done)
+
+	batch.errs = make([]error, len(batch.submissions))
+
+	var changes []route53Change
+	for _, submission := range batch.submissions {
+		changes = append(changes, submission...)
+	}
+
+	err := client.changeRecordSets(zoneID, changes)
+	if err == nil || len(batch.submissions) == 1 {
+		for i := range batch.errs {
+			batch.errs[i] = err
+		}
+		return
+	}
+
+	for i, submission := range batch.submissions {
+		batch.errs[i] = client.changeRecordSets(zoneID, submission)
+	}
+}
+
+// conflicts determinate if given changes target a record set already changed by the batch
+// must be called with the batcher mutex held
+func (bt *route53Batch) conflicts(changes []route53Change) bool {
+	for _, submission := range bt.submissions {
+		for _, change := range submission {
+			for _, c := range changes {
+				if strings.EqualFold(change.ResourceRecordSet.Name, c.ResourceRecordSet.Name) &&
+					change.ResourceRecordSet.Type == c.ResourceRecordSet.Type {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// size return the number of changes of the batch
+// must be called with the batcher mutex held
+func (bt *route53Batch) size() int {
+	size := 0
+	for _, submission := range bt.submissions {
+		size += len(submission)
+	}
+	return size
+}
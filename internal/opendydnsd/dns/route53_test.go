@@ -0,0 +1,232 @@
+package dns
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRoute53 is an in memory implementation of the Route53 API
+type fakeRoute53 struct {
+	mutex      sync.Mutex
+	recordSets map[string]route53RecordSet // by name and type
+	batches    [][]route53Change
+}
+
+func (fr *fakeRoute53) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<ErrorResponse><Error><Code>InvalidClientTokenId</Code><Message>invalid token</Message></Error></ErrorResponse>`))
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/2013-04-01/hostedzonesbyname":
+		_, _ = fmt.Fprintf(w, `<ListHostedZonesByNameResponse><HostedZones>
+<HostedZone><Id>/hostedzone/ZPRIVATE</Id><Name>example.org.</Name><Config><PrivateZone>true</PrivateZone></Config></HostedZone>
+<HostedZone><Id>/hostedzone/ZPUBLIC</Id><Name>example.org.</Name><Config><PrivateZone>false</PrivateZone></Config></HostedZone>
+</HostedZones></ListHostedZonesByNameResponse>`)
+	case r.URL.Path == "/2013-04-01/hostedzone/ZPUBLIC/rrset" && r.Method == http.MethodGet:
+		var recordSets route53RecordSets
+		for _, recordSet := range fr.recordSets {
+			if recordSet.Name >= r.URL.Query().Get("name") {
+				recordSets.RecordSets = append(recordSets.RecordSets, recordSet)
+			}
+		}
+		b, _ := xml.Marshal(recordSets)
+		_, _ = w.Write(b)
+	case r.URL.Path == "/2013-04-01/hostedzone/ZPUBLIC/rrset" && r.Method == http.MethodPost:
+		body, _ := ioutil.ReadAll(r.Body)
+		var request route53ChangeRequest
+		if err := xml.Unmarshal(body, &request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// the whole batch is rejected if one of the changes is invalid
+		for _, change := range request.Changes {
+			key := change.ResourceRecordSet.Name + change.ResourceRecordSet.Type
+			if _, exist := fr.recordSets[key]; (change.Action == "DELETE" && !exist) ||
+				change.ResourceRecordSet.ResourceRecords[0].Value == "192.0.2.255" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`<InvalidChangeBatch><Messages><Message>invalid change</Message></Messages></InvalidChangeBatch>`))
+				return
+			}
+		}
+
+		fr.batches = append(fr.batches, request.Changes)
+		for _, change := range request.Changes {
+			key := change.ResourceRecordSet.Name + change.ResourceRecordSet.Type
+			if change.Action == "DELETE" {
+				delete(fr.recordSets, key)
+			} else {
+				fr.recordSets[key] = change.ResourceRecordSet
+			}
+		}
+		_, _ = w.Write([]byte(`<ChangeResourceRecordSetsResponse/>`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	// the get-vanilla example of the AWS Signature Version 4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signRequest(req, nil, route53Credentials{
+		accessKeyID:     "AKIDEXAMPLE",
+		secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if authorization := req.Header.Get("Authorization"); authorization != expected {
+		t.Errorf("wrong signature: %s", authorization)
+	}
+}
+
+func TestNewRoute53Provisioner(t *testing.T) {
+	for _, config := range []map[string]string{
+		{},
+		{"access-key-id": "AKIDEXAMPLE"},
+		{"access-key-id": "AKIDEXAMPLE", "secret-access-key": "secret", "zones": "example.org"},
+		{"access-key-id": "AKIDEXAMPLE", "secret-access-key": "secret", "batch-delay": "soon"},
+	} {
+		if _, err := newRoute53Provisioner(config, &route53Batchers{}); err == nil {
+			t.Errorf("newRoute53Provisioner should have failed for %v", config)
+		}
+	}
+
+	batchers := &route53Batchers{}
+	config := map[string]string{
+		"access-key-id":     "AKIDEXAMPLE",
+		"secret-access-key": "secret",
+		"zones":             "example.org=Z1D633PJN98FT9, Example.com.=/hostedzone/Z3M3LMPEXAMPLE",
+	}
+	p, err := newRoute53Provisioner(config, batchers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provisioner := p.(*route53Provisioner)
+	if !reflect.DeepEqual(provisioner.zones, map[string]string{"example.org": "Z1D633PJN98FT9", "example.com": "Z3M3LMPEXAMPLE"}) {
+		t.Errorf("wrong zones: %v", provisioner.zones)
+	}
+
+	// the provisioners sharing the same credentials share the same batcher
+	other, err := newRoute53Provisioner(config, batchers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.(*route53Provisioner).batcher != provisioner.batcher {
+		t.Error("the batcher should have been shared")
+	}
+}
+
+func TestRoute53Provisioner(t *testing.T) {
+	api := &fakeRoute53{recordSets: map[string]route53RecordSet{}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	p, err := newRoute53Provisioner(map[string]string{
+		"access-key-id":     "AKIDEXAMPLE",
+		"secret-access-key": "secret",
+		"endpoint":          server.URL,
+		"batch-delay":       "100ms",
+	}, &route53Batchers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the changes made at once are sent in a single batch, a record set changed twice in a second one
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for _, change := range []struct {
+		host  string
+		value string
+	}{
+		{"foo", "192.0.2.1"},
+		{"bar", "192.0.2.2"},
+		{"bar", "2001:db8::2"},
+		{"foo", "192.0.2.3"},
+	} {
+		wg.Add(1)
+		go func(host, value string) {
+			defer wg.Done()
+			errs <- p.UpdateRecord(host, "example.org", value, 60)
+		}(change.host, change.value)
+
+		// keep the submission order
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(api.batches) != 2 || len(api.batches[0]) != 3 || len(api.batches[1]) != 1 {
+		t.Fatalf("wrong batches sent: %+v", api.batches)
+	}
+	if recordSet := api.recordSets["foo.example.org.A"]; recordSet.ResourceRecords[0].Value != "192.0.2.3" || recordSet.TTL != 60 {
+		t.Errorf("wrong record set: %+v", recordSet)
+	}
+
+	// an invalid change doesn't fail the changes batched with it
+	api.batches = nil
+	errs = make(chan error, 2)
+	for _, value := range []string{"192.0.2.255", "192.0.2.4"} {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			err := p.AddRecord("baz", "example.org", value, 0)
+			if (err != nil) != (value == "192.0.2.255") {
+				errs <- fmt.Errorf("wrong result of AddRecord(%s): %v", value, err)
+			}
+		}(value)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if recordSet := api.recordSets["baz.example.org.A"]; recordSet.ResourceRecords[0].Value != "192.0.2.4" || recordSet.TTL != defaultRecordTTL {
+		t.Errorf("wrong record set: %+v", recordSet)
+	}
+
+	// the records are deleted whatever their type
+	if err := p.DeleteRecord("bar", "example.org"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"bar.example.org.A", "bar.example.org.AAAA"} {
+		if _, exist := api.recordSets[key]; exist {
+			t.Errorf("record set %s should have been deleted", key)
+		}
+	}
+	if err := p.DeleteRecord("bar", "example.org"); err == nil {
+		t.Error("DeleteRecord() should have failed")
+	}
+
+	// unknown zone
+	if err := p.AddRecord("foo", "example.com", "192.0.2.1", 0); err == nil {
+		t.Error("AddRecord() should have failed")
+	}
+}
@@ -1,28 +1,44 @@
 package opendydnsd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/common"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/api"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/crypto/ssh/terminal"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
+// shutdownTimeout is the time given to the pending requests to complete on shutdown
+const shutdownTimeout = 10 * time.Second
+
 // DaemonApp represent a instance of the Daemon app
 type DaemonApp struct {
 	conf     config.Config
 	confPath string
 	logger   *zerolog.Logger
+	// serve is the action starting the API server
+	serve cli.ActionFunc
 }
 
 // NewDaemonApp return a new instance of the daemon app
 func NewDaemonApp() *DaemonApp {
-	return &DaemonApp{}
+	da := &DaemonApp{}
+	da.serve = da.startDaemon
+	return da
 }
 
 // GetApp return the cli.App representing the DaemonApp
@@ -31,7 +47,7 @@ func (da *DaemonApp) GetApp() *cli.App {
 		Name:    "opendydnsd",
 		Usage:   "The OpenDyDNS(Daemon)",
 		Authors: []*cli.Author{{Name: "Aloïs Micard", Email: "alois@micard.lu"}},
-		Version: "0.3.0",
+		Version: common.Version,
 		Before:  da.before,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -40,14 +56,91 @@ func (da *DaemonApp) GetApp() *cli.App {
 			},
 		},
 		Commands: []*cli.Command{
+			{
+				Name:   "serve",
+				Usage:  "Start the daemon and serve the API (default)",
+				Action: da.serve,
+			},
 			{
 				Name:      "create-user",
 				ArgsUsage: "<EMAIL>",
 				Usage:     "Create an user account",
 				Action:    da.createUser,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "admin",
+						Usage: "Grant the admin privileges to the user",
+					},
+				},
+			},
+			{
+				Name:      "set-admin",
+				ArgsUsage: "<EMAIL> <true/false>",
+				Usage:     "Grant or revoke the admin privileges of an user",
+				Action:    da.setAdmin,
+			},
+			{
+				Name:      "disable-user",
+				ArgsUsage: "<EMAIL>",
+				Usage:     "Prevent an user from logging in, the tokens already issued are rejected",
+				Action:    da.setUserDisabled(true),
+			},
+			{
+				Name:      "enable-user",
+				ArgsUsage: "<EMAIL>",
+				Usage:     "Allow a disabled user to log in again",
+				Action:    da.setUserDisabled(false),
+			},
+			{
+				Name:      "reset-password",
+				ArgsUsage: "<EMAIL>",
+				Usage:     "Replace the password of an user and revoke all their tokens",
+				Action:    da.resetPassword,
+			},
+			{
+				Name:   "list-users",
+				Usage:  "List the user accounts",
+				Action: da.listUsers,
+			},
+			{
+				Name:   "list-aliases",
+				Usage:  "List the aliases of every user",
+				Action: da.listAliases,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "user",
+						Usage: "Only list the aliases of the user having given email",
+					},
+				},
+			},
+			{
+				Name:   "gen-key",
+				Usage:  "Print a random key suitable as signing key",
+				Action: da.genKey,
+			},
+			{
+				Name:   "vacuum",
+				Usage:  "Reclaim unused space and refresh statistics of the (sqlite) database",
+				Action: da.vacuum,
+			},
+			{
+				Name:   "migrate",
+				Usage:  "Apply the pending migrations of the database schema, or revert the applied ones",
+				Action: da.migrate,
+				Flags: []cli.Flag{
+					&cli.UintFlag{
+						Name:  "to",
+						Usage: "Migrate (up or down) to given version instead of the latest one, the first one cannot be reverted",
+					},
+					&cli.BoolFlag{
+						Name:  "status",
+						Usage: "Only list the migrations and whether they have been applied",
+					},
+				},
 			},
 		},
-		Action: da.startDaemon,
+		// serve when no subcommand is given, for backward compatibility
+		Action: da.serve,
 	}
 
 	for _, flag := range common.GetLogFlags() {
@@ -65,6 +158,11 @@ func (da *DaemonApp) before(c *cli.Context) error {
 	}
 	da.logger = &logger
 
+	// the key is needed to write the configuration
+	if c.Args().First() == "gen-key" {
+		return nil
+	}
+
 	// Create configuration file if not exist
 	configFile := c.String("config")
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
@@ -90,6 +188,27 @@ func (da *DaemonApp) startDaemon(c *cli.Context) error {
 	// Display version etc...
 	da.logger.Info().Str("Version", c.App.Version).Msg("starting OpenDyDNSD")
 
+	if err := api.CheckSigningKeys(da.conf.APIConfig); err != nil {
+		if !da.conf.APIConfig.AllowWeakSigningKey {
+			da.logger.Err(err).Msg("refusing to start. generate a strong signing key using `opendydnsd gen-key`.")
+			return err
+		}
+		da.logger.Warn().Str("Error", err.Error()).Msg("the signing key is WEAK: the tokens can be forged. use for development only!")
+	}
+
+	if path := da.conf.DaemonConfig.PIDFile; path != "" {
+		pid, err := acquirePIDFile(path)
+		if err != nil {
+			da.logger.Err(err).Str("Path", path).Msg("unable to acquire the PID file.")
+			return err
+		}
+		defer func() {
+			if err := pid.release(); err != nil {
+				da.logger.Err(err).Str("Path", path).Msg("unable to remove the PID file.")
+			}
+		}()
+	}
+
 	// Instantiate the Daemon
 	d, err := daemon.NewDaemon(da.conf, da.logger)
 	if err != nil {
@@ -97,6 +216,20 @@ func (da *DaemonApp) startDaemon(c *cli.Context) error {
 		return err
 	}
 
+	if dc := da.conf.DaemonConfig.DNSServer; dc.Enabled() {
+		stop, err := d.StartDNSServer()
+		if err != nil {
+			da.logger.Err(err).Str("Addr", dc.ListenAddr).Msg("unable to start the embedded DNS server.")
+			return err
+		}
+		defer func() {
+			if err := stop(); err != nil {
+				da.logger.Err(err).Msg("error while stopping the embedded DNS server.")
+			}
+		}()
+		da.logger.Info().Str("Addr", dc.ListenAddr).Msg("embedded DNS server started.")
+	}
+
 	// Instantiate the API
 	a, err := api.NewAPI(d, da.conf.APIConfig)
 	if err != nil {
@@ -105,7 +238,38 @@ func (da *DaemonApp) startDaemon(c *cli.Context) error {
 	}
 
 	da.logger.Info().Str("Addr", da.conf.APIConfig.ListenAddr).Msg("OpenDyDNSD API started.")
-	return a.Start(da.conf.APIConfig.ListenAddr)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Start(da.conf.APIConfig.ListenAddr)
+	}()
+
+	// shutdown cleanly on interruption, to release the PID file
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		da.logger.Info().Str("Signal", sig.String()).Msg("stopping OpenDyDNSD")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return a.Shutdown(ctx)
+	}
+}
+
+func (da *DaemonApp) genKey(c *cli.Context) error {
+	key, err := api.GenerateSigningKey()
+	if err != nil {
+		da.logger.Err(err).Msg("unable to generate the key.")
+		return err
+	}
+
+	_, err = fmt.Fprintln(c.App.Writer, key)
+	return err
 }
 
 func (da *DaemonApp) createUser(c *cli.Context) error {
@@ -136,7 +300,247 @@ func (da *DaemonApp) createUser(c *cli.Context) error {
 		return err
 	}
 
-	da.logger.Info().Str("Email", email).Msg("successfully created user account.")
+	if c.Bool("admin") {
+		if err := d.SetAdmin(email, true); err != nil {
+			da.logger.Err(err).Str("Email", email).Msg("unable to grant admin privileges.")
+			return err
+		}
+	}
+
+	da.logger.Info().Str("Email", email).Bool("Admin", c.Bool("admin")).Msg("successfully created user account.")
+
+	return nil
+}
+
+func (da *DaemonApp) setAdmin(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		err := fmt.Errorf("missing EMAIL STATUS")
+		da.logger.Err(err).Msg("missing EMAIL STATUS.")
+		return err
+	}
+
+	email := c.Args().First()
+	admin, err := strconv.ParseBool(c.Args().Get(1))
+	if err != nil {
+		da.logger.Err(err).Msg("invalid status.")
+		return err
+	}
+
+	d, err := daemon.NewDaemon(da.conf, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to start the daemon.")
+		return err
+	}
+
+	if err := d.SetAdmin(email, admin); err != nil {
+		da.logger.Err(err).Str("Email", email).Msg("unable to update admin status.")
+		return err
+	}
+
+	return nil
+}
+
+func (da *DaemonApp) setUserDisabled(disabled bool) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.Args().Len() != 1 {
+			err := fmt.Errorf("missing EMAIL")
+			da.logger.Err(err).Msg("missing EMAIL.")
+			return err
+		}
+
+		email := c.Args().First()
+
+		d, err := daemon.NewDaemon(da.conf, da.logger)
+		if err != nil {
+			da.logger.Err(err).Msg("unable to start the daemon.")
+			return err
+		}
+
+		if err := d.SetUserDisabled(email, disabled); err != nil {
+			da.logger.Err(err).Str("Email", email).Msg("unable to update disabled status.")
+			return err
+		}
+
+		return nil
+	}
+}
+
+func (da *DaemonApp) resetPassword(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		err := fmt.Errorf("missing EMAIL")
+		da.logger.Err(err).Msg("missing EMAIL.")
+		return err
+	}
+
+	email := c.Args().First()
+
+	fmt.Printf("New password: ")
+	pass, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+
+	d, err := daemon.NewDaemon(da.conf, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to start the daemon.")
+		return err
+	}
+
+	if err := d.ResetPassword(email, string(pass)); err != nil {
+		da.logger.Err(err).Str("Email", email).Msg("unable to reset password.")
+		return err
+	}
+
+	da.logger.Info().Str("Email", email).Msg("successfully reset password.")
 
 	return nil
 }
+
+func (da *DaemonApp) listUsers(c *cli.Context) error {
+	conn, err := database.OpenConnection(da.conf.DatabaseConfig, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to connect to the database.")
+		return err
+	}
+
+	users, err := conn.ListUsers()
+	if err != nil {
+		da.logger.Err(err).Msg("unable to list the users.")
+		return err
+	}
+
+	aliases, err := conn.ListAllAliases()
+	if err != nil {
+		da.logger.Err(err).Msg("unable to list the aliases.")
+		return err
+	}
+
+	return writeUsers(c.App.Writer, users, aliases)
+}
+
+func (da *DaemonApp) listAliases(c *cli.Context) error {
+	conn, err := database.OpenConnection(da.conf.DatabaseConfig, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to connect to the database.")
+		return err
+	}
+
+	aliases, err := conn.ListAllAliases()
+	if err != nil {
+		da.logger.Err(err).Msg("unable to list the aliases.")
+		return err
+	}
+
+	if email := c.String("user"); email != "" {
+		var owned []database.OwnedAlias
+		for _, alias := range aliases {
+			if alias.OwnerEmail == email {
+				owned = append(owned, alias)
+			}
+		}
+		aliases = owned
+	}
+
+	return writeAliases(c.App.Writer, aliases)
+}
+
+// writeUsers write given users as a table, along with their number of aliases
+func writeUsers(w io.Writer, users []database.User, aliases []database.OwnedAlias) error {
+	count := map[string]int{}
+	for _, alias := range aliases {
+		count[alias.OwnerEmail]++
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "EMAIL\tADMIN\tDISABLED\tVERIFIED\tALIASES\tCREATED")
+	for _, user := range users {
+		_, _ = fmt.Fprintf(tw, "%s\t%t\t%t\t%t\t%d\t%s\n",
+			user.Email, user.Admin, user.Disabled, user.Verified(), count[user.Email], user.CreatedAt.Format(time.RFC3339))
+	}
+
+	return tw.Flush()
+}
+
+// writeAliases write given aliases as a table
+func writeAliases(w io.Writer, aliases []database.OwnedAlias) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ALIAS\tVALUE\tVALUE6\tTTL\tENABLED\tOWNER")
+	for _, alias := range aliases {
+		_, _ = fmt.Fprintf(tw, "%s.%s\t%s\t%s\t%d\t%t\t%s\n",
+			alias.Host, alias.Domain, alias.Value, alias.Value6, alias.TTL, alias.Enabled, alias.OwnerEmail)
+	}
+
+	return tw.Flush()
+}
+
+func (da *DaemonApp) vacuum(c *cli.Context) error {
+	conn, err := database.OpenConnection(da.conf.DatabaseConfig, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to connect to the database.")
+		return err
+	}
+
+	da.logger.Info().Str("Driver", da.conf.DatabaseConfig.Driver).Msg("vacuuming database.")
+
+	if err := conn.Vacuum(); err != nil {
+		if errors.Is(err, database.ErrVacuumNotSupported) {
+			da.logger.Info().Str("Driver", da.conf.DatabaseConfig.Driver).Msg("nothing to do: vacuum is only supported by the sqlite driver.")
+			return nil
+		}
+		if errors.Is(err, database.ErrDatabaseBusy) {
+			da.logger.Error().Msg("the database is locked by writers, try again later.")
+			return err
+		}
+
+		da.logger.Err(err).Msg("unable to vacuum the database.")
+		return err
+	}
+
+	da.logger.Info().Msg("successfully vacuumed database.")
+
+	return nil
+}
+
+func (da *DaemonApp) migrate(c *cli.Context) error {
+	migrator, err := database.NewMigrator(da.conf.DatabaseConfig, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to connect to the database.")
+		return err
+	}
+
+	if c.Bool("status") {
+		statuses, err := migrator.Status()
+		if err != nil {
+			da.logger.Err(err).Msg("unable to list the migrations.")
+			return err
+		}
+
+		return writeMigrations(c.App.Writer, statuses)
+	}
+
+	target := migrator.LatestVersion()
+	if c.IsSet("to") {
+		target = c.Uint("to")
+	}
+
+	if err := migrator.Migrate(target); err != nil {
+		da.logger.Err(err).Uint("Version", target).Msg("unable to migrate the database.")
+		return err
+	}
+
+	da.logger.Info().Uint("Version", target).Msg("successfully migrated database.")
+
+	return nil
+}
+
+// writeMigrations write given migrations as a table
+func writeMigrations(w io.Writer, statuses []database.MigrationStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,231 @@
+package opendydnsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/api"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDaemonApp_Dispatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig
+	conf.APIConfig.SigningKey = "test"
+	conf.DatabaseConfig.DSN = filepath.Join(dir, "test.db")
+
+	confPath := filepath.Join(dir, "opendydnsd.toml")
+	if err := config.Save(conf, confPath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args   []string
+		served bool
+	}{
+		{[]string{"opendydnsd", "--config", confPath}, true},
+		{[]string{"opendydnsd", "--config", confPath, "serve"}, true},
+		{[]string{"opendydnsd", "--config", confPath, "vacuum"}, false},
+	}
+
+	for _, test := range tests {
+		served := false
+
+		da := NewDaemonApp()
+		da.serve = func(c *cli.Context) error {
+			served = true
+			return nil
+		}
+
+		if err := da.GetApp().Run(test.args); err != nil {
+			t.Errorf("%v: unexpected error: %s", test.args, err)
+		}
+		if served != test.served {
+			t.Errorf("%v: wrong dispatch, served: %v", test.args, served)
+		}
+	}
+}
+
+func TestDaemonApp_GenKey(t *testing.T) {
+	var out bytes.Buffer
+
+	// no configuration is needed to generate a key
+	app := NewDaemonApp().GetApp()
+	app.Writer = &out
+	if err := app.Run([]string{"opendydnsd", "--config", filepath.Join(os.TempDir(), "missing-opendydnsd.toml"), "gen-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := strings.TrimSpace(out.String())
+	if len(key) != 43 {
+		t.Errorf("wrong key length: %d", len(key))
+	}
+	if err := api.CheckSigningKeys(config.APIConfig{SigningKey: key}); err != nil {
+		t.Errorf("the generated key should be strong: %s", err)
+	}
+}
+
+func TestDaemonApp_WeakSigningKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig
+	conf.APIConfig.SigningKey = "test"
+	conf.DatabaseConfig.DSN = filepath.Join(dir, "test.db")
+
+	confPath := filepath.Join(dir, "opendydnsd.toml")
+	if err := config.Save(conf, confPath); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewDaemonApp().GetApp().Run([]string{"opendydnsd", "--config", confPath, "serve"})
+	if !errors.Is(err, api.ErrWeakSigningKey) {
+		t.Errorf("the daemon should have refused to start: %v", err)
+	}
+
+	// nothing must have been started
+	if _, err := os.Stat(conf.DatabaseConfig.DSN); !os.IsNotExist(err) {
+		t.Errorf("the database should not have been opened: %v", err)
+	}
+}
+
+func TestDaemonApp_UserManagement(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig
+	conf.APIConfig.SigningKey = "test"
+	conf.DatabaseConfig.DSN = filepath.Join(dir, "test.db")
+	// the DNS server is not started by the user management commands
+	conf.DaemonConfig.DNSServer = config.DNSServerConfig{ListenAddr: ":53", Nameservers: []string{"ns1.example.org"}}
+	conf.DaemonConfig.DNSProvisioners = []config.DNSProvisionerConfig{
+		{Name: "embedded", Domains: []config.DomainConfig{{Domain: "example.org"}}},
+	}
+
+	confPath := filepath.Join(dir, "opendydnsd.toml")
+	if err := config.Save(conf, confPath); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zerolog.New(ioutil.Discard)
+	conn, err := database.OpenConnection(conf.DatabaseConfig, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, email := range []string{"mathieu@example.org", "alois@example.org"} {
+		user, err := conn.CreateUser(email, "hashed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.CreateAlias(database.Alias{Host: fmt.Sprintf("host%d", i), Domain: "example.org", Value: "192.0.2.1"}, user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(args ...string) string {
+		var out bytes.Buffer
+		app := NewDaemonApp().GetApp()
+		app.Writer = &out
+		if err := app.Run(append([]string{"opendydnsd", "--config", confPath, "--log-level", "error"}, args...)); err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+		return out.String()
+	}
+
+	run("disable-user", "mathieu@example.org")
+
+	lines := strings.Split(strings.TrimSpace(run("list-users")), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "EMAIL") {
+		t.Fatalf("wrong users listed: %v", lines)
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "alois@example.org" || fields[2] != "false" || fields[4] != "1" {
+		t.Errorf("wrong user listed: %s", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "mathieu@example.org" || fields[2] != "true" {
+		t.Errorf("wrong user listed: %s", lines[2])
+	}
+
+	lines = strings.Split(strings.TrimSpace(run("list-aliases", "--user", "alois@example.org")), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "host1.example.org") {
+		t.Errorf("wrong aliases listed: %v", lines)
+	}
+
+	// unknown user
+	if err := NewDaemonApp().GetApp().Run([]string{"opendydnsd", "--config", confPath, "enable-user", "unknown@example.org"}); err == nil {
+		t.Error("enable-user should have failed")
+	}
+}
+
+func TestDaemonApp_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig
+	conf.APIConfig.SigningKey = "test"
+	conf.DatabaseConfig.DSN = filepath.Join(dir, "test.db")
+	conf.DatabaseConfig.ManualMigrations = true
+
+	confPath := filepath.Join(dir, "opendydnsd.toml")
+	if err := config.Save(conf, confPath); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		app := NewDaemonApp().GetApp()
+		app.Writer = &out
+		err := app.Run(append([]string{"opendydnsd", "--config", confPath, "--log-level", "error"}, args...))
+		return out.String(), err
+	}
+
+	if _, err := run("list-users"); err != database.ErrPendingMigrations {
+		t.Errorf("list-users should have returned ErrPendingMigrations: %v", err)
+	}
+
+	if _, err := run("migrate", "--to", "1"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run("migrate", "--status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "VERSION") {
+		t.Fatalf("wrong migrations listed: %v", lines)
+	}
+	if strings.HasSuffix(lines[1], "pending") || !strings.HasSuffix(lines[2], "pending") {
+		t.Errorf("wrong migrations status: %v", lines)
+	}
+
+	if _, err := run("migrate"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := run("migrate", "--status"); err != nil || strings.Contains(out, "pending") {
+		t.Errorf("every migration should have been applied: %s (%v)", out, err)
+	}
+	if _, err := run("list-users"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,89 @@
+package opendydnsd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrAlreadyRunning is returned when the PID file is held by a running instance
+var ErrAlreadyRunning = errors.New("another instance of the daemon is already running")
+
+// pidFile is the file holding the PID of the running daemon
+// it guards against starting several instances using the same database
+type pidFile struct {
+	path string
+}
+
+// acquirePIDFile create the PID file at given path, unless it is held by a running process
+// a PID file left over by a process which is gone (stale) is reclaimed
+func acquirePIDFile(path string) (*pidFile, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, err
+			}
+
+			return &pidFile{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		pid, err := readPIDFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if pid > 0 && pid != os.Getpid() && processAlive(pid) {
+			return nil, fmt.Errorf("%w (PID %d, remove %s if it is not)", ErrAlreadyRunning, pid, path)
+		}
+
+		// stale PID file
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	// the file has been created again meanwhile
+	return nil, ErrAlreadyRunning
+}
+
+// release remove the PID file, if still held by the current process
+func (p *pidFile) release() error {
+	pid, err := readPIDFile(p.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if pid != os.Getpid() {
+		return nil
+	}
+
+	return os.Remove(p.path)
+}
+
+// readPIDFile return the PID written in given file, 0 if there is none (e.g. interrupted write)
+func readPIDFile(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, nil
+	}
+	return pid, nil
+}
@@ -0,0 +1,97 @@
+package opendydnsd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestAcquirePIDFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opendydnsd.pid")
+
+	pid, err := acquirePIDFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written, err := readPIDFile(path); err != nil || written != os.Getpid() {
+		t.Errorf("wrong PID written: %d (%v)", written, err)
+	}
+
+	if err := pid.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("the PID file should have been removed")
+	}
+
+	// nothing to release anymore
+	if err := pid.release(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAcquirePIDFile_Live(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the parent process (go test) is alive
+	path := filepath.Join(dir, "opendydnsd.pid")
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := acquirePIDFile(path); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("acquirePIDFile() should have returned ErrAlreadyRunning: %v", err)
+	}
+
+	// the PID file of the other instance is left untouched
+	if written, err := readPIDFile(path); err != nil || written != os.Getppid() {
+		t.Errorf("the PID file should not have been changed: %d (%v)", written, err)
+	}
+	if err := (&pidFile{path: path}).release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("the PID file of another process should not have been removed")
+	}
+}
+
+func TestAcquirePIDFile_Stale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opendydnsd.pid")
+
+	// a process which is gone, and an interrupted write
+	for _, content := range []string{"2147483646\n", ""} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		pid, err := acquirePIDFile(path)
+		if err != nil {
+			t.Fatalf("the stale PID file %q should have been reclaimed: %v", content, err)
+		}
+		if written, err := readPIDFile(path); err != nil || written != os.Getpid() {
+			t.Errorf("wrong PID written: %d (%v)", written, err)
+		}
+		if err := pid.release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package opendydnsd
+
+import "syscall"
+
+// processAlive determinate if a process with given PID is running
+func processAlive(pid int) bool {
+	// the signal 0 only checks the process existence
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,14 @@
+package opendydnsd
+
+import "os"
+
+// processAlive determinate if a process with given PID is running
+func processAlive(pid int) bool {
+	// the process can only be found if it exists
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
@@ -1,86 +1,684 @@
 package proto
 
-import "github.com/labstack/echo/v4"
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"sort"
+	"strings"
+	"time"
+)
 
 //go:generate mockgen -source contract.go -destination=../proto_mock/contract_mock.go -package=proto_mock
 
+// HeaderNextCursor is the header containing the cursor of the next page
+const HeaderNextCursor = "X-Next-Cursor"
+
+// HeaderAPIVersion is the header containing the API version used by the client
+const HeaderAPIVersion = "X-API-Version"
+
+// HeaderAPIVersions is the header containing the range of API versions supported
+// by the Daemon, in the MIN-MAX format. It is set on every response.
+const HeaderAPIVersions = "X-API-Versions"
+
+// HeaderSignature is the header containing the signature of the webhook notifications
+// in the sha256={hex} format: the HMAC-SHA256 of the body using the webhook secret
+const HeaderSignature = "X-OpenDyDNS-Signature"
+
+// APIVersion is the current version of the API
+// it must be bumped when a change requires both the Client and the Daemon to be upgraded
+const APIVersion = 1
+
+// MinAPIVersion is the oldest API version still supported by the Daemon
+const MinAPIVersion = 1
+
+// ErrUnsupportedAPIVersion is returned when the API version of the client is not supported
+// the supported range is given by the HeaderAPIVersions header
+var ErrUnsupportedAPIVersion = echo.NewHTTPError(400, "unsupported API version")
+
 // ErrAliasTaken is returned when the wanted alias is already taken by someone else
 var ErrAliasTaken = echo.NewHTTPError(409, "alias already taken")
 
 // ErrAliasAlreadyExist is returned when user already own the wanted alias
 var ErrAliasAlreadyExist = echo.NewHTTPError(409, "alias already exist")
 
+// ErrAliasConflict is returned when updating an alias which has been changed meanwhile
+// the alias must be fetched again before retrying
+var ErrAliasConflict = echo.NewHTTPError(409, "alias has been changed meanwhile")
+
 // ErrAliasNotFound is returned when the wanted alias cannot be found
 var ErrAliasNotFound = echo.NewHTTPError(404, "alias not found")
 
 // ErrInvalidParameters is returned when the given request is invalid
 var ErrInvalidParameters = echo.NewHTTPError(400, "invalid request parameter(s)")
 
+// ErrInvalidToken is returned when using a malformed, badly signed or expired token
+var ErrInvalidToken = echo.NewHTTPError(401, "invalid or expired jwt")
+
+// ErrTokenRevoked is returned when using a token which has been revoked
+var ErrTokenRevoked = echo.NewHTTPError(401, "token has been revoked")
+
+// ErrAliasReserved is returned when an user tries to register a reserved alias name
+var ErrAliasReserved = echo.NewHTTPError(403, "alias name is reserved")
+
+// ErrForbidden is returned when an user tries to access an admin only resource
+var ErrForbidden = echo.NewHTTPError(403, "forbidden")
+
+// ErrTTLOutOfRange is returned when the TTL is not accepted by the DNS provider of the domain
+var ErrTTLOutOfRange = echo.NewHTTPError(400, "TTL out of the range accepted by the DNS provider")
+
+// ErrValueTooLong is returned when the alias value is longer than accepted by the DNS provider
+var ErrValueTooLong = echo.NewHTTPError(400, "alias value is longer than accepted by the DNS provider")
+
+// ErrInvalidValue is returned when the alias value contains forbidden characters or does not match its record type
+var ErrInvalidValue = echo.NewHTTPError(400, "alias value is not valid for its record type")
+
+// ErrLabelsOutOfRange is returned when the alias name is nested deeper (or shallower) than allowed under its domain
+var ErrLabelsOutOfRange = echo.NewHTTPError(400, "alias name depth not allowed under this domain")
+
+// ErrUnknownField is returned when the requested response fields are not fields of the resource
+var ErrUnknownField = echo.NewHTTPError(400, "unknown field requested")
+
+// ErrWebhooksDisabled is returned when registering a webhook while the daemon doesn't allow it
+var ErrWebhooksDisabled = echo.NewHTTPError(400, "webhooks are not enabled")
+
+// ErrInvalidWebhook is returned when the webhook target is not an absolute http(s) URL
+var ErrInvalidWebhook = echo.NewHTTPError(400, "webhook URL must be an absolute http(s) URL")
+
+// ErrPrivateWebhook is returned when the webhook target is a loopback, private or link-local address
+var ErrPrivateWebhook = echo.NewHTTPError(400, "webhook URL must target a public address")
+
+// ErrTooManyWebhooks is returned when the user has registered the maximum number of webhooks
+var ErrTooManyWebhooks = echo.NewHTTPError(400, "too many webhooks registered")
+
+// ErrWebhookNotFound is returned when the wanted webhook cannot be found
+var ErrWebhookNotFound = echo.NewHTTPError(404, "webhook not found")
+
+// ErrShareNotFound is returned when the share does not exist, has been revoked or has expired
+var ErrShareNotFound = echo.NewHTTPError(404, "share not found")
+
+// ErrUserNotFound is returned when the wanted user does not exist
+var ErrUserNotFound = echo.NewHTTPError(404, "user not found")
+
+// ErrAccountDisabled is returned when authenticating (or using a token of) an user disabled by the operator
+var ErrAccountDisabled = echo.NewHTTPError(403, "account is disabled")
+
+// ErrRegistrationDisabled is returned when registering an user while the daemon doesn't allow it
+var ErrRegistrationDisabled = echo.NewHTTPError(403, "registration is not enabled")
+
+// ErrInvalidInviteCode is returned when registering an user without a valid invite code
+var ErrInvalidInviteCode = echo.NewHTTPError(403, "invalid invite code")
+
+// ErrWeakPassword is returned when registering an user whose password is too weak
+var ErrWeakPassword = echo.NewHTTPError(400, "password is too weak")
+
+// ErrEmailNotVerified is returned when authenticating an user whose email address has not been verified
+var ErrEmailNotVerified = echo.NewHTTPError(403, "email address not verified")
+
+// ErrTooManyRequests is returned when a client has made too many registration requests
+var ErrTooManyRequests = echo.NewHTTPError(429, "too many requests, try again later")
+
+// ErrInvalidVerificationToken is returned when verifying an email address using an unknown or expired token
+var ErrInvalidVerificationToken = echo.NewHTTPError(400, "invalid or expired verification token")
+
+// ErrInternal is returned when the request cannot be processed because of an unexpected error
+var ErrInternal = echo.NewHTTPError(500, "internal server error")
+
+// ErrFollowDisabled is returned when registering an alias following another name while the feature is disabled
+var ErrFollowDisabled = echo.NewHTTPError(400, "following another name is not enabled")
+
+// ErrFollowLoop is returned when the followed name leads back to the alias itself
+var ErrFollowLoop = echo.NewHTTPError(400, "the followed name leads back to the alias")
+
+// ErrFollowUnresolvable is returned when the followed name has no address
+var ErrFollowUnresolvable = echo.NewHTTPError(422, "the followed name cannot be resolved")
+
 // ErrDomainNotFound is returned when the alias to register use non supported / not existing domain
 var ErrDomainNotFound = echo.NewHTTPError(404, "requested domain not found")
 
+const (
+	// ScopeAliasesRead allow a token to list the aliases, their events and the domains
+	ScopeAliasesRead = "aliases:read"
+	// ScopeAliasesUpdate allow a token to update the value of the aliases
+	ScopeAliasesUpdate = "aliases:update"
+)
+
+const (
+	// EventAliasCreated is the type of the event emitted when an alias is registered
+	EventAliasCreated = "alias.created"
+	// EventAliasUpdated is the type of the event emitted when an alias is updated
+	EventAliasUpdated = "alias.updated"
+	// EventAliasDeleted is the type of the event emitted when an alias is deleted
+	EventAliasDeleted = "alias.deleted"
+	// EventAliasEnabled is the type of the event emitted when an alias is enabled
+	EventAliasEnabled = "alias.enabled"
+	// EventAliasDisabled is the type of the event emitted when an alias is disabled
+	EventAliasDisabled = "alias.disabled"
+	// EventAliasRenamed is the type of the event emitted when an alias is renamed
+	// the value of the event is the previous name of the alias
+	EventAliasRenamed = "alias.renamed"
+)
+
 // APIContract defined the API served by the Daemon
 type APIContract interface {
 	// Authenticate user using given credential
 	// this either return the JWT token or an error if something goes wrong
 	// POST /sessions
 	Authenticate(cred CredentialsDto) (TokenDto, error)
+	// RefreshToken return a new token of the same user & scopes, to replace given one before it expires
+	// POST /sessions/refresh
+	RefreshToken(token TokenDto) (TokenDto, error)
+	// Register create a new user account, if the Daemon allows self registration
+	// the token is only returned if the email address doesn't need to be verified
+	// POST /users (201)
+	Register(registration RegistrationDto) (RegisteredUserDto, error)
+	// VerifyEmail activate the account whose email address has received given token
+	// this return the JWT token, as on authentication
+	// POST /users/verify
+	VerifyEmail(verification VerificationDto) (TokenDto, error)
 	// GetAliases return user current aliases
-	// GET /aliases
-	GetAliases(token TokenDto) ([]AliasDto, error)
+	// only the given fields (JSON names) of the aliases are returned if any, the others are left empty
+	// GET /aliases?fields={fields}
+	GetAliases(token TokenDto, fields ...string) ([]AliasDto, error)
+	// GetAlias return the user given alias
+	// only the given fields (JSON names) of the alias are returned if any, the others are left empty
+	// GET /aliases/{name}?fields={fields}
+	GetAlias(token TokenDto, name string, fields ...string) (AliasDto, error)
+	// GetAliasesPage return a page of the user aliases, along with the
+	// opaque cursor of the next page (empty if there are no more aliases)
+	// the cursor is returned using the X-Next-Cursor header
+	// GET /aliases?cursor={cursor}&limit={limit}
+	GetAliasesPage(token TokenDto, cursor string, limit int) ([]AliasDto, string, error)
+	// GetAliasesByName return the user aliases matching given names in one call
+	// the names which don't exist (or aren't owned by the user) are reported as missing
+	// POST /aliases/batch-get
+	GetAliasesByName(token TokenDto, names []string) (AliasesLookupDto, error)
 	// RegisterAlias register a new alias for the user
+	// the Location header of the response points to the created alias
 	// POST /aliases
 	RegisterAlias(token TokenDto, alias AliasDto) (AliasDto, error)
 	// UpdateAlias update the user existing alias
+	// ErrAliasConflict (409) is returned if the given version is not the current one
 	// PUT /aliases/{name}
 	UpdateAlias(token TokenDto, alias AliasDto) (AliasDto, error)
+	// PatchAlias update the given fields of the user existing alias, the others are left unchanged
+	// ErrAliasConflict (409) is returned if the given version is not the current one
+	// PATCH /aliases/{name}
+	PatchAlias(token TokenDto, name string, patch AliasPatchDto) (AliasDto, error)
 	// DeleteAlias delete the user given alias
-	// DELETE /aliases/{name}
+	// deleting an alias which does not exist succeeds, unless the daemon is configured otherwise
+	// DELETE /aliases/{name} (204)
 	DeleteAlias(token TokenDto, name string) error
+	// SetAliasEnabled publish (or withdraw) the user given alias
+	// a disabled alias is kept but removed from the DNS
+	// POST /aliases/{name}/enable
+	// POST /aliases/{name}/disable
+	SetAliasEnabled(token TokenDto, name string, enabled bool) (AliasDto, error)
+	// RenameAlias change the host of the user given alias, keeping its value
+	// the domain of the alias cannot be changed
+	// POST /aliases/{name}/rename
+	RenameAlias(token TokenDto, name, newName string) (AliasDto, error)
+	// NotifyAlias update the IP of the user given alias
+	// the IP of the request is used if value is empty
+	// POST /aliases/{name}/notify
+	NotifyAlias(token TokenDto, name, value string) (AliasDto, error)
 
 	// GetDomains return the list of available / supported domains
 	// for alias creation
 	// GET /domains
 	GetDomains(token TokenDto) ([]DomainDto, error)
+
+	// GetEvents return the user alias changes that happened after given cursor
+	// the cursor to use for the next call is the ID of the last returned event
+	// GET /events?cursor={cursor}
+	GetEvents(token TokenDto, cursor uint) ([]EventDto, error)
+
+	// GetLimits return the usage of the user account and the restrictions applying to it
+	// GET /users/me/limits
+	GetLimits(token TokenDto) (UserLimitsDto, error)
+
+	// GetAllAliases return a page of the aliases of every user matching given filter (admin only),
+	// along with the opaque cursor of the next page (empty if there are no more aliases)
+	// the cursor is returned using the X-Next-Cursor header
+	// GET /admin/aliases?cursor={cursor}&limit={limit}&domain={domain}&owner={owner}
+	GetAllAliases(token TokenDto, cursor string, limit int, filter AliasFilterDto) ([]OwnedAliasDto, string, error)
+
+	// GetAllEvents return the alias changes of every user that happened after given cursor (admin only)
+	// the cursor to use for the next call is the ID of the last returned event
+	// GET /admin/events?cursor={cursor}
+	GetAllEvents(token TokenDto, cursor uint) ([]OwnedEventDto, error)
+
+	// GetUserAliases return a page of the aliases of given user (admin only),
+	// along with the opaque cursor of the next page (empty if there are no more aliases)
+	// the cursor is returned using the X-Next-Cursor header
+	// GET /admin/users/{email}/aliases?cursor={cursor}&limit={limit}
+	GetUserAliases(token TokenDto, email, cursor string, limit int) ([]AliasDto, string, error)
+
+	// GetDNSStatus return the status of the DNS provider (admin only)
+	// GET /admin/dns
+	GetDNSStatus(token TokenDto) (DNSStatusDto, error)
+
+	// GetDelegations return the result of the last delegation check of the managed domains (admin only)
+	// GET /admin/delegations
+	GetDelegations(token TokenDto) ([]DelegationDto, error)
+
+	// ExportZone return the published aliases of given managed domain as a zone file fragment (admin only)
+	// GET /admin/zones/{domain}
+	ExportZone(token TokenDto, domain string) (ZoneDto, error)
+
+	// GetWebhooks return the endpoints notified of the changes of the user aliases
+	// GET /users/me/webhooks
+	GetWebhooks(token TokenDto) ([]WebhookDto, error)
+	// CreateWebhook register an endpoint notified of the changes of the user aliases
+	// each change is POSTed as a WebhookEventDto, signed using the secret if any
+	// POST /users/me/webhooks (201)
+	CreateWebhook(token TokenDto, webhook WebhookDto) (WebhookDto, error)
+	// DeleteWebhook unregister the user given webhook
+	// DELETE /users/me/webhooks/{id} (204)
+	DeleteWebhook(token TokenDto, id uint) error
+
+	// CreateShare mint a read-only token giving access to the value of the user given alias
+	// the token is only returned once. The share never expires if expiresIn is zero
+	// POST /aliases/{name}/shares (201)
+	CreateShare(token TokenDto, name string, expiresIn time.Duration) (ShareDto, error)
+	// GetShares return the shares of the user aliases, without their token
+	// GET /users/me/shares
+	GetShares(token TokenDto) ([]ShareDto, error)
+	// DeleteShare revoke the user given share
+	// DELETE /users/me/shares/{id} (204)
+	DeleteShare(token TokenDto, id uint) error
+	// GetSharedAlias return the alias given share token gives access to
+	// no authentication is needed: the share token is the credential
+	// GET /shared/{token}
+	GetSharedAlias(shareToken string) (SharedAliasDto, error)
+
+	// RevokeTokens revoke all the user tokens, including the given one
+	// POST /users/me/revoke-all
+	RevokeTokens(token TokenDto) error
+
+	// GetIP return the IP of the caller, as seen by the Daemon
+	// the token is optional if the Daemon allows anonymous lookups
+	// GET /ip
+	GetIP(token TokenDto) (IPDto, error)
+
+	// GetVersion return the version of the Daemon
+	// GET /version
+	GetVersion() (VersionDto, error)
+}
+
+// APIVersions return the range of API versions supported by the Daemon, in the MIN-MAX format
+func APIVersions() string {
+	return fmt.Sprintf("%d-%d", MinAPIVersion, APIVersion)
+}
+
+// ParseAPIVersions parse given MIN-MAX range of API versions
+func ParseAPIVersions(versions string) (int, int, error) {
+	var min, max int
+	if _, err := fmt.Sscanf(versions, "%d-%d", &min, &max); err != nil || min > max {
+		return 0, 0, fmt.Errorf("invalid API versions range `%s`", versions)
+	}
+
+	return min, max, nil
 }
 
 // AliasDto represent a DyDNS alias
 type AliasDto struct {
-	Domain string `json:"domain"`
-	Value  string `json:"value"`
+	Domain string `json:"domain" xml:"domain" validate:"required,fqdn"`
+	// Value is the IPv4 address of the alias (A record) and Value6 its IPv6 one (AAAA record)
+	// an IPv6 address given as the value is published as the AAAA record. On update,
+	// the address of the other family is kept unless both are given
+	// they are optional when following another name: the value is then resolved by the daemon
+	Value  string `json:"value" xml:"value" validate:"required_without_all=Follow Value6,omitempty,ip"`
+	Value6 string `json:"value6,omitempty" xml:"value6,omitempty" validate:"omitempty,ipv6"`
+	// Follow is the name whose address is periodically copied into the value
+	// the alias is a static one if empty
+	Follow string `json:"follow,omitempty" xml:"follow,omitempty" validate:"omitempty,fqdn"`
+	// TTL of the alias in seconds, the domain default is used if zero
+	TTL int `json:"ttl,omitempty" xml:"ttl,omitempty" validate:"gte=0"`
+	// Enabled is false when the alias is not published (read only)
+	Enabled bool `json:"enabled" xml:"enabled"`
+	// DNSSEC is true when the zone of the alias is DNSSEC signed (read only)
+	DNSSEC bool `json:"dnssec" xml:"dnssec"`
+	// SourceIP and SourceUserAgent identify the client which last updated the alias (read only)
+	// they are empty unless the Daemon is configured to record them
+	SourceIP        string `json:"source_ip,omitempty" xml:"source_ip,omitempty"`
+	SourceUserAgent string `json:"source_user_agent,omitempty" xml:"source_user_agent,omitempty"`
+	// Version is incremented on each change of the alias. When given on update, the update
+	// is rejected with ErrAliasConflict if the alias has been changed since
+	Version uint `json:"version,omitempty" xml:"version,omitempty"`
+}
+
+func (a AliasDto) String() string {
+	if a.Value6 != "" {
+		return strings.TrimSpace(fmt.Sprintf("%s %s %s", a.Domain, a.Value, a.Value6))
+	}
+	return fmt.Sprintf("%s %s", a.Domain, a.Value)
+}
+
+// AliasPatchDto represent a partial update of an alias: the nil fields are left unchanged
+type AliasPatchDto struct {
+	// Value (or Value6) is set to an empty address to withdraw the A (or AAAA) record
+	// an IPv6 address given as the value updates the AAAA record
+	Value  *string `json:"value,omitempty" xml:"value,omitempty" validate:"omitempty,eq=|ip"`
+	Value6 *string `json:"value6,omitempty" xml:"value6,omitempty" validate:"omitempty,eq=|ipv6"`
+	// Follow is set to an empty name to turn the alias back into a static one
+	// the value of the followed name is resolved unless one is given
+	Follow *string `json:"follow,omitempty" xml:"follow,omitempty" validate:"omitempty,eq=|fqdn"`
+	TTL    *int    `json:"ttl,omitempty" xml:"ttl,omitempty" validate:"omitempty,gt=0"`
+	// Version, if given, must be the current version of the alias (see AliasDto)
+	Version uint `json:"version,omitempty" xml:"version,omitempty"`
+}
+
+// AliasNamesDto represent the payload of a batch lookup of aliases
+type AliasNamesDto struct {
+	Names []string `json:"names" xml:"names>name" validate:"required,max=100,dive,fqdn"`
+}
+
+// AliasesLookupDto represent the result of a batch lookup of aliases
+// Missing contains the requested names which cannot be found
+type AliasesLookupDto struct {
+	Aliases []AliasDto `json:"aliases" xml:"aliases>alias"`
+	Missing []string   `json:"missing,omitempty" xml:"missing>name,omitempty"`
+}
+
+// NotifyDto represent the (optional) payload of an alias notification
+type NotifyDto struct {
+	Value string `json:"value" xml:"value" form:"value" validate:"omitempty,ip"`
+}
+
+// RenameDto represent the payload of an alias rename
+type RenameDto struct {
+	Name string `json:"name" xml:"name" form:"name" validate:"required,fqdn"`
+}
+
+// OwnedAliasDto represent an alias along with its owner
+type OwnedAliasDto struct {
+	AliasDto
+	Owner string `json:"owner" xml:"owner"`
+}
+
+// AliasFilterDto restrict the aliases listed by an admin, the empty fields match every alias
+type AliasFilterDto struct {
+	// Domain is the base domain of the aliases (e.g. example.org)
+	Domain string
+	// Owner is the email of the alias owner
+	Owner string
+}
+
+func (a OwnedAliasDto) String() string {
+	return fmt.Sprintf("%s %s", a.AliasDto.String(), a.Owner)
 }
 
 // CredentialsDto represent the credentials
 // when issuing a authentication request
 type CredentialsDto struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+	// Scopes restrict the issued token to the given scopes (ScopeAliasesRead, ScopeAliasesUpdate)
+	// the token has full access to the account if empty
+	Scopes []string `json:"scopes,omitempty" validate:"required_with=Aliases,dive,oneof=aliases:read aliases:update"`
+	// Aliases restrict the scoped token to the given aliases, any if empty
+	Aliases []string `json:"aliases,omitempty" validate:"dive,fqdn"`
+}
+
+// RegistrationDto represent the payload of an user registration
+type RegistrationDto struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+	// InviteCode is required if the Daemon restricts the registration
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+// RegisteredUserDto represent the result of an user registration
+// the token is empty if the email address must be verified first
+type RegisteredUserDto struct {
+	Email                string `json:"email" xml:"email"`
+	VerificationRequired bool   `json:"verification_required" xml:"verification_required"`
+	Token                string `json:"token,omitempty" xml:"token,omitempty"`
+}
+
+// VerificationDto represent the payload of an email address verification
+type VerificationDto struct {
+	Token string `json:"token" xml:"token" validate:"required"`
 }
 
 // TokenDto represent the object that encapsulate the JWT token
 // when issuing a authentication request
 type TokenDto struct {
-	Token string `json:"token"`
+	Token string `json:"token" xml:"token"`
+	// MOTD is the (optional) message of the day of the Daemon, returned on authentication
+	MOTD string `json:"motd,omitempty" xml:"motd,omitempty"`
 }
 
 // DomainDto represent a domain usable to create alias
 // on the Daemon
 type DomainDto struct {
-	Domain string `json:"domain"`
+	Domain string `json:"domain" xml:"domain"`
+}
+
+func (d DomainDto) String() string {
+	return d.Domain
+}
+
+// EventDto represent a change made on an alias
+// Value is the value after the change (empty for a deletion) and PreviousValue
+// the value before the change (empty for a creation)
+type EventDto struct {
+	ID            uint      `json:"id" xml:"id"`
+	Type          string    `json:"type" xml:"type"`
+	Alias         string    `json:"alias" xml:"alias"`
+	Value         string    `json:"value,omitempty" xml:"value,omitempty"`
+	PreviousValue string    `json:"previous_value,omitempty" xml:"previous_value,omitempty"`
+	Time          time.Time `json:"time" xml:"time"`
+}
+
+func (e EventDto) String() string {
+	return fmt.Sprintf("%d %s %s %s", e.ID, e.Type, e.Alias, e.Value)
+}
+
+// OwnedEventDto represent an event along with the user it belongs to
+// the owner is empty if the user has been deleted since
+type OwnedEventDto struct {
+	EventDto
+	Owner string `json:"owner,omitempty" xml:"owner,omitempty"`
+}
+
+func (e OwnedEventDto) String() string {
+	return fmt.Sprintf("%s %s", e.EventDto.String(), e.Owner)
+}
+
+// Delegation statuses of a managed domain
+const (
+	// DelegationOK means the domain is delegated to the expected nameservers
+	DelegationOK = "ok"
+	// DelegationMissing means the domain has no nameservers
+	DelegationMissing = "missing"
+	// DelegationMismatch means the domain is delegated to unexpected nameservers
+	DelegationMismatch = "mismatch"
+	// DelegationError means the nameservers of the domain could not be checked
+	DelegationError = "error"
+)
+
+// DelegationDto represent the delegation status of a managed domain
+type DelegationDto struct {
+	Domain      string    `json:"domain" xml:"domain"`
+	Status      string    `json:"status" xml:"status"`
+	Nameservers []string  `json:"nameservers,omitempty" xml:"nameservers>nameserver,omitempty"`
+	Error       string    `json:"error,omitempty" xml:"error,omitempty"`
+	CheckedAt   time.Time `json:"checked_at" xml:"checked_at"`
+}
+
+func (d DelegationDto) String() string {
+	return fmt.Sprintf("%s %s %s", d.Domain, d.Status, strings.Join(d.Nameservers, ","))
+}
+
+// WebhookDto represent an endpoint notified of the changes of the user aliases
+type WebhookDto struct {
+	ID  uint   `json:"id" xml:"id"`
+	URL string `json:"url" xml:"url" validate:"required,url"`
+	// Secret is used to sign the notifications (write only), see HeaderSignature
+	Secret string `json:"secret,omitempty" xml:"-"`
+	// Signed is true if the notifications are signed (read only)
+	Signed bool `json:"signed" xml:"signed"`
+}
+
+func (w WebhookDto) String() string {
+	return fmt.Sprintf("%d %s", w.ID, w.URL)
+}
+
+// WebhookEventDto is the notification POSTed to the webhooks on each change of the user aliases
+type WebhookEventDto struct {
+	// Type is one of the EventAlias* types
+	Type          string `json:"type"`
+	Alias         string `json:"alias"`
+	Value         string `json:"value,omitempty"`
+	PreviousValue string `json:"previous_value,omitempty"`
+	// PreviousAlias is the name of the alias before a rename
+	PreviousAlias string    `json:"previous_alias,omitempty"`
+	TTL           int       `json:"ttl,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// ShareRequestDto represent the creation of a share
+type ShareRequestDto struct {
+	// ExpiresIn is the lifetime of the share in seconds (10 years at most), it never expires if zero
+	ExpiresIn int `json:"expires_in" xml:"expires_in" validate:"gte=0,lte=315360000"`
+}
+
+// ShareDto represent a read-only access to the value of an alias
+type ShareDto struct {
+	ID    uint   `json:"id" xml:"id"`
+	Alias string `json:"alias" xml:"alias"`
+	// Token is only returned on creation, since only its hash is kept
+	Token string `json:"token,omitempty" xml:"token,omitempty"`
+	// ExpiresAt is nil if the share never expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+}
+
+func (s ShareDto) String() string {
+	return fmt.Sprintf("%d %s", s.ID, s.Alias)
+}
+
+// SharedAliasDto is the alias as seen through a share
+type SharedAliasDto struct {
+	Alias   string `json:"alias" xml:"alias"`
+	Value   string `json:"value" xml:"value"`
+	Value6  string `json:"value6,omitempty" xml:"value6,omitempty"`
+	Enabled bool   `json:"enabled" xml:"enabled"`
+}
+
+func (s SharedAliasDto) String() string {
+	return strings.TrimSpace(s.Value + " " + s.Value6)
+}
+
+// ZoneDto represent the published aliases of a managed domain in the zone file format
+type ZoneDto struct {
+	Domain string `json:"domain" xml:"domain"`
+	Zone   string `json:"zone" xml:"zone"`
+}
+
+func (z ZoneDto) String() string {
+	return strings.TrimSuffix(z.Zone, "\n")
+}
+
+// UserLimitsDto represent the usage of an user account and the restrictions applying to it
+type UserLimitsDto struct {
+	// Aliases is the number of aliases owned by the user
+	Aliases int64 `json:"aliases" xml:"aliases"`
+	// Domains are the domains the user can register aliases under
+	Domains []string `json:"domains" xml:"domains>domain"`
+	// ReservedNames are the alias names the user cannot register (none for admins)
+	ReservedNames []string `json:"reserved_names,omitempty" xml:"reserved_names>reserved_name,omitempty"`
+	// Admin is true if the user is an administrator
+	Admin bool `json:"admin" xml:"admin"`
+}
+
+func (l UserLimitsDto) String() string {
+	return fmt.Sprintf("%d aliases, domains: %s", l.Aliases, strings.Join(l.Domains, ","))
+}
+
+// VersionDto represent the version of the Daemon
+type VersionDto struct {
+	Version string `json:"version" xml:"version"`
+}
+
+func (v VersionDto) String() string {
+	return v.Version
+}
+
+// DNSStatusDto represent the status of the DNS provider
+type DNSStatusDto struct {
+	// CircuitBreaker is the state of the circuit breaker: disabled, closed, open or half-open
+	CircuitBreaker string `json:"circuit_breaker" xml:"circuit_breaker"`
+	// PendingRecords is the number of DNS record changes waiting for the provider to recover
+	PendingRecords int64 `json:"pending_records" xml:"pending_records"`
+}
+
+func (s DNSStatusDto) String() string {
+	return fmt.Sprintf("%s %d", s.CircuitBreaker, s.PendingRecords)
+}
+
+// IPDto represent the IP of the caller, as seen by the Daemon
+type IPDto struct {
+	IP string `json:"ip" xml:"ip"`
+	// Version of the IP: either 4 or 6
+	Version int `json:"version" xml:"version"`
+}
+
+func (i IPDto) String() string {
+	return i.IP
 }
 
 // ErrorDto is the generic error response in case of API error
-// TODO make my own error mapper
 type ErrorDto struct {
 	Message string `json:"message"`
+	// Details contains the per-field errors (field name -> reason) if any
+	Details map[string]string `json:"details,omitempty"`
 }
 
 func (e ErrorDto) Error() string {
 	return e.Message
 }
 
+func (e ErrorDto) String() string {
+	return e.Message
+}
+
+// MarshalXML render the details as a list sorted by field, the maps being unsupported by encoding/xml
+func (e ErrorDto) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	type detail struct {
+		Field  string `xml:"field,attr"`
+		Reason string `xml:",chardata"`
+	}
+
+	var details []detail
+	for field, reason := range e.Details {
+		details = append(details, detail{Field: field, Reason: reason})
+	}
+	sort.Slice(details, func(i, j int) bool {
+		return details[i].Field < details[j].Field
+	})
+
+	start.Name = xml.Name{Local: "error"}
+	return enc.EncodeElement(struct {
+		Message string   `xml:"message"`
+		Details []detail `xml:"details>detail,omitempty"`
+	}{e.Message, details}, start)
+}
+
+// Is determinate if the error returned by the API is the given one, e.g. errors.Is(err, ErrAliasConflict)
+func (e ErrorDto) Is(target error) bool {
+	httpErr, ok := target.(*echo.HTTPError)
+	return ok && httpErr.Message == e.Message
+}
+
 // UserContext represent the JWT token payload
 // and identify the logged in user in secured endpoints
 type UserContext struct {
-	UserID uint
+	UserID       uint
+	TokenVersion uint
+	// RemoteAddr and UserAgent identify the client making the request
+	// they are only set for the requests updating an alias
+	RemoteAddr string
+	UserAgent  string
 }